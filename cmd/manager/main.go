@@ -16,7 +16,9 @@ import (
 	"k8s.io/client-go/rest"
 
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/controller"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/controller/polkadot"
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/version"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
@@ -39,6 +41,7 @@ var (
 	metricsHost               = "0.0.0.0"
 	metricsPort         int32 = 8383
 	operatorMetricsPort int32 = 8686
+	webhookPort               = 9443
 )
 var log = logf.Log.WithName("cmd")
 
@@ -65,6 +68,9 @@ func main() {
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	// The webhook server needs the TLS certificate issued by cert-manager (deploy/webhook.yaml), the webhooks are disabled by default
+	enableWebhooks := pflag.Bool("enable-webhooks", false, "Serve the validating and defaulting admission webhooks of the Polkadot CR and the validating webhook of the validator StatefulSets")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
@@ -104,6 +110,7 @@ func main() {
 	mgr, err := manager.New(cfg, manager.Options{
 		Namespace:          namespace,
 		MetricsBindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		Port:               webhookPort,
 	})
 	if err != nil {
 		log.Error(err, "")
@@ -124,6 +131,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup the admission webhooks, the serving certificate is mounted in the default CertDir of the webhook server
+	if *enableWebhooks {
+		if err := (&polkadotv1alpha1.Polkadot{}).SetupWebhookWithManager(mgr); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+		if err := polkadot.SetupValidatorScaleWebhookWithManager(mgr); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
 	// Add the Metrics Service
 	addMetrics(ctx, cfg, namespace)
 
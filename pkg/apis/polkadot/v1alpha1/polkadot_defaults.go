@@ -0,0 +1,177 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultClientVersion is the tag of the client image used when clientVersion is not set
+	DefaultClientVersion = "latest"
+	// DefaultSentryReplicas is the amount of sentry nodes deployed when replicas is not set
+	DefaultSentryReplicas = 1
+	// DefaultSentryRPCMode is the exposure of the sentry RPC endpoints when no mode is set
+	DefaultSentryRPCMode = RPCModeSafeExternal
+	// DefaultValidatorRPCMode is the exposure of the validator RPC endpoints when no mode is set, the session keys are on the validator
+	DefaultValidatorRPCMode = RPCModeLocalOnly
+	// DefaultBackupRetention is the number of backups kept when retention is not set
+	DefaultBackupRetention = 7
+	// DefaultUpgradeTimeoutSeconds is the time given to an upgraded pod to pass the health gates when timeoutSeconds is not set
+	DefaultUpgradeTimeoutSeconds = 1800
+	// DefaultUpgradeMaxBlocksBehind is the distance to the chain head tolerated when maxBlocksBehind is not set
+	DefaultUpgradeMaxBlocksBehind = 5
+	// DefaultStandbyFailoverAfterSeconds is the time the active validator must be unhealthy before the failover when failoverAfterSeconds is not set
+	DefaultStandbyFailoverAfterSeconds = 300
+)
+
+// GetDefaultResources returns the resources requested by a node when none are set
+func GetDefaultResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+	}
+}
+
+// GetSentryServiceType returns the type of the sentry p2p Service, the sentries are exposed with a NodePort if no type is set.
+// With the public address support the sentries are exposed by the per-pod Services, the shared one is internal
+func (r *Polkadot) GetSentryServiceType() corev1.ServiceType {
+	if r.Spec.Sentry.PublicAddressSupport.Enabled {
+		return corev1.ServiceTypeClusterIP
+	}
+	if r.Spec.Sentry.Service.Type != "" {
+		return r.Spec.Sentry.Service.Type
+	}
+	return corev1.ServiceTypeNodePort
+}
+
+// GetSentryPublicServiceType returns the type of the per-pod sentry Services, NodePort if no type is set
+func (r *Polkadot) GetSentryPublicServiceType() corev1.ServiceType {
+	if r.Spec.Sentry.Service.Type != "" {
+		return r.Spec.Sentry.Service.Type
+	}
+	return corev1.ServiceTypeNodePort
+}
+
+// GetValidatorServiceType returns the type of the validator p2p Service, if no type is set:
+// behind the sentries the validator is reachable only inside the cluster, a standalone validator is exposed with a NodePort
+func (r *Polkadot) GetValidatorServiceType() corev1.ServiceType {
+	if r.Spec.Validator.Service.Type != "" {
+		return r.Spec.Validator.Service.Type
+	}
+	if r.Spec.Kind == KindSentryAndValidator {
+		return corev1.ServiceTypeClusterIP
+	}
+	return corev1.ServiceTypeNodePort
+}
+
+// GetSentryRPC returns the RPC settings of the sentries, with the default mode if none is set
+func (r *Polkadot) GetSentryRPC() RPCSpec {
+	rpc := r.Spec.Sentry.RPC
+	if rpc.Mode == "" {
+		rpc.Mode = DefaultSentryRPCMode
+	}
+	return rpc
+}
+
+// GetValidatorRPC returns the RPC settings of the validator, with the default mode if none is set
+func (r *Polkadot) GetValidatorRPC() RPCSpec {
+	rpc := r.Spec.Validator.RPC
+	if rpc.Mode == "" {
+		rpc.Mode = DefaultValidatorRPCMode
+	}
+	return rpc
+}
+
+// GetBackupRetention returns the number of backups kept, the default one if not set
+func (r *Polkadot) GetBackupRetention() int32 {
+	if r.Spec.Backup == nil || r.Spec.Backup.Retention == 0 {
+		return DefaultBackupRetention
+	}
+	return r.Spec.Backup.Retention
+}
+
+// GetBackupPrefix returns the prefix of the keys of the backups, the name of the CR if not set
+func (r *Polkadot) GetBackupPrefix() string {
+	if r.Spec.Backup == nil || r.Spec.Backup.S3.Prefix == "" {
+		return r.Name
+	}
+	return strings.Trim(r.Spec.Backup.S3.Prefix, "/")
+}
+
+// GetUpgradeTimeout returns the time given to an upgraded pod to pass the health gates, the default one if not set
+func (r *Polkadot) GetUpgradeTimeout() time.Duration {
+	if r.Spec.Upgrade == nil || r.Spec.Upgrade.TimeoutSeconds == 0 {
+		return DefaultUpgradeTimeoutSeconds * time.Second
+	}
+	return time.Duration(r.Spec.Upgrade.TimeoutSeconds) * time.Second
+}
+
+// GetUpgradeMaxBlocksBehind returns the distance to the chain head tolerated by the health gates, the default one if not set
+func (r *Polkadot) GetUpgradeMaxBlocksBehind() int64 {
+	if r.Spec.Upgrade == nil || r.Spec.Upgrade.MaxBlocksBehind == 0 {
+		return DefaultUpgradeMaxBlocksBehind
+	}
+	return r.Spec.Upgrade.MaxBlocksBehind
+}
+
+// GetStandbyFailoverAfter returns the time the active validator must be unhealthy before the failover, the default one if not set
+func (r *Polkadot) GetStandbyFailoverAfter() time.Duration {
+	if r.Spec.Validator.Standby == nil || r.Spec.Validator.Standby.FailoverAfterSeconds == 0 {
+		return DefaultStandbyFailoverAfterSeconds * time.Second
+	}
+	return time.Duration(r.Spec.Validator.Standby.FailoverAfterSeconds) * time.Second
+}
+
+// GetActiveValidatorNode returns the validator node running with the session keys, the primary one unless failed over to the standby
+func (r *Polkadot) GetActiveValidatorNode() ValidatorNode {
+	if r.Spec.Validator.Standby == nil || r.Status.Standby == nil || r.Status.Standby.Active == "" {
+		return ValidatorNodePrimary
+	}
+	return r.Status.Standby.Active
+}
+
+// GetClientImageTag returns the tag of the client image, clientVersion if not set
+func (r *Polkadot) GetClientImageTag() string {
+	if r.Spec.Image.Tag != "" {
+		return r.Spec.Image.Tag
+	}
+	if r.Spec.ClientVersion != "" {
+		return r.Spec.ClientVersion
+	}
+	return DefaultClientVersion
+}
+
+// GetBackend returns the backend of the database, rocksdb if not set
+func (s DatabaseSpec) GetBackend() DatabaseBackend {
+	if s.Backend == "" {
+		return DatabaseBackendRocksDB
+	}
+	return s.Backend
+}
+
+// IsExternal tells if the endpoints are reachable from outside the pod
+func (s RPCSpec) IsExternal() bool {
+	return s.Mode == RPCModeSafeExternal || s.Mode == RPCModeUnsafeExternal
+}
+
+// GetMethods returns the set of RPC methods served by the node, if not set it follows the mode
+func (s RPCSpec) GetMethods() RPCMethods {
+	if s.Methods != "" {
+		return s.Methods
+	}
+	if s.Mode == RPCModeLocalOnly || s.Mode == RPCModeUnsafeExternal {
+		return RPCMethodsUnsafe
+	}
+	return RPCMethodsSafe
+}
+
+// IsFailingOver tells if a failover waits for the pods of the previous validator, nobody holds the session keys meanwhile
+func (s *StandbyStatus) IsFailingOver() bool {
+	return s.Phase == StandbyPhaseFailingOver || s.Phase == StandbyPhaseFailoverStuck
+}
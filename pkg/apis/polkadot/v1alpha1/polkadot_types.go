@@ -3,6 +3,7 @@ package v1alpha1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -14,36 +15,323 @@ type PolkadotSpec struct {
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 
-	ClientVersion              string                     `json:"clientVersion"`
-	Kind                       string                     `json:"kind"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	Kind          string `json:"kind"`
+	// Image overrides the client image of the operator, clientVersion is the tag if none is set
+	Image ImageSpec `json:"image,omitempty"`
+	// Chain selects the chain run by the nodes, if empty the default chain of the client image is run
+	Chain                      ChainSpec                  `json:"chain,omitempty"`
 	Validator                  Validator                  `json:"validator,omitempty"`
 	Sentry                     Sentry                     `json:"sentry,omitempty"`
 	MetricsSupport             MetricsSupport             `json:"metricsSupport"`
 	SecureCommunicationSupport SecureCommunicationSupport `json:"secureCommunicationSupport"`
+	// Backup schedules backups of the chain database to an S3-compatible object storage
+	Backup *BackupSpec `json:"backup,omitempty"`
+	// Upgrade stages the upgrades of the client image one pod at a time, if not set all the pods are updated at once
+	Upgrade *UpgradeSpec `json:"upgrade,omitempty"`
 }
 
+const (
+	// KindSentry deploys only the sentry nodes
+	KindSentry = "Sentry"
+	// KindValidator deploys only the validator node
+	KindValidator = "Validator"
+	// KindSentryAndValidator deploys the validator node behind the sentry nodes
+	KindSentryAndValidator = "SentryAndValidator"
+)
+
+// ImageSpec locates the client image of the nodes
+type ImageSpec struct {
+	// Repository of the client image, e.g. "parity/polkadot", the IMAGE_CLIENT env var of the operator if not set
+	Repository string `json:"repository,omitempty"`
+	// Tag of the client image, clientVersion if not set
+	Tag string `json:"tag,omitempty"`
+	// Digest pins the client image, e.g. "sha256:<64 hex characters>": the tag is only informative
+	Digest           string                        `json:"digest,omitempty"`
+	PullPolicy       corev1.PullPolicy             `json:"pullPolicy,omitempty"`
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+}
+
+// ChainSpec selects the chain by one of: the name of a chain known by the client, a chain spec JSON stored in a ConfigMap,
+// or a chain spec JSON downloaded from a URL
+type ChainSpec struct {
+	// Name of a chain known by the client: polkadot, kusama, westend or rococo
+	Name string `json:"name,omitempty"`
+	// ConfigMapRef selects the ConfigMap key holding the chain spec JSON
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+	// URL of the chain spec JSON, it is downloaded by each pod when it starts
+	URL string `json:"url,omitempty"`
+}
+
+const (
+	ChainPolkadot = "polkadot"
+	ChainKusama   = "kusama"
+	ChainWestend  = "westend"
+	ChainRococo   = "rococo"
+)
+
 type Validator struct {
-	ClientName             string                      `json:"clientName"`
-	NodeKey                string                      `json:"nodeKey"`
+	ClientName string `json:"clientName"`
+	NodeKey    string `json:"nodeKey,omitempty"`
+	// NodeKeySecretRef selects the Secret key holding the node key, it is mutually exclusive with NodeKey.
+	// If none of them is set, the operator generates a node key into a Secret
+	NodeKeySecretRef *corev1.SecretKeySelector `json:"nodeKeySecretRef,omitempty"`
+	// ReservedSentryID overrides the peer ID derived from the sentry node key
 	ReservedSentryID       string                      `json:"reservedSentryID,omitempty"`
 	Resources              corev1.ResourceRequirements `json:"resources,omitempty" protobuf:"bytes,opt,name=resources"`
 	DataPersistenceSupport DataPersistenceSupport      `json:"dataPersistenceSupport"`
+	// Service configures the p2p Service of the validator, it is a NodePort with the Validator kind
+	// and a ClusterIP, reachable only by the sentries, with the SentryAndValidator kind
+	Service ServiceSpec `json:"service,omitempty"`
+	// RPC configures the exposure of the RPC and websocket endpoints of the validator, localOnly by default
+	RPC RPCSpec `json:"rpc,omitempty"`
+	// Database configures the chain database of the validator
+	Database DatabaseSpec `json:"database,omitempty"`
+	// Keystore mounts the session keys of a Secret as the keystore of the validator, instead of the keystore of the data volume
+	Keystore *KeystoreSpec `json:"keystore,omitempty"`
+	// LeaseStartGate starts the validator client only once its pod holds the validator Lease, granted by the operator.
+	// It is a start gate, not a double-signing protection: a client already running is never stopped
+	LeaseStartGate LeaseStartGate `json:"leaseStartGate,omitempty"`
+	// Standby runs a second validator node without the session keys, the keys are moved to it when the active one is unhealthy
+	Standby *StandbySpec `json:"standby,omitempty"`
+	// ExtraArgs, Env, EnvFrom and PodTemplate customize the pod of the validator
+	Overrides `json:",inline"`
 }
 
 type Sentry struct {
-	Replicas               int32                       `json:"replicas"`
-	ClientName             string                      `json:"clientName"`
-	NodeKey                string                      `json:"nodeKey"`
+	Replicas   int32  `json:"replicas"`
+	ClientName string `json:"clientName"`
+	NodeKey    string `json:"nodeKey,omitempty"`
+	// NodeKeySecretRef selects the Secret key holding the node key, it is mutually exclusive with NodeKey.
+	// If none of them is set, the operator generates a node key into a Secret
+	NodeKeySecretRef *corev1.SecretKeySelector `json:"nodeKeySecretRef,omitempty"`
+	// ReservedValidatorID overrides the peer ID derived from the validator node key
 	ReservedValidatorID    string                      `json:"reservedValidatorID,omitempty"`
 	Resources              corev1.ResourceRequirements `json:"resources,omitempty" protobuf:"bytes,opt,name=resources"`
 	DataPersistenceSupport DataPersistenceSupport      `json:"dataPersistenceSupport"`
+	// Service configures the p2p Service of the sentries, it is a NodePort by default
+	Service ServiceSpec `json:"service,omitempty"`
+	// PublicAddressSupport exposes each sentry pod with its own p2p Service, configured by Service
+	PublicAddressSupport PublicAddressSupport `json:"publicAddressSupport,omitempty"`
+	// RPC configures the exposure of the RPC and websocket endpoints of the sentries, safeExternal by default
+	RPC RPCSpec `json:"rpc,omitempty"`
+	// Database configures the chain database of the sentries
+	Database DatabaseSpec `json:"database,omitempty"`
+	// ExtraArgs, Env, EnvFrom and PodTemplate customize the pods of the sentries
+	Overrides `json:",inline"`
+}
+
+// DatabaseSpec configures the chain database of the nodes of a role, the client defaults apply to the fields not set
+type DatabaseSpec struct {
+	// Pruning is "archive" to keep the state of all the blocks, or the number of recent blocks whose state is kept
+	Pruning string `json:"pruning,omitempty"`
+	// StateCacheSize is the size of the internal state cache in bytes
+	StateCacheSize int64 `json:"stateCacheSize,omitempty"`
+	// DBCache is the size of the database cache in MiB
+	DBCache int32 `json:"dbCache,omitempty"`
+	// Backend of the database: rocksdb or paritydb
+	Backend DatabaseBackend `json:"backend,omitempty"`
+	// Resync wipes the chain database of the persisted data when its value changes, e.g. set it to the current date.
+	// It is required to switch between archive and pruned, or to change the backend, on persisted data
+	Resync string `json:"resync,omitempty"`
+}
+
+// PruningArchive keeps the state of all the blocks
+const PruningArchive = "archive"
+
+type DatabaseBackend string
+
+const (
+	DatabaseBackendRocksDB  DatabaseBackend = "rocksdb"
+	DatabaseBackendParityDB DatabaseBackend = "paritydb"
+)
+
+// Overrides customize the pods of a role beyond the settings managed by the operator
+type Overrides struct {
+	// ExtraArgs are appended to the command of the client, e.g. "--in-peers=50".
+	// The flags managed by the operator are rejected
+	ExtraArgs []string `json:"extraArgs,omitempty"`
+	// Env is added to the environment of the client container
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// EnvFrom is added to the environment sources of the client container
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+	// PodTemplate is a strategic merge patch of the pod template, for the pod level settings:
+	// e.g. metadata.annotations, spec.nodeSelector, spec.tolerations, spec.affinity, spec.priorityClassName, spec.imagePullSecrets
+	// +kubebuilder:pruning:PreserveUnknownFields
+	PodTemplate *runtime.RawExtension `json:"podTemplate,omitempty"`
+}
+
+// RPCSpec configures the RPC and websocket endpoints of the nodes of a role
+type RPCSpec struct {
+	// Mode of exposure of the endpoints: disabled, localOnly, safeExternal or unsafeExternal
+	Mode RPCMode `json:"mode,omitempty"`
+	// CORS lists the origins allowed to call the endpoints from a browser, "all" allows any origin.
+	// If empty the client default applies (localhost and https://polkadot.js.org)
+	CORS []string `json:"cors,omitempty"`
+	// Methods overrides the set of RPC methods served: Safe or Unsafe.
+	// If empty, the unsafe methods are served only by the localOnly and unsafeExternal modes
+	Methods RPCMethods `json:"methods,omitempty"`
+}
+
+type RPCMode string
+
+const (
+	// RPCModeDisabled binds the endpoints to the loopback interface with the safe methods only, nothing in the pod uses them
+	RPCModeDisabled RPCMode = "disabled"
+	// RPCModeLocalOnly binds the endpoints to the loopback interface, they are reachable by the containers of the pod only
+	RPCModeLocalOnly RPCMode = "localOnly"
+	// RPCModeSafeExternal binds the endpoints to all the interfaces and serves the safe methods only
+	RPCModeSafeExternal RPCMode = "safeExternal"
+	// RPCModeUnsafeExternal binds the endpoints to all the interfaces and serves all the methods
+	RPCModeUnsafeExternal RPCMode = "unsafeExternal"
+)
+
+type RPCMethods string
+
+const (
+	// RPCMethodsSafe serves the RPC methods safe to be exposed publicly
+	RPCMethodsSafe RPCMethods = "Safe"
+	// RPCMethodsUnsafe serves all the RPC methods, e.g. author_rotateKeys
+	RPCMethodsUnsafe RPCMethods = "Unsafe"
+)
+
+// PublicAddressSupport creates a NodePort or LoadBalancer Service per sentry pod, the external address of the Service
+// is advertised by the pod with --public-addr
+type PublicAddressSupport struct {
+	Enabled bool `json:"enabled"`
+	// InternalAddressFallback advertises the InternalIP of the node running a pod when the node has no ExternalIP, NodePort only.
+	// E.g. the nodes of a bare metal cluster whose internal addresses are public
+	InternalAddressFallback bool `json:"internalAddressFallback,omitempty"`
+}
+
+// LeaseStartGate makes the validator pod wait for the validator Lease before starting the client. The operator grants the Lease
+// to a single pod, and to a new pod only once the previous holder is removed, its Lease expired and its containers known terminated.
+// The Lease is checked when the pod starts only and it is renewed by the operator, not by the validator: it doesn't fence a client
+// already running
+type LeaseStartGate struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ServiceSpec configures the Service publishing the p2p port of a role.
+// The RPC, websocket and metrics ports are published on a separate ClusterIP Service
+type ServiceSpec struct {
+	// Type of the p2p Service: ClusterIP, NodePort or LoadBalancer
+	Type corev1.ServiceType `json:"type,omitempty"`
+	// Annotations are added to the p2p Service, e.g. the settings of the cloud load balancer
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ExternalTrafficPolicy of a NodePort or LoadBalancer Service: Cluster or Local
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+	// NodePort fixes the node port of the p2p port, if 0 it is allocated by the API server
+	NodePort int32 `json:"nodePort,omitempty"`
+	// LoadBalancerSourceRanges restricts the clients of a LoadBalancer Service to the given CIDRs
+	LoadBalancerSourceRanges []string `json:"loadBalancerSourceRanges,omitempty"`
 }
 
 type DataPersistenceSupport struct {
 	Enabled               bool                         `json:"enabled"`
 	PersistentVolumeClaim corev1.PersistentVolumeClaim `json:"persistentVolumeClaim,omitempty" protobuf:"bytes,name=volumeClaimTemplates"`
+	// RetentionPolicy is applied to the chain data PersistentVolumeClaims when the CR is deleted, default Retain
+	RetentionPolicy RetentionPolicy `json:"retentionPolicy,omitempty"`
+	// VolumeSnapshotClassName is used by the Snapshot retention policy, if empty the cluster default class is used
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+	// RestoreFrom fills the empty chain database of a new pod from a snapshot of the chain data, instead of syncing it from the network
+	RestoreFrom *RestoreSource `json:"restoreFrom,omitempty"`
+}
+
+// RestoreSource locates a snapshot of the chain data, exactly one of the fields is set.
+// The archives (URL and S3) are tar files, optionally gzip compressed (.tar.gz or .tgz), of the base path of a node:
+// the chain databases are in chains/<chain id>/db
+type RestoreSource struct {
+	// URL of the archive, http or https
+	URL string `json:"url,omitempty"`
+	// S3 locates the archive in an S3-compatible object storage
+	S3 *S3Object `json:"s3,omitempty"`
+	// PersistentVolumeClaimName is an existing claim holding the chain data of a node, e.g. the claim of another Polkadot CR.
+	// The claim of each new pod is cloned from it
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName,omitempty"`
+	// VolumeSnapshotName is a VolumeSnapshot of the chain data of a node, e.g. taken by the Snapshot retention policy.
+	// The claim of each new pod is restored from it
+	VolumeSnapshotName string `json:"volumeSnapshotName,omitempty"`
+}
+
+// KeystoreSpec is a keystore managed out of the cluster. Every key of the Secret is a file of the keystore: the name is the hex
+// encoding of the key type and the public key, the value is the JSON string of the secret seed or phrase
+type KeystoreSpec struct {
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// StandbySpec configures the failover of the validator to a warm standby: a node of the same chain, kept synced without the session keys.
+// The keystore Secret and the node key are moved to the standby only once the pod of the unhealthy validator is gone
+type StandbySpec struct {
+	// FailoverAfterSeconds is the time the active validator must be unhealthy before the failover
+	FailoverAfterSeconds int32 `json:"failoverAfterSeconds,omitempty"`
+}
+
+// UpgradeSpec configures the health gates of a staged upgrade: each upgraded pod must be ready, peered and at the chain head
+// before the next one is upgraded, the sentries first and the validator last
+type UpgradeSpec struct {
+	// TimeoutSeconds is the time given to each upgraded pod to pass the health gates, the upgrade is rolled back afterwards
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// MaxBlocksBehind is the distance to the highest known block tolerated for a pod at the chain head
+	MaxBlocksBehind int64 `json:"maxBlocksBehind,omitempty"`
+}
+
+// BackupSpec schedules backups of the chain database of a sentry. A sentry is stopped for the time of the backup,
+// so that the database is consistent: the validator is never backed up
+type BackupSpec struct {
+	// Schedule of the backups in the cron format, e.g. "0 3 * * *"
+	Schedule string `json:"schedule"`
+	// Role of the node backed up, sentry by default and the only one supported
+	Role string `json:"role,omitempty"`
+	// Retention is the number of backups kept in the bucket, the older ones are deleted after each backup. Default 7
+	Retention int32 `json:"retention,omitempty"`
+	// S3 is the destination of the backups, the archives are named <prefix>/<backup Job name>.tar.gz
+	S3 BackupS3Destination `json:"s3"`
+	// Suspend stops the scheduling of new backups
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+const (
+	// BackupRoleSentry backs up the sentry with the highest ordinal
+	BackupRoleSentry = "sentry"
+)
+
+// BackupS3Destination is a bucket of an S3-compatible object storage, the credentials are required to upload the backups
+type BackupS3Destination struct {
+	S3Bucket `json:",inline"`
+	// Prefix of the keys of the archives, default the name of the CR
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// S3Bucket locates a bucket of an S3-compatible object storage
+type S3Bucket struct {
+	// Endpoint URL of the object storage, e.g. https://s3.eu-central-1.amazonaws.com
+	Endpoint string `json:"endpoint"`
+	// Region signing the requests, default us-east-1
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// CredentialsSecretRef names the Secret holding the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys, if not set the requests are anonymous
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// S3Object locates an object of an S3-compatible object storage
+type S3Object struct {
+	S3Bucket `json:",inline"`
+	// Key of the object in the bucket
+	Key string `json:"key"`
 }
 
+type RetentionPolicy string
+
+const (
+	// RetentionPolicyRetain keeps the PersistentVolumeClaims
+	RetentionPolicyRetain RetentionPolicy = "Retain"
+	// RetentionPolicyDelete deletes the PersistentVolumeClaims
+	RetentionPolicyDelete RetentionPolicy = "Delete"
+	// RetentionPolicySnapshot takes a VolumeSnapshot of each PersistentVolumeClaim, then deletes the claims
+	RetentionPolicySnapshot RetentionPolicy = "Snapshot"
+)
+
 type MetricsSupport struct {
 	Enabled bool `json:"enabled"`
 }
@@ -58,10 +346,236 @@ type PolkadotStatus struct {
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
 
-	// TODO add observable status here
+	Phase              PolkadotPhase       `json:"phase,omitempty"`
+	ObservedGeneration int64               `json:"observedGeneration,omitempty"`
+	ClientVersion      string              `json:"clientVersion,omitempty"`
+	Sentry             NodeSetStatus       `json:"sentry,omitempty"`
+	Validator          NodeSetStatus       `json:"validator,omitempty"`
+	Nodes              []NodeStatus        `json:"nodes,omitempty"`
+	Conditions         []PolkadotCondition `json:"conditions,omitempty"`
+	// ImageDigest is the digest of the client image run by all the pods, as resolved by the container runtime
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// Backups is the history of the scheduled backups, the most recent first
+	Backups []BackupStatus `json:"backups,omitempty"`
+	// KeyRotation reports the last session keys rotation requested with the rotate-keys annotation
+	KeyRotation *KeyRotationStatus `json:"keyRotation,omitempty"`
+	// Upgrade reports the progress of the last staged upgrade of the client image
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+	// Standby reports the validator node holding the session keys and the failovers to the standby
+	Standby *StandbyStatus `json:"standby,omitempty"`
+}
+
+// RotateKeysAnnotation requests a rotation of the session keys of the validator, a new value requests a new rotation
+const RotateKeysAnnotation = "polkadot.swisscomblockchain.com/rotate-keys"
+
+// ReleaseValidatorLeaseAnnotation confirms that the containers of the removed validator pod holding the validator Lease are
+// terminated, its value is the UID of the holder. Required when the node of the holder is not ready or removed
+const ReleaseValidatorLeaseAnnotation = "polkadot.swisscomblockchain.com/release-validator-lease"
+
+// KeyRotationStatus reports a rotation of the session keys of the validator through author_rotateKeys
+type KeyRotationStatus struct {
+	// Request is the value of the rotate-keys annotation the rotation refers to
+	Request string           `json:"request"`
+	Phase   KeyRotationPhase `json:"phase"`
+	// PodName is the validator pod whose keystore holds the new keys
+	PodName string `json:"podName,omitempty"`
+	// SessionKeys are the new public session keys, the hex argument of the session.setKeys extrinsic
+	SessionKeys    string       `json:"sessionKeys,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Message explains why a rotation is still pending or failed, e.g. the error of the last call
+	Message string `json:"message,omitempty"`
+}
+
+type KeyRotationPhase string
+
+const (
+	// KeyRotationPhasePending: the validator is restarted with the unsafe RPC methods reachable by the operator, or the call was rejected by the node
+	KeyRotationPhasePending KeyRotationPhase = "Pending"
+	// KeyRotationPhaseCompleted: the new keys are in the keystore, they must be registered on chain with session.setKeys
+	KeyRotationPhaseCompleted KeyRotationPhase = "Completed"
+	// KeyRotationPhaseFailed: the call may have reached the node without an answer, the keys may have been rotated anyway.
+	// author_rotateKeys is not idempotent, the call is not retried until a new rotate-keys value is set
+	KeyRotationPhaseFailed KeyRotationPhase = "Failed"
+)
+
+// UpgradeStatus reports a staged upgrade of the client image
+type UpgradeStatus struct {
+	Phase UpgradePhase `json:"phase"`
+	// FromImage and FromVersion are the client image and version before the upgrade, they are restored by a rollback
+	FromImage   string `json:"fromImage"`
+	FromVersion string `json:"fromVersion,omitempty"`
+	// ToImage and ToVersion are the client image and version the pods are upgraded to
+	ToImage   string `json:"toImage"`
+	ToVersion string `json:"toVersion,omitempty"`
+	// PodName is the upgraded pod whose health gates are checked
+	PodName string `json:"podName,omitempty"`
+	// UpgradedPods is the number of the pods upgraded and healthy, out of TotalPods
+	UpgradedPods int32        `json:"upgradedPods"`
+	TotalPods    int32        `json:"totalPods"`
+	StartTime    *metav1.Time `json:"startTime,omitempty"`
+	// PodStartTime is the time the upgrade of the pod started, its health gates time out after timeoutSeconds
+	PodStartTime   *metav1.Time `json:"podStartTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Message reports the failing health gate, or the reason of the rollback
+	Message string `json:"message,omitempty"`
+}
+
+type UpgradePhase string
+
+const (
+	// UpgradePhaseInProgress: the pods are upgraded one at a time
+	UpgradePhaseInProgress UpgradePhase = "InProgress"
+	// UpgradePhaseCompleted: all the pods run the new client image
+	UpgradePhaseCompleted UpgradePhase = "Completed"
+	// UpgradePhaseRolledBack: a pod failed its health gates, the pods are rolled back to the previous client image
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+	// UpgradePhaseBlocked: the health gates can't check the chain synchronization of the pods, neither through an external RPC
+	// nor through the metrics exporter. The pods keep the previous client image
+	UpgradePhaseBlocked UpgradePhase = "Blocked"
+)
+
+// StandbyStatus reports the validator node running with the session keys, the other one is the warm standby
+type StandbyStatus struct {
+	Active ValidatorNode `json:"active"`
+	Phase  StandbyPhase  `json:"phase"`
+	// UnhealthySince is the time the active validator was first seen unhealthy, it is failed over after failoverAfterSeconds
+	UnhealthySince *metav1.Time `json:"unhealthySince,omitempty"`
+	// LastFailoverTime is the start of the last failover, Failovers their number
+	LastFailoverTime *metav1.Time `json:"lastFailoverTime,omitempty"`
+	Failovers        int32        `json:"failovers,omitempty"`
+	// Message reports why the active validator is unhealthy, or what the failover is waiting for
+	Message string `json:"message,omitempty"`
+}
+
+// ValidatorNode designates one of the two validator StatefulSets of the standby mode
+type ValidatorNode string
+
+const (
+	// ValidatorNodePrimary is the validator StatefulSet, the one deployed without standby
+	ValidatorNodePrimary ValidatorNode = "primary"
+	// ValidatorNodeSecondary is the StatefulSet added by the standby mode
+	ValidatorNodeSecondary ValidatorNode = "secondary"
+)
+
+type StandbyPhase string
+
+const (
+	// StandbyPhaseHealthy: the active validator is healthy
+	StandbyPhaseHealthy StandbyPhase = "Healthy"
+	// StandbyPhaseUnhealthy: the active validator is unhealthy, it is failed over after failoverAfterSeconds
+	StandbyPhaseUnhealthy StandbyPhase = "Unhealthy"
+	// StandbyPhaseFailingOver: the standby becomes active, the session keys are moved to it once the pod of the previous validator is gone
+	StandbyPhaseFailingOver StandbyPhase = "FailingOver"
+	// StandbyPhaseFailoverStuck: the pod of the previous validator is not gone after the failover timeout, e.g. stuck terminating
+	// on a node lost. The session keys are moved once the administrator removed it
+	StandbyPhaseFailoverStuck StandbyPhase = "FailoverStuck"
+)
+
+// BackupStatus reports a scheduled backup, as observed on its Job
+type BackupStatus struct {
+	// Name of the backup Job
+	Name  string      `json:"name"`
+	Phase BackupPhase `json:"phase"`
+	// S3 locates the archive, it can be used as the restoreFrom.s3 of a role
+	S3             S3Object     `json:"s3"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+type BackupPhase string
+
+const (
+	// BackupPhaseRunning: the sentry is being stopped, or its database is being uploaded
+	BackupPhaseRunning BackupPhase = "Running"
+	// BackupPhaseSucceeded: the archive is uploaded
+	BackupPhaseSucceeded BackupPhase = "Succeeded"
+	// BackupPhaseFailed: the Job failed, see its logs
+	BackupPhaseFailed BackupPhase = "Failed"
+)
+
+// PolkadotPhase is a summary of the state of the deployed nodes
+type PolkadotPhase string
+
+const (
+	// PhasePending: none of the desired nodes is ready yet
+	PhasePending PolkadotPhase = "Pending"
+	// PhaseSyncing: the nodes are ready, but a rollout is still in progress or a node is syncing the chain
+	PhaseSyncing PolkadotPhase = "Syncing"
+	// PhaseRunning: all the desired nodes are ready and up to date
+	PhaseRunning PolkadotPhase = "Running"
+	// PhaseDegraded: only part of the desired nodes is ready
+	PhaseDegraded PolkadotPhase = "Degraded"
+)
+
+// NodeSetStatus reports the readiness of the nodes of a role (sentry or validator)
+type NodeSetStatus struct {
+	Replicas      int32 `json:"replicas"`
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// PeerID is derived from the node key of the role
+	PeerID string `json:"peerID,omitempty"`
+	// PodPeerIDs maps the pod names to their peer IDs, set when each pod has its own node key
+	PodPeerIDs map[string]string `json:"podPeerIDs,omitempty"`
+	// PublicAddresses maps the pod names to the multiaddrs they advertise, set when the public address support is enabled
+	PublicAddresses map[string]string `json:"publicAddresses,omitempty"`
+	// Restores maps the pod names to the progress of the restore of their chain data, set when restoreFrom is configured
+	Restores map[string]RestoreStatus `json:"restores,omitempty"`
+}
+
+// RestoreStatus reports the restore of the chain data of a pod, as observed on its restore init container.
+// The restore is skipped when the chain database of the pod is not empty, the phase is Completed as well
+type RestoreStatus struct {
+	Phase RestorePhase `json:"phase"`
+	// StartTime is the start of the last run of the restore
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is the end of the last run of the restore
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Message is the outcome reported by the restore, e.g. the reason of a failure
+	Message string `json:"message,omitempty"`
+}
+
+type RestorePhase string
+
+const (
+	// RestorePhasePending: the restore has not started yet, e.g. the pod is waiting for its volumes
+	RestorePhasePending RestorePhase = "Pending"
+	// RestorePhaseRestoring: the snapshot is being fetched and unpacked
+	RestorePhaseRestoring RestorePhase = "Restoring"
+	// RestorePhaseCompleted: the chain data is restored, or the database was not empty
+	RestorePhaseCompleted RestorePhase = "Completed"
+	// RestorePhaseFailed: the last run of the restore failed, it is retried by the kubelet
+	RestorePhaseFailed RestorePhase = "Failed"
+)
+
+// NodeStatus reports the chain synchronization of a pod, as returned by its RPC endpoint
+type NodeStatus struct {
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	IsSyncing    bool   `json:"isSyncing"`
+	Peers        int64  `json:"peers"`
+	CurrentBlock int64  `json:"currentBlock"`
+	HighestBlock int64  `json:"highestBlock"`
+	// Error is set when the node could not be queried, through its RPC endpoint or its metrics exporter
+	Error string `json:"error,omitempty"`
+}
+
+// PolkadotConditionType is the type of a PolkadotCondition
+type PolkadotConditionType string
+
+const (
+	ConditionStatefulSetsReady    PolkadotConditionType = "StatefulSetsReady"
+	ConditionServicesReady        PolkadotConditionType = "ServicesReady"
+	ConditionNetworkPolicyApplied PolkadotConditionType = "NetworkPolicyApplied"
+)
 
-	// Nodes are the names of the CustomResource pods... ?? to check
-	Nodes []string `json:"nodes"`
+// PolkadotCondition follows the schema of the standard metav1.Condition,
+// which is not available in the Kubernetes version the operator is pinned to
+type PolkadotCondition struct {
+	Type               PolkadotConditionType  `json:"type"`
+	Status             metav1.ConditionStatus `json:"status"`
+	ObservedGeneration int64                  `json:"observedGeneration,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+	Reason             string                 `json:"reason"`
+	Message            string                 `json:"message"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -69,6 +583,12 @@ type PolkadotStatus struct {
 // Polkadot is the Schema for the polkadots API
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:path=polkadots,scope=Namespaced
+// +kubebuilder:printcolumn:name="Kind",type="string",JSONPath=".spec.kind"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Version",type="string",JSONPath=".status.clientVersion"
+// +kubebuilder:printcolumn:name="Sentry",type="integer",JSONPath=".status.sentry.readyReplicas"
+// +kubebuilder:printcolumn:name="Validator",type="integer",JSONPath=".status.validator.readyReplicas"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 type Polkadot struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
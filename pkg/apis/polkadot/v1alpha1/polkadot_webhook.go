@@ -0,0 +1,729 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/p2p"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"net"
+	"net/url"
+	"regexp"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strconv"
+	"strings"
+)
+
+// +kubebuilder:webhook:path=/mutate-polkadot-swisscomblockchain-com-v1alpha1-polkadot,mutating=true,failurePolicy=fail,groups=polkadot.swisscomblockchain.com,resources=polkadots,verbs=create;update,versions=v1alpha1,name=mpolkadot.swisscomblockchain.com
+// +kubebuilder:webhook:path=/validate-polkadot-swisscomblockchain-com-v1alpha1-polkadot,mutating=false,failurePolicy=fail,groups=polkadot.swisscomblockchain.com,resources=polkadots,verbs=create;update,versions=v1alpha1,name=vpolkadot.swisscomblockchain.com
+
+// managedPrefix is the prefix of the labels and annotations set by the operator
+const managedPrefix = "polkadot.swisscomblockchain.com/"
+
+// managedFlags are the flags of the client set by the operator from the spec, they can't be passed as extra args
+var managedFlags = map[string]string{
+	"--chain":               "spec.chain",
+	"--name":                "clientName",
+	"--port":                "the P2P_PORT of the operator",
+	"--rpc-port":            "the RPC_PORT of the operator",
+	"--ws-port":             "the WS_PORT of the operator",
+	"--node-key":            "nodeKey",
+	"--node-key-file":       "nodeKeySecretRef",
+	"-d":                    "dataPersistenceSupport",
+	"--base-path":           "dataPersistenceSupport",
+	"--rpc-external":        "rpc.mode",
+	"--ws-external":         "rpc.mode",
+	"--unsafe-rpc-external": "rpc.mode",
+	"--unsafe-ws-external":  "rpc.mode",
+	"--rpc-methods":         "rpc.methods",
+	"--rpc-cors":            "rpc.cors",
+	"--sentry":              "kind",
+	"--validator":           "kind",
+	"--reserved-nodes":      "kind",
+	"--reserved-only":       "kind",
+	"--public-addr":         "publicAddressSupport",
+	"--pruning":             "database.pruning",
+	"--unsafe-pruning":      "database.pruning",
+	"--state-cache-size":    "database.stateCacheSize",
+	"--db-cache":            "database.dbCache",
+	"--database":            "database.backend",
+	"--db":                  "database.backend",
+	"--keystore-path":       "validator.keystore",
+}
+
+// backupPrefixPattern restricts the prefixes to the characters not escaped in the URLs of the objects
+var backupPrefixPattern = regexp.MustCompile(`^/?[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*/?$`)
+
+// imageRepositoryPattern is a repository of a container image, with an optional registry host and port, without tag or digest
+var imageRepositoryPattern = regexp.MustCompile(`^([A-Za-z0-9.-]+(:[0-9]+)?/)?[a-z0-9]+([._-]+[a-z0-9]+)*(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`)
+
+// imageTagPattern is a tag of a container image
+var imageTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// imageDigestPattern is a sha256 digest of a container image manifest
+var imageDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// managedEnvVars are the environment variables of the client container set by the operator
+var managedEnvVars = []string{"POD_NAME", "PUBLIC_ADDR"}
+
+// managedPodLabels are the labels of the pod template set by the operator, they select the pods
+var managedPodLabels = []string{"app", "app.kubernetes.io/instance", "role"}
+
+// managedPodSpecFields are the fields of the pod spec built by the operator, they can't be patched by the pod template
+var managedPodSpecFields = []string{"containers", "initContainers", "volumes", "securityContext"}
+
+// SetupWebhookWithManager registers the defaulting and the validating webhooks of the Polkadot CR
+func (r *Polkadot) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+var _ webhook.Defaulter = &Polkadot{}
+
+// Default implements webhook.Defaulter, the defaults are applied on create only: the CRs created before the webhook keep
+// the fields unset, so that their pods are not rolled by their next update (e.g. the finalizer added by the operator).
+// The creation timestamp is set by the API server after the mutating admission
+func (r *Polkadot) Default() {
+	if !r.CreationTimestamp.IsZero() {
+		return
+	}
+	if r.Spec.ClientVersion == "" {
+		r.Spec.ClientVersion = DefaultClientVersion
+	}
+	// replicas can't be told apart from an omitted field, a sentry role is scaled down by changing the kind
+	if isSentryKind(r.Spec.Kind) && r.Spec.Sentry.Replicas == 0 {
+		r.Spec.Sentry.Replicas = DefaultSentryReplicas
+	}
+	if isEmptyResources(r.Spec.Sentry.Resources) {
+		r.Spec.Sentry.Resources = GetDefaultResources()
+	}
+	if isEmptyResources(r.Spec.Validator.Resources) {
+		r.Spec.Validator.Resources = GetDefaultResources()
+	}
+	if r.Spec.Sentry.DataPersistenceSupport.RetentionPolicy == "" {
+		r.Spec.Sentry.DataPersistenceSupport.RetentionPolicy = RetentionPolicyRetain
+	}
+	if r.Spec.Validator.DataPersistenceSupport.RetentionPolicy == "" {
+		r.Spec.Validator.DataPersistenceSupport.RetentionPolicy = RetentionPolicyRetain
+	}
+	if r.Spec.Sentry.RPC.Mode == "" {
+		r.Spec.Sentry.RPC.Mode = DefaultSentryRPCMode
+	}
+	if r.Spec.Validator.RPC.Mode == "" {
+		r.Spec.Validator.RPC.Mode = DefaultValidatorRPCMode
+	}
+	if r.Spec.Backup != nil {
+		if r.Spec.Backup.Role == "" {
+			r.Spec.Backup.Role = BackupRoleSentry
+		}
+		if r.Spec.Backup.Retention == 0 {
+			r.Spec.Backup.Retention = DefaultBackupRetention
+		}
+	}
+	if r.Spec.Upgrade != nil {
+		if r.Spec.Upgrade.TimeoutSeconds == 0 {
+			r.Spec.Upgrade.TimeoutSeconds = DefaultUpgradeTimeoutSeconds
+		}
+		if r.Spec.Upgrade.MaxBlocksBehind == 0 {
+			r.Spec.Upgrade.MaxBlocksBehind = DefaultUpgradeMaxBlocksBehind
+		}
+	}
+	if r.Spec.Validator.Standby != nil && r.Spec.Validator.Standby.FailoverAfterSeconds == 0 {
+		r.Spec.Validator.Standby.FailoverAfterSeconds = DefaultStandbyFailoverAfterSeconds
+	}
+}
+
+var _ webhook.Validator = &Polkadot{}
+
+// ValidateCreate implements webhook.Validator
+func (r *Polkadot) ValidateCreate() error {
+	return r.toInvalidError(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator, on top of the create checks it blocks the changes the StatefulSets can't apply
+func (r *Polkadot) ValidateUpdate(old runtime.Object) error {
+	if r.GetDeletionTimestamp() != nil {
+		// the finalizer must always be removable
+		return nil
+	}
+	oldPolkadot, ok := old.(*Polkadot)
+	if !ok {
+		return fmt.Errorf("expected a Polkadot object, found %T", old)
+	}
+
+	errs := r.validateSpec()
+	specPath := field.NewPath("spec")
+	errs = append(errs, validateDataPersistenceUpdate(specPath.Child("sentry", "dataPersistenceSupport"), r.Spec.Sentry.DataPersistenceSupport, oldPolkadot.Spec.Sentry.DataPersistenceSupport)...)
+	errs = append(errs, validateDataPersistenceUpdate(specPath.Child("validator", "dataPersistenceSupport"), r.Spec.Validator.DataPersistenceSupport, oldPolkadot.Spec.Validator.DataPersistenceSupport)...)
+	if r.Spec.Sentry.DataPersistenceSupport.Enabled {
+		errs = append(errs, validateDatabaseUpdate(specPath.Child("sentry", "database"), r.Spec.Sentry.Database, oldPolkadot.Spec.Sentry.Database)...)
+	}
+	if r.Spec.Validator.DataPersistenceSupport.Enabled {
+		errs = append(errs, validateDatabaseUpdate(specPath.Child("validator", "database"), r.Spec.Validator.Database, oldPolkadot.Spec.Validator.Database)...)
+	}
+	if (!isValidatorKind(r.Spec.Kind) || r.Spec.Validator.Standby == nil) && oldPolkadot.isStandbyRequired() {
+		errs = append(errs, field.Forbidden(specPath.Child("validator", "standby"), "the session keys are on the secondary validator StatefulSet or moving, the standby mode can't be disabled"))
+	}
+	return r.toInvalidError(errs)
+}
+
+// ValidateDelete implements webhook.Validator
+func (r *Polkadot) ValidateDelete() error {
+	return nil
+}
+
+func (r *Polkadot) validateSpec() field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if !isSentryKind(r.Spec.Kind) && !isValidatorKind(r.Spec.Kind) {
+		errs = append(errs, field.NotSupported(specPath.Child("kind"), r.Spec.Kind, []string{KindSentry, KindValidator, KindSentryAndValidator}))
+	}
+	errs = append(errs, validateChain(specPath.Child("chain"), r.Spec.Chain)...)
+	errs = append(errs, r.validateImage(specPath.Child("image"))...)
+
+	if isSentryKind(r.Spec.Kind) {
+		sentryPath := specPath.Child("sentry")
+		if r.Spec.Sentry.Replicas < 0 {
+			errs = append(errs, field.Invalid(sentryPath.Child("replicas"), r.Spec.Sentry.Replicas, "must be greater than or equal to 0"))
+		}
+		errs = append(errs, validateNodeKey(sentryPath, r.Spec.Sentry.NodeKey, r.Spec.Sentry.NodeKeySecretRef)...)
+		errs = append(errs, r.validateSentryNodeKeyShared(sentryPath)...)
+		if r.Spec.Kind == KindSentryAndValidator {
+			errs = append(errs, validateReservedPeerID(sentryPath.Child("reservedValidatorID"), r.Spec.Sentry.ReservedValidatorID)...)
+		}
+		errs = append(errs, validateRetentionPolicy(sentryPath.Child("dataPersistenceSupport", "retentionPolicy"), r.Spec.Sentry.DataPersistenceSupport.RetentionPolicy)...)
+		errs = append(errs, validateRestoreSource(sentryPath.Child("dataPersistenceSupport"), r.Spec.Sentry.DataPersistenceSupport)...)
+		if r.Spec.Sentry.PublicAddressSupport.Enabled {
+			errs = append(errs, validateService(sentryPath.Child("service"), r.Spec.Sentry.Service, r.GetSentryPublicServiceType())...)
+			if r.GetSentryPublicServiceType() == corev1.ServiceTypeClusterIP {
+				errs = append(errs, field.Forbidden(sentryPath.Child("service", "type"), "the public address support requires a NodePort or LoadBalancer Service"))
+			}
+			if r.Spec.Sentry.Service.NodePort != 0 {
+				errs = append(errs, field.Forbidden(sentryPath.Child("service", "nodePort"), "a fixed node port can't be shared by the per-pod Services"))
+			}
+		} else {
+			errs = append(errs, validateService(sentryPath.Child("service"), r.Spec.Sentry.Service, r.GetSentryServiceType())...)
+		}
+		errs = append(errs, r.validateRPC(sentryPath.Child("rpc"), r.GetSentryRPC())...)
+		errs = append(errs, validateOverrides(sentryPath, r.Spec.Sentry.Overrides)...)
+		errs = append(errs, validateDatabase(sentryPath.Child("database"), r.Spec.Sentry.Database)...)
+	}
+
+	if isValidatorKind(r.Spec.Kind) {
+		validatorPath := specPath.Child("validator")
+		errs = append(errs, validateNodeKey(validatorPath, r.Spec.Validator.NodeKey, r.Spec.Validator.NodeKeySecretRef)...)
+		if r.Spec.Kind == KindSentryAndValidator {
+			errs = append(errs, validateReservedPeerID(validatorPath.Child("reservedSentryID"), r.Spec.Validator.ReservedSentryID)...)
+		}
+		errs = append(errs, validateRetentionPolicy(validatorPath.Child("dataPersistenceSupport", "retentionPolicy"), r.Spec.Validator.DataPersistenceSupport.RetentionPolicy)...)
+		errs = append(errs, validateRestoreSource(validatorPath.Child("dataPersistenceSupport"), r.Spec.Validator.DataPersistenceSupport)...)
+		errs = append(errs, validateService(validatorPath.Child("service"), r.Spec.Validator.Service, r.GetValidatorServiceType())...)
+		if r.Spec.Kind == KindSentryAndValidator && r.GetValidatorServiceType() != corev1.ServiceTypeClusterIP {
+			errs = append(errs, field.Forbidden(validatorPath.Child("service", "type"), "the validator behind the sentries must not be exposed outside the cluster"))
+		}
+		errs = append(errs, r.validateRPC(validatorPath.Child("rpc"), r.GetValidatorRPC())...)
+		errs = append(errs, validateOverrides(validatorPath, r.Spec.Validator.Overrides)...)
+		errs = append(errs, validateDatabase(validatorPath.Child("database"), r.Spec.Validator.Database)...)
+		if r.Spec.Validator.Keystore != nil && r.Spec.Validator.Keystore.SecretRef.Name == "" {
+			errs = append(errs, field.Required(validatorPath.Child("keystore", "secretRef", "name"), ""))
+		}
+		if r.Spec.Validator.Standby != nil {
+			errs = append(errs, r.validateStandby(validatorPath.Child("standby"))...)
+		}
+	}
+
+	if r.Annotations[RotateKeysAnnotation] != "" {
+		errs = append(errs, r.validateKeyRotation(field.NewPath("metadata", "annotations").Key(RotateKeysAnnotation))...)
+	}
+
+	if r.Spec.Backup != nil {
+		errs = append(errs, r.validateBackup(specPath.Child("backup"))...)
+	}
+
+	if r.Spec.Upgrade != nil {
+		upgradePath := specPath.Child("upgrade")
+		if r.Spec.Upgrade.TimeoutSeconds < 0 {
+			errs = append(errs, field.Invalid(upgradePath.Child("timeoutSeconds"), r.Spec.Upgrade.TimeoutSeconds, "must be greater than or equal to 0"))
+		}
+		if r.Spec.Upgrade.MaxBlocksBehind < 0 {
+			errs = append(errs, field.Invalid(upgradePath.Child("maxBlocksBehind"), r.Spec.Upgrade.MaxBlocksBehind, "must be greater than or equal to 0"))
+		}
+	}
+
+	return errs
+}
+
+// validateKeyRotation checks that the keys generated by the validator outlive the restarts of the rotation
+func (r *Polkadot) validateKeyRotation(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if !isValidatorKind(r.Spec.Kind) {
+		errs = append(errs, field.Forbidden(path, "the session keys are rotated on the validator, the kind has none"))
+		return errs
+	}
+	if r.Spec.Validator.Keystore != nil {
+		errs = append(errs, field.Forbidden(path, "the keystore of a Secret is read only, its keys are rotated out of the cluster"))
+	}
+	if !r.Spec.Validator.DataPersistenceSupport.Enabled {
+		errs = append(errs, field.Forbidden(path, "the rotated keys are stored in the keystore of the data volume, the validator data persistence is required"))
+	}
+	return errs
+}
+
+// isStandbyRequired tells if the session keys are on the secondary validator StatefulSet, or if a failover is moving them
+func (r *Polkadot) isStandbyRequired() bool {
+	standby := r.Status.Standby
+	return r.Spec.Validator.Standby != nil && standby != nil && (standby.Active == ValidatorNodeSecondary || standby.IsFailingOver())
+}
+
+// validateStandby checks that the session keys can be moved to the standby, and that it keeps its chain data through the restart
+func (r *Polkadot) validateStandby(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if r.Spec.Validator.Standby.FailoverAfterSeconds < 0 {
+		errs = append(errs, field.Invalid(path.Child("failoverAfterSeconds"), r.Spec.Validator.Standby.FailoverAfterSeconds, "must be greater than or equal to 0"))
+	}
+	if r.Spec.Validator.Keystore == nil {
+		errs = append(errs, field.Forbidden(path, "the session keys are moved to the standby with the keystore of a Secret, spec.validator.keystore is required"))
+	}
+	if !r.Spec.Validator.DataPersistenceSupport.Enabled {
+		errs = append(errs, field.Forbidden(path, "the standby is synced before the failover, the validator data persistence is required"))
+	}
+	if !r.GetValidatorRPC().IsExternal() && !r.Spec.MetricsSupport.Enabled {
+		errs = append(errs, field.Forbidden(path, "the chain synchronization of the validators is checked before the failover, "+
+			"the metrics support or an external spec.validator.rpc is required"))
+	}
+	return errs
+}
+
+// validateBackup checks that a sentry with persisted data can be stopped for the backups, the credentials are required to upload
+func (r *Polkadot) validateBackup(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	backup := r.Spec.Backup
+	if len(strings.Fields(backup.Schedule)) != 5 && !strings.HasPrefix(backup.Schedule, "@") {
+		errs = append(errs, field.Invalid(path.Child("schedule"), backup.Schedule, `must be a cron schedule, e.g. "0 3 * * *"`))
+	}
+	if backup.Role != "" && backup.Role != BackupRoleSentry {
+		errs = append(errs, field.NotSupported(path.Child("role"), backup.Role, []string{BackupRoleSentry}))
+	}
+	if backup.Retention < 0 {
+		errs = append(errs, field.Invalid(path.Child("retention"), backup.Retention, "must be greater than or equal to 0"))
+	}
+	if !isSentryKind(r.Spec.Kind) {
+		errs = append(errs, field.Forbidden(path, "the backups are taken from a sentry, the validator is never stopped"))
+	} else {
+		if !r.Spec.Sentry.DataPersistenceSupport.Enabled {
+			errs = append(errs, field.Forbidden(path, "the backups require the data persistence of the sentries"))
+		}
+		if r.Spec.Kind == KindSentryAndValidator && r.Spec.Sentry.Replicas < 2 {
+			errs = append(errs, field.Forbidden(path, "a backup stops a sentry, at least 2 sentry replicas are required to keep the validator connected"))
+		}
+	}
+	errs = append(errs, validateS3Bucket(path.Child("s3"), backup.S3.S3Bucket)...)
+	if backup.S3.CredentialsSecretRef == nil {
+		errs = append(errs, field.Required(path.Child("s3", "credentialsSecretRef"), "the credentials are required to upload the backups"))
+	}
+	if backup.S3.Prefix != "" && !backupPrefixPattern.MatchString(backup.S3.Prefix) {
+		errs = append(errs, field.Invalid(path.Child("s3", "prefix"), backup.S3.Prefix, "must be made of letters, digits, '.', '_', '-' and '/' separators"))
+	}
+	return errs
+}
+
+// validateImage checks that the reference of the client image can be built from the repository, the tag and the digest
+func (r *Polkadot) validateImage(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	image := r.Spec.Image
+	if image.Repository != "" && !imageRepositoryPattern.MatchString(image.Repository) {
+		errs = append(errs, field.Invalid(path.Child("repository"), image.Repository, `must be a repository without tag or digest, e.g. "parity/polkadot"`))
+	}
+	if !imageTagPattern.MatchString(r.GetClientImageTag()) {
+		if image.Tag != "" {
+			errs = append(errs, field.Invalid(path.Child("tag"), image.Tag, "must be a valid image tag"))
+		} else {
+			errs = append(errs, field.Invalid(field.NewPath("spec", "clientVersion"), r.Spec.ClientVersion, "must be a valid image tag"))
+		}
+	}
+	if image.Digest != "" && !imageDigestPattern.MatchString(image.Digest) {
+		errs = append(errs, field.Invalid(path.Child("digest"), image.Digest, `must be a sha256 digest, e.g. "sha256:<64 hex characters>"`))
+	}
+	switch image.PullPolicy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("pullPolicy"), image.PullPolicy, []string{string(corev1.PullAlways), string(corev1.PullIfNotPresent), string(corev1.PullNever)}))
+	}
+	for i, secret := range image.ImagePullSecrets {
+		if secret.Name == "" {
+			errs = append(errs, field.Required(path.Child("imagePullSecrets").Index(i).Child("name"), ""))
+		}
+	}
+	return errs
+}
+
+// validateChain checks that at most one source of the chain is set, if none is set the client runs its default chain
+func validateChain(path *field.Path, chain ChainSpec) field.ErrorList {
+	var errs field.ErrorList
+	var sources []string
+	if chain.Name != "" {
+		sources = append(sources, "name")
+		switch chain.Name {
+		case ChainPolkadot, ChainKusama, ChainWestend, ChainRococo:
+		default:
+			errs = append(errs, field.NotSupported(path.Child("name"), chain.Name, []string{ChainPolkadot, ChainKusama, ChainWestend, ChainRococo}))
+		}
+	}
+	if chain.ConfigMapRef != nil {
+		sources = append(sources, "configMapRef")
+		if chain.ConfigMapRef.Name == "" {
+			errs = append(errs, field.Required(path.Child("configMapRef", "name"), ""))
+		}
+		if chain.ConfigMapRef.Key == "" {
+			errs = append(errs, field.Required(path.Child("configMapRef", "key"), ""))
+		}
+	}
+	if chain.URL != "" {
+		sources = append(sources, "url")
+		if u, err := url.Parse(chain.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, field.Invalid(path.Child("url"), chain.URL, "must be an http or https URL"))
+		}
+	}
+	if len(sources) > 1 {
+		errs = append(errs, field.Forbidden(path, strings.Join(sources, ", ")+" are mutually exclusive"))
+	}
+	return errs
+}
+
+// validateRestoreSource checks that a single source is set, the restore requires the data persistence
+func validateRestoreSource(path *field.Path, dataPersistence DataPersistenceSupport) field.ErrorList {
+	source := dataPersistence.RestoreFrom
+	if source == nil {
+		return nil
+	}
+	path = path.Child("restoreFrom")
+	var errs field.ErrorList
+	if !dataPersistence.Enabled {
+		errs = append(errs, field.Forbidden(path, "the restore requires the data persistence to be enabled"))
+	}
+	var sources []string
+	if source.URL != "" {
+		sources = append(sources, "url")
+		if u, err := url.Parse(source.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, field.Invalid(path.Child("url"), source.URL, "must be an http or https URL"))
+		} else if !isArchiveName(u.Path) {
+			errs = append(errs, field.Invalid(path.Child("url"), source.URL, "must be a .tar, .tar.gz or .tgz archive"))
+		}
+	}
+	if source.S3 != nil {
+		sources = append(sources, "s3")
+		errs = append(errs, validateS3Bucket(path.Child("s3"), source.S3.S3Bucket)...)
+		if !isArchiveName(source.S3.Key) {
+			errs = append(errs, field.Invalid(path.Child("s3", "key"), source.S3.Key, "must be a .tar, .tar.gz or .tgz archive"))
+		}
+	}
+	if source.PersistentVolumeClaimName != "" {
+		sources = append(sources, "persistentVolumeClaimName")
+	}
+	if source.VolumeSnapshotName != "" {
+		sources = append(sources, "volumeSnapshotName")
+	}
+	if len(sources) == 0 {
+		errs = append(errs, field.Required(path, "one of url, s3, persistentVolumeClaimName or volumeSnapshotName is required"))
+	}
+	if len(sources) > 1 {
+		errs = append(errs, field.Forbidden(path, strings.Join(sources, ", ")+" are mutually exclusive"))
+	}
+	return errs
+}
+
+func validateS3Bucket(path *field.Path, bucket S3Bucket) field.ErrorList {
+	var errs field.ErrorList
+	if u, err := url.Parse(bucket.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, field.Invalid(path.Child("endpoint"), bucket.Endpoint, "must be an http or https URL"))
+	}
+	if bucket.Bucket == "" {
+		errs = append(errs, field.Required(path.Child("bucket"), ""))
+	}
+	if bucket.CredentialsSecretRef != nil && bucket.CredentialsSecretRef.Name == "" {
+		errs = append(errs, field.Required(path.Child("credentialsSecretRef", "name"), ""))
+	}
+	return errs
+}
+
+// isArchiveName tells if the name is a tar file, optionally gzip compressed
+func isArchiveName(name string) bool {
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// validateNodeKey checks the node key of a role, if none is set the operator generates it
+func validateNodeKey(rolePath *field.Path, nodeKey string, nodeKeySecretRef *corev1.SecretKeySelector) field.ErrorList {
+	var errs field.ErrorList
+	if nodeKey != "" && nodeKeySecretRef != nil {
+		errs = append(errs, field.Forbidden(rolePath.Child("nodeKeySecretRef"), "nodeKey and nodeKeySecretRef are mutually exclusive"))
+	}
+	if nodeKey != "" {
+		if _, err := p2p.ParseNodeKey(nodeKey); err != nil {
+			// the node key is a secret, it is not echoed back
+			errs = append(errs, field.Invalid(rolePath.Child("nodeKey"), "<redacted>", err.Error()))
+		}
+	}
+	if nodeKeySecretRef != nil {
+		if nodeKeySecretRef.Name == "" {
+			errs = append(errs, field.Required(rolePath.Child("nodeKeySecretRef", "name"), ""))
+		}
+		if nodeKeySecretRef.Key == "" {
+			errs = append(errs, field.Required(rolePath.Child("nodeKeySecretRef", "key"), ""))
+		}
+	}
+	return errs
+}
+
+// validateSentryNodeKeyShared rejects a node key set by the user for several sentries: they would share the peer ID, and the validator
+// would reserve the load-balanced sentry Service only. The node keys generated by the operator are distinct for each sentry pod
+func (r *Polkadot) validateSentryNodeKeyShared(sentryPath *field.Path) field.ErrorList {
+	if r.Spec.Sentry.Replicas <= 1 || (r.Spec.Sentry.NodeKey == "" && r.Spec.Sentry.NodeKeySecretRef == nil) {
+		return nil
+	}
+	path := sentryPath.Child("nodeKey")
+	if r.Spec.Sentry.NodeKeySecretRef != nil {
+		path = sentryPath.Child("nodeKeySecretRef")
+	}
+	return field.ErrorList{field.Forbidden(path, "the sentries would share the node key and the peer ID, "+
+		"unset it with more than one sentry replica so that the operator generates a node key per sentry pod")}
+}
+
+// validateReservedPeerID checks the optional peer ID override, if empty the operator derives it from the node key
+func validateReservedPeerID(path *field.Path, peerID string) field.ErrorList {
+	if peerID == "" {
+		return nil
+	}
+	if err := p2p.ValidatePeerID(peerID); err != nil {
+		return field.ErrorList{field.Invalid(path, peerID, err.Error())}
+	}
+	return nil
+}
+
+func validateRetentionPolicy(path *field.Path, policy RetentionPolicy) field.ErrorList {
+	switch policy {
+	case "", RetentionPolicyRetain, RetentionPolicyDelete, RetentionPolicySnapshot:
+		return nil
+	}
+	return field.ErrorList{field.NotSupported(path, policy, []string{string(RetentionPolicyRetain), string(RetentionPolicyDelete), string(RetentionPolicySnapshot)})}
+}
+
+// validateService checks the p2p Service settings against the type of the Service
+func validateService(path *field.Path, service ServiceSpec, serviceType corev1.ServiceType) field.ErrorList {
+	var errs field.ErrorList
+	switch serviceType {
+	case corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("type"), serviceType, []string{string(corev1.ServiceTypeClusterIP), string(corev1.ServiceTypeNodePort), string(corev1.ServiceTypeLoadBalancer)}))
+	}
+	isExternal := serviceType == corev1.ServiceTypeNodePort || serviceType == corev1.ServiceTypeLoadBalancer
+
+	switch service.ExternalTrafficPolicy {
+	case "":
+	case corev1.ServiceExternalTrafficPolicyTypeCluster, corev1.ServiceExternalTrafficPolicyTypeLocal:
+		if !isExternal {
+			errs = append(errs, field.Forbidden(path.Child("externalTrafficPolicy"), "only supported by the NodePort and LoadBalancer Services"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("externalTrafficPolicy"), service.ExternalTrafficPolicy, []string{string(corev1.ServiceExternalTrafficPolicyTypeCluster), string(corev1.ServiceExternalTrafficPolicyTypeLocal)}))
+	}
+
+	for key := range service.Annotations {
+		if strings.HasPrefix(key, managedPrefix) {
+			errs = append(errs, field.Forbidden(path.Child("annotations").Key(key), "the annotation is managed by the operator"))
+		}
+	}
+
+	if service.NodePort != 0 {
+		if service.NodePort < 1 || service.NodePort > 65535 {
+			errs = append(errs, field.Invalid(path.Child("nodePort"), service.NodePort, "must be a valid port number"))
+		} else if !isExternal {
+			errs = append(errs, field.Forbidden(path.Child("nodePort"), "only supported by the NodePort and LoadBalancer Services"))
+		}
+	}
+
+	if len(service.LoadBalancerSourceRanges) > 0 && serviceType != corev1.ServiceTypeLoadBalancer {
+		errs = append(errs, field.Forbidden(path.Child("loadBalancerSourceRanges"), "only supported by the LoadBalancer Services"))
+	}
+	for i, sourceRange := range service.LoadBalancerSourceRanges {
+		if _, _, err := net.ParseCIDR(sourceRange); err != nil {
+			errs = append(errs, field.Invalid(path.Child("loadBalancerSourceRanges").Index(i), sourceRange, "must be a CIDR, e.g. 10.0.0.0/8"))
+		}
+	}
+	return errs
+}
+
+// validateRPC checks the RPC settings of a role against its mode
+func (r *Polkadot) validateRPC(path *field.Path, rpc RPCSpec) field.ErrorList {
+	var errs field.ErrorList
+	switch rpc.Mode {
+	case RPCModeDisabled, RPCModeLocalOnly, RPCModeSafeExternal, RPCModeUnsafeExternal:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("mode"), rpc.Mode, []string{string(RPCModeDisabled), string(RPCModeLocalOnly), string(RPCModeSafeExternal), string(RPCModeUnsafeExternal)}))
+	}
+
+	switch rpc.Methods {
+	case "", RPCMethodsSafe:
+	case RPCMethodsUnsafe:
+		if rpc.Mode == RPCModeDisabled || rpc.Mode == RPCModeSafeExternal {
+			errs = append(errs, field.Forbidden(path.Child("methods"), "the unsafe methods are not served by the "+string(rpc.Mode)+" mode"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("methods"), rpc.Methods, []string{string(RPCMethodsSafe), string(RPCMethodsUnsafe)}))
+	}
+
+	if len(rpc.CORS) > 0 && rpc.Mode == RPCModeDisabled {
+		errs = append(errs, field.Forbidden(path.Child("cors"), "the endpoints of the disabled mode are not used"))
+	}
+	for i, origin := range rpc.CORS {
+		if origin == "" || (origin == "all" && len(rpc.CORS) > 1) {
+			errs = append(errs, field.Invalid(path.Child("cors").Index(i), origin, `must be an origin, e.g. https://polkadot.js.org, or "all" alone`))
+		}
+	}
+
+	if rpc.Mode == RPCModeDisabled && r.Spec.MetricsSupport.Enabled {
+		errs = append(errs, field.Forbidden(path.Child("mode"), "the metrics exporter reads the node through its RPC endpoints"))
+	}
+	return errs
+}
+
+// validateOverrides rejects the overrides conflicting with the settings managed by the operator
+func validateOverrides(rolePath *field.Path, overrides Overrides) field.ErrorList {
+	var errs field.ErrorList
+	for i, arg := range overrides.ExtraArgs {
+		flag := strings.SplitN(arg, "=", 2)[0]
+		if setting, isManaged := managedFlags[flag]; isManaged {
+			errs = append(errs, field.Forbidden(rolePath.Child("extraArgs").Index(i), flag+" is managed by the operator, use "+setting))
+		}
+	}
+	for i, env := range overrides.Env {
+		if containsString(managedEnvVars, env.Name) {
+			errs = append(errs, field.Forbidden(rolePath.Child("env").Index(i).Child("name"), env.Name+" is managed by the operator"))
+		}
+	}
+	if overrides.PodTemplate != nil {
+		errs = append(errs, validatePodTemplate(rolePath.Child("podTemplate"), overrides.PodTemplate.Raw)...)
+	}
+	return errs
+}
+
+// validatePodTemplate checks that the patch applies to a pod template and leaves the fields managed by the operator untouched
+func validatePodTemplate(path *field.Path, patch []byte) field.ErrorList {
+	merged, err := strategicpatch.StrategicMergePatch([]byte("{}"), patch, corev1.PodTemplateSpec{})
+	if err == nil {
+		err = json.Unmarshal(merged, &corev1.PodTemplateSpec{})
+	}
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, string(patch), "must be a strategic merge patch of a pod template: "+err.Error())}
+	}
+
+	var errs field.ErrorList
+	var template struct {
+		Metadata struct {
+			Labels      map[string]string `json:"labels"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec map[string]json.RawMessage `json:"spec"`
+	}
+	_ = json.Unmarshal(patch, &template)
+	for key := range template.Metadata.Labels {
+		if containsString(managedPodLabels, key) || strings.HasPrefix(key, managedPrefix) {
+			errs = append(errs, field.Forbidden(path.Child("metadata", "labels").Key(key), "the label is managed by the operator"))
+		}
+	}
+	for key := range template.Metadata.Annotations {
+		if strings.HasPrefix(key, managedPrefix) {
+			errs = append(errs, field.Forbidden(path.Child("metadata", "annotations").Key(key), "the annotation is managed by the operator"))
+		}
+	}
+	for _, name := range managedPodSpecFields {
+		if _, isFound := template.Spec[name]; isFound {
+			errs = append(errs, field.Forbidden(path.Child("spec", name), "the field is managed by the operator"))
+		}
+	}
+	return errs
+}
+
+func validateDatabase(path *field.Path, database DatabaseSpec) field.ErrorList {
+	var errs field.ErrorList
+	if database.Pruning != "" && database.Pruning != PruningArchive {
+		if blocks, err := strconv.ParseUint(database.Pruning, 10, 32); err != nil || blocks == 0 {
+			errs = append(errs, field.Invalid(path.Child("pruning"), database.Pruning, `must be "archive" or a number of blocks`))
+		}
+	}
+	if database.StateCacheSize < 0 {
+		errs = append(errs, field.Invalid(path.Child("stateCacheSize"), database.StateCacheSize, "must be greater than or equal to 0"))
+	}
+	if database.DBCache < 0 {
+		errs = append(errs, field.Invalid(path.Child("dbCache"), database.DBCache, "must be greater than or equal to 0"))
+	}
+	switch database.Backend {
+	case "", DatabaseBackendRocksDB, DatabaseBackendParityDB:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("backend"), database.Backend, []string{string(DatabaseBackendRocksDB), string(DatabaseBackendParityDB)}))
+	}
+	return errs
+}
+
+// validateDatabaseUpdate rejects the changes the client can't apply to an existing database, unless a resync is requested
+func validateDatabaseUpdate(path *field.Path, current DatabaseSpec, old DatabaseSpec) field.ErrorList {
+	if current.Resync != old.Resync {
+		return nil
+	}
+	var errs field.ErrorList
+	if (current.Pruning == PruningArchive) != (old.Pruning == PruningArchive) {
+		errs = append(errs, field.Forbidden(path.Child("pruning"), "switching between archive and pruned requires a resync of the chain data, change database.resync to request it"))
+	}
+	if current.GetBackend() != old.GetBackend() {
+		errs = append(errs, field.Forbidden(path.Child("backend"), "changing the backend requires a resync of the chain data, change database.resync to request it"))
+	}
+	return errs
+}
+
+// validateDataPersistenceUpdate rejects the changes of the volumeClaimTemplates, they are immutable in a StatefulSet
+func validateDataPersistenceUpdate(path *field.Path, current DataPersistenceSupport, old DataPersistenceSupport) field.ErrorList {
+	var errs field.ErrorList
+	if current.Enabled != old.Enabled {
+		errs = append(errs, field.Forbidden(path.Child("enabled"), "the data persistence can't be enabled or disabled on a running deployment"))
+	}
+	if current.Enabled && !equality.Semantic.DeepEqual(current.PersistentVolumeClaim, old.PersistentVolumeClaim) {
+		errs = append(errs, field.Forbidden(path.Child("persistentVolumeClaim"), "the persistent volume claim template is immutable"))
+	}
+	return errs
+}
+
+func (r *Polkadot) toInvalidError(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(SchemeGroupVersion.WithKind("Polkadot").GroupKind(), r.Name, errs)
+}
+
+func isSentryKind(kind string) bool {
+	return kind == KindSentry || kind == KindSentryAndValidator
+}
+
+func isValidatorKind(kind string) bool {
+	return kind == KindValidator || kind == KindSentryAndValidator
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isEmptyResources(resources corev1.ResourceRequirements) bool {
+	return len(resources.Limits) == 0 && len(resources.Requests) == 0
+}
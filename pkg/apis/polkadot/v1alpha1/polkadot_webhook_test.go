@@ -0,0 +1,781 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+	"testing"
+)
+
+func getValidPolkadot() *Polkadot {
+	return &Polkadot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "polkadot-cr",
+		},
+		Spec: PolkadotSpec{
+			ClientVersion: "latest",
+			Kind:          KindSentryAndValidator,
+			Sentry: Sentry{
+				Replicas:            1,
+				NodeKey:             "0000000000000000000000000000000000000000000000000000000000000013",
+				ReservedValidatorID: "QmQtR1cdEaJM11qBWQBd34FoSgFichCjhtsBfrUFsVAjZM",
+			},
+			Validator: Validator{
+				NodeKey:          "0000000000000000000000000000000000000000000000000000000000000021",
+				ReservedSentryID: "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPPhm",
+			},
+		},
+	}
+}
+
+func getValidBackup() *BackupSpec {
+	return &BackupSpec{
+		Schedule: "0 3 * * *",
+		S3: BackupS3Destination{
+			S3Bucket: S3Bucket{Endpoint: "http://minio:9000", Bucket: "backups", CredentialsSecretRef: &corev1.LocalObjectReference{Name: "minio-credentials"}},
+		},
+	}
+}
+
+func TestDefault(t *testing.T) {
+	polkadot := &Polkadot{Spec: PolkadotSpec{Kind: KindSentry}}
+	polkadot.Default()
+
+	if polkadot.Spec.ClientVersion != DefaultClientVersion {
+		t.Fatalf("Default: unexpected clientVersion (%v)", polkadot.Spec.ClientVersion)
+	}
+	if polkadot.Spec.Sentry.Replicas != DefaultSentryReplicas {
+		t.Fatalf("Default: unexpected replicas (%v)", polkadot.Spec.Sentry.Replicas)
+	}
+	if polkadot.Spec.Sentry.Resources.Requests.Cpu().IsZero() || polkadot.Spec.Validator.Resources.Requests.Memory().IsZero() {
+		t.Fatalf("Default: resources not set (%v)", polkadot.Spec.Sentry.Resources)
+	}
+	if polkadot.Spec.Sentry.DataPersistenceSupport.RetentionPolicy != RetentionPolicyRetain {
+		t.Fatalf("Default: unexpected retentionPolicy (%v)", polkadot.Spec.Sentry.DataPersistenceSupport.RetentionPolicy)
+	}
+	if polkadot.Spec.Sentry.RPC.Mode != RPCModeSafeExternal || polkadot.Spec.Validator.RPC.Mode != RPCModeLocalOnly {
+		t.Fatalf("Default: unexpected RPC modes (%v) (%v)", polkadot.Spec.Sentry.RPC.Mode, polkadot.Spec.Validator.RPC.Mode)
+	}
+
+	polkadot.Spec.Backup = getValidBackup()
+	polkadot.Default()
+	if polkadot.Spec.Backup.Role != BackupRoleSentry || polkadot.Spec.Backup.Retention != DefaultBackupRetention || polkadot.GetBackupPrefix() != polkadot.Name {
+		t.Fatalf("Default: unexpected backup (%v)", polkadot.Spec.Backup)
+	}
+
+	polkadot.Spec.Upgrade = &UpgradeSpec{}
+	polkadot.Default()
+	if polkadot.Spec.Upgrade.TimeoutSeconds != DefaultUpgradeTimeoutSeconds || polkadot.Spec.Upgrade.MaxBlocksBehind != DefaultUpgradeMaxBlocksBehind {
+		t.Fatalf("Default: unexpected upgrade (%v)", polkadot.Spec.Upgrade)
+	}
+
+	polkadot.Spec.Validator.Standby = &StandbySpec{}
+	polkadot.Default()
+	if polkadot.Spec.Validator.Standby.FailoverAfterSeconds != DefaultStandbyFailoverAfterSeconds || polkadot.GetActiveValidatorNode() != ValidatorNodePrimary {
+		t.Fatalf("Default: unexpected standby (%v)", polkadot.Spec.Validator.Standby)
+	}
+
+	// the values set by the user are kept
+	polkadot = getValidPolkadot()
+	polkadot.Spec.ClientVersion = "v0.8.0"
+	polkadot.Spec.Sentry.Replicas = 3
+	polkadot.Spec.Sentry.Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+	polkadot.Default()
+
+	if polkadot.Spec.ClientVersion != "v0.8.0" || polkadot.Spec.Sentry.Replicas != 3 || len(polkadot.Spec.Sentry.Resources.Requests) != 0 {
+		t.Fatalf("Default: user values overridden (%v)", polkadot.Spec)
+	}
+
+	// an existing CR, e.g. created before the webhook, is not defaulted on update: its pods are not rolled
+	polkadot = &Polkadot{ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.Now()}, Spec: PolkadotSpec{Kind: KindSentry}}
+	polkadot.Default()
+	if polkadot.Spec.ClientVersion != "" || polkadot.Spec.Sentry.Replicas != 0 || !isEmptyResources(polkadot.Spec.Sentry.Resources) {
+		t.Fatalf("Default: the existing CR was defaulted (%v)", polkadot.Spec)
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name          string
+		mutate        func(polkadot *Polkadot)
+		expectedField string
+	}{
+		{
+			name:   "Polkadot valid",
+			mutate: func(polkadot *Polkadot) {},
+		},
+		{
+			name: "Polkadot unknown kind",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = "SentryOnly"
+			},
+			expectedField: "spec.kind",
+		},
+		{
+			name: "Polkadot negative replicas",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Replicas = -1
+			},
+			expectedField: "spec.sentry.replicas",
+		},
+		{
+			name: "Polkadot invalid node key",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.NodeKey = "0021"
+			},
+			expectedField: "spec.validator.nodeKey",
+		},
+		{
+			name: "Polkadot generated node key",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.NodeKey = ""
+			},
+		},
+		{
+			name: "Polkadot node key and node key Secret",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.NodeKeySecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "validator-key"}, Key: "nodeKey"}
+			},
+			expectedField: "spec.validator.nodeKeySecretRef",
+		},
+		{
+			name: "Polkadot node key Secret without key",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.NodeKey = ""
+				polkadot.Spec.Validator.NodeKeySecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "validator-key"}}
+			},
+			expectedField: "spec.validator.nodeKeySecretRef.key",
+		},
+		{
+			name: "Polkadot node key shared by the sentries",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Replicas = 2
+			},
+			expectedField: "spec.sentry.nodeKey",
+		},
+		{
+			name: "Polkadot node key Secret shared by the sentries",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Replicas = 2
+				polkadot.Spec.Sentry.NodeKey = ""
+				polkadot.Spec.Sentry.NodeKeySecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "sentry-key"}, Key: "nodeKey"}
+			},
+			expectedField: "spec.sentry.nodeKeySecretRef",
+		},
+		{
+			name: "Polkadot node keys generated for the sentries",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Replicas = 2
+				polkadot.Spec.Sentry.NodeKey = ""
+			},
+		},
+		{
+			name: "Polkadot invalid reserved sentry ID",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.ReservedSentryID = "sentry"
+			},
+			expectedField: "spec.validator.reservedSentryID",
+		},
+		{
+			name: "Polkadot reserved IDs derived",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.ReservedValidatorID = ""
+				polkadot.Spec.Validator.ReservedSentryID = ""
+			},
+		},
+		{
+			name: "Polkadot sentry LoadBalancer",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Service = ServiceSpec{
+					Type:                     corev1.ServiceTypeLoadBalancer,
+					ExternalTrafficPolicy:    corev1.ServiceExternalTrafficPolicyTypeLocal,
+					NodePort:                 30333,
+					LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+				}
+			},
+		},
+		{
+			name: "Polkadot invalid load balancer source range",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Service.Type = corev1.ServiceTypeLoadBalancer
+				polkadot.Spec.Sentry.Service.LoadBalancerSourceRanges = []string{"10.0.0.1"}
+			},
+			expectedField: "spec.sentry.service.loadBalancerSourceRanges[0]",
+		},
+		{
+			name: "Polkadot Service annotation managed by the operator",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Service.Annotations = map[string]string{"polkadot.swisscomblockchain.com/managed-annotations": "a"}
+			},
+			expectedField: "spec.sentry.service.annotations[polkadot.swisscomblockchain.com/managed-annotations]",
+		},
+		{
+			name: "Polkadot node port on a ClusterIP Service",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Service.Type = corev1.ServiceTypeClusterIP
+				polkadot.Spec.Sentry.Service.NodePort = 30333
+			},
+			expectedField: "spec.sentry.service.nodePort",
+		},
+		{
+			name: "Polkadot public address support on a ClusterIP Service",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.PublicAddressSupport.Enabled = true
+				polkadot.Spec.Sentry.Service.Type = corev1.ServiceTypeClusterIP
+			},
+			expectedField: "spec.sentry.service.type",
+		},
+		{
+			name: "Polkadot public address support with a fixed node port",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.PublicAddressSupport.Enabled = true
+				polkadot.Spec.Sentry.Service.NodePort = 30333
+			},
+			expectedField: "spec.sentry.service.nodePort",
+		},
+		{
+			name: "Polkadot public address support on LoadBalancer Services",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.PublicAddressSupport.Enabled = true
+				polkadot.Spec.Sentry.Service.Type = corev1.ServiceTypeLoadBalancer
+			},
+		},
+		{
+			name: "Polkadot known chain",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Chain.Name = ChainWestend
+			},
+		},
+		{
+			name: "Polkadot unknown chain",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Chain.Name = "polkadot-dev"
+			},
+			expectedField: "spec.chain.name",
+		},
+		{
+			name: "Polkadot chain spec from several sources",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Chain.Name = ChainRococo
+				polkadot.Spec.Chain.URL = "https://example.com/spec.json"
+			},
+			expectedField: "spec.chain",
+		},
+		{
+			name: "Polkadot chain spec URL not http",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Chain.URL = "ftp://example.com/spec.json"
+			},
+			expectedField: "spec.chain.url",
+		},
+		{
+			name: "Polkadot chain spec ConfigMap without key",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Chain.ConfigMapRef = &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "testnet"}}
+			},
+			expectedField: "spec.chain.configMapRef.key",
+		},
+		{
+			name: "Polkadot overrides",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.ExtraArgs = []string{"--in-peers=50", "--log", "sync=debug"}
+				polkadot.Spec.Sentry.Env = []corev1.EnvVar{{Name: "RUST_BACKTRACE", Value: "1"}}
+				polkadot.Spec.Validator.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"metadata":{"labels":{"team":"ops"}},"spec":{"nodeSelector":{"pool":"validators"}}}`)}
+			},
+		},
+		{
+			name: "Polkadot managed flag as extra arg",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.ExtraArgs = []string{"--in-peers=50", "--rpc-cors=all"}
+			},
+			expectedField: "spec.validator.extraArgs[1]",
+		},
+		{
+			name: "Polkadot managed env",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Env = []corev1.EnvVar{{Name: "POD_NAME", Value: "sentry"}}
+			},
+			expectedField: "spec.sentry.env[0].name",
+		},
+		{
+			name: "Polkadot pod template patching the containers",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"containers":[{"name":"polkadot","image":"other"}]}}`)}
+			},
+			expectedField: "spec.sentry.podTemplate.spec.containers",
+		},
+		{
+			name: "Polkadot pod template patching a selector label",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"metadata":{"labels":{"role":"validator"}}}`)}
+			},
+			expectedField: "spec.sentry.podTemplate.metadata.labels[role]",
+		},
+		{
+			name: "Polkadot pod template not a pod template",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"nodeSelector":"pool"}}`)}
+			},
+			expectedField: "spec.sentry.podTemplate",
+		},
+		{
+			name: "Polkadot unknown RPC mode",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.RPC.Mode = "external"
+			},
+			expectedField: "spec.validator.rpc.mode",
+		},
+		{
+			name: "Polkadot unsafe methods on safe endpoints",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.RPC = RPCSpec{Mode: RPCModeSafeExternal, Methods: RPCMethodsUnsafe}
+			},
+			expectedField: "spec.sentry.rpc.methods",
+		},
+		{
+			name: "Polkadot CORS all with other origins",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.RPC.CORS = []string{"all", "https://polkadot.js.org"}
+			},
+			expectedField: "spec.sentry.rpc.cors[0]",
+		},
+		{
+			name: "Polkadot metrics of a node with the RPC disabled",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.MetricsSupport.Enabled = true
+				polkadot.Spec.Validator.RPC.Mode = RPCModeDisabled
+			},
+			expectedField: "spec.validator.rpc.mode",
+		},
+		{
+			name: "Polkadot unsafe RPC",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.RPC = RPCSpec{Mode: RPCModeUnsafeExternal, CORS: []string{"all"}}
+				polkadot.Spec.Validator.RPC = RPCSpec{Mode: RPCModeLocalOnly, Methods: RPCMethodsSafe}
+			},
+		},
+		{
+			name: "Polkadot validator exposed behind the sentries",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.Service.Type = corev1.ServiceTypeNodePort
+			},
+			expectedField: "spec.validator.service.type",
+		},
+		{
+			name: "Polkadot standalone validator exposed",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = KindValidator
+				polkadot.Spec.Validator.Service.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+			},
+		},
+		{
+			name: "Polkadot database settings",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Database = DatabaseSpec{Pruning: "1000", StateCacheSize: 1073741824, DBCache: 1024, Backend: DatabaseBackendParityDB}
+				polkadot.Spec.Validator.Database = DatabaseSpec{Pruning: PruningArchive}
+			},
+		},
+		{
+			name: "Polkadot invalid pruning",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Database.Pruning = "full"
+			},
+			expectedField: "spec.sentry.database.pruning",
+		},
+		{
+			name: "Polkadot negative db cache",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.Database.DBCache = -1
+			},
+			expectedField: "spec.validator.database.dbCache",
+		},
+		{
+			name: "Polkadot unknown database backend",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.Database.Backend = "leveldb"
+			},
+			expectedField: "spec.validator.database.backend",
+		},
+		{
+			name: "Polkadot database flag in the extra args",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.ExtraArgs = []string{"--pruning=archive"}
+			},
+			expectedField: "spec.sentry.extraArgs",
+		},
+		{
+			name: "Polkadot restore from a URL",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.DataPersistenceSupport = DataPersistenceSupport{Enabled: true, RestoreFrom: &RestoreSource{URL: "https://snapshots.example.com/polkadot.tar.gz"}}
+			},
+		},
+		{
+			name: "Polkadot restore from S3",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.DataPersistenceSupport = DataPersistenceSupport{Enabled: true, RestoreFrom: &RestoreSource{S3: &S3Object{
+					S3Bucket: S3Bucket{Endpoint: "https://s3.example.com", Bucket: "snapshots", CredentialsSecretRef: &corev1.LocalObjectReference{Name: "s3-credentials"}},
+					Key:      "polkadot.tgz",
+				}}}
+			},
+		},
+		{
+			name: "Polkadot restore without data persistence",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom = &RestoreSource{VolumeSnapshotName: "snapshot"}
+			},
+			expectedField: "spec.sentry.dataPersistenceSupport.restoreFrom",
+		},
+		{
+			name: "Polkadot restore from several sources",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.DataPersistenceSupport = DataPersistenceSupport{Enabled: true, RestoreFrom: &RestoreSource{VolumeSnapshotName: "snapshot", PersistentVolumeClaimName: "claim"}}
+			},
+			expectedField: "spec.sentry.dataPersistenceSupport.restoreFrom",
+		},
+		{
+			name: "Polkadot restore from an unsupported archive",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.DataPersistenceSupport = DataPersistenceSupport{Enabled: true, RestoreFrom: &RestoreSource{URL: "https://snapshots.example.com/polkadot.tar.lz4"}}
+			},
+			expectedField: "spec.sentry.dataPersistenceSupport.restoreFrom.url",
+		},
+		{
+			name: "Polkadot restore from S3 without bucket",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.DataPersistenceSupport = DataPersistenceSupport{Enabled: true, RestoreFrom: &RestoreSource{S3: &S3Object{S3Bucket: S3Bucket{Endpoint: "https://s3.example.com"}, Key: "polkadot.tar"}}}
+			},
+			expectedField: "spec.validator.dataPersistenceSupport.restoreFrom.s3.bucket",
+		},
+		{
+			name: "Polkadot backup",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.Replicas = 2
+				polkadot.Spec.Sentry.NodeKey = ""
+				polkadot.Spec.Sentry.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Backup = getValidBackup()
+			},
+		},
+		{
+			name: "Polkadot backup of the only sentry of a validator",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Sentry.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Backup = getValidBackup()
+			},
+			expectedField: "spec.backup",
+		},
+		{
+			name: "Polkadot backup of a validator",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = KindValidator
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Backup = getValidBackup()
+			},
+			expectedField: "spec.backup",
+		},
+		{
+			name: "Polkadot backup with an invalid schedule",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = KindSentry
+				polkadot.Spec.Sentry.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Backup = getValidBackup()
+				polkadot.Spec.Backup.Schedule = "0 3 * *"
+			},
+			expectedField: "spec.backup.schedule",
+		},
+		{
+			name: "Polkadot backup without credentials",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = KindSentry
+				polkadot.Spec.Sentry.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Backup = getValidBackup()
+				polkadot.Spec.Backup.S3.CredentialsSecretRef = nil
+			},
+			expectedField: "spec.backup.s3.credentialsSecretRef",
+		},
+		{
+			name: "Polkadot backup with an invalid prefix",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = KindSentry
+				polkadot.Spec.Sentry.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Backup = getValidBackup()
+				polkadot.Spec.Backup.S3.Prefix = "backups/polkadot cr"
+			},
+			expectedField: "spec.backup.s3.prefix",
+		},
+		{
+			name: "Polkadot image",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Image = ImageSpec{
+					Repository:       "registry.example.com:5000/parity/polkadot",
+					Tag:              "v0.8.1",
+					Digest:           "sha256:" + strings.Repeat("a", 64),
+					PullPolicy:       corev1.PullAlways,
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry-credentials"}},
+				}
+			},
+		},
+		{
+			name: "Polkadot image repository with a tag",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Image.Repository = "parity/polkadot:v0.8.1"
+			},
+			expectedField: "spec.image.repository",
+		},
+		{
+			name: "Polkadot image with an invalid digest",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Image.Digest = "sha256:abc"
+			},
+			expectedField: "spec.image.digest",
+		},
+		{
+			name: "Polkadot image with an invalid clientVersion",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.ClientVersion = "v0.8.1@sha256"
+			},
+			expectedField: "spec.clientVersion",
+		},
+		{
+			name: "Polkadot image with an unknown pull policy",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Image.PullPolicy = "Sometimes"
+			},
+			expectedField: "spec.image.pullPolicy",
+		},
+		{
+			name: "Polkadot image pull secret without a name",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Image.ImagePullSecrets = []corev1.LocalObjectReference{{}}
+			},
+			expectedField: "spec.image.imagePullSecrets[0].name",
+		},
+		{
+			name: "Polkadot keystore",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+			},
+		},
+		{
+			name: "Polkadot keystore without a Secret",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{}
+			},
+			expectedField: "spec.validator.keystore.secretRef.name",
+		},
+		{
+			name: "Polkadot keystore path in the extra args",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.ExtraArgs = []string{"--keystore-path=/keys"}
+			},
+			expectedField: "spec.validator.extraArgs",
+		},
+		{
+			name: "Polkadot key rotation",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Annotations = map[string]string{RotateKeysAnnotation: "1"}
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+			},
+		},
+		{
+			name: "Polkadot key rotation without a validator",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Annotations = map[string]string{RotateKeysAnnotation: "1"}
+				polkadot.Spec.Kind = KindSentry
+			},
+			expectedField: "metadata.annotations[" + RotateKeysAnnotation + "]",
+		},
+		{
+			name: "Polkadot key rotation without the validator data persistence",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Annotations = map[string]string{RotateKeysAnnotation: "1"}
+			},
+			expectedField: "metadata.annotations[" + RotateKeysAnnotation + "]",
+		},
+		{
+			name: "Polkadot key rotation with the keystore of a Secret",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Annotations = map[string]string{RotateKeysAnnotation: "1"}
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+			},
+			expectedField: "metadata.annotations[" + RotateKeysAnnotation + "]",
+		},
+		{
+			name: "Polkadot staged upgrade",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Upgrade = &UpgradeSpec{TimeoutSeconds: 600, MaxBlocksBehind: 10}
+			},
+		},
+		{
+			name: "Polkadot staged upgrade with a negative timeout",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Upgrade = &UpgradeSpec{TimeoutSeconds: -1}
+			},
+			expectedField: "spec.upgrade.timeoutSeconds",
+		},
+		{
+			name: "Polkadot staged upgrade with a negative distance to the chain head",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Upgrade = &UpgradeSpec{MaxBlocksBehind: -1}
+			},
+			expectedField: "spec.upgrade.maxBlocksBehind",
+		},
+		{
+			name: "Polkadot validator standby",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.MetricsSupport.Enabled = true
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+				polkadot.Spec.Validator.Standby = &StandbySpec{FailoverAfterSeconds: 600}
+			},
+		},
+		{
+			name: "Polkadot validator standby with an external validator RPC",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.RPC.Mode = RPCModeSafeExternal
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+				polkadot.Spec.Validator.Standby = &StandbySpec{}
+			},
+		},
+		{
+			name: "Polkadot validator standby whose chain synchronization can't be checked",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+				polkadot.Spec.Validator.Standby = &StandbySpec{}
+			},
+			expectedField: "spec.validator.standby",
+		},
+		{
+			name: "Polkadot validator standby without the keystore of a Secret",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.MetricsSupport.Enabled = true
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Validator.Standby = &StandbySpec{}
+			},
+			expectedField: "spec.validator.standby",
+		},
+		{
+			name: "Polkadot validator standby without the validator data persistence",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+				polkadot.Spec.Validator.Standby = &StandbySpec{}
+			},
+			expectedField: "spec.validator.standby",
+		},
+		{
+			name: "Polkadot validator standby with a negative failover time",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+				polkadot.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+				polkadot.Spec.Validator.Standby = &StandbySpec{FailoverAfterSeconds: -1}
+			},
+			expectedField: "spec.validator.standby.failoverAfterSeconds",
+		},
+		{
+			name: "Polkadot reserved ID not required by the kind",
+			mutate: func(polkadot *Polkadot) {
+				polkadot.Spec.Kind = KindValidator
+				polkadot.Spec.Validator.ReservedSentryID = ""
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			polkadot := getValidPolkadot()
+			test.mutate(polkadot)
+
+			err := polkadot.ValidateCreate()
+			if test.expectedField == "" && err != nil {
+				t.Fatalf("ValidateCreate: (%v)", err)
+			}
+			if test.expectedField != "" && (err == nil || !strings.Contains(err.Error(), test.expectedField)) {
+				t.Fatalf("ValidateCreate: expected an error on %v, found (%v)", test.expectedField, err)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	old := getValidPolkadot()
+	old.Spec.Validator.DataPersistenceSupport.Enabled = true
+
+	polkadot := old.DeepCopy()
+	polkadot.Spec.ClientVersion = "v0.8.0"
+	if err := polkadot.ValidateUpdate(old); err != nil {
+		t.Fatalf("ValidateUpdate: (%v)", err)
+	}
+
+	polkadot = old.DeepCopy()
+	polkadot.Spec.Validator.DataPersistenceSupport.PersistentVolumeClaim.Spec.StorageClassName = &[]string{"fast"}[0]
+	err := polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.dataPersistenceSupport.persistentVolumeClaim") {
+		t.Fatalf("ValidateUpdate: expected the template change to be rejected (%v)", err)
+	}
+
+	polkadot = old.DeepCopy()
+	polkadot.Spec.Validator.DataPersistenceSupport.Enabled = false
+	err = polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.dataPersistenceSupport.enabled") {
+		t.Fatalf("ValidateUpdate: expected the data persistence toggle to be rejected (%v)", err)
+	}
+
+	// the database of an existing volume can't be switched between archive and pruned without a resync
+	old.Spec.Validator.Database = DatabaseSpec{Pruning: "1000"}
+	polkadot = old.DeepCopy()
+	polkadot.Spec.Validator.Database.Pruning = "2000"
+	if err := polkadot.ValidateUpdate(old); err != nil {
+		t.Fatalf("ValidateUpdate: (%v)", err)
+	}
+
+	polkadot.Spec.Validator.Database.Pruning = PruningArchive
+	err = polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.database.pruning") {
+		t.Fatalf("ValidateUpdate: expected the pruning switch to be rejected (%v)", err)
+	}
+
+	polkadot = old.DeepCopy()
+	polkadot.Spec.Validator.Database.Backend = DatabaseBackendParityDB
+	err = polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.database.backend") {
+		t.Fatalf("ValidateUpdate: expected the backend switch to be rejected (%v)", err)
+	}
+
+	polkadot.Spec.Validator.Database.Pruning = PruningArchive
+	polkadot.Spec.Validator.Database.Resync = "1"
+	if err := polkadot.ValidateUpdate(old); err != nil {
+		t.Fatalf("ValidateUpdate: expected the switches to be accepted with a resync (%v)", err)
+	}
+
+	// the standby mode can't be disabled while the session keys are on the standby StatefulSet
+	old.Spec.Validator.Keystore = &KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+	old.Spec.Validator.Standby = &StandbySpec{}
+	old.Status.Standby = &StandbyStatus{Active: ValidatorNodePrimary}
+	polkadot = old.DeepCopy()
+	polkadot.Spec.Validator.Standby = nil
+	if err := polkadot.ValidateUpdate(old); err != nil {
+		t.Fatalf("ValidateUpdate: (%v)", err)
+	}
+
+	old.Status.Standby.Active = ValidatorNodeSecondary
+	err = polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.standby") {
+		t.Fatalf("ValidateUpdate: expected the standby removal to be rejected (%v)", err)
+	}
+
+	old.Status.Standby = &StandbyStatus{Active: ValidatorNodePrimary, Phase: StandbyPhaseFailingOver}
+	err = polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.standby") {
+		t.Fatalf("ValidateUpdate: expected the standby removal to be rejected during a failover (%v)", err)
+	}
+
+	old.Status.Standby.Phase = StandbyPhaseFailoverStuck
+	err = polkadot.ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "spec.validator.standby") {
+		t.Fatalf("ValidateUpdate: expected the standby removal to be rejected during a stuck failover (%v)", err)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"time"
+)
+
+func (r *ReconcilerPolkadot) handleBackup(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerBackup(CRInstance)
+	return handler.handleBackupSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerBackup(CRInstance *polkadotv1alpha1.Polkadot) IHandlerBackup {
+	if CRKind(CRInstance.Spec.Kind) == Validator {
+		return &handlerBackupValidator{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == Sentry {
+		return &handlerBackupSentry{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerBackupSentryAndValidator{}
+	}
+	return &handlerBackupDefault{}
+}
+
+//pattern Strategy
+type IHandlerBackup interface {
+	handleBackupSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerBackupValidator struct {
+}
+
+func (h *handlerBackupValidator) handleBackupSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	// the validator is never stopped for a backup, a CronJob left by a previous kind is deleted
+	return r.handleBackupGeneric(CRInstance, nil)
+}
+
+type handlerBackupSentry struct {
+}
+
+func (h *handlerBackupSentry) handleBackupSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if CRInstance.Spec.Backup == nil {
+		return r.handleBackupGeneric(CRInstance, nil)
+	}
+	return r.handleBackupGeneric(CRInstance, newCronJobBackup(CRInstance))
+}
+
+type handlerBackupSentryAndValidator struct {
+}
+
+func (h *handlerBackupSentryAndValidator) handleBackupSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return (&handlerBackupSentry{}).handleBackupSpecific(r, CRInstance)
+}
+
+type handlerBackupDefault struct {
+}
+
+func (h *handlerBackupDefault) handleBackupSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return handleSkip()
+}
+
+// handleBackupGeneric creates or updates the backup CronJob, a nil desired CronJob deletes the one controlled by the CustomResource.
+// The Jobs of a deleted CronJob are garbage collected, a running backup is stopped
+func (r *ReconcilerPolkadot) handleBackupGeneric(CRInstance *polkadotv1alpha1.Polkadot, desiredResource *batchv1beta1.CronJob) (bool, error) {
+
+	name := GetBackupCronJobName(CRInstance.Name)
+	logger := log.WithValues("CronJob.Namespace", CRInstance.Namespace, "CronJob.Name", name)
+
+	toBeFoundResource := &batchv1beta1.CronJob{}
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the backup CronJob...")
+		return NotForcedRequeue, err
+	}
+
+	if desiredResource == nil {
+		if isNotFound == true || !metav1.IsControlledBy(toBeFoundResource, CRInstance) {
+			return NotForcedRequeue, nil
+		}
+		logger.Info("Deleting the backup CronJob not required anymore...")
+		err := r.deleteResource(toBeFoundResource, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil {
+			logger.Error(err, "Error on deleting the backup CronJob...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the backup CronJob")
+		return ForcedRequeue, nil
+	}
+
+	if isNotFound == true {
+		logger.Info("Backup CronJob not found...")
+		logger.Info("Creating a new backup CronJob...")
+		err := r.createResource(desiredResource, CRInstance)
+		if err != nil {
+			logger.Error(err, "Error on creating a new backup CronJob...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Created the new backup CronJob")
+		return ForcedRequeue, nil
+	}
+	foundResource := toBeFoundResource
+
+	if foundResource.Annotations[specHashAnnotation] != desiredResource.Annotations[specHashAnnotation] {
+		logger.Info("Found a backup CronJob hash mismatch...", "Current", foundResource.Annotations[specHashAnnotation], "Desired", desiredResource.Annotations[specHashAnnotation])
+		updated := foundResource.DeepCopy()
+		updated.Labels = mergeMaps(updated.Labels, desiredResource.Labels)
+		updated.Annotations = mergeMaps(updated.Annotations, desiredResource.Annotations)
+		updated.Spec = desiredResource.Spec
+		logger.Info("Updating the backup CronJob...")
+		err := r.updateResource(updated)
+		if err != nil {
+			logger.Error(err, "Update backup CronJob Error...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Updated the backup CronJob...")
+	}
+
+	return NotForcedRequeue, nil
+}
+
+// fetchBackupJobs returns the Jobs created by the backup CronJob
+func (r *ReconcilerPolkadot) fetchBackupJobs(CRInstance *polkadotv1alpha1.Polkadot) ([]batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	err := r.client.List(context.TODO(), jobs, client.InNamespace(CRInstance.Namespace), client.MatchingLabels(getBackupLabels(CRInstance.Name)))
+	if err != nil {
+		return nil, err
+	}
+	return jobs.Items, nil
+}
+
+// isBackupRunning tells if the sentry backed up has to be stopped, i.e. a backup Job is neither complete nor failed
+func (r *ReconcilerPolkadot) isBackupRunning(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if CRInstance.Spec.Backup == nil {
+		return false, nil
+	}
+	jobs, err := r.fetchBackupJobs(CRInstance)
+	if err != nil {
+		return false, err
+	}
+	for i := range jobs {
+		if getBackupPhase(&jobs[i]) == polkadotv1alpha1.BackupPhaseRunning {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// newStatefulSetSentryBackedUp returns the sentry StatefulSet without the sentry backed up while a backup is running,
+// the scale down releases the data claim mounted by the backup Job
+func (r *ReconcilerPolkadot) newStatefulSetSentryBackedUp(CRInstance *polkadotv1alpha1.Polkadot, validatorReservedNodes []string) (*appsv1.StatefulSet, error) {
+	statefulSet := newStatefulSetSentry(CRInstance, validatorReservedNodes)
+	isRunning, err := r.isBackupRunning(CRInstance)
+	if err != nil {
+		log.Error(err, "Error on fetch the backup Jobs...", "Polkadot.Name", CRInstance.Name)
+		return nil, err
+	}
+	if isRunning && *statefulSet.Spec.Replicas > 0 {
+		replicas := *statefulSet.Spec.Replicas - 1
+		statefulSet.Spec.Replicas = &replicas
+	}
+	return statefulSet, nil
+}
+
+// fetchBackupsStatus merges the observed backup Jobs into the previous history, so that the backups outlive the Jobs
+// deleted by the CronJob. The history keeps the retained successful backups and the most recent other ones
+func (r *ReconcilerPolkadot) fetchBackupsStatus(CRInstance *polkadotv1alpha1.Polkadot, previous []polkadotv1alpha1.BackupStatus) ([]polkadotv1alpha1.BackupStatus, error) {
+	if CRInstance.Spec.Backup == nil {
+		return nil, nil
+	}
+	jobs, err := r.fetchBackupJobs(CRInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make(map[string]polkadotv1alpha1.BackupStatus)
+	for _, backup := range previous {
+		backups[backup.Name] = backup
+	}
+	for i := range jobs {
+		backups[jobs[i].Name] = getBackupStatus(CRInstance, &jobs[i])
+	}
+
+	var sorted []polkadotv1alpha1.BackupStatus
+	for _, backup := range backups {
+		sorted = append(sorted, backup)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !getBackupTime(sorted[i]).Equal(getBackupTime(sorted[j])) {
+			return getBackupTime(sorted[j]).Before(getBackupTime(sorted[i]))
+		}
+		return sorted[i].Name > sorted[j].Name
+	})
+
+	var history []polkadotv1alpha1.BackupStatus
+	succeeded, others := int32(0), int32(0)
+	for _, backup := range sorted {
+		if backup.Phase == polkadotv1alpha1.BackupPhaseSucceeded {
+			if succeeded >= CRInstance.GetBackupRetention() {
+				continue
+			}
+			succeeded++
+		} else {
+			if others >= backupJobsHistoryLimit {
+				continue
+			}
+			others++
+		}
+		history = append(history, backup)
+	}
+	return history, nil
+}
+
+func getBackupStatus(CRInstance *polkadotv1alpha1.Polkadot, job *batchv1.Job) polkadotv1alpha1.BackupStatus {
+	startTime := job.CreationTimestamp
+	if job.Status.StartTime != nil {
+		startTime = *job.Status.StartTime
+	}
+	backup := polkadotv1alpha1.BackupStatus{
+		Name:      job.Name,
+		Phase:     getBackupPhase(job),
+		S3:        getBackupS3Object(CRInstance, job.Name),
+		StartTime: &startTime,
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue && (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) {
+			completionTime := condition.LastTransitionTime
+			backup.CompletionTime = &completionTime
+		}
+	}
+	return backup
+}
+
+func getBackupPhase(job *batchv1.Job) polkadotv1alpha1.BackupPhase {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		if condition.Type == batchv1.JobComplete {
+			return polkadotv1alpha1.BackupPhaseSucceeded
+		}
+		if condition.Type == batchv1.JobFailed {
+			return polkadotv1alpha1.BackupPhaseFailed
+		}
+	}
+	return polkadotv1alpha1.BackupPhaseRunning
+}
+
+func getBackupTime(backup polkadotv1alpha1.BackupStatus) time.Time {
+	if backup.StartTime == nil {
+		return time.Time{}
+	}
+	return backup.StartTime.Time
+}
+
+// getBackupJobRequests maps a backup Job to the CustomResource of its instance label, the Jobs are owned by the CronJob
+func getBackupJobRequests(object handler.MapObject) []reconcile.Request {
+	labels := object.Meta.GetLabels()
+	if labels["role"] != backupRole || labels[instanceLabel] == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: labels[instanceLabel], Namespace: object.Meta.GetNamespace()}}}
+}
+
+// isAnyBackupRunning tells if the status of a backup is expected to change without a change of the CustomResource
+func isAnyBackupRunning(backups []polkadotv1alpha1.BackupStatus) bool {
+	for _, backup := range backups {
+		if backup.Phase == polkadotv1alpha1.BackupPhaseRunning {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+package polkadot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"io"
+	"io/ioutil"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func getFakeBackupJob(name string, startTime time.Time, conditionType batchv1.JobConditionType) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: getBackupLabels(CRName)},
+		Status:     batchv1.JobStatus{StartTime: &metav1.Time{Time: startTime}},
+	}
+	if conditionType != "" {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+	}
+	return job
+}
+
+func getBackupScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Errorf("appsv1.AddToScheme: %v", err)
+	}
+	if err := batchv1.AddToScheme(scheme); err != nil {
+		t.Errorf("batchv1.AddToScheme: %v", err)
+	}
+	if err := batchv1beta1.AddToScheme(scheme); err != nil {
+		t.Errorf("batchv1beta1.AddToScheme: %v", err)
+	}
+	return scheme
+}
+
+func TestHandleBackup(t *testing.T) {
+	scheme := getBackupScheme(t)
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeBackup())
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	isRequeueForced, err := reconciler.handleBackup(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleBackup: (%v)", err)
+	}
+	cronJob := &batchv1beta1.CronJob{}
+	cronJobName := GetBackupCronJobName(CRName)
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: cronJobName}, cronJob); err != nil {
+		t.Fatalf("handleBackup: (%v)", err)
+	}
+	if cronJob.Spec.Schedule != "0 3 * * *" || cronJob.Spec.ConcurrencyPolicy != batchv1beta1.ForbidConcurrent {
+		t.Fatalf("handleBackup: unexpected CronJob (%v)", cronJob.Spec)
+	}
+	isRequeueForced, err = reconciler.handleBackup(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleBackup: (%v)", err)
+	}
+
+	// a change of the spec updates the CronJob
+	polkadot.Spec.Backup.Suspend = true
+	if _, err := reconciler.handleBackup(polkadot); err != nil {
+		t.Fatalf("handleBackup: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: cronJobName}, cronJob)
+	if !*cronJob.Spec.Suspend {
+		t.Fatalf("handleBackup: the CronJob was not updated (%v)", cronJob.Spec)
+	}
+
+	// the CronJob is deleted once the backups are disabled
+	polkadot.Spec.Backup = nil
+	isRequeueForced, err = reconciler.handleBackup(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleBackup: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: cronJobName}, &batchv1beta1.CronJob{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the backup CronJob was not deleted: (%v)", err)
+	}
+}
+
+func TestNewCronJobBackup(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeBackup())
+
+	podSpec := newCronJobBackup(polkadot).Spec.JobTemplate.Spec.Template.Spec
+	claim := podSpec.Volumes[0].PersistentVolumeClaim
+	if claim == nil || claim.ClaimName != "polkadot-volume-"+GetSentryStatefulSetName(CRName)+"-1" {
+		t.Fatalf("newCronJobBackup: unexpected volumes (%v)", podSpec.Volumes)
+	}
+	term := podSpec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+	if term.LabelSelector.MatchLabels[appsv1.StatefulSetPodNameLabel] != GetSentryStatefulSetName(CRName)+"-1" {
+		t.Fatalf("newCronJobBackup: unexpected affinity (%v)", podSpec.Affinity)
+	}
+	container := podSpec.Containers[0]
+	script := container.Command[2]
+	if !container.VolumeMounts[0].ReadOnly || container.Env[0].Value != "http://minio:9000/backups" || container.Env[1].Value != CRName {
+		t.Fatalf("newCronJobBackup: unexpected container (%v)", container)
+	}
+	if !strings.Contains(script, "tar -czf /backup/db.tar.gz $dbs") || !strings.Contains(script, `-T /backup/db.tar.gz "$S3_BUCKET_URL/$key"`) {
+		t.Fatalf("newCronJobBackup: unexpected script (%v)", script)
+	}
+}
+
+// getFakeS3 serves the S3 requests of the backup script on /<bucket>: PUT and DELETE of an object, and the ListObjectsV2
+// of a prefix. The requests must be signed with the AWS Signature Version 4 of the access key
+func getFakeS3(bucket string, accessKey string, objects map[string][]byte) *httptest.Server {
+	var mutex sync.Mutex
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+accessKey+"/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/us-east-1/s3/aws4_request") {
+			http.Error(w, "unsigned request", http.StatusForbidden)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")
+		switch {
+		case r.Method == http.MethodPut:
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			objects[key] = body
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && r.URL.Path == "/"+bucket && r.URL.Query().Get("list-type") == "2":
+			fmt.Fprint(w, "<ListBucketResult>")
+			for name := range objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", name)
+				}
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// TestBackupScript runs the script of the backup container against a fake S3 with bash, the container runs it with the sh
+// of curlimages/curl: both support pipefail
+func TestBackupScript(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not installed")
+	}
+	if help, err := exec.Command("curl", "--help", "all").Output(); err != nil || !strings.Contains(string(help), "--aws-sigv4") {
+		t.Skip("curl is not installed or doesn't support --aws-sigv4")
+	}
+
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeBackup())
+	prefix := polkadot.GetBackupPrefix()
+	objects := map[string][]byte{
+		prefix + "/polkadot-cr-backup-1000.tar.gz": []byte("oldest"),
+		prefix + "/polkadot-cr-backup-2000.tar.gz": []byte("old"),
+		prefix + "/notes.txt":                      []byte("not an archive"),
+	}
+	server := getFakeS3("backups", "access-key", objects)
+	defer server.Close()
+	polkadot.Spec.Backup.S3.Endpoint = server.URL
+
+	directory, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatalf("TempDir: (%v)", err)
+	}
+	defer os.RemoveAll(directory)
+	data := filepath.Join(directory, "data")
+	scratch := filepath.Join(directory, "scratch")
+	for _, dir := range []string{filepath.Join(data, "chains", "ksmcc3", "db"), filepath.Join(data, "chains", "ksmcc3", "keystore"), scratch} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll: (%v)", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(data, "chains", "ksmcc3", "db", "CURRENT"), []byte("MANIFEST-000001"), 0644); err != nil {
+		t.Fatalf("WriteFile: (%v)", err)
+	}
+
+	container := newCronJobBackup(polkadot).Spec.JobTemplate.Spec.Template.Spec.Containers[0]
+	script := strings.NewReplacer("cd "+volumeMountPath+";", "cd "+data+";", backupScratchMountPath+"/", scratch+"/").Replace(container.Command[2])
+	cmd := exec.Command("bash", "-c", script)
+	cmd.Env = append(os.Environ(),
+		"S3_BUCKET_URL="+container.Env[0].Value,
+		"BACKUP_PREFIX="+prefix,
+		"RETENTION=2",
+		"JOB_NAME=polkadot-cr-backup-3000",
+		"AWS_ACCESS_KEY_ID=access-key",
+		"AWS_SECRET_ACCESS_KEY=secret-key",
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("the backup script failed: (%v) %s", err, output)
+	}
+
+	archive, isFound := objects[prefix+"/polkadot-cr-backup-3000.tar.gz"]
+	if !isFound {
+		t.Fatalf("the archive was not uploaded: (%v)", objects)
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("the archive is not gzipped: (%v)", err)
+	}
+	var files []string
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("the archive is not a tar: (%v)", err)
+		}
+		files = append(files, header.Name)
+	}
+	if !reflect.DeepEqual(files, []string{"chains/ksmcc3/db/", "chains/ksmcc3/db/CURRENT"}) {
+		t.Fatalf("the archive doesn't hold the chain database only: (%v)", files)
+	}
+
+	// the retention keeps the two most recent archives, the other objects of the prefix are not deleted
+	if _, isFound := objects[prefix+"/polkadot-cr-backup-1000.tar.gz"]; isFound || len(objects) != 3 {
+		t.Fatalf("the retention was not applied: (%v)", objects)
+	}
+}
+
+func TestBackupStopsSentry(t *testing.T) {
+	scheme := getBackupScheme(t)
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeBackup())
+	job := getFakeBackupJob(CRName+"-backup-1", time.Now(), "")
+
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, job)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	if _, err := reconciler.handleStatefulSet(polkadot); err != nil {
+		t.Fatalf("handleStatefulSet: (%v)", err)
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, statefulSet)
+	if *statefulSet.Spec.Replicas != 1 {
+		t.Fatalf("handleStatefulSet: the sentry was not stopped (%v)", *statefulSet.Spec.Replicas)
+	}
+
+	// the sentry is restarted once the backup is complete
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := client.Update(context.TODO(), job); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	if _, err := reconciler.handleStatefulSet(polkadot); err != nil {
+		t.Fatalf("handleStatefulSet: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, statefulSet)
+	if *statefulSet.Spec.Replicas != 2 {
+		t.Fatalf("handleStatefulSet: the sentry was not restarted (%v)", *statefulSet.Spec.Replicas)
+	}
+}
+
+func TestFetchBackupsStatus(t *testing.T) {
+	scheme := getBackupScheme(t)
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeBackup())
+	now := time.Now()
+
+	client := fake.NewFakeClientWithScheme(scheme, polkadot,
+		getFakeBackupJob(CRName+"-backup-3", now, ""),
+		getFakeBackupJob(CRName+"-backup-2", now.Add(-time.Hour), batchv1.JobComplete),
+		getFakeBackupJob(CRName+"-backup-1", now.Add(-2*time.Hour), batchv1.JobFailed),
+	)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	// the backups of the Jobs deleted by the CronJob are kept up to the retention
+	previous := []polkadotv1alpha1.BackupStatus{
+		{Name: CRName + "-backup-0", Phase: polkadotv1alpha1.BackupPhaseSucceeded, StartTime: &metav1.Time{Time: now.Add(-3 * time.Hour)}},
+		{Name: CRName + "-backup-00", Phase: polkadotv1alpha1.BackupPhaseSucceeded, StartTime: &metav1.Time{Time: now.Add(-4 * time.Hour)}},
+	}
+	backups, err := reconciler.fetchBackupsStatus(polkadot, previous)
+	if err != nil {
+		t.Fatalf("fetchBackupsStatus: (%v)", err)
+	}
+	var names []string
+	for _, backup := range backups {
+		names = append(names, backup.Name)
+	}
+	expected := []string{CRName + "-backup-3", CRName + "-backup-2", CRName + "-backup-1", CRName + "-backup-0"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("fetchBackupsStatus: unexpected backups (%v)", names)
+	}
+	if backups[0].Phase != polkadotv1alpha1.BackupPhaseRunning || backups[1].S3.Key != CRName+"/"+CRName+"-backup-2.tar.gz" || backups[1].CompletionTime == nil {
+		t.Fatalf("fetchBackupsStatus: unexpected backups (%v)", backups)
+	}
+	if !isAnyBackupRunning(backups) {
+		t.Fatalf("isAnyBackupRunning: the running backup was not detected")
+	}
+}
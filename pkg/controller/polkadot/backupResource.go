@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+)
+
+// newCronJobBackup returns the CronJob backing up the sentry with the highest ordinal. The pod of a backup Job mounts the
+// data claim of the sentry: it is not scheduled on the node of the running sentry, the operator stops the sentry while the Job runs
+func newCronJobBackup(CRInstance *polkadotv1alpha1.Polkadot) *batchv1beta1.CronJob {
+	backup := CRInstance.Spec.Backup
+	labels := getBackupLabels(CRInstance.Name)
+	targetPodName := getBackupTargetPodName(CRInstance)
+	dataPersistence := CRInstance.Spec.Sentry.DataPersistenceSupport
+	dataVolumeName := dataPersistence.PersistentVolumeClaim.ObjectMeta.Name
+	suspend := backup.Suspend
+	activeDeadlineSeconds := backupActiveDeadlineSeconds
+	backoffLimit := backupBackoffLimit
+	historyLimit := backupJobsHistoryLimit
+
+	container := corev1.Container{
+		Name:    backupContainerName,
+		Image:   restoreDownloadImage,
+		Command: []string{"sh", "-c", getBackupScript(backup.S3.S3Bucket)},
+		Env: append([]corev1.EnvVar{
+			{Name: "S3_BUCKET_URL", Value: getS3BucketURL(backup.S3.S3Bucket)},
+			{Name: "BACKUP_PREFIX", Value: CRInstance.GetBackupPrefix()},
+			{Name: "RETENTION", Value: strconv.Itoa(int(CRInstance.GetBackupRetention()))},
+			{Name: "JOB_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.labels['" + jobNameLabel + "']"}}},
+		}, getS3CredentialsEnv(backup.S3.CredentialsSecretRef.Name)...),
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: dataVolumeName, MountPath: volumeMountPath, ReadOnly: true},
+			{Name: backupScratchVolumeName, MountPath: backupScratchMountPath},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	podSpec := corev1.PodSpec{
+		SecurityContext: getPodSecurityContext(),
+		RestartPolicy:   corev1.RestartPolicyNever,
+		Containers:      []corev1.Container{container},
+		Volumes: []corev1.Volume{
+			{
+				Name: dataVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: getDataClaimName(dataVolumeName, targetPodName)},
+				},
+			},
+			{Name: backupScratchVolumeName, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		},
+		Affinity: &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{appsv1.StatefulSetPodNameLabel: targetPodName}},
+					TopologyKey:   corev1.LabelHostname,
+				}},
+			},
+		},
+	}
+
+	cronJob := &batchv1beta1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetBackupCronJobName(CRInstance.Name),
+			Namespace: CRInstance.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:                   backup.Schedule,
+			Suspend:                    &suspend,
+			ConcurrencyPolicy:          batchv1beta1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: &historyLimit,
+			FailedJobsHistoryLimit:     &historyLimit,
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: batchv1.JobSpec{
+					ActiveDeadlineSeconds: &activeDeadlineSeconds,
+					BackoffLimit:          &backoffLimit,
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec:       podSpec,
+					},
+				},
+			},
+		},
+	}
+	cronJob.Annotations = map[string]string{specHashAnnotation: getSpecHash(&cronJob.Spec)}
+	return cronJob
+}
+
+// getBackupScript archives the chain databases of the data volume and uploads the archive to <prefix>/<Job name>.tar.gz,
+// then deletes the oldest archives of the prefix beyond the retention. The archive is a valid restoreFrom source
+func getBackupScript(bucket polkadotv1alpha1.S3Bucket) string {
+	sign := getS3SignArgs(bucket)
+	archive := backupScratchMountPath + "/db.tar.gz"
+	return `set -e -o pipefail; key="$BACKUP_PREFIX/$JOB_NAME.tar.gz"; cd ` + volumeMountPath + `; ` +
+		`dbs=$(ls -d chains/*/db chains/*/paritydb 2>/dev/null || true); ` +
+		`[ -n "$dbs" ] || { echo "no chain database found in the data volume" >&2; exit 1; }; ` +
+		`tar -czf ` + archive + ` $dbs; ` +
+		`curl -fsS --retry 3 ` + sign + ` -T ` + archive + ` "$S3_BUCKET_URL/$key"; ` +
+		`curl -fsS --retry 3 ` + sign + ` "$S3_BUCKET_URL?list-type=2&prefix=$BACKUP_PREFIX/" | grep -o '<Key>[^<]*</Key>' | sed -e 's|<Key>||' -e 's|</Key>||' | ` +
+		`{ grep '\.tar\.gz$' || true; } | sort -r | tail -n +$((RETENTION + 1)) | ` +
+		`while read -r old; do curl -fsS --retry 3 ` + sign + ` -X DELETE "$S3_BUCKET_URL/$old"; done; ` +
+		`echo "uploaded $key"`
+}
+
+// getBackupTargetPodName returns the sentry backed up, the highest ordinal is the first one removed by the StatefulSet on scale down
+func getBackupTargetPodName(CRInstance *polkadotv1alpha1.Polkadot) string {
+	ordinal := int(CRInstance.Spec.Sentry.Replicas) - 1
+	if ordinal < 0 {
+		ordinal = 0
+	}
+	return GetSentryStatefulSetName(CRInstance.Name) + "-" + strconv.Itoa(ordinal)
+}
+
+// getDataClaimName returns the name of the claim created by the StatefulSet for the pod from the volume claim template
+func getDataClaimName(volumeClaimTemplateName string, podName string) string {
+	return volumeClaimTemplateName + "-" + podName
+}
+
+// getBackupS3Object returns the archive uploaded by the backup Job
+func getBackupS3Object(CRInstance *polkadotv1alpha1.Polkadot, jobName string) polkadotv1alpha1.S3Object {
+	return polkadotv1alpha1.S3Object{
+		S3Bucket: CRInstance.Spec.Backup.S3.S3Bucket,
+		Key:      CRInstance.GetBackupPrefix() + "/" + jobName + ".tar.gz",
+	}
+}
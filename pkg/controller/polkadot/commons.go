@@ -9,14 +9,15 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 type CRKind string
 const (
-	Sentry CRKind = "Sentry"
-	Validator CRKind = "Validator"
-	SentryAndValidator CRKind = "SentryAndValidator"
+	Sentry CRKind = polkadotv1alpha1.KindSentry
+	Validator CRKind = polkadotv1alpha1.KindValidator
+	SentryAndValidator CRKind = polkadotv1alpha1.KindSentryAndValidator
 )
 
 const(
@@ -50,4 +51,11 @@ func (r *ReconcilerPolkadot) fetchResource(resource interface{}, key types.Names
 
 func (r *ReconcilerPolkadot) updateResource(resource interface{}) error {
 	return r.client.Update(context.TODO(), resource.(runtime.Object))
-}
\ No newline at end of file
+}
+func (r *ReconcilerPolkadot) deleteResource(resource interface{}, opts ...client.DeleteOption) error {
+	return r.client.Delete(context.TODO(), resource.(runtime.Object), opts...)
+}
+
+func (r *ReconcilerPolkadot) updateResourceStatus(resource interface{}) error {
+	return r.client.Status().Update(context.TODO(), resource.(runtime.Object))
+}
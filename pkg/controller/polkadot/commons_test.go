@@ -7,10 +7,13 @@ import (
 	v12 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"testing"
@@ -24,15 +27,15 @@ func TestCreateResource(t *testing.T) {
 	}{
 		{
 			name:        "Service request creation",
-			newResource: getFakeService(ServiceSentryName, corev1.ServiceTypeClusterIP),
+			newResource: getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeClusterIP),
 		},
 		{
 			name:        "NetworkPolicy request creation",
-			newResource: getFakeNetworkPolicy(ValidatorNetworkPolicy, "status1"),
+			newResource: getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName), "status1"),
 		},
 		{
 			name:        "StatefulSet request creation",
-			newResource: getFakeStatefulSet(SentrySSName, 1),
+			newResource: getFakeStatefulSet(GetSentryStatefulSetName(CRName), 1),
 		},
 	}
 
@@ -79,18 +82,18 @@ func TestFetchResource(t *testing.T) {
 	}{
 		{
 			name:     "Service request found",
-			resourceName: ServiceSentryName,
-			resource: getFakeService(ServiceSentryName, corev1.ServiceTypeClusterIP),
+			resourceName: GetSentryServiceName(CRName),
+			resource: getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeClusterIP),
 		},
 		{
 			name:     "NetworkPolicy request found",
-			resourceName: ValidatorNetworkPolicy,
-			resource: getFakeNetworkPolicy(ValidatorNetworkPolicy, "status1"),
+			resourceName: GetValidatorNetworkPolicyName(CRName),
+			resource: getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName), "status1"),
 		},
 		{
 			name:     "StatefulSet request found",
-			resourceName: SentrySSName,
-			resource: getFakeStatefulSet(SentrySSName, 1),
+			resourceName: GetSentryStatefulSetName(CRName),
+			resource: getFakeStatefulSet(GetSentryStatefulSetName(CRName), 1),
 		},
 	}
 
@@ -101,18 +104,18 @@ func TestFetchResource(t *testing.T) {
 	}{
 		{
 			name:     "Service request NOT found",
-			resourceName: ServiceSentryName,
-			resource: getFakeService(ServiceSentryName, corev1.ServiceTypeClusterIP),
+			resourceName: GetSentryServiceName(CRName),
+			resource: getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeClusterIP),
 		},
 		{
 			name:     "NetworkPolicy request NOT found",
-			resourceName: ValidatorNetworkPolicy,
-			resource: getFakeNetworkPolicy(ValidatorNetworkPolicy, "status1"),
+			resourceName: GetValidatorNetworkPolicyName(CRName),
+			resource: getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName), "status1"),
 		},
 		{
 			name:     "StatefulSet request NOT found",
-			resourceName: SentrySSName,
-			resource: getFakeStatefulSet(SentrySSName, 1),
+			resourceName: GetSentryStatefulSetName(CRName),
+			resource: getFakeStatefulSet(GetSentryStatefulSetName(CRName), 1),
 		},
 	}
 
@@ -187,15 +190,15 @@ func TestUpdateResource(t *testing.T) {
 		},
 		{
 			name:             "NetworkPolicy request update",
-			resourceName: 	ValidatorNetworkPolicy,
-			resource:         Resource{getFakeNetworkPolicy(ValidatorNetworkPolicy, "status1")},
-			expectedResource: Resource{getFakeNetworkPolicy(ValidatorNetworkPolicy, "status2")},
+			resourceName: 	GetValidatorNetworkPolicyName(CRName),
+			resource:         Resource{getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName), "status1")},
+			expectedResource: Resource{getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName), "status2")},
 		},
 		{
 			name:             "StatefulSet request update",
-			resourceName: 	SentrySSName,
-			resource:         Resource{getFakeStatefulSet(SentrySSName, 1)},
-			expectedResource: Resource{getFakeStatefulSet(SentrySSName, 2)},
+			resourceName: 	GetSentryStatefulSetName(CRName),
+			resource:         Resource{getFakeStatefulSet(GetSentryStatefulSetName(CRName), 1)},
+			expectedResource: Resource{getFakeStatefulSet(GetSentryStatefulSetName(CRName), 2)},
 		},
 	}
 
@@ -246,14 +249,141 @@ func TestUpdateResource(t *testing.T) {
 	}
 }
 
-func getFakePolkadot() *polkadotv1alpha1.Polkadot{
+// fakePolkadotOption sets a part of the spec of the Polkadot returned by getFakePolkadot
+type fakePolkadotOption func(polkadot *polkadotv1alpha1.Polkadot)
+
+func getFakePolkadot(options ...fakePolkadotOption) *polkadotv1alpha1.Polkadot{
 	// A Polkadot object with metadata and spec.
-	return &polkadotv1alpha1.Polkadot{
+	polkadot := &polkadotv1alpha1.Polkadot{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      CRName,
 			Namespace: corev1.NamespaceAll,
 		},
 	}
+	for _, option := range options {
+		option(polkadot)
+	}
+	return polkadot
+}
+
+// withFakeSentry deploys a single sentry with a fixed node key
+func withFakeSentry() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Kind = string(Sentry)
+		polkadot.Spec.ClientVersion = "latest"
+		polkadot.Spec.Sentry = polkadotv1alpha1.Sentry{
+			Replicas:   1,
+			ClientName: "IronoaSentry",
+			NodeKey:    "0000000000000000000000000000000000000000000000000000000000000013",
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("0.5"),
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+			},
+		}
+	}
+}
+
+// withFakeValidator deploys a validator without sentries
+func withFakeValidator() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Kind = string(Validator)
+		polkadot.Spec.ClientVersion = "latest"
+		polkadot.Spec.Validator.ClientName = "IronoaValidator"
+	}
+}
+
+// withFakeValidatorDataPersistence enables the data persistence of the validator
+func withFakeValidatorDataPersistence() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+		polkadot.Spec.Validator.DataPersistenceSupport.PersistentVolumeClaim = corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "polkadot-volume"}}
+	}
+}
+
+// withFakeKeyRotation requests a rotation of the session keys of the validator
+func withFakeKeyRotation() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Annotations = map[string]string{polkadotv1alpha1.RotateKeysAnnotation: "1"}
+	}
+}
+
+// withFakeStandby adds a warm standby to the validator, with the session keys in a Secret and the metrics exporter.
+// The standby requires the data persistence of the validator, see withFakeValidatorDataPersistence
+func withFakeStandby() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Validator.NodeKey = "0000000000000000000000000000000000000000000000000000000000000021"
+		polkadot.Spec.Validator.Keystore = &polkadotv1alpha1.KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+		polkadot.Spec.Validator.Standby = &polkadotv1alpha1.StandbySpec{FailoverAfterSeconds: 300}
+		// the RPC of the validator is local only, its chain synchronization is read from the metrics exporter
+		polkadot.Spec.MetricsSupport.Enabled = true
+	}
+}
+
+// withFakeSentryDataPersistence enables the data persistence of the sentries, after withFakeSentry
+func withFakeSentryDataPersistence() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Sentry.DataPersistenceSupport.Enabled = true
+		polkadot.Spec.Sentry.DataPersistenceSupport.PersistentVolumeClaim = corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "polkadot-volume"}}
+	}
+}
+
+// withFakeDeletion marks the CR as deleted, the finalizer applies the retention policy to the claims of the sentries
+func withFakeDeletion(retentionPolicy polkadotv1alpha1.RetentionPolicy) fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		deletionTimestamp := metav1.Now()
+		polkadot.DeletionTimestamp = &deletionTimestamp
+		polkadot.Finalizers = []string{polkadotFinalizer}
+		polkadot.Spec.Sentry.DataPersistenceSupport.RetentionPolicy = retentionPolicy
+	}
+}
+
+// withFakePublicAddress exposes two sentries with a public Service each, after withFakeSentry
+func withFakePublicAddress(serviceType corev1.ServiceType) fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Sentry.Replicas = 2
+		polkadot.Spec.Sentry.Service.Type = serviceType
+		polkadot.Spec.Sentry.PublicAddressSupport.Enabled = true
+	}
+}
+
+// withFakeRestore restores the sentries from the source, after withFakeSentryDataPersistence
+func withFakeRestore(source polkadotv1alpha1.RestoreSource) fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom = &source
+	}
+}
+
+// withFakeBackup backs up one of two sentries to S3 every night, after withFakeSentryDataPersistence
+func withFakeBackup() fakePolkadotOption {
+	return func(polkadot *polkadotv1alpha1.Polkadot) {
+		polkadot.Spec.Sentry.Replicas = 2
+		polkadot.Spec.Backup = &polkadotv1alpha1.BackupSpec{
+			Schedule:  "0 3 * * *",
+			Retention: 2,
+			S3: polkadotv1alpha1.BackupS3Destination{
+				S3Bucket: polkadotv1alpha1.S3Bucket{Endpoint: "http://minio:9000", Bucket: "backups", CredentialsSecretRef: &corev1.LocalObjectReference{Name: "minio-credentials"}},
+			},
+		}
+	}
+}
+
+// getFakeReconciler returns a reconciler with a fake client tracking the objects, and the recorder of its Events
+func getFakeReconciler(t *testing.T, objs ...runtime.Object) (ReconcilerPolkadot, client.Client, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := v12.AddToScheme(scheme); err != nil {
+		t.Errorf("v12.AddToScheme: %v", err)
+	}
+	client := fake.NewFakeClientWithScheme(scheme, objs...)
+	recorder := record.NewFakeRecorder(10)
+	return ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}, client, recorder
 }
 
 func getFakeService(name string, serviceType corev1.ServiceType) *corev1.Service {
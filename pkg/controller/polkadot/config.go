@@ -2,47 +2,312 @@
 // Licensed under MIT License
 package polkadot
 
+import "time"
+
+const (
+	// timeout of a single JSON-RPC call to a node
+	nodeRPCTimeout = 2 * time.Second
+	// interval between two polls of the nodes chain synchronization status
+	nodeStatusPollInterval = 30 * time.Second
+	// error of the status of a node whose RPC is bound to the loopback interface, without the metrics exporter sidecar
+	nodeNotQueryableError = "the node is not queryable, its RPC is local only and the metrics support is disabled"
+	nodeNotQueryableHint  = "enable the metrics support or an external RPC"
+)
+
+const (
+	metricsPortName    = "http-metrics"
+	P2PPortName        = "p2p"
+	RPCPortName        = "http-rpc"
+	WSPortName         = "websocket-rpc"
+	volumeMountPath    = "/data"
+	serviceName        = "polkadot"
+	sentrySuffix       = "-sentry"
+	validatorSuffix    = "-validator"
+	headlessSuffix     = "-headless"
+	rpcSuffix          = "-rpc"
+	instanceLabel      = "app.kubernetes.io/instance"
+	polkadotFinalizer  = "polkadot.swisscomblockchain.com/finalizer"
+	specHashAnnotation = "polkadot.swisscomblockchain.com/spec-hash"
+	// annotation of the Services holding the comma separated keys of the annotations set from the CR, the keys removed from the CR are removed
+	managedAnnotationsAnnotation = "polkadot.swisscomblockchain.com/managed-annotations"
+	// reason of the Event emitted on the CR when the podTemplate patch of a role can't be applied
+	podTemplateInvalidReason = "PodTemplateInvalid"
+)
+
+const (
+	nodeKeySuffix     = "-node-key"
+	nodeKeyVolumeName = "node-key"
+	nodeKeyMountPath  = "/keys"
+	nodeKeyFileName   = "node-key"
+	// key of the node key in the Secrets generated by the operator
+	nodeKeySecretKey = "nodeKey"
+	podNameEnvVar    = "POD_NAME"
+)
+
+const (
+	publicSuffix = "-public"
+	// annotation of a sentry pod holding the multiaddr advertised by the pod, set by the operator
+	publicAddrAnnotation = "polkadot.swisscomblockchain.com/public-addr"
+	publicAddrEnvVar     = "PUBLIC_ADDR"
+	publicAddrVolumeName = "public-addr"
+	publicAddrMountPath  = "/podinfo"
+	publicAddrFileName   = "public-addr"
+	// annotation of a sentry pod holding the node without ExternalIP reported by the PublicAddressMissing Event, set by the operator
+	publicAddrMissingAnnotation = "polkadot.swisscomblockchain.com/public-addr-missing"
+	// reason of the Event emitted on the CR when the node running a sentry pod has no address to advertise
+	publicAddressMissingReason = "PublicAddressMissing"
+)
+
+const (
+	// images of the helper containers, pinned so that the pod templates don't change with the upstream images.
+	// curl signs the S3 requests (--aws-sigv4) since 7.75.0
+	curlImage    = "curlimages/curl:7.78.0"
+	busyboxImage = "busybox:1.33.1"
+)
+
+const (
+	chainSpecVolumeName = "chainspec"
+	chainSpecMountPath  = "/chainspec"
+	chainSpecFileName   = "spec.json"
+	// image of the init container downloading a chain spec from a URL
+	chainSpecDownloadImage = curlImage
+)
+
+const (
+	// file of the data volume holding the last resync token applied, the chain database is wiped when the token changes
+	resyncFileName = ".resync"
+	resyncEnvVar   = "RESYNC"
+)
+
+const (
+	restoreContainerName = "restore-data"
+	restoreURLEnvVar     = "RESTORE_URL"
+	// file of the data volume present while a restore is in progress, an interrupted restore is started over
+	restoringFileName = ".restoring"
+	// file of the data volume holding the pod and the CR the volume belongs to, a volume copied from another node is prepared once
+	volumeOwnerFileName = ".owner"
+	volumeOwnerEnvVar   = "VOLUME_OWNER"
+	// image of the init container downloading an archive, a copied volume is prepared by busybox
+	restoreDownloadImage = curlImage
+	defaultS3Region      = "us-east-1"
+)
+
+const (
+	backupSuffix            = "-backup"
+	backupRole              = "backup"
+	backupContainerName     = "backup"
+	backupScratchVolumeName = "backup"
+	backupScratchMountPath  = "/backup"
+	// label set by the Job controller on the pods of a Job
+	jobNameLabel = "job-name"
+	// a backup stopping the sentry for longer is failed, the sentry is restarted
+	backupActiveDeadlineSeconds = int64(6 * 60 * 60)
+	backupBackoffLimit          = int32(2)
+	backupJobsHistoryLimit      = int32(3)
+)
+
+const (
+	keystoreVolumeName = "keystore"
+	keystoreMountPath  = "/keystore"
+	keyRotationSuffix  = "-key-rotation"
+	// author_rotateKeys writes the keys to the keystore, it can take longer than the other calls
+	keyRotationRPCTimeout = 30 * time.Second
+	// reason of the Event emitted on the CR once the session keys are rotated
+	sessionKeysRotatedReason = "SessionKeysRotated"
+)
+
+const (
+	validatorLockSuffix = "-lock"
+	// annotation of the validator pod holding the UID of the pod granted the validator Lease, set by the operator
+	validatorLeaseAnnotation     = "polkadot.swisscomblockchain.com/validator-lease"
+	validatorLeaseVolumeName     = "validator-lease"
+	validatorLeaseMountPath      = "/lease"
+	validatorLeaseHolderFileName = "holder"
+	validatorLeaseUIDFileName    = "uid"
+	// annotation of the validator Lease holding the node running the holder pod
+	validatorLeaseNodeAnnotation = "polkadot.swisscomblockchain.com/holder-node"
+	// the Lease of a pod removed is granted again only once expired, the operator renews it at most every renew interval
+	validatorLeaseDuration      = 60 * time.Second
+	validatorLeaseRenewInterval = 10 * time.Second
+	// reason of the Event emitted on the CR while the Lease of a removed pod can't be granted again without a confirmation
+	validatorLeaseBlockedReason = "ValidatorLeaseBlocked"
+	// reason of the Event emitted on the CR when the validator StatefulSet is scaled above one replica
+	validatorScaleRefusedReason = "ValidatorScaleRefused"
+)
+
+// reasons of the Events emitted on the CR at the end of a staged upgrade, or when it can't start
 const (
-	ServiceSentryName    = "sentry-service"
-	ServiceValidatorName = "validator-service"
-	metricsPortName        = "http-metrics"
-	P2PPortName            = "p2p"
-	RPCPortName            = "http-rpc"
-	WSPortName             = "websocket-rpc"
-	ValidatorSSName        = "validator-sset"
-	SentrySSName           = "sentry-sset"
-	ValidatorNetworkPolicy = "validator-networkpolicy"
-	volumeMountPath        = "/data"
-	serviceName            = "polkadot"
-)
-
-func getAppLabels() map[string]string {
-	labels := map[string]string{"app": "polkadot"}
+	upgradeCompletedReason  = "UpgradeCompleted"
+	upgradeRolledBackReason = "UpgradeRolledBack"
+	upgradeBlockedReason    = "UpgradeBlocked"
+)
+
+const (
+	secondarySuffix = "-secondary"
+	// label of the pods of the validator StatefulSet running with the session keys, selected by the validator Services
+	activeValidatorLabel = "polkadot.swisscomblockchain.com/active-validator"
+	// reasons of the Events emitted on the CR when the validator is failed over to the standby
+	validatorFailoverReason          = "ValidatorFailover"
+	validatorFailoverCompletedReason = "ValidatorFailoverCompleted"
+	validatorFailoverStuckReason     = "ValidatorFailoverStuck"
+	// time a failover waits for the pods of the previous validator before it is reported as stuck
+	validatorFailoverTimeout = 10 * time.Minute
+)
+
+// fixed names used by the operator before the child resources were derived from the CR name
+const (
+	legacyServiceSentryName      = "sentry-service"
+	legacyServiceValidatorName   = "validator-service"
+	legacyValidatorSSName        = "validator-sset"
+	legacySentrySSName           = "sentry-sset"
+	legacyValidatorNetworkPolicy = "validator-networkpolicy"
+	// annotation of the CR set by the operator when it migrates a legacy validator, the validator is kept stopped until it is removed
+	legacyMigrationPendingAnnotation = "polkadot.swisscomblockchain.com/legacy-migration-pending"
+	// reason of the Event emitted on the CR when the validator waits for the confirmation of the migration
+	legacyMigrationPendingReason = "LegacyMigrationPending"
+)
+
+func GetSentryStatefulSetName(CRName string) string {
+	return CRName + sentrySuffix
+}
+
+func GetValidatorStatefulSetName(CRName string) string {
+	return CRName + validatorSuffix
+}
+
+func GetSentryServiceName(CRName string) string {
+	return CRName + sentrySuffix
+}
+
+func GetValidatorServiceName(CRName string) string {
+	return CRName + validatorSuffix
+}
+
+// GetSentryRPCServiceName is the name of the internal Service publishing the RPC ports of the sentries
+func GetSentryRPCServiceName(CRName string) string {
+	return CRName + sentrySuffix + rpcSuffix
+}
+
+// GetValidatorRPCServiceName is the name of the internal Service publishing the RPC ports of the validator
+func GetValidatorRPCServiceName(CRName string) string {
+	return CRName + validatorSuffix + rpcSuffix
+}
+
+// GetSentryHeadlessServiceName is the name of the headless Service governing the sentry StatefulSet
+func GetSentryHeadlessServiceName(CRName string) string {
+	return CRName + sentrySuffix + headlessSuffix
+}
+
+// GetValidatorHeadlessServiceName is the name of the headless Service governing the validator StatefulSet
+func GetValidatorHeadlessServiceName(CRName string) string {
+	return CRName + validatorSuffix + headlessSuffix
+}
+
+func GetValidatorNetworkPolicyName(CRName string) string {
+	return CRName + validatorSuffix
+}
+
+// GetSentryNodeKeySecretName is the name of the Secret generated when the sentry node key is not supplied
+func GetSentryNodeKeySecretName(CRName string) string {
+	return CRName + sentrySuffix + nodeKeySuffix
+}
+
+// GetValidatorNodeKeySecretName is the name of the Secret generated when the validator node key is not supplied
+func GetValidatorNodeKeySecretName(CRName string) string {
+	return CRName + validatorSuffix + nodeKeySuffix
+}
+
+// GetValidatorKeyRotationNetworkPolicyName is the name of the NetworkPolicy restricting the validator RPC to the operator during a key rotation
+func GetValidatorKeyRotationNetworkPolicyName(CRName string) string {
+	return CRName + validatorSuffix + keyRotationSuffix
+}
+
+// GetValidatorLeaseName is the name of the Lease held by the validator pod allowed to start the client
+func GetValidatorLeaseName(CRName string) string {
+	return CRName + validatorSuffix + validatorLockSuffix
+}
+
+// GetValidatorSecondaryStatefulSetName is the name of the second validator StatefulSet of the standby mode
+func GetValidatorSecondaryStatefulSetName(CRName string) string {
+	return CRName + validatorSuffix + secondarySuffix
+}
+
+// GetBackupCronJobName is the name of the CronJob scheduling the backups
+func GetBackupCronJobName(CRName string) string {
+	return CRName + backupSuffix
+}
+
+// getSentryPublicServiceName returns the name of the p2p Service exposing a single sentry pod
+func getSentryPublicServiceName(podName string) string {
+	return podName + publicSuffix
+}
+
+// getPodHostName returns the DNS name of a StatefulSet pod, relative to the namespace
+func getPodHostName(podName string, governingServiceName string) string {
+	return podName + "." + governingServiceName
+}
+
+func getAppLabels(CRName string) map[string]string {
+	labels := map[string]string{"app": "polkadot", instanceLabel: CRName}
 	return labels
 }
 
-func getSentrylabels() map[string]string {
-	labels := getAppLabels()
+func getSentrylabels(CRName string) map[string]string {
+	labels := getAppLabels(CRName)
 	labels["role"] = "sentry"
 	return labels
 }
 
-func getValidatorLabels() map[string]string {
-	labels := getAppLabels()
+func getValidatorLabels(CRName string) map[string]string {
+	labels := getAppLabels(CRName)
 	labels["role"] = "validator"
 	return labels
 }
 
+func getValidatorSecondaryLabels(CRName string) map[string]string {
+	labels := getAppLabels(CRName)
+	labels["role"] = "validator" + secondarySuffix
+	return labels
+}
+
+// getActiveValidatorLabels select the pod running with the session keys, among the two validator StatefulSets of the standby mode
+func getActiveValidatorLabels(CRName string) map[string]string {
+	labels := getAppLabels(CRName)
+	labels[activeValidatorLabel] = "true"
+	return labels
+}
+
+// getBackupLabels are the labels of the backup CronJob, Jobs and pods
+func getBackupLabels(CRName string) map[string]string {
+	labels := getAppLabels(CRName)
+	labels["role"] = backupRole
+	return labels
+}
+
+// getOperatorLabels returns the labels of the operator pod, see deploy/operator.yaml
+func getOperatorLabels() map[string]string {
+	return map[string]string{"name": "polkadot-operator"}
+}
+
 func getCopyLabelsWithVersion(labels map[string]string, version string) map[string]string {
 	newLabels := getCopy(labels)
 	newLabels["version"] = version
 	return newLabels
 }
 
+// mergeMaps returns a copy of base with the entries of overlay added or replaced
+func mergeMaps(base map[string]string, overlay map[string]string) map[string]string {
+	result := getCopy(base)
+	for key, value := range overlay {
+		result[key] = value
+	}
+	return result
+}
+
 func getCopy(originalMap map[string]string) map[string]string {
 	newMap := make(map[string]string)
 	for key, value := range originalMap {
 		newMap[key] = value
 	}
 	return newMap
-}
\ No newline at end of file
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	"fmt"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// handleFinalizer adds the finalizer to the CustomResource and, when the CustomResource is being deleted,
+// applies the retention policy to the chain data PersistentVolumeClaims before removing the finalizer
+func (r *ReconcilerPolkadot) handleFinalizer(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	logger := log.WithValues("Polkadot.Namespace", CRInstance.Namespace, "Polkadot.Name", CRInstance.Name)
+
+	if CRInstance.GetDeletionTimestamp() == nil {
+		if containsString(CRInstance.GetFinalizers(), polkadotFinalizer) {
+			return NotForcedRequeue, nil
+		}
+		logger.Info("Adding the finalizer...")
+		CRInstance.SetFinalizers(append(CRInstance.GetFinalizers(), polkadotFinalizer))
+		err := r.updateResource(CRInstance)
+		if err != nil {
+			logger.Error(err, "Error on adding the finalizer...")
+			return NotForcedRequeue, err
+		}
+		return NotForcedRequeue, nil
+	}
+
+	if !containsString(CRInstance.GetFinalizers(), polkadotFinalizer) {
+		return NotForcedRequeue, nil
+	}
+
+	isCompleted, err := r.handleRetentionPolicy(CRInstance, GetSentryStatefulSetName(CRInstance.Name), getSentrylabels(CRInstance.Name), CRInstance.Spec.Sentry.DataPersistenceSupport)
+	if err != nil || !isCompleted {
+		return !isCompleted, err
+	}
+	isCompleted, err = r.handleRetentionPolicy(CRInstance, GetValidatorStatefulSetName(CRInstance.Name), getValidatorLabels(CRInstance.Name), CRInstance.Spec.Validator.DataPersistenceSupport)
+	if err != nil || !isCompleted {
+		return !isCompleted, err
+	}
+	if isValidatorStandbyEnabled(CRInstance) {
+		isCompleted, err = r.handleRetentionPolicy(CRInstance, GetValidatorSecondaryStatefulSetName(CRInstance.Name), getValidatorSecondaryLabels(CRInstance.Name), CRInstance.Spec.Validator.DataPersistenceSupport)
+		if err != nil || !isCompleted {
+			return !isCompleted, err
+		}
+	}
+
+	logger.Info("Removing the finalizer...")
+	CRInstance.SetFinalizers(removeString(CRInstance.GetFinalizers(), polkadotFinalizer))
+	err = r.updateResource(CRInstance)
+	if err != nil {
+		logger.Error(err, "Error on removing the finalizer...")
+		return NotForcedRequeue, err
+	}
+	return NotForcedRequeue, nil
+}
+
+// handleRetentionPolicy applies the retention policy to the PersistentVolumeClaims of a StatefulSet,
+// it returns false while it is waiting for the pods to be gone or for the VolumeSnapshots to be ready
+func (r *ReconcilerPolkadot) handleRetentionPolicy(CRInstance *polkadotv1alpha1.Polkadot, statefulSetName string, labels map[string]string, dataPersistence polkadotv1alpha1.DataPersistenceSupport) (bool, error) {
+	if dataPersistence.Enabled != true || getRetentionPolicy(dataPersistence) == polkadotv1alpha1.RetentionPolicyRetain {
+		return true, nil
+	}
+
+	if getRetentionPolicy(dataPersistence) == polkadotv1alpha1.RetentionPolicySnapshot {
+		// the snapshot of a volume written by a running node is not consistent
+		isScaledDown, err := r.handleRetentionScaleDown(CRInstance, statefulSetName, labels)
+		if err != nil || !isScaledDown {
+			return false, err
+		}
+	}
+
+	pvcs, err := r.fetchChainDataPVCs(CRInstance, statefulSetName, labels, dataPersistence)
+	if err != nil {
+		return false, err
+	}
+
+	isCompleted := true
+	for i := range pvcs {
+		pvc := &pvcs[i]
+		logger := log.WithValues("PersistentVolumeClaim.Namespace", pvc.Namespace, "PersistentVolumeClaim.Name", pvc.Name)
+
+		if getRetentionPolicy(dataPersistence) == polkadotv1alpha1.RetentionPolicySnapshot {
+			isReady, err := r.handleVolumeSnapshot(CRInstance, pvc, dataPersistence.VolumeSnapshotClassName)
+			if err != nil {
+				logger.Error(err, "Error on the VolumeSnapshot of the PersistentVolumeClaim...")
+				return false, err
+			}
+			if !isReady {
+				logger.Info("Waiting for the VolumeSnapshot of the PersistentVolumeClaim...")
+				isCompleted = false
+				continue
+			}
+		}
+
+		logger.Info("Deleting the PersistentVolumeClaim...")
+		err := r.deleteResource(pvc)
+		if err != nil {
+			logger.Error(err, "Error on deleting the PersistentVolumeClaim...")
+			return false, err
+		}
+		logger.Info("Deleted the PersistentVolumeClaim")
+	}
+	return isCompleted, nil
+}
+
+// handleRetentionScaleDown scales the StatefulSet to 0 and returns whether its pods are gone, terminating pods included.
+// The StatefulSet is garbage collected only once the finalizer is removed
+func (r *ReconcilerPolkadot) handleRetentionScaleDown(CRInstance *polkadotv1alpha1.Polkadot, statefulSetName string, labels map[string]string) (bool, error) {
+	logger := log.WithValues("StatefulSet.Namespace", CRInstance.Namespace, "StatefulSet.Name", statefulSetName)
+
+	statefulSet := &appsv1.StatefulSet{}
+	isNotFound, err := r.fetchResource(statefulSet, types.NamespacedName{Name: statefulSetName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the StatefulSet...")
+		return false, err
+	}
+	if isNotFound == false {
+		if statefulSet.Spec.Replicas == nil || *statefulSet.Spec.Replicas != 0 {
+			logger.Info("Scaling the StatefulSet to 0 before the VolumeSnapshots...")
+			replicas := int32(0)
+			updated := statefulSet.DeepCopy()
+			updated.Spec.Replicas = &replicas
+			err := r.updateResource(updated)
+			if err != nil {
+				logger.Error(err, "Update StatefulSet Error...")
+				return false, err
+			}
+			logger.Info("Scaled the StatefulSet to 0")
+			return false, nil
+		}
+		if !isStatefulSetScaledDown(statefulSet) {
+			logger.Info("Waiting for the scale down of the StatefulSet...")
+			return false, nil
+		}
+	}
+
+	pods, err := r.fetchPods(CRInstance, labels)
+	if err != nil {
+		logger.Error(err, "Error on fetch the pods...")
+		return false, err
+	}
+	if len(pods) > 0 {
+		logger.Info("Waiting for the termination of the pods...", "Pods", len(pods))
+		return false, nil
+	}
+	return true, nil
+}
+
+// handleVolumeSnapshot creates the VolumeSnapshot of the PersistentVolumeClaim and returns whether it is ready to use
+func (r *ReconcilerPolkadot) handleVolumeSnapshot(CRInstance *polkadotv1alpha1.Polkadot, pvc *corev1.PersistentVolumeClaim, volumeSnapshotClassName string) (bool, error) {
+	name := getVolumeSnapshotName(CRInstance, pvc)
+
+	toBeFoundResource := newVolumeSnapshotEmpty()
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: name, Namespace: pvc.Namespace})
+	if err != nil {
+		return false, err
+	}
+	if isNotFound == true {
+		// the snapshot must survive the CustomResource, so it is not owned by it
+		return false, r.client.Create(context.TODO(), newVolumeSnapshot(name, pvc, volumeSnapshotClassName))
+	}
+	return isVolumeSnapshotReady(toBeFoundResource), nil
+}
+
+// fetchChainDataPVCs returns the PersistentVolumeClaims created from the volumeClaimTemplates of a StatefulSet,
+// including the ones left over by a scale down
+func (r *ReconcilerPolkadot) fetchChainDataPVCs(CRInstance *polkadotv1alpha1.Polkadot, statefulSetName string, labels map[string]string, dataPersistence polkadotv1alpha1.DataPersistenceSupport) ([]corev1.PersistentVolumeClaim, error) {
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	err := r.client.List(context.TODO(), pvcList, client.InNamespace(CRInstance.Namespace), client.MatchingLabels(labels))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := dataPersistence.PersistentVolumeClaim.ObjectMeta.Name + "-" + statefulSetName + "-"
+	var pvcs []corev1.PersistentVolumeClaim
+	for _, pvc := range pvcList.Items {
+		if strings.HasPrefix(pvc.Name, prefix) && pvc.DeletionTimestamp == nil {
+			pvcs = append(pvcs, pvc)
+		}
+	}
+	return pvcs, nil
+}
+
+func getRetentionPolicy(dataPersistence polkadotv1alpha1.DataPersistenceSupport) polkadotv1alpha1.RetentionPolicy {
+	if dataPersistence.RetentionPolicy == "" {
+		return polkadotv1alpha1.RetentionPolicyRetain
+	}
+	return dataPersistence.RetentionPolicy
+}
+
+// getVolumeSnapshotName is unique for each deletion of a CustomResource with the same name
+func getVolumeSnapshotName(CRInstance *polkadotv1alpha1.Polkadot, pvc *corev1.PersistentVolumeClaim) string {
+	return fmt.Sprintf("%s-%d", pvc.Name, CRInstance.GetDeletionTimestamp().Unix())
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(slice []string, s string) []string {
+	var result []string
+	for _, item := range slice {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
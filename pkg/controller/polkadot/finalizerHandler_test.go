@@ -0,0 +1,174 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strconv"
+	"testing"
+)
+
+func TestHandleFinalizer(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Errorf("appsv1.AddToScheme: %v", err)
+	}
+
+	t.Run("Finalizer added", func(t *testing.T) {
+		// A Polkadot object with metadata and spec.
+		polkadot := getFakePolkadot()
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleFinalizer(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer: (%v)", err)
+		}
+
+		found := &polkadotv1alpha1.Polkadot{}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: CRName}, found)
+		if err != nil || !containsString(found.GetFinalizers(), polkadotFinalizer) {
+			t.Fatalf("the finalizer was not added: (%v) (%v)", found.GetFinalizers(), err)
+		}
+	})
+
+	t.Run("Retention policy Retain", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeDeletion(polkadotv1alpha1.RetentionPolicyRetain))
+		pvc := getFakePVC(GetSentryStatefulSetName(CRName), 0)
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, pvc)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleFinalizer(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer: (%v)", err)
+		}
+		if containsString(polkadot.GetFinalizers(), polkadotFinalizer) {
+			t.Fatalf("the finalizer was not removed: (%v)", polkadot.GetFinalizers())
+		}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name}, &corev1.PersistentVolumeClaim{})
+		if err != nil {
+			t.Fatalf("the PersistentVolumeClaim was not retained: (%v)", err)
+		}
+	})
+
+	t.Run("Retention policy Delete", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeDeletion(polkadotv1alpha1.RetentionPolicyDelete))
+		pvc := getFakePVC(GetSentryStatefulSetName(CRName), 0)
+		otherPVC := getFakePVC("other-polkadot-sentry", 0)
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, pvc, otherPVC)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleFinalizer(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer: (%v)", err)
+		}
+		if containsString(polkadot.GetFinalizers(), polkadotFinalizer) {
+			t.Fatalf("the finalizer was not removed: (%v)", polkadot.GetFinalizers())
+		}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name}, &corev1.PersistentVolumeClaim{})
+		if !errors.IsNotFound(err) {
+			t.Fatalf("the PersistentVolumeClaim was not deleted: (%v)", err)
+		}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: otherPVC.Name}, &corev1.PersistentVolumeClaim{})
+		if err != nil {
+			t.Fatalf("a PersistentVolumeClaim of another StatefulSet was deleted: (%v)", err)
+		}
+	})
+
+	t.Run("Retention policy Snapshot", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeDeletion(polkadotv1alpha1.RetentionPolicySnapshot))
+		pvc := getFakePVC(GetSentryStatefulSetName(CRName), 0)
+		statefulSet := getFakeStatefulSet(GetSentryStatefulSetName(CRName), 1)
+		pod := getFakePod(GetSentryStatefulSetName(CRName)+"-0", getSentrylabels(CRName), corev1.PodRunning)
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, pvc, statefulSet, pod)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		// the StatefulSet is scaled to 0 before the VolumeSnapshot
+		isRequeueForced, err := reconciler.handleFinalizer(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer must wait for the scale down: (%v)", err)
+		}
+		if err := client.Get(context.TODO(), types.NamespacedName{Name: statefulSet.Name}, statefulSet); err != nil || *statefulSet.Spec.Replicas != 0 {
+			t.Fatalf("the StatefulSet was not scaled to 0: (%v)", err)
+		}
+		snapshot := newVolumeSnapshotEmpty()
+		err = client.Get(context.TODO(), types.NamespacedName{Name: getVolumeSnapshotName(polkadot, pvc)}, snapshot)
+		if !errors.IsNotFound(err) {
+			t.Fatalf("the VolumeSnapshot was created before the scale down: (%v)", err)
+		}
+
+		// the VolumeSnapshot waits for the pods to be gone
+		isRequeueForced, err = reconciler.handleFinalizer(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer must wait for the termination of the pods: (%v)", err)
+		}
+		if err := client.Delete(context.TODO(), pod); err != nil {
+			t.Fatalf("Delete: (%v)", err)
+		}
+
+		isRequeueForced, err = reconciler.handleFinalizer(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer must wait for the VolumeSnapshot: (%v)", err)
+		}
+
+		err = client.Get(context.TODO(), types.NamespacedName{Name: getVolumeSnapshotName(polkadot, pvc)}, snapshot)
+		if err != nil {
+			t.Fatalf("the VolumeSnapshot was not created: (%v)", err)
+		}
+		pvcName, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "persistentVolumeClaimName")
+		if pvcName != pvc.Name {
+			t.Fatalf("the VolumeSnapshot source doesn't match the PersistentVolumeClaim: (%v)", pvcName)
+		}
+
+		if err := unstructured.SetNestedField(snapshot.Object, true, "status", "readyToUse"); err != nil {
+			t.Fatalf("SetNestedField: (%v)", err)
+		}
+		if err := client.Update(context.TODO(), snapshot); err != nil {
+			t.Fatalf("Update: (%v)", err)
+		}
+
+		isRequeueForced, err = reconciler.handleFinalizer(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleFinalizer: (%v)", err)
+		}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name}, &corev1.PersistentVolumeClaim{})
+		if !errors.IsNotFound(err) {
+			t.Fatalf("the PersistentVolumeClaim was not deleted: (%v)", err)
+		}
+		if containsString(polkadot.GetFinalizers(), polkadotFinalizer) {
+			t.Fatalf("the finalizer was not removed: (%v)", polkadot.GetFinalizers())
+		}
+	})
+}
+
+func getFakePVC(statefulSetName string, ordinal int) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "polkadot-volume-" + statefulSetName + "-" + strconv.Itoa(ordinal),
+			Labels: getSentrylabels(CRName),
+		},
+	}
+}
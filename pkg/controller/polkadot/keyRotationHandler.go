@@ -0,0 +1,223 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"net/url"
+	"strings"
+)
+
+func (r *ReconcilerPolkadot) handleKeyRotation(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerKeyRotation(CRInstance)
+	return handler.handleKeyRotationSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerKeyRotation(CRInstance *polkadotv1alpha1.Polkadot) IHandlerKeyRotation {
+	if CRKind(CRInstance.Spec.Kind) == Validator || CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerKeyRotationValidator{}
+	}
+	return &handlerKeyRotationDefault{}
+}
+
+//pattern Strategy
+type IHandlerKeyRotation interface {
+	handleKeyRotationSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerKeyRotationValidator struct {
+}
+
+func (h *handlerKeyRotationValidator) handleKeyRotationSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	isRequired, err := r.isKeyRotationNetworkPolicyRequired(CRInstance)
+	if err != nil {
+		log.Error(err, "Error on fetch the key rotation state...", "Polkadot.Name", CRInstance.Name)
+		return NotForcedRequeue, err
+	}
+	if !isRequired {
+		return r.handleNetworkPolicyRemoval(CRInstance, GetValidatorKeyRotationNetworkPolicyName(CRInstance.Name))
+	}
+	// the NetworkPolicy is created and verified before the rotation is marked pending, the pending status switches the validator
+	// to the unsafe methods: the StatefulSet is never updated while the NetworkPolicy is missing
+	isForcedRequeue, err := r.handleNetworkPolicyGeneric(CRInstance, newNetworkPolicyKeyRotation(CRInstance))
+	if isForcedRequeue == ForcedRequeue || err != nil {
+		return isForcedRequeue, err
+	}
+	if !isKeyRotationPending(CRInstance) {
+		return NotForcedRequeue, nil
+	}
+	return r.handleKeyRotationGeneric(CRInstance, GetValidatorStatefulSetName(CRInstance.Name)+"-0")
+}
+
+type handlerKeyRotationDefault struct {
+}
+
+func (h *handlerKeyRotationDefault) handleKeyRotationSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return handleSkip()
+}
+
+// handleKeyRotationGeneric calls author_rotateKeys on the validator pod once it serves the unsafe methods, the public keys are
+// stored in the status and announced by an Event. It runs once the key rotation NetworkPolicy exists: the pending status written
+// here switches the validator to the unsafe methods. A call rejected by the node or not delivered is retried by the next poll,
+// any other error fails the rotation since the keys may have been rotated anyway
+func (r *ReconcilerPolkadot) handleKeyRotationGeneric(CRInstance *polkadotv1alpha1.Polkadot, podName string) (bool, error) {
+
+	logger := log.WithValues("Pod.Namespace", CRInstance.Namespace, "Pod.Name", podName)
+	request := CRInstance.Annotations[polkadotv1alpha1.RotateKeysAnnotation]
+
+	pod := &corev1.Pod{}
+	isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: podName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the validator pod...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true || !isPodServingUnsafeRPC(pod) {
+		logger.Info("Waiting for the validator pod to serve the unsafe RPC methods...")
+		return r.updateKeyRotationStatus(CRInstance, polkadotv1alpha1.KeyRotationStatus{
+			Request: request,
+			Phase:   polkadotv1alpha1.KeyRotationPhasePending,
+			Message: "waiting for the validator pod to serve the unsafe RPC methods",
+		})
+	}
+
+	logger.Info("Rotating the session keys...")
+	sessionKeys, err := substrate.NewClient(getNodeRPCURL(*pod), keyRotationRPCTimeout).RotateKeys()
+	if err != nil && isRotateKeysRetryable(err) {
+		logger.Info("Unable to rotate the session keys", "Reason", err.Error())
+		return r.updateKeyRotationStatus(CRInstance, polkadotv1alpha1.KeyRotationStatus{
+			Request: request,
+			Phase:   polkadotv1alpha1.KeyRotationPhasePending,
+			Message: err.Error(),
+		})
+	}
+	if err != nil {
+		logger.Info("Failed to rotate the session keys, not retried", "Reason", err.Error())
+		return r.updateKeyRotationStatus(CRInstance, polkadotv1alpha1.KeyRotationStatus{
+			Request: request,
+			Phase:   polkadotv1alpha1.KeyRotationPhaseFailed,
+			PodName: podName,
+			Message: err.Error() + ": the keys may have been rotated, check the keystore of the pod and set a new rotate-keys value to retry",
+		})
+	}
+	logger.Info("Rotated the session keys", "SessionKeys", sessionKeys)
+
+	completionTime := metav1.Now()
+	_, err = r.updateKeyRotationStatus(CRInstance, polkadotv1alpha1.KeyRotationStatus{
+		Request:        request,
+		Phase:          polkadotv1alpha1.KeyRotationPhaseCompleted,
+		PodName:        podName,
+		SessionKeys:    sessionKeys,
+		CompletionTime: &completionTime,
+	})
+	if err != nil {
+		return NotForcedRequeue, err
+	}
+	r.recorder.Event(CRInstance, corev1.EventTypeNormal, sessionKeysRotatedReason,
+		"Rotated the session keys of "+podName+", register them with the session.setKeys extrinsic: "+sessionKeys)
+	// the validator is restarted with its own RPC settings
+	return ForcedRequeue, nil
+}
+
+func (r *ReconcilerPolkadot) updateKeyRotationStatus(CRInstance *polkadotv1alpha1.Polkadot, keyRotation polkadotv1alpha1.KeyRotationStatus) (bool, error) {
+	current := CRInstance.Status.KeyRotation
+	if current != nil && current.Request == keyRotation.Request && current.Phase == keyRotation.Phase && current.Message == keyRotation.Message {
+		return NotForcedRequeue, nil
+	}
+	CRInstance.Status.KeyRotation = &keyRotation
+	err := r.updateResourceStatus(CRInstance)
+	if err != nil {
+		log.Error(err, "Update Polkadot status Error...", "Polkadot.Name", CRInstance.Name)
+		return NotForcedRequeue, err
+	}
+	return NotForcedRequeue, nil
+}
+
+// isKeyRotationNetworkPolicyRequired keeps the NetworkPolicy from the request of a rotation until the validator
+// is rolled out again with its own RPC settings: the live pods serve the unsafe methods until they are replaced
+func (r *ReconcilerPolkadot) isKeyRotationNetworkPolicyRequired(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if isKeyRotationPending(CRInstance) {
+		return true, nil
+	}
+	isNotFound, err := r.fetchResource(&v1.NetworkPolicy{}, types.NamespacedName{Name: GetValidatorKeyRotationNetworkPolicyName(CRInstance.Name), Namespace: CRInstance.Namespace})
+	if err != nil || isNotFound == true {
+		return false, err
+	}
+	statefulSet, err := r.fetchStatefulSetStatus(CRInstance, GetValidatorStatefulSetName(CRInstance.Name), true)
+	if err != nil || statefulSet == nil {
+		return false, err
+	}
+	rpc := getValidatorRPC(CRInstance)
+	isUnsafeDesired := rpc.IsExternal() && rpc.GetMethods() == polkadotv1alpha1.RPCMethodsUnsafe
+	if isServingUnsafeRPC(statefulSet.Spec.Template.Spec.Containers) && !isUnsafeDesired {
+		return true, nil
+	}
+	return !areStatefulSetsRolledOut([]*appsv1.StatefulSet{statefulSet}), nil
+}
+
+// isKeyRotationPending tells if the rotate-keys annotation requests a rotation neither completed nor failed yet
+func isKeyRotationPending(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	request := CRInstance.Annotations[polkadotv1alpha1.RotateKeysAnnotation]
+	if request == "" || !isValidatorDeployed(CRInstance) {
+		return false
+	}
+	keyRotation := CRInstance.Status.KeyRotation
+	return keyRotation == nil || keyRotation.Request != request ||
+		(keyRotation.Phase != polkadotv1alpha1.KeyRotationPhaseCompleted && keyRotation.Phase != polkadotv1alpha1.KeyRotationPhaseFailed)
+}
+
+// isKeyRotationLockedDown tells if the validator may serve the unsafe methods for a pending rotation: the pending status
+// of the request is written by handleKeyRotationGeneric only once the key rotation NetworkPolicy exists
+func isKeyRotationLockedDown(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	keyRotation := CRInstance.Status.KeyRotation
+	return isKeyRotationPending(CRInstance) && keyRotation != nil && keyRotation.Request == CRInstance.Annotations[polkadotv1alpha1.RotateKeysAnnotation]
+}
+
+// isRotateKeysRetryable tells if a failed author_rotateKeys call surely didn't rotate the keys: the node rejected it,
+// or the connection to the node could not be established. A timeout or a malformed answer may follow a rotation
+func isRotateKeysRetryable(err error) bool {
+	if _, isRPCError := err.(*substrate.Error); isRPCError {
+		return true
+	}
+	if urlError, isURLError := err.(*url.Error); isURLError {
+		opError, isOpError := urlError.Err.(*net.OpError)
+		return isOpError && opError.Op == "dial" && !opError.Timeout()
+	}
+	return false
+}
+
+// isPodServingUnsafeRPC tells if the pod is ready and serves the unsafe methods to the operator
+func isPodServingUnsafeRPC(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || !isPodReady(pod) {
+		return false
+	}
+	return isServingUnsafeRPC(pod.Spec.Containers)
+}
+
+// isServingUnsafeRPC tells if the client container binds the unsafe methods to all the interfaces
+func isServingUnsafeRPC(containers []corev1.Container) bool {
+	for _, container := range containers {
+		if container.Name != serviceName {
+			continue
+		}
+		command := strings.Join(container.Command, " ")
+		return strings.Contains(command, "--unsafe-rpc-external") && strings.Contains(command, "--rpc-methods="+string(polkadotv1alpha1.RPCMethodsUnsafe))
+	}
+	return false
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleKeyRotation(t *testing.T) {
+
+	server := fake.NewServer(map[string]interface{}{"author_rotateKeys": "0x0102"})
+	defer server.Close()
+	defer setFakeRPCPort(t, server.URL)()
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Errorf("appsv1.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("v1.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeKeyRotation())
+	client := clientfake.NewFakeClientWithScheme(scheme, polkadot)
+	recorder := record.NewFakeRecorder(10)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}
+	networkPolicyName := types.NamespacedName{Name: GetValidatorKeyRotationNetworkPolicyName(CRName)}
+
+	// the NetworkPolicy is created before the validator serves the unsafe methods
+	isRequeueForced, err := reconciler.handleKeyRotation(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleKeyRotation: (%v)", err)
+	}
+	networkPolicy := &v1.NetworkPolicy{}
+	if err := client.Get(context.TODO(), networkPolicyName, networkPolicy); err != nil {
+		t.Fatalf("handleKeyRotation: the NetworkPolicy was not created (%v)", err)
+	}
+	if networkPolicy.Spec.Ingress[0].From[0].PodSelector.MatchLabels["name"] != "polkadot-operator" {
+		t.Fatalf("handleKeyRotation: unexpected NetworkPolicy (%v)", networkPolicy.Spec)
+	}
+	if isServingUnsafeRPC(newStatefulSetValidator(polkadot, nil).Spec.Template.Spec.Containers) {
+		t.Fatalf("newStatefulSetValidator: the unsafe methods are served before the rotation is pending")
+	}
+
+	// the rotation waits for the validator pod
+	isRequeueForced, err = reconciler.handleKeyRotation(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleKeyRotation: (%v)", err)
+	}
+	if polkadot.Status.KeyRotation == nil || polkadot.Status.KeyRotation.Phase != polkadotv1alpha1.KeyRotationPhasePending {
+		t.Fatalf("handleKeyRotation: the rotation must be pending (%v)", polkadot.Status.KeyRotation)
+	}
+	if !isServingUnsafeRPC(newStatefulSetValidator(polkadot, nil).Spec.Template.Spec.Containers) {
+		t.Fatalf("newStatefulSetValidator: the unsafe methods are not served once the rotation is pending")
+	}
+
+	// the validator restarted with the unsafe methods serves the rotation
+	unsafeStatefulSet := newStatefulSetValidator(polkadot, nil)
+	pod := getFakePod(GetValidatorStatefulSetName(CRName)+"-0", getValidatorLabels(CRName), corev1.PodRunning)
+	pod.Spec = unsafeStatefulSet.Spec.Template.Spec
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if err := client.Create(context.TODO(), pod); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleKeyRotation(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleKeyRotation: (%v)", err)
+	}
+	keyRotation := polkadot.Status.KeyRotation
+	if keyRotation.Phase != polkadotv1alpha1.KeyRotationPhaseCompleted || keyRotation.SessionKeys != "0x0102" || keyRotation.PodName != pod.Name || keyRotation.CompletionTime == nil {
+		t.Fatalf("handleKeyRotation: unexpected status (%v)", keyRotation)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, sessionKeysRotatedReason) || !strings.Contains(event, "0x0102") {
+		t.Fatalf("handleKeyRotation: unexpected event (%v)", event)
+	}
+	if isKeyRotationPending(polkadot) {
+		t.Fatalf("isKeyRotationPending: the rotation is completed")
+	}
+
+	// the NetworkPolicy is kept while the validator still serves the unsafe methods
+	if err := client.Create(context.TODO(), unsafeStatefulSet); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleKeyRotation(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleKeyRotation: (%v)", err)
+	}
+	if err := client.Get(context.TODO(), networkPolicyName, &v1.NetworkPolicy{}); err != nil {
+		t.Fatalf("handleKeyRotation: the NetworkPolicy was deleted too early (%v)", err)
+	}
+
+	// the NetworkPolicy is deleted once the validator is rolled out with its own RPC settings
+	statefulSet := &appsv1.StatefulSet{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: GetValidatorStatefulSetName(CRName)}, statefulSet)
+	statefulSet.Spec = newStatefulSetValidator(polkadot, nil).Spec
+	statefulSet.Status.UpdatedReplicas = 1
+	if err := client.Update(context.TODO(), statefulSet); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleKeyRotation(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleKeyRotation: (%v)", err)
+	}
+	err = client.Get(context.TODO(), networkPolicyName, &v1.NetworkPolicy{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the key rotation NetworkPolicy was not deleted: (%v)", err)
+	}
+
+	// a new request starts a new rotation
+	polkadot.Annotations[polkadotv1alpha1.RotateKeysAnnotation] = "2"
+	if !isKeyRotationPending(polkadot) {
+		t.Fatalf("isKeyRotationPending: the new request was not detected")
+	}
+}
+
+func TestHandleKeyRotationFailed(t *testing.T) {
+
+	// a malformed answer may follow a rotation
+	server := fake.NewServer(map[string]interface{}{"author_rotateKeys": 42})
+	defer server.Close()
+	defer setFakeRPCPort(t, server.URL)()
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeKeyRotation())
+	polkadot.Status.KeyRotation = &polkadotv1alpha1.KeyRotationStatus{Request: "1", Phase: polkadotv1alpha1.KeyRotationPhasePending}
+	pod := getFakePod(GetValidatorStatefulSetName(CRName)+"-0", getValidatorLabels(CRName), corev1.PodRunning)
+	pod.Spec = newStatefulSetValidator(polkadot, nil).Spec.Template.Spec
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	client := clientfake.NewFakeClientWithScheme(scheme, polkadot, pod)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: record.NewFakeRecorder(10)}
+
+	// the rotation is failed and not retried
+	isRequeueForced, err := reconciler.handleKeyRotationGeneric(polkadot, pod.Name)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleKeyRotationGeneric: (%v)", err)
+	}
+	if polkadot.Status.KeyRotation.Phase != polkadotv1alpha1.KeyRotationPhaseFailed || isKeyRotationPending(polkadot) {
+		t.Fatalf("handleKeyRotationGeneric: unexpected status (%v)", polkadot.Status.KeyRotation)
+	}
+	if isServingUnsafeRPC(newStatefulSetValidator(polkadot, nil).Spec.Template.Spec.Containers) {
+		t.Fatalf("newStatefulSetValidator: the unsafe methods are served after a failed rotation")
+	}
+}
+
+func TestIsRotateKeysRetryable(t *testing.T) {
+	if !isRotateKeysRetryable(&substrate.Error{Code: -32601, Message: "Method not found"}) {
+		t.Fatalf("isRotateKeysRetryable: a call rejected by the node is retryable")
+	}
+	_, err := substrate.NewClient("http://127.0.0.1:1", time.Second).RotateKeys()
+	if err == nil || !isRotateKeysRetryable(err) {
+		t.Fatalf("isRotateKeysRetryable: a call not delivered is retryable (%v)", err)
+	}
+	if isRotateKeysRetryable(errors.NewTimeoutError("timeout", 0)) {
+		t.Fatalf("isRotateKeysRetryable: only the known errors are retryable")
+	}
+}
+
+func TestNewStatefulSetValidatorKeyRotation(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeKeyRotation())
+	polkadot.Status.KeyRotation = &polkadotv1alpha1.KeyRotationStatus{Request: "1", Phase: polkadotv1alpha1.KeyRotationPhasePending}
+	polkadot.Spec.Validator.Keystore = &polkadotv1alpha1.KeystoreSpec{SecretRef: corev1.LocalObjectReference{Name: "session-keys"}}
+
+	podSpec := newStatefulSetValidator(polkadot, nil).Spec.Template.Spec
+	if !isServingUnsafeRPC(podSpec.Containers) {
+		t.Fatalf("newStatefulSetValidator: the unsafe methods are not served during the rotation (%v)", podSpec.Containers[0].Command)
+	}
+	if !strings.Contains(strings.Join(podSpec.Containers[0].Command, " "), "--keystore-path "+keystoreMountPath) {
+		t.Fatalf("newStatefulSetValidator: the keystore is not used (%v)", podSpec.Containers[0].Command)
+	}
+	isMounted := false
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == keystoreVolumeName && volume.Secret != nil && volume.Secret.SecretName == "session-keys" {
+			isMounted = true
+		}
+	}
+	if !isMounted {
+		t.Fatalf("newStatefulSetValidator: the keystore Secret is not mounted (%v)", podSpec.Volumes)
+	}
+
+	// the validator keeps its own RPC settings without a pending rotation
+	polkadot.Annotations = nil
+	podSpec = newStatefulSetValidator(polkadot, nil).Spec.Template.Spec
+	if isServingUnsafeRPC(podSpec.Containers) {
+		t.Fatalf("newStatefulSetValidator: the unsafe methods must not be served (%v)", podSpec.Containers[0].Command)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+// handleLegacyResources migrates a deployment created with the old fixed resource names:
+// the fixed-name children controlled by the CustomResource are deleted, so that the new CR-derived ones can take over.
+// It must run before the other handlers, otherwise two validators with the same keys could be running at the same time.
+// Once the legacy pods are terminated, the chain data claims of the new StatefulSets are cloned from the legacy ones, see handleLegacyClaims.
+// The new validator is kept stopped until the user confirms the migration by removing the pending annotation of the CR
+func (r *ReconcilerPolkadot) handleLegacyResources(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	isRequeueForced, err := r.handleLegacyMigrationPending(CRInstance)
+	if err != nil || isRequeueForced {
+		return isRequeueForced, err
+	}
+
+	legacyResources := []metav1.Object{
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: legacySentrySSName}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: legacyValidatorSSName}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: legacyServiceSentryName}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: legacyServiceValidatorName}},
+		&v1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Name: legacyValidatorNetworkPolicy}},
+	}
+
+	for _, legacyResource := range legacyResources {
+		isDeleted, err := r.handleLegacyResourceGeneric(CRInstance, legacyResource)
+		if err != nil {
+			return NotForcedRequeue, err
+		}
+		if isDeleted {
+			isRequeueForced = ForcedRequeue
+		}
+	}
+	if isRequeueForced {
+		// the legacy volumes are cloned once their pods are terminated
+		return ForcedRequeue, nil
+	}
+	return r.handleLegacyClaims(CRInstance)
+}
+
+// handleLegacyMigrationPending sets the pending annotation on the CR before the legacy validator is deleted:
+// the new validator doesn't start until the user has checked the migrated data and keys, and removed the annotation
+func (r *ReconcilerPolkadot) handleLegacyMigrationPending(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if isLegacyMigrationPending(CRInstance) {
+		return NotForcedRequeue, nil
+	}
+
+	logger := log.WithValues("Legacy.Namespace", CRInstance.Namespace, "Legacy.Name", legacyValidatorSSName)
+
+	toBeFoundResource := &appsv1.StatefulSet{}
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: legacyValidatorSSName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the legacy resource...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true || !metav1.IsControlledBy(toBeFoundResource, CRInstance) || toBeFoundResource.GetDeletionTimestamp() != nil {
+		return NotForcedRequeue, nil
+	}
+
+	logger.Info("Setting the legacy migration pending annotation...")
+	annotations := CRInstance.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[legacyMigrationPendingAnnotation] = "true"
+	CRInstance.SetAnnotations(annotations)
+	err = r.updateResource(CRInstance)
+	if err != nil {
+		logger.Error(err, "Update Polkadot Error...")
+		return NotForcedRequeue, err
+	}
+	r.recorder.Event(CRInstance, corev1.EventTypeWarning, legacyMigrationPendingReason,
+		"the legacy validator is migrated, the new validator is stopped until the annotation "+legacyMigrationPendingAnnotation+" is removed")
+	return ForcedRequeue, nil
+}
+
+// handleLegacyClaims clones the chain data claim of each legacy pod into the claim of the new pod with the same ordinal,
+// before the new StatefulSet creates it empty. The legacy claims are kept, they must be deleted by hand
+func (r *ReconcilerPolkadot) handleLegacyClaims(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if isSentryDeployed(CRInstance) {
+		isRequeueForced, err := r.handleLegacyClaimsGeneric(CRInstance, legacySentrySSName, getSentryPodNames(CRInstance), getSentrylabels(CRInstance.Name), CRInstance.Spec.Sentry.DataPersistenceSupport)
+		if err != nil || isRequeueForced {
+			return isRequeueForced, err
+		}
+	}
+	if isValidatorDeployed(CRInstance) {
+		podNames := []string{GetValidatorStatefulSetName(CRInstance.Name) + "-0"}
+		return r.handleLegacyClaimsGeneric(CRInstance, legacyValidatorSSName, podNames, getValidatorLabels(CRInstance.Name), CRInstance.Spec.Validator.DataPersistenceSupport)
+	}
+	return NotForcedRequeue, nil
+}
+
+func (r *ReconcilerPolkadot) handleLegacyClaimsGeneric(CRInstance *polkadotv1alpha1.Polkadot, legacyStatefulSetName string, podNames []string, labels map[string]string, dataPersistence polkadotv1alpha1.DataPersistenceSupport) (bool, error) {
+	if !dataPersistence.Enabled {
+		return NotForcedRequeue, nil
+	}
+
+	templateName := dataPersistence.PersistentVolumeClaim.ObjectMeta.Name
+	for ordinal, podName := range podNames {
+		legacyName := getDataClaimName(templateName, legacyStatefulSetName+"-"+strconv.Itoa(ordinal))
+		name := getDataClaimName(templateName, podName)
+		logger := log.WithValues("PersistentVolumeClaim.Namespace", CRInstance.Namespace, "PersistentVolumeClaim.Name", name, "Legacy.Name", legacyName)
+
+		isNotFound, err := r.fetchResource(&corev1.PersistentVolumeClaim{}, types.NamespacedName{Name: legacyName, Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the legacy resource...")
+			return NotForcedRequeue, err
+		}
+		if isNotFound == true {
+			continue
+		}
+		isNotFound, err = r.fetchResource(&corev1.PersistentVolumeClaim{}, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the PersistentVolumeClaim...")
+			return NotForcedRequeue, err
+		}
+		if isNotFound == false {
+			continue
+		}
+
+		logger.Info("Creating a new PersistentVolumeClaim cloned from the legacy one...")
+		dataPersistence.RestoreFrom = &polkadotv1alpha1.RestoreSource{PersistentVolumeClaimName: legacyName}
+		err = r.client.Create(context.TODO(), newPersistentVolumeClaimRestore(name, CRInstance.Namespace, labels, dataPersistence))
+		if err != nil {
+			logger.Error(err, "Error on creating a new PersistentVolumeClaim...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Created the new PersistentVolumeClaim")
+		return ForcedRequeue, nil
+	}
+	return NotForcedRequeue, nil
+}
+
+// isLegacyMigrationPending tells if the migration of a legacy validator waits for the confirmation of the user
+func isLegacyMigrationPending(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	_, isPending := CRInstance.GetAnnotations()[legacyMigrationPendingAnnotation]
+	return isPending
+}
+
+func (r *ReconcilerPolkadot) handleLegacyResourceGeneric(CRInstance *polkadotv1alpha1.Polkadot, toBeFoundResource metav1.Object) (bool, error) {
+
+	logger := log.WithValues("Legacy.Namespace", CRInstance.Namespace, "Legacy.Name", toBeFoundResource.GetName())
+
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: toBeFoundResource.GetName(), Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the legacy resource...")
+		return false, err
+	}
+	if isNotFound == true || !metav1.IsControlledBy(toBeFoundResource, CRInstance) {
+		return false, nil
+	}
+	if toBeFoundResource.GetDeletionTimestamp() != nil {
+		logger.Info("Waiting for the deletion of the legacy resource...")
+		return true, nil
+	}
+
+	logger.Info("Deleting the legacy resource...")
+	// foreground deletion: the legacy StatefulSet is visible until all its pods are terminated
+	err = r.deleteResource(toBeFoundResource, client.PropagationPolicy(metav1.DeletePropagationForeground))
+	if err != nil {
+		logger.Error(err, "Error on deleting the legacy resource...")
+		return false, err
+	}
+	logger.Info("Deleted the legacy resource")
+	return true, nil
+}
@@ -0,0 +1,151 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	v12 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestHandleLegacyResources(t *testing.T) {
+
+	// A Polkadot object with metadata and spec.
+	polkadot := getFakePolkadot()
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v12.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	t.Run("Legacy resources owned", func(t *testing.T) {
+		legacyStatefulSet := getFakeStatefulSet(legacySentrySSName, 1)
+		legacyService := getFakeService(legacyServiceSentryName, corev1.ServiceTypeNodePort)
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+		if err := reconciler.createResource(legacyStatefulSet, polkadot); err != nil {
+			t.Fatalf("createResource: (%v)", err)
+		}
+		if err := reconciler.createResource(legacyService, polkadot); err != nil {
+			t.Fatalf("createResource: (%v)", err)
+		}
+
+		isRequeueForced, err := reconciler.handleLegacyResources(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+
+		err = client.Get(context.TODO(), types.NamespacedName{Name: legacySentrySSName}, &v12.StatefulSet{})
+		if !errors.IsNotFound(err) {
+			t.Fatalf("the legacy StatefulSet was not deleted: (%v)", err)
+		}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: legacyServiceSentryName}, &corev1.Service{})
+		if !errors.IsNotFound(err) {
+			t.Fatalf("the legacy Service was not deleted: (%v)", err)
+		}
+	})
+
+	t.Run("Legacy resources not owned", func(t *testing.T) {
+		// Objects to track in the fake client.
+		objs := []runtime.Object{polkadot, getFakeStatefulSet(legacySentrySSName, 1)}
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, objs...)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleLegacyResources(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+
+		err = client.Get(context.TODO(), types.NamespacedName{Name: legacySentrySSName}, &v12.StatefulSet{})
+		if err != nil {
+			t.Fatalf("a not owned StatefulSet was deleted: (%v)", err)
+		}
+	})
+
+	t.Run("Legacy validator migrated", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence())
+		legacyClaim := getFakePVC(legacyValidatorSSName, 0)
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, legacyClaim)
+		recorder := record.NewFakeRecorder(10)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}
+		if err := reconciler.createResource(getFakeStatefulSet(legacyValidatorSSName, 1), polkadot); err != nil {
+			t.Fatalf("createResource: (%v)", err)
+		}
+
+		// the validator is stopped before the legacy one is deleted
+		isRequeueForced, err := reconciler.handleLegacyResources(polkadot)
+		if !isRequeueForced || err != nil || !isLegacyMigrationPending(polkadot) {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+		if event := <-recorder.Events; !strings.Contains(event, legacyMigrationPendingReason) {
+			t.Fatalf("unexpected event (%v)", event)
+		}
+		if replicas := *newStatefulSetValidator(polkadot, nil).Spec.Replicas; replicas != 0 {
+			t.Fatalf("the validator is not stopped: (%v)", replicas)
+		}
+		isRequeueForced, err = reconciler.handleLegacyResources(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+
+		// the claim of the new validator is cloned from the legacy one
+		isRequeueForced, err = reconciler.handleLegacyResources(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		claimName := getDataClaimName("polkadot-volume", GetValidatorStatefulSetName(CRName)+"-0")
+		if err := client.Get(context.TODO(), types.NamespacedName{Name: claimName}, pvc); err != nil {
+			t.Fatalf("the legacy claim was not cloned: (%v)", err)
+		}
+		if dataSource := pvc.Spec.DataSource; dataSource == nil || dataSource.Kind != "PersistentVolumeClaim" || dataSource.Name != legacyClaim.Name {
+			t.Fatalf("unexpected data source (%v)", pvc.Spec.DataSource)
+		}
+		if err := client.Get(context.TODO(), types.NamespacedName{Name: legacyClaim.Name}, pvc); err != nil {
+			t.Fatalf("the legacy claim was deleted: (%v)", err)
+		}
+		isRequeueForced, err = reconciler.handleLegacyResources(polkadot)
+		if isRequeueForced || err != nil || !isLegacyMigrationPending(polkadot) {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+
+		// the user confirms the migration
+		delete(polkadot.Annotations, legacyMigrationPendingAnnotation)
+		if replicas := *newStatefulSetValidator(polkadot, nil).Spec.Replicas; replicas != 1 {
+			t.Fatalf("the validator is not started: (%v)", replicas)
+		}
+	})
+
+	t.Run("Legacy resources not found", func(t *testing.T) {
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleLegacyResources(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleLegacyResources: (%v)", err)
+		}
+	})
+}
@@ -3,9 +3,13 @@
 package polkadot
 
 import (
+	"github.com/go-logr/logr"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"reflect"
 )
 
 func (r *ReconcilerPolkadot) handleNetworkPolicy(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
@@ -38,12 +42,13 @@ func (h *handlerNetworkPolicySentryAndValidator) handleNetworkPolicySpecific(r *
 type handlerNetworkPolicyDefault struct {
 }
 func (h *handlerNetworkPolicyDefault) handleNetworkPolicySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error){
-	return handleSkip()
+	// the secure communication is disabled or not supported by the kind: a previously created policy must not isolate the validator anymore
+	return r.handleNetworkPolicyRemoval(CRInstance, GetValidatorNetworkPolicyName(CRInstance.Name))
 }
 
 func (r *ReconcilerPolkadot) handleNetworkPolicyGeneric(CRInstance *polkadotv1alpha1.Polkadot, desiredResource *v1.NetworkPolicy) (bool, error) {
 
-	logger := log.WithValues("Service.Namespace", desiredResource.Namespace, "Service.Name", desiredResource.Name)
+	logger := log.WithValues("NetworkPolicy.Namespace", desiredResource.Namespace, "NetworkPolicy.Name", desiredResource.Name)
 
 	toBeFoundResource := &v1.NetworkPolicy{}
 	isNotFound,err := r.fetchResource(toBeFoundResource,types.NamespacedName{Name: desiredResource.Name, Namespace: desiredResource.Namespace})
@@ -63,7 +68,95 @@ func (r *ReconcilerPolkadot) handleNetworkPolicyGeneric(CRInstance *polkadotv1al
 		return ForcedRequeue, nil
 	}
 
-	//TODO add check differences
+	foundResource := toBeFoundResource
+
+	if areNetworkPoliciesDifferent(foundResource, desiredResource, logger) {
+		logger.Info("Updating the Network Policy...")
+		err := r.updateResource(getNetworkPolicyToUpdate(foundResource, desiredResource))
+		if err != nil {
+			logger.Error(err, "Update Network Policy Error...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Updated the Network Policy...")
+	}
 
 	return NotForcedRequeue, nil
+}
+
+// handleNetworkPolicyRemoval deletes the named Network Policy, only if it is controlled by the CustomResource
+func (r *ReconcilerPolkadot) handleNetworkPolicyRemoval(CRInstance *polkadotv1alpha1.Polkadot, name string) (bool, error) {
+
+	logger := log.WithValues("NetworkPolicy.Namespace", CRInstance.Namespace, "NetworkPolicy.Name", name)
+
+	toBeFoundResource := &v1.NetworkPolicy{}
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the Network Policy...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true || !metav1.IsControlledBy(toBeFoundResource, CRInstance) || toBeFoundResource.GetDeletionTimestamp() != nil {
+		return NotForcedRequeue, nil
+	}
+
+	logger.Info("Deleting the Network Policy not required anymore...")
+	err = r.deleteResource(toBeFoundResource)
+	if err != nil {
+		logger.Error(err, "Error on deleting the Network Policy...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Deleted the Network Policy")
+	return ForcedRequeue, nil
+}
+
+func areNetworkPoliciesDifferent(currentNetworkPolicy *v1.NetworkPolicy, desiredNetworkPolicy *v1.NetworkPolicy, logger logr.Logger) bool {
+	result := false
+
+	if !reflect.DeepEqual(getDefaultedNetworkPolicySpec(currentNetworkPolicy.Spec), getDefaultedNetworkPolicySpec(desiredNetworkPolicy.Spec)) {
+		logger.Info("Found a spec mismatch...")
+		result = true
+	}
+	for key, value := range desiredNetworkPolicy.Labels {
+		if currentNetworkPolicy.Labels[key] != value {
+			logger.Info("Found a label mismatch...", "Label", key)
+			result = true
+		}
+	}
+
+	return result
+}
+
+// getDefaultedNetworkPolicySpec applies the defaults of the API server, so that they are not detected as drift
+func getDefaultedNetworkPolicySpec(spec v1.NetworkPolicySpec) v1.NetworkPolicySpec {
+	defaulted := *spec.DeepCopy()
+	tcp := corev1.ProtocolTCP
+	for i := range defaulted.Ingress {
+		for j := range defaulted.Ingress[i].Ports {
+			if defaulted.Ingress[i].Ports[j].Protocol == nil {
+				defaulted.Ingress[i].Ports[j].Protocol = &tcp
+			}
+		}
+	}
+	for i := range defaulted.Egress {
+		for j := range defaulted.Egress[i].Ports {
+			if defaulted.Egress[i].Ports[j].Protocol == nil {
+				defaulted.Egress[i].Ports[j].Protocol = &tcp
+			}
+		}
+	}
+	if len(defaulted.PolicyTypes) == 0 {
+		defaulted.PolicyTypes = []v1.PolicyType{v1.PolicyTypeIngress}
+		if len(defaulted.Egress) > 0 {
+			defaulted.PolicyTypes = append(defaulted.PolicyTypes, v1.PolicyTypeEgress)
+		}
+	}
+	return defaulted
+}
+
+// getNetworkPolicyToUpdate applies the desired spec on a copy of current, so that the resourceVersion is preserved
+func getNetworkPolicyToUpdate(currentNetworkPolicy *v1.NetworkPolicy, desiredNetworkPolicy *v1.NetworkPolicy) *v1.NetworkPolicy {
+	updated := currentNetworkPolicy.DeepCopy()
+	updated.Labels = mergeMaps(updated.Labels, desiredNetworkPolicy.Labels)
+	updated.Annotations = mergeMaps(updated.Annotations, desiredNetworkPolicy.Annotations)
+	updated.Spec = desiredNetworkPolicy.Spec
+	return updated
 }
\ No newline at end of file
@@ -1,10 +1,16 @@
 package polkadot
 
 import (
+	"context"
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
 	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"testing"
 )
 
@@ -17,14 +23,14 @@ func TestHandleNetworkPolicyGeneric(t *testing.T) {
 	testsOK := testStruct{
 		{
 			name:        "NetworkPolicy healthy",
-			newResource: getFakeNetworkPolicy(ValidatorNetworkPolicy,"status1"),
+			newResource: getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName),"status1"),
 		},
 	}
 
 	testsNotFound := testStruct{
 		{
 			name:        "NetworkPolicy not found",
-			newResource: getFakeNetworkPolicy(ValidatorNetworkPolicy,"status1"),
+			newResource: getFakeNetworkPolicy(GetValidatorNetworkPolicyName(CRName),"status1"),
 		},
 	}
 
@@ -72,3 +78,150 @@ func TestHandleNetworkPolicyGeneric(t *testing.T) {
 	}
 }
 
+
+func TestHandleNetworkPolicyGenericUpdate(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	// A Polkadot object with metadata and spec.
+	polkadot := getFakePolkadot()
+	desired := newNetworkPolicyValidator(polkadot)
+
+	// the API server defaults the policy types and the ports protocol
+	defaulted := desired.DeepCopy()
+	defaulted.Spec = getDefaultedNetworkPolicySpec(desired.Spec)
+
+	drifted := desired.DeepCopy()
+	drifted.Spec.Egress = nil
+
+	tests := []struct {
+		name       string
+		current    *v1.NetworkPolicy
+		isExpected bool
+	}{
+		{
+			name:       "NetworkPolicy defaulted by the API server",
+			current:    defaulted,
+			isExpected: false,
+		},
+		{
+			name:       "NetworkPolicy drifted",
+			current:    drifted,
+			isExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isDifferent := areNetworkPoliciesDifferent(test.current, desired, log)
+			if isDifferent != test.isExpected {
+				t.Fatalf("areNetworkPoliciesDifferent: (%v)", isDifferent)
+			}
+
+			// Create a fake client to mock API calls.
+			client := fake.NewFakeClientWithScheme(scheme, polkadot, test.current)
+			reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+			isRequeueForced, err := reconciler.handleNetworkPolicyGeneric(polkadot, newNetworkPolicyValidator(polkadot))
+			if isRequeueForced || err != nil {
+				t.Fatalf("handleNetworkPolicyGeneric: (%v)", err)
+			}
+
+			found := &v1.NetworkPolicy{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: desired.Name}, found)
+			if err != nil {
+				t.Fatalf("handleNetworkPolicyGeneric: (%v)", err)
+			}
+			if areNetworkPoliciesDifferent(found, desired, log) {
+				t.Fatalf("the Network Policy was not reconciled: (%v)", found.Spec)
+			}
+		})
+	}
+}
+
+func TestHandleNetworkPolicyRemoval(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		isControlled    bool
+		isExpectDeleted bool
+	}{
+		{
+			name:            "NetworkPolicy controlled by the CR",
+			isControlled:    true,
+			isExpectDeleted: true,
+		},
+		{
+			name:            "NetworkPolicy not controlled by the CR",
+			isControlled:    false,
+			isExpectDeleted: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A Polkadot object with secure communication disabled
+			polkadot := getFakePolkadot()
+			polkadot.Spec.Kind = string(SentryAndValidator)
+
+			networkPolicy := newNetworkPolicyValidator(polkadot)
+			if test.isControlled {
+				if err := controllerutil.SetControllerReference(polkadot, networkPolicy, scheme); err != nil {
+					t.Fatalf("SetControllerReference: (%v)", err)
+				}
+			}
+
+			// Create a fake client to mock API calls.
+			client := fake.NewFakeClientWithScheme(scheme, polkadot, networkPolicy)
+			reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+			isRequeueForced, err := reconciler.handleNetworkPolicy(polkadot)
+			if isRequeueForced != test.isExpectDeleted || err != nil {
+				t.Fatalf("handleNetworkPolicy: (%v) (%v)", isRequeueForced, err)
+			}
+
+			err = client.Get(context.TODO(), types.NamespacedName{Name: networkPolicy.Name}, &v1.NetworkPolicy{})
+			if errors.IsNotFound(err) != test.isExpectDeleted {
+				t.Fatalf("handleNetworkPolicy: unexpected deletion result (%v)", err)
+			}
+		})
+	}
+}
+
+func TestNewNetworkPolicyValidatorRPC(t *testing.T) {
+	polkadot := getFakePolkadot()
+
+	// only the p2p port of the validator is reachable, by the sentries
+	ingress := newNetworkPolicyValidator(polkadot).Spec.Ingress
+	if len(ingress) != 1 || len(ingress[0].Ports) != 1 || !reflect.DeepEqual(ingress[0].From[0].PodSelector.MatchLabels, getSentrylabels(CRName)) {
+		t.Fatalf("newNetworkPolicyValidator: unexpected ingress (%v)", ingress)
+	}
+
+	// the unsafe endpoints are reachable only by the operator
+	polkadot.Spec.Validator.RPC.Mode = polkadotv1alpha1.RPCModeUnsafeExternal
+	ingress = newNetworkPolicyValidator(polkadot).Spec.Ingress
+	if len(ingress) != 2 || len(ingress[1].Ports) != 2 || !reflect.DeepEqual(ingress[1].From[0].PodSelector.MatchLabels, getOperatorLabels()) {
+		t.Fatalf("newNetworkPolicyValidator: unexpected ingress (%v)", ingress)
+	}
+
+	// the safe endpoints are reachable by any pod
+	polkadot.Spec.Validator.RPC.Mode = polkadotv1alpha1.RPCModeSafeExternal
+	ingress = newNetworkPolicyValidator(polkadot).Spec.Ingress
+	if len(ingress) != 2 || len(ingress[1].From) != 0 {
+		t.Fatalf("newNetworkPolicyValidator: unexpected ingress (%v)", ingress)
+	}
+}
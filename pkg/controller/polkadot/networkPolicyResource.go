@@ -3,32 +3,37 @@
 package polkadot
 
 import (
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
 	v1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// newNetworkPolicyValidator isolates the validator: only the sentries reach its p2p port,
+// the RPC and websocket ports are reachable according to spec.validator.rpc
 func newNetworkPolicyValidator(CRInstance *polkadotv1alpha1.Polkadot) *v1.NetworkPolicy {
-	labels := getValidatorLabels()
-	sentryLabels := getSentrylabels()
+	sentryLabels := getSentrylabels(CRInstance.Name)
+	p2pPort := intstr.FromInt(config.P2PPortEnvVar.Value)
 
 	return &v1.NetworkPolicy{
 		TypeMeta: metav1.TypeMeta{},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      ValidatorNetworkPolicy,
+			Name:      GetValidatorNetworkPolicyName(CRInstance.Name),
 			Namespace: CRInstance.Namespace,
 		},
 		Spec: v1.NetworkPolicySpec{
-			PodSelector: metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Ingress: []v1.NetworkPolicyIngressRule{{
+			PodSelector: getValidatorPodSelector(CRInstance),
+			Ingress: append([]v1.NetworkPolicyIngressRule{{
 				From: []v1.NetworkPolicyPeer{{
 					PodSelector: &metav1.LabelSelector{
 						MatchLabels: sentryLabels,
 					},
 				}},
-			}},
+				Ports: []v1.NetworkPolicyPort{{
+					Port: &p2pPort,
+				}},
+			}}, append(getRPCIngressRules(CRInstance.GetValidatorRPC()), getMetricsIngressRules(CRInstance)...)...),
 			Egress: []v1.NetworkPolicyEgressRule{{
 				To: []v1.NetworkPolicyPeer{{
 					PodSelector: &metav1.LabelSelector{
@@ -39,3 +44,95 @@ func newNetworkPolicyValidator(CRInstance *polkadotv1alpha1.Polkadot) *v1.Networ
 		},
 	}
 }
+
+// getRPCIngressRules returns the rules allowing the RPC and websocket ports: the safe endpoints are reachable by any pod,
+// the unsafe ones only by the operator, which polls the chain synchronization status of the nodes.
+// No rule is needed if the endpoints are bound to the loopback interface
+func getRPCIngressRules(rpc polkadotv1alpha1.RPCSpec) []v1.NetworkPolicyIngressRule {
+	if !rpc.IsExternal() {
+		return nil
+	}
+	rpcPort := intstr.FromInt(config.RPCPortEnvVar.Value)
+	wsPort := intstr.FromInt(config.WSPortEnvVar.Value)
+	rule := v1.NetworkPolicyIngressRule{
+		Ports: []v1.NetworkPolicyPort{{
+			Port: &rpcPort,
+		}, {
+			Port: &wsPort,
+		}},
+	}
+	if rpc.GetMethods() == polkadotv1alpha1.RPCMethodsUnsafe {
+		rule.From = []v1.NetworkPolicyPeer{{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: getOperatorLabels(),
+			},
+		}}
+	}
+	return []v1.NetworkPolicyIngressRule{rule}
+}
+
+// getMetricsIngressRules returns the rule allowing the operator to read the metrics exporter sidecar,
+// which reports the chain synchronization of a validator whose RPC is bound to the loopback interface
+func getMetricsIngressRules(CRInstance *polkadotv1alpha1.Polkadot) []v1.NetworkPolicyIngressRule {
+	if !CRInstance.Spec.MetricsSupport.Enabled {
+		return nil
+	}
+	metricsPort := intstr.FromInt(config.MetricsPortEnvVar.Value)
+	return []v1.NetworkPolicyIngressRule{{
+		From: []v1.NetworkPolicyPeer{{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: getOperatorLabels(),
+			},
+		}},
+		Ports: []v1.NetworkPolicyPort{{
+			Port: &metricsPort,
+		}},
+	}}
+}
+
+// newNetworkPolicyKeyRotation restricts the RPC and websocket ports of the validator to the operator while the unsafe methods
+// are served for a key rotation. The NetworkPolicies are additive: without the validator isolation of the secure communications
+// the other ports of the validator stay reachable by any peer.
+// A NetworkPolicy can't select a ServiceAccount: any pod of the namespace labelled as the operator is allowed, so the right
+// to create pods in the namespace must be restricted
+func newNetworkPolicyKeyRotation(CRInstance *polkadotv1alpha1.Polkadot) *v1.NetworkPolicy {
+	rpcPort := intstr.FromInt(config.RPCPortEnvVar.Value)
+	wsPort := intstr.FromInt(config.WSPortEnvVar.Value)
+	ingress := []v1.NetworkPolicyIngressRule{{
+		From: []v1.NetworkPolicyPeer{{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: getOperatorLabels(),
+			},
+		}},
+		Ports: []v1.NetworkPolicyPort{{
+			Port: &rpcPort,
+		}, {
+			Port: &wsPort,
+		}},
+	}}
+	if !isNetworkPolicyRequired(CRInstance) {
+		p2pPort := intstr.FromInt(config.P2PPortEnvVar.Value)
+		metricsPort := intstr.FromInt(config.MetricsPortEnvVar.Value)
+		ingress = append(ingress, v1.NetworkPolicyIngressRule{
+			Ports: []v1.NetworkPolicyPort{{
+				Port: &p2pPort,
+			}, {
+				Port: &metricsPort,
+			}},
+		})
+	}
+
+	return &v1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetValidatorKeyRotationNetworkPolicyName(CRInstance.Name),
+			Namespace: CRInstance.Namespace,
+			Labels:    getValidatorLabels(CRInstance.Name),
+		},
+		Spec: v1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: getValidatorLabels(CRInstance.Name),
+			},
+			Ingress: ingress,
+		},
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"encoding/hex"
+	"fmt"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/p2p"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func (r *ReconcilerPolkadot) handleNodeKey(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerNodeKey(CRInstance)
+	return handler.handleNodeKeySpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerNodeKey(CRInstance *polkadotv1alpha1.Polkadot) IHandlerNodeKey {
+	if CRKind(CRInstance.Spec.Kind) == Validator {
+		return &handlerNodeKeyValidator{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == Sentry {
+		return &handlerNodeKeySentry{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerNodeKeySentryAndValidator{}
+	}
+	return &handlerNodeKeyDefault{}
+}
+
+//pattern Strategy
+type IHandlerNodeKey interface {
+	handleNodeKeySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerNodeKeyValidator struct {
+}
+
+func (h *handlerNodeKeyValidator) handleNodeKeySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if !isNodeKeyGenerated(CRInstance.Spec.Validator.NodeKey, CRInstance.Spec.Validator.NodeKeySecretRef) {
+		return handleSkip()
+	}
+	return r.handleNodeKeyGeneric(CRInstance, GetValidatorNodeKeySecretName(CRInstance.Name), getValidatorLabels(CRInstance.Name), []string{nodeKeySecretKey})
+}
+
+type handlerNodeKeySentry struct {
+}
+
+func (h *handlerNodeKeySentry) handleNodeKeySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	if !isNodeKeyGenerated(CRInstance.Spec.Sentry.NodeKey, CRInstance.Spec.Sentry.NodeKeySecretRef) {
+		return handleSkip()
+	}
+	// a node key per sentry pod, so that the validator can reserve each of them
+	return r.handleNodeKeyGeneric(CRInstance, GetSentryNodeKeySecretName(CRInstance.Name), getSentrylabels(CRInstance.Name), getSentryPodNames(CRInstance))
+}
+
+type handlerNodeKeySentryAndValidator struct {
+}
+
+func (h *handlerNodeKeySentryAndValidator) handleNodeKeySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	isForcedRequeue, err := (&handlerNodeKeySentry{}).handleNodeKeySpecific(r, CRInstance)
+	if isForcedRequeue == ForcedRequeue || err != nil {
+		return isForcedRequeue, err
+	}
+	return (&handlerNodeKeyValidator{}).handleNodeKeySpecific(r, CRInstance)
+}
+
+type handlerNodeKeyDefault struct {
+}
+
+func (h *handlerNodeKeyDefault) handleNodeKeySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return handleSkip()
+}
+
+// handleNodeKeyGeneric generates the node key Secret if it doesn't exist and adds the missing keys, an existing key is never replaced.
+// The keys of the scaled down pods are kept, so that the pods get the same identity when they are scaled up again
+func (r *ReconcilerPolkadot) handleNodeKeyGeneric(CRInstance *polkadotv1alpha1.Polkadot, name string, labels map[string]string, keys []string) (bool, error) {
+
+	logger := log.WithValues("Secret.Namespace", CRInstance.Namespace, "Secret.Name", name)
+
+	toBeFoundResource := &corev1.Secret{}
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the node key Secret...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == false {
+		foundResource := toBeFoundResource
+		isAdded, err := addMissingNodeKeys(foundResource, keys)
+		if err != nil {
+			logger.Error(err, "Error on generating a new node key...")
+			return NotForcedRequeue, err
+		}
+		if !isAdded {
+			return NotForcedRequeue, nil
+		}
+		logger.Info("Adding the missing node keys to the Secret...")
+		err = r.updateResource(foundResource)
+		if err != nil {
+			logger.Error(err, "Update node key Secret Error...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Updated the node key Secret")
+		return ForcedRequeue, nil
+	}
+
+	logger.Info("Node key Secret not found...")
+	logger.Info("Generating a new node key Secret...")
+	desiredResource, err := newSecretNodeKey(name, CRInstance.Namespace, labels, keys)
+	if err != nil {
+		logger.Error(err, "Error on generating a new node key...")
+		return NotForcedRequeue, err
+	}
+	err = r.createResource(desiredResource, CRInstance)
+	if err != nil {
+		logger.Error(err, "Error on creating a new node key Secret...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Created the new node key Secret")
+	return ForcedRequeue, nil
+}
+
+// fetchPeerID derives the peer ID of a role from its node key, in plain text or stored in a Secret
+func (r *ReconcilerPolkadot) fetchPeerID(CRInstance *polkadotv1alpha1.Polkadot, nodeKey string, nodeKeySecret *corev1.SecretKeySelector) (string, error) {
+	if nodeKeySecret == nil {
+		return p2p.PeerIDFromNodeKey(nodeKey)
+	}
+	peerIDs, err := r.fetchPeerIDs(CRInstance, nodeKeySecret.Name, []string{nodeKeySecret.Key})
+	if err != nil {
+		return "", err
+	}
+	return peerIDs[0], nil
+}
+
+// fetchPeerIDs derives the peer IDs from the node keys stored in a Secret, in the order of the given keys
+func (r *ReconcilerPolkadot) fetchPeerIDs(CRInstance *polkadotv1alpha1.Polkadot, secretName string, keys []string) ([]string, error) {
+	secret := &corev1.Secret{}
+	isNotFound, err := r.fetchResource(secret, types.NamespacedName{Name: secretName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		return nil, err
+	}
+	if isNotFound == true {
+		return nil, fmt.Errorf("the node key Secret %s is not found", secretName)
+	}
+
+	var peerIDs []string
+	for _, key := range keys {
+		data, isFound := secret.Data[key]
+		if !isFound {
+			return nil, fmt.Errorf("the node key Secret %s has no key %s", secretName, key)
+		}
+		nodeKey := string(data)
+		if len(data) == p2p.NodeKeySize {
+			// the nodes accept the unencoded secret as well
+			nodeKey = hex.EncodeToString(data)
+		}
+		peerID, err := p2p.PeerIDFromNodeKey(nodeKey)
+		if err != nil {
+			return nil, err
+		}
+		peerIDs = append(peerIDs, peerID)
+	}
+	return peerIDs, nil
+}
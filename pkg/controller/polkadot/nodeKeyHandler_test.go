@@ -0,0 +1,171 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestHandleNodeKey(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	t.Run("Node key generated", func(t *testing.T) {
+		// A Polkadot object without node key
+		polkadot := getFakePolkadot(withFakeSentry())
+		polkadot.Spec.Sentry.NodeKey = ""
+		polkadot.Spec.Sentry.Replicas = 2
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleNodeKey(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleNodeKey: (%v)", err)
+		}
+
+		generated := &corev1.Secret{}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryNodeKeySecretName(CRName)}, generated)
+		if err != nil {
+			t.Fatalf("handleNodeKey: (%v)", err)
+		}
+		firstPod, secondPod := GetSentryStatefulSetName(CRName)+"-0", GetSentryStatefulSetName(CRName)+"-1"
+		if len(generated.Data) != 2 || len(generated.Data[firstPod]) != 64 || len(generated.Data[secondPod]) != 64 || !metav1.IsControlledBy(generated, polkadot) {
+			t.Fatalf("handleNodeKey: unexpected generated Secret (%v)", generated.ObjectMeta)
+		}
+		if string(generated.Data[firstPod]) == string(generated.Data[secondPod]) {
+			t.Fatalf("handleNodeKey: the sentry pods share the same node key")
+		}
+
+		// the generated keys are never replaced
+		isRequeueForced, err = reconciler.handleNodeKey(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleNodeKey: (%v)", err)
+		}
+
+		// a key is added on scale up, the key of a removed pod is kept on scale down
+		polkadot.Spec.Sentry.Replicas = 3
+		isRequeueForced, err = reconciler.handleNodeKey(polkadot)
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleNodeKey: (%v)", err)
+		}
+		polkadot.Spec.Sentry.Replicas = 1
+		isRequeueForced, err = reconciler.handleNodeKey(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleNodeKey: (%v)", err)
+		}
+
+		found := &corev1.Secret{}
+		_ = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryNodeKeySecretName(CRName)}, found)
+		if len(found.Data) != 3 || string(found.Data[firstPod]) != string(generated.Data[firstPod]) || string(found.Data[secondPod]) != string(generated.Data[secondPod]) {
+			t.Fatalf("handleNodeKey: unexpected node keys after scaling (%v)", len(found.Data))
+		}
+	})
+
+	t.Run("Node key supplied", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeSentry())
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleNodeKey(polkadot)
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleNodeKey: (%v)", err)
+		}
+		list := &corev1.SecretList{}
+		_ = client.List(context.TODO(), list)
+		if len(list.Items) != 0 {
+			t.Fatalf("handleNodeKey: unexpected Secrets (%v)", len(list.Items))
+		}
+	})
+}
+
+func TestFetchPeerID(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeSentry())
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentry-key"},
+		Data:       map[string][]byte{"key": []byte(polkadot.Spec.Sentry.NodeKey + "\n")},
+	}
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, secret)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	fromSpec, err := reconciler.fetchPeerID(polkadot, polkadot.Spec.Sentry.NodeKey, nil)
+	if err != nil || !strings.HasPrefix(fromSpec, "12D3KooW") {
+		t.Fatalf("fetchPeerID: (%v) (%v)", fromSpec, err)
+	}
+
+	selector := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "sentry-key"}, Key: "key"}
+	fromSecret, err := reconciler.fetchPeerID(polkadot, "", selector)
+	if err != nil || fromSecret != fromSpec {
+		t.Fatalf("fetchPeerID: (%v) (%v)", fromSecret, err)
+	}
+
+	selector.Name = "missing"
+	_, err = reconciler.fetchPeerID(polkadot, "", selector)
+	if err == nil {
+		t.Fatalf("fetchPeerID: expected an error for a missing Secret")
+	}
+}
+
+func TestNewStatefulSetNodeKeySecret(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.NodeKey = ""
+	polkadot.Spec.Sentry.NodeKeySecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "sentry-key"}, Key: "key"}
+
+	statefulSet := newStatefulSetSentry(polkadot, nil)
+	podSpec := statefulSet.Spec.Template.Spec
+	command := strings.Join(podSpec.Containers[0].Command, " ")
+	if strings.Contains(command, "--node-key ") || !strings.Contains(command, "--node-key-file "+nodeKeyMountPath+"/"+nodeKeyFileName) {
+		t.Fatalf("newStatefulSetSentry: unexpected command (%v)", command)
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].Secret.SecretName != "sentry-key" || podSpec.Volumes[0].Secret.Items[0].Key != "key" {
+		t.Fatalf("newStatefulSetSentry: unexpected volumes (%v)", podSpec.Volumes)
+	}
+	if len(podSpec.Containers[0].VolumeMounts) != 1 || podSpec.Containers[0].VolumeMounts[0].MountPath != nodeKeyMountPath {
+		t.Fatalf("newStatefulSetSentry: unexpected volume mounts (%v)", podSpec.Containers[0].VolumeMounts)
+	}
+}
+
+func TestNewStatefulSetNodeKeyPerPod(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.NodeKey = ""
+
+	statefulSet := newStatefulSetSentry(polkadot, nil)
+	podSpec := statefulSet.Spec.Template.Spec
+	command := strings.Join(podSpec.Containers[0].Command, " ")
+	if !strings.Contains(command, "--node-key-file "+nodeKeyMountPath+"/$("+podNameEnvVar+")") {
+		t.Fatalf("newStatefulSetSentry: unexpected command (%v)", command)
+	}
+	if len(podSpec.Volumes) != 1 || podSpec.Volumes[0].Secret.SecretName != GetSentryNodeKeySecretName(CRName) || len(podSpec.Volumes[0].Secret.Items) != 0 {
+		t.Fatalf("newStatefulSetSentry: unexpected volumes (%v)", podSpec.Volumes)
+	}
+	env := podSpec.Containers[0].Env
+	if len(env) == 0 || env[len(env)-1].Name != podNameEnvVar || env[len(env)-1].ValueFrom.FieldRef.FieldPath != "metadata.name" {
+		t.Fatalf("newStatefulSetSentry: unexpected env (%v)", env)
+	}
+}
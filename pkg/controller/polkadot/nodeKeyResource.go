@@ -0,0 +1,90 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/p2p"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strconv"
+)
+
+// newSecretNodeKey returns a Secret holding a new random node key for each of the given keys
+func newSecretNodeKey(name string, namespace string, labels map[string]string, keys []string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{},
+	}
+	if _, err := addMissingNodeKeys(secret, keys); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// addMissingNodeKeys generates the node keys not stored in the Secret yet, the existing ones are never replaced
+func addMissingNodeKeys(secret *corev1.Secret, keys []string) (bool, error) {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	isAdded := false
+	for _, key := range keys {
+		if _, isFound := secret.Data[key]; isFound {
+			continue
+		}
+		nodeKey, err := p2p.GenerateNodeKey()
+		if err != nil {
+			return false, err
+		}
+		secret.Data[key] = []byte(nodeKey)
+		isAdded = true
+	}
+	return isAdded, nil
+}
+
+// getSentryNodeKeySecret returns the Secret key holding the sentry node key, nil if the node key is set in plain text.
+// The Secret generated by the operator holds a node key per sentry pod, named after the pod: the returned Key is empty
+func getSentryNodeKeySecret(CRInstance *polkadotv1alpha1.Polkadot) *corev1.SecretKeySelector {
+	return getNodeKeySecret(CRInstance.Spec.Sentry.NodeKey, CRInstance.Spec.Sentry.NodeKeySecretRef, GetSentryNodeKeySecretName(CRInstance.Name), "")
+}
+
+// getValidatorNodeKeySecret returns the Secret key holding the validator node key, nil if the node key is set in plain text
+func getValidatorNodeKeySecret(CRInstance *polkadotv1alpha1.Polkadot) *corev1.SecretKeySelector {
+	return getNodeKeySecret(CRInstance.Spec.Validator.NodeKey, CRInstance.Spec.Validator.NodeKeySecretRef, GetValidatorNodeKeySecretName(CRInstance.Name), nodeKeySecretKey)
+}
+
+func getNodeKeySecret(nodeKey string, nodeKeySecretRef *corev1.SecretKeySelector, generatedSecretName string, generatedKey string) *corev1.SecretKeySelector {
+	if nodeKeySecretRef != nil {
+		return nodeKeySecretRef
+	}
+	if nodeKey != "" {
+		return nil
+	}
+	return &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: generatedSecretName},
+		Key:                  generatedKey,
+	}
+}
+
+func isNodeKeyGenerated(nodeKey string, nodeKeySecretRef *corev1.SecretKeySelector) bool {
+	return nodeKey == "" && nodeKeySecretRef == nil
+}
+
+// isNodeKeyPerPod is true when every pod of the role reads its own node key, named after the pod
+func isNodeKeyPerPod(nodeKeySecret *corev1.SecretKeySelector) bool {
+	return nodeKeySecret != nil && nodeKeySecret.Key == ""
+}
+
+// getSentryPodNames returns the names of the desired sentry pods, ordered by StatefulSet ordinal
+func getSentryPodNames(CRInstance *polkadotv1alpha1.Polkadot) []string {
+	var names []string
+	for ordinal := 0; ordinal < int(CRInstance.Spec.Sentry.Replicas); ordinal++ {
+		names = append(names, GetSentryStatefulSetName(CRInstance.Name)+"-"+strconv.Itoa(ordinal))
+	}
+	return names
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	"fmt"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sort"
+	"sync"
+	"time"
+)
+
+// nodeStatusCache polls the RPC endpoints of the pods of all the CustomResources every nodeStatusPollInterval, out of the
+// reconciliation: a node not responding doesn't hold the reconciliation of the other CustomResources.
+// A CustomResource is enqueued through events when the status of its nodes changes, the reconciliation reads the last polled one
+type nodeStatusCache struct {
+	client client.Client
+	events chan event.GenericEvent
+	mutex  sync.RWMutex
+	// the status of the nodes of each CustomResource, by pod name
+	nodes map[types.NamespacedName]map[string]polledNodeStatus
+}
+
+type polledNodeStatus struct {
+	podUID types.UID
+	status polkadotv1alpha1.NodeStatus
+}
+
+func newNodeStatusCache(c client.Client) *nodeStatusCache {
+	return &nodeStatusCache{
+		client: c,
+		events: make(chan event.GenericEvent, 100),
+		nodes:  make(map[types.NamespacedName]map[string]polledNodeStatus),
+	}
+}
+
+// Start polls the nodes until stop is closed, it implements manager.Runnable
+func (c *nodeStatusCache) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(nodeStatusPollInterval)
+	defer ticker.Stop()
+	for {
+		c.poll()
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *nodeStatusCache) poll() {
+	polkadotList := &polkadotv1alpha1.PolkadotList{}
+	err := c.client.List(context.TODO(), polkadotList)
+	if err != nil {
+		log.Error(err, "Error on list the Polkadot CustomResources...")
+		return
+	}
+
+	polled := make(map[types.NamespacedName]bool)
+	for i := range polkadotList.Items {
+		CRInstance := &polkadotList.Items[i]
+		key := types.NamespacedName{Namespace: CRInstance.Namespace, Name: CRInstance.Name}
+		polled[key] = true
+		nodes, err := fetchNodesStatus(c.client, CRInstance)
+		if err != nil {
+			log.Error(err, "Error on fetch the nodes status...", "Polkadot.Name", CRInstance.Name)
+			continue
+		}
+		if c.setNodesStatus(key, nodes) {
+			c.events <- event.GenericEvent{Meta: CRInstance, Object: CRInstance}
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.nodes {
+		if !polled[key] {
+			delete(c.nodes, key)
+		}
+	}
+}
+
+// setNodesStatus stores the polled status of the nodes of a CustomResource, it tells if the status changed
+func (c *nodeStatusCache) setNodesStatus(key types.NamespacedName, nodes map[string]polledNodeStatus) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	previous, isFound := c.nodes[key]
+	c.nodes[key] = nodes
+	return !isFound || !reflect.DeepEqual(previous, nodes)
+}
+
+// getNodesStatus returns the last polled status of the nodes of the CustomResource, sorted by pod name
+func (c *nodeStatusCache) getNodesStatus(CRInstance *polkadotv1alpha1.Polkadot) []polkadotv1alpha1.NodeStatus {
+	if c == nil {
+		return nil
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var nodes []polkadotv1alpha1.NodeStatus
+	for _, polled := range c.nodes[types.NamespacedName{Namespace: CRInstance.Namespace, Name: CRInstance.Name}] {
+		nodes = append(nodes, polled.status)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// getPodNodeStatus returns the last polled status of the node of the pod. A pod not polled since it started, e.g. just replaced,
+// is queried directly
+func (c *nodeStatusCache) getPodNodeStatus(CRInstance *polkadotv1alpha1.Polkadot, pod corev1.Pod) polkadotv1alpha1.NodeStatus {
+	if c != nil {
+		c.mutex.RLock()
+		polled, isFound := c.nodes[types.NamespacedName{Namespace: CRInstance.Namespace, Name: CRInstance.Name}][pod.Name]
+		c.mutex.RUnlock()
+		if isFound && polled.podUID == pod.UID {
+			return polled.status
+		}
+	}
+	return fetchPodNodeStatus(CRInstance, pod)
+}
+
+// fetchPodNodeStatus queries the node of the pod through its RPC endpoint if it is reachable by the operator,
+// through the metrics exporter sidecar otherwise. Without either the status reports the node as not queryable
+func fetchPodNodeStatus(CRInstance *polkadotv1alpha1.Polkadot, pod corev1.Pod) polkadotv1alpha1.NodeStatus {
+	rpc := getPodRPC(CRInstance, pod)
+	if rpc.IsExternal() {
+		return getNodeStatus(pod, substrate.NewClient(getNodeRPCURL(pod), nodeRPCTimeout), rpc.GetMethods() == polkadotv1alpha1.RPCMethodsUnsafe)
+	}
+	if CRInstance.Spec.MetricsSupport.Enabled {
+		return getNodeMetricsStatus(pod, substrate.NewExporterClient(getNodeMetricsURL(pod), nodeRPCTimeout))
+	}
+	return polkadotv1alpha1.NodeStatus{
+		Name:  pod.Name,
+		Role:  pod.Labels["role"],
+		Error: nodeNotQueryableError,
+	}
+}
+
+// fetchNodesStatus polls every running pod of the CustomResource, through its RPC endpoint or its metrics exporter.
+// The pods of a role that can't be queried are reported with an error
+func fetchNodesStatus(c client.Client, CRInstance *polkadotv1alpha1.Polkadot) (map[string]polledNodeStatus, error) {
+	podList := &corev1.PodList{}
+	err := c.List(context.TODO(), podList, client.InNamespace(CRInstance.Namespace), client.MatchingLabels(getAppLabels(CRInstance.Name)))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]polledNodeStatus)
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		nodes[pod.Name] = polledNodeStatus{
+			podUID: pod.UID,
+			status: fetchPodNodeStatus(CRInstance, pod),
+		}
+	}
+	return nodes, nil
+}
+
+func getNodeRPCURL(pod corev1.Pod) string {
+	return fmt.Sprintf("http://%s:%d", pod.Status.PodIP, config.RPCPortEnvVar.Value)
+}
+
+func getNodeMetricsURL(pod corev1.Pod) string {
+	return fmt.Sprintf("http://%s:%d", pod.Status.PodIP, config.MetricsPortEnvVar.Value)
+}
+
+// isNodeQueryable tells if the operator can query the chain synchronization of the nodes of a role,
+// through their RPC endpoint or through the metrics exporter sidecar
+func isNodeQueryable(CRInstance *polkadotv1alpha1.Polkadot, rpc polkadotv1alpha1.RPCSpec) bool {
+	return rpc.IsExternal() || CRInstance.Spec.MetricsSupport.Enabled
+}
+
+// getPodRPC returns the RPC settings of the role of the pod
+func getPodRPC(CRInstance *polkadotv1alpha1.Polkadot, pod corev1.Pod) polkadotv1alpha1.RPCSpec {
+	if pod.Labels["role"] == getValidatorLabels(CRInstance.Name)["role"] || pod.Labels["role"] == getValidatorSecondaryLabels(CRInstance.Name)["role"] {
+		return CRInstance.GetValidatorRPC()
+	}
+	return CRInstance.GetSentryRPC()
+}
+
+// getNodeStatus queries the health and the sync state of the node, the peers are queried only if the unsafe methods are served
+func getNodeStatus(pod corev1.Pod, rpcClient *substrate.Client, isUnsafeRPC bool) polkadotv1alpha1.NodeStatus {
+	node := polkadotv1alpha1.NodeStatus{
+		Name: pod.Name,
+		Role: pod.Labels["role"],
+	}
+
+	health, err := rpcClient.Health()
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	node.IsSyncing = health.IsSyncing
+	node.Peers = health.Peers
+
+	syncState, err := rpcClient.SyncState()
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	node.CurrentBlock = syncState.CurrentBlock
+	node.HighestBlock = syncState.CurrentBlock
+	if syncState.HighestBlock != nil {
+		node.HighestBlock = *syncState.HighestBlock
+	}
+	if !isUnsafeRPC {
+		return node
+	}
+
+	// the best block announced by the peers can be ahead of the one known by the sync state
+	peers, err := rpcClient.Peers()
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	for _, peer := range peers {
+		if peer.BestNumber > node.HighestBlock {
+			node.HighestBlock = peer.BestNumber
+		}
+	}
+	return node
+}
+
+// getNodeMetricsStatus reads the health and the head block of the node from the metrics exporter sidecar.
+// The exporter doesn't publish the highest block announced by the peers, the node reports whether it is syncing
+func getNodeMetricsStatus(pod corev1.Pod, exporterClient *substrate.ExporterClient) polkadotv1alpha1.NodeStatus {
+	node := polkadotv1alpha1.NodeStatus{
+		Name: pod.Name,
+		Role: pod.Labels["role"],
+	}
+
+	metrics, err := exporterClient.Metrics()
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	node.IsSyncing = metrics.Health.IsSyncing
+	node.Peers = metrics.Health.Peers
+	node.CurrentBlock = metrics.HeadBlock
+	node.HighestBlock = metrics.HeadBlock
+	return node
+}
+
+func isAnyNodeSyncing(nodes []polkadotv1alpha1.NodeStatus) bool {
+	for _, node := range nodes {
+		if node.IsSyncing {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package polkadot
+
+import (
+	"context"
+	"fmt"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"strconv"
+	"testing"
+)
+
+func TestFetchNodesStatus(t *testing.T) {
+
+	server := fake.NewServer(map[string]interface{}{
+		"system_health":    map[string]interface{}{"peers": 2, "isSyncing": true, "shouldHavePeers": true},
+		"system_syncState": map[string]interface{}{"startingBlock": 0, "currentBlock": 100, "highestBlock": 200},
+		"system_peers": []map[string]interface{}{
+			{"peerId": "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPPhm", "roles": "FULL", "bestHash": "0x01", "bestNumber": 210},
+		},
+	})
+	defer server.Close()
+	defer setFakeRPCPort(t, server.URL)()
+
+	// A Polkadot object with metadata and spec.
+	polkadot := getFakePolkadot()
+	polkadot.Spec.Sentry.RPC.Mode = polkadotv1alpha1.RPCModeUnsafeExternal
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	// Objects to track in the fake client.
+	objs := []runtime.Object{
+		polkadot,
+		getFakePod(GetSentryStatefulSetName(CRName)+"-0", getSentrylabels(CRName), corev1.PodRunning),
+		getFakePod(GetSentryStatefulSetName(CRName)+"-1", getSentrylabels(CRName), corev1.PodPending),
+		getFakePod("other-polkadot-sentry-0", getSentrylabels("other-polkadot"), corev1.PodRunning),
+		// the RPC of the validator is bound to the loopback interface by default
+		getFakePod(GetValidatorStatefulSetName(CRName)+"-0", getValidatorLabels(CRName), corev1.PodRunning),
+	}
+
+	// Create a fake client to mock API calls.
+	client := clientfake.NewFakeClientWithScheme(scheme, objs...)
+	cache := newNodeStatusCache(client)
+
+	// the CustomResource is enqueued once its nodes are polled
+	cache.poll()
+	if len(cache.events) != 1 {
+		t.Fatalf("poll: the CustomResource was not enqueued")
+	}
+	<-cache.events
+	nodes := cache.getNodesStatus(polkadot)
+	if len(nodes) != 2 {
+		t.Fatalf("only the running pods of the CR must be polled: (%v)", nodes)
+	}
+	node := nodes[0]
+	if node.Name != GetSentryStatefulSetName(CRName)+"-0" || node.Role != "sentry" || !node.IsSyncing || node.Peers != 2 || node.CurrentBlock != 100 || node.HighestBlock != 210 || node.Error != "" {
+		t.Fatalf("the node status doesn't match the expected result: (%v)", node)
+	}
+	if !isAnyNodeSyncing(nodes) {
+		t.Fatalf("isAnyNodeSyncing: (%v)", nodes)
+	}
+	// without the metrics exporter the validator is reported as not queryable
+	if validator := nodes[1]; validator.Role != "validator" || validator.Error != nodeNotQueryableError {
+		t.Fatalf("the validator must be reported as not queryable: (%v)", validator)
+	}
+
+	// the CustomResource is not enqueued while the status of its nodes doesn't change
+	cache.poll()
+	if len(cache.events) != 0 {
+		t.Fatalf("poll: the CustomResource was enqueued without change")
+	}
+
+	// system_peers is an unsafe method, with the safe ones the highest block is the one of the sync state
+	polkadot.Spec.Sentry.RPC.Mode = polkadotv1alpha1.RPCModeSafeExternal
+	if err := client.Update(context.TODO(), polkadot); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	cache.poll()
+	<-cache.events
+	nodes = cache.getNodesStatus(polkadot)
+	if len(nodes) != 2 || nodes[0].HighestBlock != 200 || nodes[0].Error != "" {
+		t.Fatalf("the node status doesn't match the expected result: (%v)", nodes)
+	}
+
+	// with the metrics support the validator is polled through its metrics exporter
+	exporter := getFakeExporter(4, 0, 205)
+	defer exporter.Close()
+	defer setFakeMetricsPort(t, exporter.URL)()
+	polkadot.Spec.MetricsSupport.Enabled = true
+	if err := client.Update(context.TODO(), polkadot); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	cache.poll()
+	<-cache.events
+	nodes = cache.getNodesStatus(polkadot)
+	if validator := nodes[1]; validator.Error != "" || validator.Peers != 4 || validator.IsSyncing || validator.CurrentBlock != 205 || validator.HighestBlock != 205 {
+		t.Fatalf("the validator status doesn't match the metrics of the exporter: (%v)", validator)
+	}
+
+	server.Close()
+	cache.poll()
+	nodes = cache.getNodesStatus(polkadot)
+	if len(nodes) != 2 || nodes[0].Error == "" {
+		t.Fatalf("an unreachable node must be reported with an error: (%v)", nodes)
+	}
+
+	// the nodes of a deleted CustomResource are dropped
+	if err := client.Delete(context.TODO(), polkadot); err != nil {
+		t.Fatalf("Delete: (%v)", err)
+	}
+	cache.poll()
+	if nodes := cache.getNodesStatus(polkadot); len(nodes) != 0 {
+		t.Fatalf("poll: the nodes of the deleted CustomResource are kept (%v)", nodes)
+	}
+}
+
+func TestIsPolkadotUpdateReconciled(t *testing.T) {
+	polkadot := getFakePolkadot()
+	polkadot.Generation = 1
+	updated := polkadot.DeepCopy()
+
+	// a status update is not reconciled
+	updated.Status.Nodes = []polkadotv1alpha1.NodeStatus{{Name: "node", CurrentBlock: 100}}
+	if isPolkadotUpdateReconciled(event.UpdateEvent{MetaOld: polkadot, ObjectOld: polkadot, MetaNew: updated, ObjectNew: updated}) {
+		t.Fatalf("isPolkadotUpdateReconciled: the status update is reconciled")
+	}
+
+	// a new annotation, e.g. a key rotation request, or a new generation are reconciled
+	updated.Annotations = map[string]string{polkadotv1alpha1.RotateKeysAnnotation: "1"}
+	if !isPolkadotUpdateReconciled(event.UpdateEvent{MetaOld: polkadot, ObjectOld: polkadot, MetaNew: updated, ObjectNew: updated}) {
+		t.Fatalf("isPolkadotUpdateReconciled: the annotation update is not reconciled")
+	}
+	updated = polkadot.DeepCopy()
+	updated.Generation = 2
+	if !isPolkadotUpdateReconciled(event.UpdateEvent{MetaOld: polkadot, ObjectOld: polkadot, MetaNew: updated, ObjectNew: updated}) {
+		t.Fatalf("isPolkadotUpdateReconciled: the spec update is not reconciled")
+	}
+}
+
+// setFakeRPCPort points the RPC port of the nodes to the fake server listening on localhost, it returns the restore function
+func setFakeRPCPort(t *testing.T, serverURL string) func() {
+	return setFakePort(t, &config.RPCPortEnvVar, serverURL)
+}
+
+// setFakeMetricsPort points the port of the metrics exporters to the fake one listening on localhost, it returns the restore function
+func setFakeMetricsPort(t *testing.T, serverURL string) func() {
+	return setFakePort(t, &config.MetricsPortEnvVar, serverURL)
+}
+
+func setFakePort(t *testing.T, envVar *config.EnvVarInt, serverURL string) func() {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatalf("url.Parse: (%v)", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("strconv.Atoi: (%v)", err)
+	}
+	previousPort := envVar.Value
+	envVar.Value = port
+	return func() { envVar.Value = previousPort }
+}
+
+// getFakeExporter serves the metrics of the exporter sidecar of a node
+func getFakeExporter(peers int, isSyncing int, headBlock int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "dot_chain_block_number{block=\"head\"} %d\ndot_peer_count %d\ndot_isSyncing %d\ndot_rpc_healthy 1\n", headBlock, peers, isSyncing)
+	}))
+}
+
+func getFakePod(name string, labels map[string]string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			PodIP: "127.0.0.1",
+		},
+	}
+}
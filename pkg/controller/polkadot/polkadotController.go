@@ -7,15 +7,23 @@ import (
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
 )
 
 var log = logf.Log.WithName(config.ControllerNameEnvVar.Value)
@@ -24,31 +32,45 @@ var log = logf.Log.WithName(config.ControllerNameEnvVar.Value)
 type ReconcilerPolkadot struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	// nodeStatusCache polls the RPC endpoints of the nodes out of the reconciliation
+	nodeStatusCache *nodeStatusCache
 }
 
 // Add creates a new Polkadot Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	nodeStatusCache := newNodeStatusCache(mgr.GetClient())
+	err := mgr.Add(nodeStatusCache)
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, nodeStatusCache), nodeStatusCache.events)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcilerPolkadot{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager, nodeStatusCache *nodeStatusCache) reconcile.Reconciler {
+	return &ReconcilerPolkadot{client: mgr.GetClient(), scheme: mgr.GetScheme(), recorder: mgr.GetEventRecorderFor(config.ControllerNameEnvVar.Value), nodeStatusCache: nodeStatusCache}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+func add(mgr manager.Manager, r reconcile.Reconciler, nodeStatusEvents <-chan event.GenericEvent) error {
 	// Create a new controller
 	c, err := controller.New(config.ControllerNameEnvVar.Value, mgr, controller.Options{Reconciler: r, MaxConcurrentReconciles: 1})
 	if err != nil {
 		return err
 	}
 
-	// Watch for changes to primary resource CustomResource
-	err = c.Watch(&source.Kind{Type: &polkadotv1alpha1.Polkadot{}}, &handler.EnqueueRequestForObject{})
+	// Watch for changes to primary resource CustomResource, except the status updates: the status is written by the reconciliation
+	err = c.Watch(&source.Kind{Type: &polkadotv1alpha1.Polkadot{}}, &handler.EnqueueRequestForObject{}, predicate.Funcs{UpdateFunc: isPolkadotUpdateReconciled})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the status of the nodes polled by the node status cache and requeue the CustomResource
+	err = c.Watch(&source.Channel{Source: nodeStatusEvents}, &handler.EnqueueRequestForObject{})
 	if err != nil {
 		return err
 	}
@@ -71,11 +93,49 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	//TODO add watch for NetworkPolicy
+	// Watch for changes to secondary resource NetworkPolicy and requeue the owner CustomResource
+	err = c.Watch(&source.Kind{Type: &v1.NetworkPolicy{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &polkadotv1alpha1.Polkadot{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource CronJob and requeue the owner CustomResource
+	err = c.Watch(&source.Kind{Type: &batchv1beta1.CronJob{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &polkadotv1alpha1.Polkadot{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the backup Jobs, owned by the CronJob, and requeue the CustomResource of the instance label:
+	// the sentry backed up is stopped while a Job runs
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(getBackupJobRequests),
+	})
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// isPolkadotUpdateReconciled filters the updates of the CustomResource to reconcile: a change of the spec, of the metadata,
+// e.g. the annotations requesting a key rotation, or a deletion. The status updates would trigger a reconciliation each poll
+func isPolkadotUpdateReconciled(e event.UpdateEvent) bool {
+	if e.MetaOld == nil || e.MetaNew == nil {
+		return true
+	}
+	return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration() ||
+		!reflect.DeepEqual(e.MetaOld.GetAnnotations(), e.MetaNew.GetAnnotations()) ||
+		!reflect.DeepEqual(e.MetaOld.GetLabels(), e.MetaNew.GetLabels()) ||
+		!reflect.DeepEqual(e.MetaOld.GetFinalizers(), e.MetaNew.GetFinalizers()) ||
+		(e.MetaOld.GetDeletionTimestamp() == nil) != (e.MetaNew.GetDeletionTimestamp() == nil)
+}
+
 // blank assignment to verify that ReconcilerPolkadot implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcilerPolkadot{}
 
@@ -93,7 +153,91 @@ func (r *ReconcilerPolkadot) Reconcile(request reconcile.Request) (reconcile.Res
 		return handleRequeueStd(err, logger)
 	}
 
-	isRequeueForced, err := r.handleStatefulSet(handledCRInstance)
+	isRequeueForced, err := r.handleFinalizer(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+	if handledCRInstance.GetDeletionTimestamp() != nil {
+		// the CustomResource is being deleted, owned objects are automatically garbage collected
+		return handleRequeueStd(err, logger)
+	}
+
+	isRequeueForced, err = r.handleLegacyResources(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleNodeKey(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleRestore(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleKeyRotation(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleUpgrade(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleValidatorStandby(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleBackup(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleValidatorUpdate(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleStatefulSet(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
+	isRequeueForced, err = r.handleValidatorLease(handledCRInstance)
 	if err != nil {
 		return handleRequeueError(err,logger)
 	}
@@ -109,6 +253,14 @@ func (r *ReconcilerPolkadot) Reconcile(request reconcile.Request) (reconcile.Res
 		return handleRequeueForced(err, logger)
 	}
 
+	isRequeueForced, err = r.handlePublicAddress(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isRequeueForced {
+		return handleRequeueForced(err, logger)
+	}
+
 	isRequeueForced, err = r.handleNetworkPolicy(handledCRInstance)
 	if err != nil {
 		return handleRequeueError(err,logger)
@@ -117,6 +269,16 @@ func (r *ReconcilerPolkadot) Reconcile(request reconcile.Request) (reconcile.Res
 		return handleRequeueForced(err, logger)
 	}
 
+	err = r.handleStatus(handledCRInstance)
+	if err != nil {
+		return handleRequeueError(err,logger)
+	}
+	if isAnyRestoreInProgress(handledCRInstance.Status.Sentry, handledCRInstance.Status.Validator) || isAnyBackupRunning(handledCRInstance.Status.Backups) || isKeyRotationPending(handledCRInstance) || isUpgradeInProgress(handledCRInstance) || isValidatorLeaseStartGated(handledCRInstance) || isValidatorStandbyEnabled(handledCRInstance) {
+		// keep the progress of the restores, backups, key rotations and upgrades up to date, renew the validator Lease and check
+		// the health of the active validator. The chain synchronization status of the nodes is refreshed by the node status cache
+		return handleRequeueAfter(nodeStatusPollInterval, logger)
+	}
+
 	return handleRequeueStd(err, logger)
 }
 
@@ -135,4 +297,9 @@ func handleRequeueForced (err error, logger logr.Logger) (reconcile.Result, erro
 func handleRequeueStd (err error, logger logr.Logger) (reconcile.Result, error){
 	logger.Info("Return and not requeing the request")
 	return reconcile.Result{}, nil
-}
\ No newline at end of file
+}
+
+func handleRequeueAfter (after time.Duration, logger logr.Logger) (reconcile.Result, error){
+	logger.Info("Requeing the Reconciling request after " + after.String())
+	return reconcile.Result{RequeueAfter: after}, nil
+}
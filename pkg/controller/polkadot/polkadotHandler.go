@@ -19,7 +19,7 @@ func (r *ReconcilerPolkadot) handleCustomResource(request reconcile.Request) (*p
 	}
 	if isNotFound == true {
 		// Request object not found, could have been deleted after reconcile request.
-		// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+		// Owned objects are automatically garbage collected, the additional cleanup logic is in handleFinalizer.
 		// Return and don't requeue
 		logger.Info("Custom Resource not found...")
 		return nil, nil
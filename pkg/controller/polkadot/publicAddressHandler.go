@@ -0,0 +1,274 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+)
+
+func (r *ReconcilerPolkadot) handlePublicAddress(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerPublicAddress(CRInstance)
+	return handler.handlePublicAddressSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerPublicAddress(CRInstance *polkadotv1alpha1.Polkadot) IHandlerPublicAddress {
+	if CRInstance.Spec.Sentry.PublicAddressSupport.Enabled != true {
+		return &handlerPublicAddressDefault{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == Sentry || CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerPublicAddressSentry{}
+	}
+	return &handlerPublicAddressDefault{}
+}
+
+//pattern Strategy
+type IHandlerPublicAddress interface {
+	handlePublicAddressSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerPublicAddressSentry struct {
+}
+
+func (h *handlerPublicAddressSentry) handlePublicAddressSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return r.handlePublicAddressGeneric(CRInstance, getSentryPodNames(CRInstance))
+}
+
+type handlerPublicAddressDefault struct {
+}
+
+func (h *handlerPublicAddressDefault) handlePublicAddressSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	// the per-pod Services of a disabled public address support are removed
+	return r.handlePublicServicesRemoval(CRInstance, nil)
+}
+
+// handlePublicAddressGeneric exposes each sentry pod with its own Service and annotates the pod with the external address of the Service.
+// A pod already annotated with a different address is deleted, so that it is recreated advertising the new one
+func (r *ReconcilerPolkadot) handlePublicAddressGeneric(CRInstance *polkadotv1alpha1.Polkadot, podNames []string) (bool, error) {
+	for _, podName := range podNames {
+		isRequeueForced, err := r.handleServiceGeneric(CRInstance, newServiceSentryPublic(CRInstance, podName))
+		if isRequeueForced == ForcedRequeue || err != nil {
+			return isRequeueForced, err
+		}
+	}
+	isRequeueForced, err := r.handlePublicServicesRemoval(CRInstance, podNames)
+	if isRequeueForced == ForcedRequeue || err != nil {
+		return isRequeueForced, err
+	}
+
+	for _, podName := range podNames {
+		isRequeueForced, err := r.handlePodPublicAddress(CRInstance, podName)
+		if isRequeueForced == ForcedRequeue || err != nil {
+			return isRequeueForced, err
+		}
+	}
+	return NotForcedRequeue, nil
+}
+
+func (r *ReconcilerPolkadot) handlePodPublicAddress(CRInstance *polkadotv1alpha1.Polkadot, podName string) (bool, error) {
+
+	logger := log.WithValues("Pod.Namespace", CRInstance.Namespace, "Pod.Name", podName)
+
+	pod := &corev1.Pod{}
+	isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: podName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the sentry Pod...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true || pod.GetDeletionTimestamp() != nil {
+		return NotForcedRequeue, nil
+	}
+
+	service := &corev1.Service{}
+	isNotFound, err = r.fetchResource(service, types.NamespacedName{Name: getSentryPublicServiceName(podName), Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the public Service...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true {
+		return ForcedRequeue, nil
+	}
+
+	publicAddress, err := r.fetchPublicAddress(CRInstance, service, pod)
+	if err != nil {
+		logger.Error(err, "Error on fetch the public address...")
+		return NotForcedRequeue, err
+	}
+	if publicAddress == "" {
+		// the Service watch triggers a new reconciliation once the load balancer is provisioned
+		logger.Info("Waiting for the external address of the public Service or of the node...")
+		return NotForcedRequeue, nil
+	}
+
+	currentAddress := pod.Annotations[publicAddrAnnotation]
+	if currentAddress == publicAddress {
+		return NotForcedRequeue, nil
+	}
+	if currentAddress != "" {
+		logger.Info("Found a public address mismatch, deleting the Pod to restart it...", "Current", currentAddress, "Desired", publicAddress)
+		err := r.deleteResource(pod)
+		if err != nil {
+			logger.Error(err, "Error on deleting the Pod...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the Pod")
+		return ForcedRequeue, nil
+	}
+
+	logger.Info("Annotating the Pod with its public address...", "Address", publicAddress)
+	pod.Annotations = mergeMaps(pod.Annotations, map[string]string{publicAddrAnnotation: publicAddress})
+	delete(pod.Annotations, publicAddrMissingAnnotation)
+	err = r.updateResource(pod)
+	if err != nil {
+		logger.Error(err, "Update Pod Error...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Annotated the Pod")
+	return ForcedRequeue, nil
+}
+
+// fetchPublicAddress returns the multiaddr the pod is reachable at from outside the cluster, empty if not known yet:
+// the ingress of a LoadBalancer Service, or the address of the node running the pod on the node port of a NodePort Service.
+// The internal address of the node is advertised only if explicitly enabled, it is usually private
+func (r *ReconcilerPolkadot) fetchPublicAddress(CRInstance *polkadotv1alpha1.Polkadot, service *corev1.Service, pod *corev1.Pod) (string, error) {
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+		port := getServicePort(service.Spec.Ports, P2PPortName)
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return getPublicMultiaddr(ingress.IP, port), nil
+			}
+			if ingress.Hostname != "" {
+				return getPublicMultiaddr(ingress.Hostname, port), nil
+			}
+		}
+		return "", nil
+	}
+
+	nodePort := getAllocatedNodePort(service.Spec.Ports, P2PPortName)
+	if nodePort == 0 || pod.Spec.NodeName == "" {
+		return "", nil
+	}
+	node := &corev1.Node{}
+	isNotFound, err := r.fetchResource(node, types.NamespacedName{Name: pod.Spec.NodeName})
+	if err != nil || isNotFound == true {
+		return "", err
+	}
+	if address := getNodeAddress(node, corev1.NodeExternalIP); address != "" {
+		return getPublicMultiaddr(address, nodePort), nil
+	}
+	if CRInstance.Spec.Sentry.PublicAddressSupport.InternalAddressFallback {
+		if address := getNodeAddress(node, corev1.NodeInternalIP); address != "" {
+			return getPublicMultiaddr(address, nodePort), nil
+		}
+		return "", nil
+	}
+	// the pod waits for its annotation until the node gets an ExternalIP or the fallback is enabled
+	return "", r.handlePublicAddressMissing(CRInstance, pod, node.Name)
+}
+
+// handlePublicAddressMissing records the node without ExternalIP in an annotation of the pod,
+// so that the Event is emitted once per pod and node instead of at every reconciliation
+func (r *ReconcilerPolkadot) handlePublicAddressMissing(CRInstance *polkadotv1alpha1.Polkadot, pod *corev1.Pod, nodeName string) error {
+	if pod.Annotations[publicAddrMissingAnnotation] == nodeName {
+		return nil
+	}
+	pod.Annotations = mergeMaps(pod.Annotations, map[string]string{publicAddrMissingAnnotation: nodeName})
+	err := r.updateResource(pod)
+	if err != nil {
+		log.Error(err, "Update Pod Error...", "Pod.Name", pod.Name)
+		return err
+	}
+	r.recorder.Event(CRInstance, corev1.EventTypeWarning, publicAddressMissingReason,
+		"The node "+nodeName+" running the pod "+pod.Name+" has no ExternalIP to advertise, enable sentry.publicAddressSupport.internalAddressFallback to advertise its InternalIP")
+	return nil
+}
+
+func getServicePort(ports []corev1.ServicePort, name string) int32 {
+	for _, port := range ports {
+		if port.Name == name {
+			return port.Port
+		}
+	}
+	return 0
+}
+
+func getNodeAddress(node *corev1.Node, addressType corev1.NodeAddressType) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// getPublicMultiaddr returns the multiaddr of an IP address or of a host name
+func getPublicMultiaddr(host string, port int32) string {
+	protocol := "dns4"
+	if ip := net.ParseIP(host); ip != nil {
+		protocol = "ip4"
+		if ip.To4() == nil {
+			protocol = "ip6"
+		}
+	}
+	return "/" + protocol + "/" + host + "/tcp/" + strconv.Itoa(int(port))
+}
+
+// handlePublicServicesRemoval deletes the per-pod Services controlled by the CustomResource of the pods not in podNames, e.g. after a scale down
+func (r *ReconcilerPolkadot) handlePublicServicesRemoval(CRInstance *polkadotv1alpha1.Polkadot, podNames []string) (bool, error) {
+
+	logger := log.WithValues("Polkadot.Namespace", CRInstance.Namespace, "Polkadot.Name", CRInstance.Name)
+
+	serviceList := &corev1.ServiceList{}
+	err := r.client.List(context.TODO(), serviceList, client.InNamespace(CRInstance.Namespace), client.MatchingLabels(getSentrylabels(CRInstance.Name)))
+	if err != nil {
+		logger.Error(err, "Error on list the public Services...")
+		return NotForcedRequeue, err
+	}
+
+	desired := make(map[string]bool)
+	for _, podName := range podNames {
+		desired[podName] = true
+	}
+
+	isRequeueForced := NotForcedRequeue
+	for i := range serviceList.Items {
+		service := &serviceList.Items[i]
+		podName, isPublic := service.Labels[appsv1.StatefulSetPodNameLabel]
+		if !isPublic || desired[podName] || !metav1.IsControlledBy(service, CRInstance) || service.GetDeletionTimestamp() != nil {
+			continue
+		}
+		logger.Info("Deleting the public Service not required anymore...", "Service.Name", service.Name)
+		err := r.deleteResource(service)
+		if err != nil {
+			logger.Error(err, "Error on deleting the public Service...")
+			return NotForcedRequeue, err
+		}
+		isRequeueForced = ForcedRequeue
+	}
+	return isRequeueForced, nil
+}
+
+// fetchPublicAddressesStatus returns the addresses advertised by the pods, as annotated by the operator
+func (r *ReconcilerPolkadot) fetchPublicAddressesStatus(CRInstance *polkadotv1alpha1.Polkadot, podNames []string) map[string]string {
+	var publicAddresses map[string]string
+	for _, podName := range podNames {
+		pod := &corev1.Pod{}
+		isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: podName, Namespace: CRInstance.Namespace})
+		if err != nil || isNotFound == true || pod.Annotations[publicAddrAnnotation] == "" {
+			continue
+		}
+		if publicAddresses == nil {
+			publicAddresses = make(map[string]string)
+		}
+		publicAddresses[podName] = pod.Annotations[publicAddrAnnotation]
+	}
+	return publicAddresses
+}
@@ -0,0 +1,252 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func getFakeSentryPod(ordinal string, nodeName string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: GetSentryStatefulSetName(CRName) + "-" + ordinal},
+		Spec:       corev1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func TestHandlePublicAddressNodePort(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeSentry(), withFakePublicAddress(corev1.ServiceTypeNodePort))
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeExternalIP, Address: "203.0.113.10"}}},
+	}
+	pod := getFakeSentryPod("0", node.Name)
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, node, pod)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	// a Service is created per sentry pod
+	for i := 0; i < 3; i++ {
+		if _, err := reconciler.handlePublicAddress(polkadot); err != nil {
+			t.Fatalf("handlePublicAddress: (%v)", err)
+		}
+	}
+	service := &corev1.Service{}
+	err := client.Get(context.TODO(), types.NamespacedName{Name: getSentryPublicServiceName(pod.Name)}, service)
+	if err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	if service.Spec.Type != corev1.ServiceTypeNodePort || service.Spec.Selector["statefulset.kubernetes.io/pod-name"] != pod.Name {
+		t.Fatalf("handlePublicAddress: unexpected Service (%v)", service.Spec)
+	}
+
+	// the node port is allocated by the API server
+	service.Spec.Ports[0].NodePort = 31000
+	_ = client.Update(context.TODO(), service)
+
+	isRequeueForced, err := reconciler.handlePublicAddress(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	found := &corev1.Pod{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name}, found)
+	if found.Annotations[publicAddrAnnotation] != "/ip4/203.0.113.10/tcp/31000" {
+		t.Fatalf("handlePublicAddress: unexpected annotations (%v)", found.Annotations)
+	}
+
+	// the pod is restarted when its address changes
+	node.Status.Addresses[0].Address = "203.0.113.11"
+	_ = client.Update(context.TODO(), node)
+	isRequeueForced, err = reconciler.handlePublicAddress(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name}, &corev1.Pod{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the Pod was not deleted: (%v)", err)
+	}
+
+	// the Service of a removed pod is deleted
+	polkadot.Spec.Sentry.Replicas = 1
+	isRequeueForced, err = reconciler.handlePublicAddress(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: getSentryPublicServiceName(GetSentryStatefulSetName(CRName) + "-1")}, &corev1.Service{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the Service was not deleted: (%v)", err)
+	}
+}
+
+func TestHandlePublicAddressLoadBalancer(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeSentry(), withFakePublicAddress(corev1.ServiceTypeLoadBalancer))
+	polkadot.Spec.Sentry.Replicas = 1
+	pod := getFakeSentryPod("0", "node-a")
+	service := newServiceSentryPublic(polkadot, pod.Name)
+	if err := (&ReconcilerPolkadot{scheme: scheme}).setOwnership(polkadot, service); err != nil {
+		t.Fatalf("setOwnership: (%v)", err)
+	}
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, pod, service)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	// the pod is not annotated until the load balancer is provisioned
+	isRequeueForced, err := reconciler.handlePublicAddress(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "sentry-0.example.com"}}
+	_ = client.Update(context.TODO(), service)
+	isRequeueForced, err = reconciler.handlePublicAddress(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	found := &corev1.Pod{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name}, found)
+	if found.Annotations[publicAddrAnnotation] != "/dns4/sentry-0.example.com/tcp/"+strconv.Itoa(int(service.Spec.Ports[0].Port)) {
+		t.Fatalf("handlePublicAddress: unexpected annotations (%v)", found.Annotations)
+	}
+
+	// disabling the public address support removes the Services
+	polkadot.Spec.Sentry.PublicAddressSupport.Enabled = false
+	isRequeueForced, err = reconciler.handlePublicAddress(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: service.Name}, &corev1.Service{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the Service was not deleted: (%v)", err)
+	}
+}
+
+func TestHandlePublicAddressInternalAddress(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeSentry(), withFakePublicAddress(corev1.ServiceTypeNodePort))
+	polkadot.Spec.Sentry.Replicas = 1
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.10"}}},
+	}
+	pod := getFakeSentryPod("0", node.Name)
+	pod.Status.HostIP = "10.0.0.10"
+	service := newServiceSentryPublic(polkadot, pod.Name)
+	service.Spec.Ports[0].NodePort = 31000
+	if err := (&ReconcilerPolkadot{scheme: scheme}).setOwnership(polkadot, service); err != nil {
+		t.Fatalf("setOwnership: (%v)", err)
+	}
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, node, pod, service)
+	recorder := record.NewFakeRecorder(10)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}
+
+	// the private address of a node without ExternalIP is not advertised by default
+	isRequeueForced, err := reconciler.handlePublicAddress(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	found := &corev1.Pod{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name}, found)
+	if found.Annotations[publicAddrAnnotation] != "" {
+		t.Fatalf("handlePublicAddress: unexpected annotations (%v)", found.Annotations)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, publicAddressMissingReason) {
+		t.Fatalf("handlePublicAddress: unexpected event (%v)", event)
+	}
+	// the Event is emitted once
+	if _, err := reconciler.handlePublicAddress(polkadot); err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("handlePublicAddress: unexpected event (%v)", <-recorder.Events)
+	}
+
+	// the InternalIP is advertised once explicitly enabled
+	polkadot.Spec.Sentry.PublicAddressSupport.InternalAddressFallback = true
+	isRequeueForced, err = reconciler.handlePublicAddress(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handlePublicAddress: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name}, found)
+	found = &corev1.Pod{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name}, found)
+	if found.Annotations[publicAddrAnnotation] != "/ip4/10.0.0.10/tcp/31000" || found.Annotations[publicAddrMissingAnnotation] != "" {
+		t.Fatalf("handlePublicAddress: unexpected annotations (%v)", found.Annotations)
+	}
+}
+
+func TestGetPublicMultiaddr(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{host: "203.0.113.10", expected: "/ip4/203.0.113.10/tcp/30333"},
+		{host: "2001:db8::1", expected: "/ip6/2001:db8::1/tcp/30333"},
+		{host: "sentry.example.com", expected: "/dns4/sentry.example.com/tcp/30333"},
+	}
+	for _, test := range tests {
+		if result := getPublicMultiaddr(test.host, 30333); result != test.expected {
+			t.Fatalf("getPublicMultiaddr: unexpected multiaddr (%v)", result)
+		}
+	}
+}
+
+func TestNewStatefulSetPublicAddress(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry(), withFakePublicAddress(corev1.ServiceTypeNodePort))
+
+	podSpec := newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	command := strings.Join(podSpec.Containers[0].Command, " ")
+	if !strings.Contains(command, "--public-addr $("+publicAddrEnvVar+")") {
+		t.Fatalf("newStatefulSetSentry: unexpected command (%v)", command)
+	}
+	if len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].VolumeMounts[0].Name != publicAddrVolumeName {
+		t.Fatalf("newStatefulSetSentry: unexpected init containers (%v)", podSpec.InitContainers)
+	}
+	env := podSpec.Containers[0].Env
+	if len(env) != 1 || env[0].ValueFrom.FieldRef.FieldPath != "metadata.annotations['"+publicAddrAnnotation+"']" {
+		t.Fatalf("newStatefulSetSentry: unexpected env (%v)", env)
+	}
+
+	// the sentries are exposed by the per-pod Services only
+	if service := newServiceSentry(polkadot); service.Spec.Type != corev1.ServiceTypeClusterIP {
+		t.Fatalf("newServiceSentry: unexpected type (%v)", service.Spec.Type)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+)
+
+func (r *ReconcilerPolkadot) handleRestore(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerRestore(CRInstance)
+	return handler.handleRestoreSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerRestore(CRInstance *polkadotv1alpha1.Polkadot) IHandlerRestore {
+	if CRKind(CRInstance.Spec.Kind) == Validator {
+		return &handlerRestoreValidator{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == Sentry {
+		return &handlerRestoreSentry{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerRestoreSentryAndValidator{}
+	}
+	return &handlerRestoreDefault{}
+}
+
+//pattern Strategy
+type IHandlerRestore interface {
+	handleRestoreSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerRestoreValidator struct {
+}
+
+func (h *handlerRestoreValidator) handleRestoreSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	// the standby syncs from the network, only the claim of the primary validator is restored
+	podNames := []string{GetValidatorStatefulSetName(CRInstance.Name) + "-0"}
+	return r.handleRestoreGeneric(CRInstance, podNames, getValidatorLabels(CRInstance.Name), CRInstance.Spec.Validator.DataPersistenceSupport)
+}
+
+type handlerRestoreSentry struct {
+}
+
+func (h *handlerRestoreSentry) handleRestoreSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return r.handleRestoreGeneric(CRInstance, getSentryPodNames(CRInstance), getSentrylabels(CRInstance.Name), CRInstance.Spec.Sentry.DataPersistenceSupport)
+}
+
+type handlerRestoreSentryAndValidator struct {
+}
+
+func (h *handlerRestoreSentryAndValidator) handleRestoreSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	isForcedRequeue, err := (&handlerRestoreSentry{}).handleRestoreSpecific(r, CRInstance)
+	if isForcedRequeue == ForcedRequeue || err != nil {
+		return isForcedRequeue, err
+	}
+	return (&handlerRestoreValidator{}).handleRestoreSpecific(r, CRInstance)
+}
+
+type handlerRestoreDefault struct {
+}
+
+func (h *handlerRestoreDefault) handleRestoreSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return handleSkip()
+}
+
+// handleRestoreGeneric provisions the data claim of each pod from the claim or the VolumeSnapshot the role is restored from, before the
+// StatefulSet creates it from the volume claim template: every pod gets its own copy. A claim already present, e.g. of a pod restarted,
+// is kept. The claims are not owned by the CR, as the claims created by the StatefulSet, so that the retention policy applies
+func (r *ReconcilerPolkadot) handleRestoreGeneric(CRInstance *polkadotv1alpha1.Polkadot, podNames []string, labels map[string]string, dataPersistence polkadotv1alpha1.DataPersistenceSupport) (bool, error) {
+	if !dataPersistence.Enabled || !isRestoreSourceClone(dataPersistence.RestoreFrom) {
+		return NotForcedRequeue, nil
+	}
+
+	for _, podName := range podNames {
+		name := getDataClaimName(dataPersistence.PersistentVolumeClaim.ObjectMeta.Name, podName)
+		logger := log.WithValues("PersistentVolumeClaim.Namespace", CRInstance.Namespace, "PersistentVolumeClaim.Name", name)
+
+		isNotFound, err := r.fetchResource(&corev1.PersistentVolumeClaim{}, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the PersistentVolumeClaim...")
+			return NotForcedRequeue, err
+		}
+		if isNotFound == false {
+			continue
+		}
+
+		logger.Info("PersistentVolumeClaim not found...")
+		logger.Info("Creating a new PersistentVolumeClaim from the restore source...")
+		err = r.client.Create(context.TODO(), newPersistentVolumeClaimRestore(name, CRInstance.Namespace, labels, dataPersistence))
+		if err != nil {
+			logger.Error(err, "Error on creating a new PersistentVolumeClaim...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Created the new PersistentVolumeClaim")
+		return ForcedRequeue, nil
+	}
+	return NotForcedRequeue, nil
+}
+
+// fetchRestoresStatus returns the progress of the restore of the pods, nil if the role is not restored
+func (r *ReconcilerPolkadot) fetchRestoresStatus(CRInstance *polkadotv1alpha1.Polkadot, dataPersistence polkadotv1alpha1.DataPersistenceSupport, podNames []string) map[string]polkadotv1alpha1.RestoreStatus {
+	if !dataPersistence.Enabled || dataPersistence.RestoreFrom == nil {
+		return nil
+	}
+	var restores map[string]polkadotv1alpha1.RestoreStatus
+	for _, podName := range podNames {
+		pod := &corev1.Pod{}
+		isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: podName, Namespace: CRInstance.Namespace})
+		if err != nil || isNotFound == true {
+			continue
+		}
+		restore, isFound := getRestoreStatus(pod)
+		if !isFound {
+			continue
+		}
+		if restores == nil {
+			restores = make(map[string]polkadotv1alpha1.RestoreStatus)
+		}
+		restores[podName] = restore
+	}
+	return restores
+}
+
+// getRestoreStatus observes the state of the restore init container of the pod, the kubelet retries a failed container
+// and keeps the outcome of the failed run as the last termination state
+func getRestoreStatus(pod *corev1.Pod) (polkadotv1alpha1.RestoreStatus, bool) {
+	for _, containerStatus := range pod.Status.InitContainerStatuses {
+		if containerStatus.Name != restoreContainerName {
+			continue
+		}
+		state := containerStatus.State
+		if state.Running != nil {
+			startTime := state.Running.StartedAt
+			return polkadotv1alpha1.RestoreStatus{Phase: polkadotv1alpha1.RestorePhaseRestoring, StartTime: &startTime}, true
+		}
+		if state.Terminated == nil {
+			state = containerStatus.LastTerminationState
+		}
+		if state.Terminated == nil {
+			return polkadotv1alpha1.RestoreStatus{Phase: polkadotv1alpha1.RestorePhasePending}, true
+		}
+		startTime := state.Terminated.StartedAt
+		completionTime := state.Terminated.FinishedAt
+		restore := polkadotv1alpha1.RestoreStatus{
+			Phase:          polkadotv1alpha1.RestorePhaseCompleted,
+			StartTime:      &startTime,
+			CompletionTime: &completionTime,
+			Message:        strings.TrimSpace(state.Terminated.Message),
+		}
+		if state.Terminated.ExitCode != 0 {
+			restore.Phase = polkadotv1alpha1.RestorePhaseFailed
+		}
+		return restore, true
+	}
+	return polkadotv1alpha1.RestoreStatus{}, false
+}
+
+// isAnyRestoreInProgress tells if the status of a restore is expected to change without a change of the child resources
+func isAnyRestoreInProgress(nodeSets ...polkadotv1alpha1.NodeSetStatus) bool {
+	for _, nodeSet := range nodeSets {
+		for _, restore := range nodeSet.Restores {
+			if restore.Phase != polkadotv1alpha1.RestorePhaseCompleted {
+				return true
+			}
+		}
+	}
+	return false
+}
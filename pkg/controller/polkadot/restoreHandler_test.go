@@ -0,0 +1,231 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestHandleRestoreVolumeSnapshot(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeRestore(polkadotv1alpha1.RestoreSource{VolumeSnapshotName: "snapshot-a"}))
+	polkadot.Spec.Sentry.Replicas = 2
+	// the claim of a pod already present is kept
+	keptClaim := getFakePVC(GetSentryStatefulSetName(CRName), 1)
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, keptClaim)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	isRequeueForced, err := reconciler.handleRestore(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleRestore: (%v)", err)
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	claimName := getDataClaimName("polkadot-volume", GetSentryStatefulSetName(CRName)+"-0")
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: claimName}, pvc); err != nil {
+		t.Fatalf("handleRestore: (%v)", err)
+	}
+	dataSource := pvc.Spec.DataSource
+	if dataSource == nil || dataSource.Kind != "VolumeSnapshot" || dataSource.Name != "snapshot-a" || *dataSource.APIGroup != "snapshot.storage.k8s.io" {
+		t.Fatalf("handleRestore: unexpected data source (%v)", pvc.Spec.DataSource)
+	}
+	if pvc.Labels[instanceLabel] != CRName || len(pvc.OwnerReferences) != 0 {
+		t.Fatalf("handleRestore: the claim must be labelled as the claims of the StatefulSet and not owned by the CR (%v)", pvc.ObjectMeta)
+	}
+	isRequeueForced, err = reconciler.handleRestore(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleRestore: (%v)", err)
+	}
+	pvc = &corev1.PersistentVolumeClaim{}
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: keptClaim.Name}, pvc); err != nil || pvc.Spec.DataSource != nil {
+		t.Fatalf("handleRestore: the claim already present was replaced (%v)", err)
+	}
+
+	// a new pod gets a claim cloned from the source claim
+	polkadot.Spec.Sentry.Replicas = 3
+	polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom = &polkadotv1alpha1.RestoreSource{PersistentVolumeClaimName: "retained-claim"}
+	isRequeueForced, err = reconciler.handleRestore(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleRestore: (%v)", err)
+	}
+	claimName = getDataClaimName("polkadot-volume", GetSentryStatefulSetName(CRName)+"-2")
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: claimName}, pvc); err != nil {
+		t.Fatalf("handleRestore: (%v)", err)
+	}
+	if dataSource := pvc.Spec.DataSource; dataSource == nil || dataSource.Kind != "PersistentVolumeClaim" || dataSource.Name != "retained-claim" || dataSource.APIGroup != nil {
+		t.Fatalf("handleRestore: unexpected data source (%v)", pvc.Spec.DataSource)
+	}
+
+	// the claims of a downloaded archive are created by the StatefulSet
+	polkadot.Spec.Sentry.Replicas = 4
+	polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom = &polkadotv1alpha1.RestoreSource{URL: "https://snapshots.example.com/polkadot.tar.gz"}
+	isRequeueForced, err = reconciler.handleRestore(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleRestore: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: getDataClaimName("polkadot-volume", GetSentryStatefulSetName(CRName)+"-3")}, pvc)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("handleRestore: a claim was created for a downloaded archive (%v)", err)
+	}
+}
+
+func TestNewStatefulSetRestore(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence(), withFakeRestore(polkadotv1alpha1.RestoreSource{URL: "https://snapshots.example.com/polkadot.tar.gz"}))
+
+	podSpec := newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	if len(podSpec.InitContainers) != 2 || podSpec.InitContainers[1].Name != restoreContainerName || len(podSpec.Volumes) != 0 {
+		t.Fatalf("newStatefulSetSentry: unexpected init containers (%v)", podSpec.InitContainers)
+	}
+	restore := podSpec.InitContainers[1]
+	script := restore.Command[2]
+	if restore.Image != restoreDownloadImage || restore.Env[0].Value != polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom.URL || !strings.Contains(script, "| tar -xzf - -C /data") {
+		t.Fatalf("newStatefulSetSentry: unexpected restore container (%v)", restore)
+	}
+
+	// the requests to the object storage are signed with the credentials of the Secret
+	polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom = &polkadotv1alpha1.RestoreSource{S3: &polkadotv1alpha1.S3Object{
+		S3Bucket: polkadotv1alpha1.S3Bucket{Endpoint: "https://s3.example.com/", Bucket: "snapshots", CredentialsSecretRef: &corev1.LocalObjectReference{Name: "s3-credentials"}},
+		Key:      "polkadot/db.tar",
+	}}
+	restore = newStatefulSetSentry(polkadot, nil).Spec.Template.Spec.InitContainers[1]
+	script = restore.Command[2]
+	if restore.Env[0].Value != "https://s3.example.com/snapshots/polkadot/db.tar" || len(restore.Env) != 3 || restore.Env[2].ValueFrom.SecretKeyRef.Name != "s3-credentials" {
+		t.Fatalf("newStatefulSetSentry: unexpected env (%v)", restore.Env)
+	}
+	if !strings.Contains(script, `--aws-sigv4 "aws:amz:us-east-1:s3"`) || !strings.Contains(script, "| tar -xf - -C /data") {
+		t.Fatalf("newStatefulSetSentry: unexpected script (%v)", script)
+	}
+
+	// a claim provisioned from a VolumeSnapshot is prepared before the resync, no restore volume is mounted
+	polkadot.Spec.Sentry.DataPersistenceSupport.RestoreFrom = &polkadotv1alpha1.RestoreSource{VolumeSnapshotName: "snapshot-a"}
+	polkadot.Spec.Sentry.Database.Resync = "1"
+	podSpec = newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	if len(podSpec.InitContainers) != 3 || podSpec.InitContainers[1].Name != restoreContainerName || podSpec.InitContainers[2].Name != "resync-data" || len(podSpec.Volumes) != 0 {
+		t.Fatalf("newStatefulSetSentry: unexpected init containers (%v)", podSpec.InitContainers)
+	}
+	if restore := podSpec.InitContainers[1]; restore.Image != busyboxImage || len(restore.VolumeMounts) != 1 {
+		t.Fatalf("newStatefulSetSentry: unexpected restore container (%v)", restore)
+	}
+	// the copied volume is marked by the restore init container only
+	if permissions := podSpec.InitContainers[0]; len(permissions.Env) != 0 {
+		t.Fatalf("newStatefulSetSentry: unexpected volume permissions container (%v)", permissions)
+	}
+}
+
+// TestRestoreCloneScript runs the script of the restore init container of a copied volume, the VOLUME_OWNER variable is expanded by the kubelet
+func TestRestoreCloneScript(t *testing.T) {
+	directory, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatalf("TempDir: (%v)", err)
+	}
+	defer os.RemoveAll(directory)
+	data := filepath.Join(directory, "data")
+	terminationLog := filepath.Join(directory, "termination-log")
+	for _, dir := range []string{"db", "keystore", "network"} {
+		if err := os.MkdirAll(filepath.Join(data, "chains", "ksmcc3", dir), 0755); err != nil {
+			t.Fatalf("MkdirAll: (%v)", err)
+		}
+	}
+	// the volume of another node
+	if err := ioutil.WriteFile(filepath.Join(data, volumeOwnerFileName), []byte("uid-a/polkadot-cr-sentry-1"), 0644); err != nil {
+		t.Fatalf("WriteFile: (%v)", err)
+	}
+
+	container := getRestoreCloneInitContainer("polkadot-volume", "uid-b", "1")
+	script := strings.NewReplacer(volumeMountPath+"/", data+"/", "/dev/termination-log", terminationLog).Replace(container.Command[2])
+	run := func() string {
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Env = append(os.Environ(), volumeOwnerEnvVar+"=uid-b/polkadot-cr-sentry-0", resyncEnvVar+"=1")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("the restore script failed: (%v) %s", err, output)
+		}
+		message, err := ioutil.ReadFile(terminationLog)
+		if err != nil {
+			t.Fatalf("ReadFile: (%v)", err)
+		}
+		return strings.TrimSpace(string(message))
+	}
+
+	if message := run(); message != "restored" {
+		t.Fatalf("unexpected message (%v)", message)
+	}
+	files, _ := filepath.Glob(filepath.Join(data, "chains", "ksmcc3", "*"))
+	if len(files) != 1 || filepath.Base(files[0]) != "db" {
+		t.Fatalf("the keys of the source node were not removed: (%v)", files)
+	}
+	if owner, _ := ioutil.ReadFile(filepath.Join(data, volumeOwnerFileName)); string(owner) != "uid-b/polkadot-cr-sentry-0" {
+		t.Fatalf("the volume was not marked: (%s)", owner)
+	}
+	if resync, _ := ioutil.ReadFile(filepath.Join(data, resyncFileName)); string(resync) != "1" {
+		t.Fatalf("the resync token was not recorded: (%s)", resync)
+	}
+
+	// the keys generated by the node once restored are kept on restart
+	if err := os.MkdirAll(filepath.Join(data, "chains", "ksmcc3", "network"), 0755); err != nil {
+		t.Fatalf("MkdirAll: (%v)", err)
+	}
+	if message := run(); !strings.HasPrefix(message, "skipped") {
+		t.Fatalf("unexpected message (%v)", message)
+	}
+	if _, err := os.Stat(filepath.Join(data, "chains", "ksmcc3", "network")); err != nil {
+		t.Fatalf("the keys of the node were removed on restart: (%v)", err)
+	}
+}
+
+func TestGetRestoreStatus(t *testing.T) {
+	startedAt := metav1.Now()
+	tests := []struct {
+		name     string
+		status   corev1.ContainerStatus
+		expected polkadotv1alpha1.RestorePhase
+		message  string
+	}{
+		{name: "pending", status: corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}}, expected: polkadotv1alpha1.RestorePhasePending},
+		{name: "restoring", status: corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: startedAt}}}, expected: polkadotv1alpha1.RestorePhaseRestoring},
+		{name: "completed", status: corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "restored\n"}}}, expected: polkadotv1alpha1.RestorePhaseCompleted, message: "restored"},
+		{
+			name: "failed",
+			status: corev1.ContainerStatus{
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 22, Message: "curl: (22) The requested URL returned error: 404"}},
+			},
+			expected: polkadotv1alpha1.RestorePhaseFailed,
+			message:  "curl: (22) The requested URL returned error: 404",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.status.Name = restoreContainerName
+			pod := &corev1.Pod{Status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{{Name: "volume-mount-permissions-data"}, test.status}}}
+			restore, isFound := getRestoreStatus(pod)
+			if !isFound || restore.Phase != test.expected || restore.Message != test.message {
+				t.Fatalf("getRestoreStatus: unexpected status (%v)", restore)
+			}
+		})
+	}
+
+	if _, isFound := getRestoreStatus(&corev1.Pod{}); isFound {
+		t.Fatalf("getRestoreStatus: unexpected status of a pod without restore")
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newPersistentVolumeClaimRestore returns the data claim of a pod, named as the claim the StatefulSet creates from the volume claim template,
+// provisioned from the claim or the VolumeSnapshot the role is restored from
+func newPersistentVolumeClaimRestore(name string, namespace string, labels map[string]string, dataPersistence polkadotv1alpha1.DataPersistenceSupport) *corev1.PersistentVolumeClaim {
+	template := dataPersistence.PersistentVolumeClaim
+	spec := template.Spec.DeepCopy()
+	spec.DataSource = getRestoreDataSource(dataPersistence.RestoreFrom)
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    mergeMaps(template.Labels, labels),
+		},
+		Spec: *spec,
+	}
+}
+
+func getRestoreDataSource(source *polkadotv1alpha1.RestoreSource) *corev1.TypedLocalObjectReference {
+	if source.VolumeSnapshotName != "" {
+		apiGroup := volumeSnapshotGVK.Group
+		return &corev1.TypedLocalObjectReference{APIGroup: &apiGroup, Kind: volumeSnapshotGVK.Kind, Name: source.VolumeSnapshotName}
+	}
+	return &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: source.PersistentVolumeClaimName}
+}
+
+// isRestoreSourceClone tells if the data claims are provisioned from the source, the other sources are downloaded by the restore init container
+func isRestoreSourceClone(source *polkadotv1alpha1.RestoreSource) bool {
+	return source != nil && (source.PersistentVolumeClaimName != "" || source.VolumeSnapshotName != "")
+}
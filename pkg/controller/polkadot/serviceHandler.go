@@ -6,7 +6,9 @@ import (
 	"github.com/go-logr/logr"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/types"
+	"reflect"
 )
 
 func (r *ReconcilerPolkadot) handleService(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
@@ -36,23 +38,21 @@ type IHandlerService interface {
 type handlerServiceValidator struct {
 }
 func (h *handlerServiceValidator) handleServiceSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
-	return r.handleServiceGeneric(CRInstance, newServiceValidator(CRInstance))
+	return r.handleServicesGeneric(CRInstance, newServiceValidator(CRInstance), newServiceValidatorRPC(CRInstance), newServiceValidatorHeadless(CRInstance))
 }
 
 type handlerServiceSentry struct {
 }
 func (h *handlerServiceSentry) handleServiceSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
-	return r.handleServiceGeneric(CRInstance, newServiceSentry(CRInstance))
+	return r.handleServicesGeneric(CRInstance, newServiceSentry(CRInstance), newServiceSentryRPC(CRInstance), newServiceSentryHeadless(CRInstance))
 }
 
 type handlerServiceSentryAndValidator struct {
 }
 func (h *handlerServiceSentryAndValidator) handleServiceSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
-	isForcedRequeue, err := r.handleServiceGeneric(CRInstance, newServiceSentry(CRInstance))
-	if isForcedRequeue == ForcedRequeue || err != nil {
-		return isForcedRequeue, err
-	}
-	return r.handleServiceGeneric(CRInstance, newServiceValidator(CRInstance))
+	return r.handleServicesGeneric(CRInstance,
+		newServiceSentry(CRInstance), newServiceSentryRPC(CRInstance), newServiceSentryHeadless(CRInstance),
+		newServiceValidator(CRInstance), newServiceValidatorRPC(CRInstance), newServiceValidatorHeadless(CRInstance))
 }
 
 type handlerServiceDefault struct {
@@ -61,6 +61,17 @@ func (h *handlerServiceDefault) handleServiceSpecific(r *ReconcilerPolkadot, CRI
 	return handleSkip()
 }
 
+// handleServicesGeneric reconciles the Services in order, it stops at the first one requiring a requeue
+func (r *ReconcilerPolkadot) handleServicesGeneric(CRInstance *polkadotv1alpha1.Polkadot, desiredResources ...*corev1.Service) (bool, error) {
+	for _, desiredResource := range desiredResources {
+		isForcedRequeue, err := r.handleServiceGeneric(CRInstance, desiredResource)
+		if isForcedRequeue == ForcedRequeue || err != nil {
+			return isForcedRequeue, err
+		}
+	}
+	return NotForcedRequeue, nil
+}
+
 func (r *ReconcilerPolkadot) handleServiceGeneric(CRInstance *polkadotv1alpha1.Polkadot, desiredResource *corev1.Service) (bool, error) {
 
 	logger := log.WithValues("Service.Namespace", desiredResource.Namespace, "Service.Name", desiredResource.Name)
@@ -84,9 +95,20 @@ func (r *ReconcilerPolkadot) handleServiceGeneric(CRInstance *polkadotv1alpha1.P
 	}
 	foundResource := toBeFoundResource
 
+	if isServiceRecreationRequired(foundResource, desiredResource, logger) {
+		logger.Info("Deleting the Service to recreate it...")
+		err := r.deleteResource(foundResource)
+		if err != nil {
+			logger.Error(err, "Error on deleting the Service...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the Service")
+		return ForcedRequeue, nil
+	}
+
 	if areServicesDifferent(foundResource, desiredResource, logger) {
 		logger.Info("Updating the Service...")
-		err := r.updateResource(desiredResource)
+		err := r.updateResource(getServiceToUpdate(foundResource, desiredResource))
 		if err != nil {
 			logger.Error(err, "Update Service Error...")
 			return NotForcedRequeue, err
@@ -97,7 +119,143 @@ func (r *ReconcilerPolkadot) handleServiceGeneric(CRInstance *polkadotv1alpha1.P
 	return NotForcedRequeue, nil
 }
 
+// isServiceRecreationRequired detects the changes that can't be applied in place, e.g. from and to a headless Service
+func isServiceRecreationRequired(currentService *corev1.Service, desiredService *corev1.Service, logger logr.Logger) bool {
+	isCurrentHeadless := currentService.Spec.ClusterIP == corev1.ClusterIPNone
+	isDesiredHeadless := desiredService.Spec.ClusterIP == corev1.ClusterIPNone
+	if isCurrentHeadless != isDesiredHeadless {
+		logger.Info("Found a clusterIP mismatch...", "Current", currentService.Spec.ClusterIP, "Desired", desiredService.Spec.ClusterIP)
+		return true
+	}
+	return false
+}
+
 func areServicesDifferent(currentService *corev1.Service, desiredService *corev1.Service, logger logr.Logger) bool {
 	result := false
+
+	if currentService.Spec.Type != desiredService.Spec.Type {
+		logger.Info("Found a type mismatch...", "Current", currentService.Spec.Type, "Desired", desiredService.Spec.Type)
+		result = true
+	}
+	if currentService.Spec.PublishNotReadyAddresses != desiredService.Spec.PublishNotReadyAddresses {
+		logger.Info("Found a publishNotReadyAddresses mismatch...")
+		result = true
+	}
+	// the policy is defaulted to Cluster by the API server
+	if desiredService.Spec.ExternalTrafficPolicy != "" && currentService.Spec.ExternalTrafficPolicy != desiredService.Spec.ExternalTrafficPolicy {
+		logger.Info("Found an externalTrafficPolicy mismatch...", "Current", currentService.Spec.ExternalTrafficPolicy, "Desired", desiredService.Spec.ExternalTrafficPolicy)
+		result = true
+	}
+	if !equality.Semantic.DeepEqual(currentService.Spec.LoadBalancerSourceRanges, desiredService.Spec.LoadBalancerSourceRanges) {
+		logger.Info("Found a loadBalancerSourceRanges mismatch...")
+		result = true
+	}
+	for key, value := range desiredService.Annotations {
+		if currentService.Annotations[key] != value {
+			logger.Info("Found an annotation mismatch...", "Annotation", key)
+			result = true
+		}
+	}
+	for _, key := range getDroppedAnnotations(currentService, desiredService) {
+		logger.Info("Found an annotation removed...", "Annotation", key)
+		result = true
+	}
+	if !reflect.DeepEqual(getCopy(currentService.Spec.Selector), getCopy(desiredService.Spec.Selector)) {
+		logger.Info("Found a selector mismatch...")
+		result = true
+	}
+	for key, value := range desiredService.Labels {
+		if currentService.Labels[key] != value {
+			logger.Info("Found a label mismatch...", "Label", key)
+			result = true
+		}
+	}
+	if areServicePortsDifferent(currentService.Spec.Ports, desiredService.Spec.Ports) {
+		logger.Info("Found a ports mismatch...")
+		result = true
+	}
+
 	return result
-}
\ No newline at end of file
+}
+
+// areServicePortsDifferent ignores the nodePorts allocated by the API server
+func areServicePortsDifferent(currentPorts []corev1.ServicePort, desiredPorts []corev1.ServicePort) bool {
+	if len(currentPorts) != len(desiredPorts) {
+		return true
+	}
+	for i := range desiredPorts {
+		c, d := currentPorts[i], desiredPorts[i]
+		if c.Name != d.Name || c.Port != d.Port || c.TargetPort != d.TargetPort || getProtocol(c) != getProtocol(d) {
+			return true
+		}
+		if d.NodePort != 0 && c.NodePort != d.NodePort {
+			return true
+		}
+	}
+	return false
+}
+
+func getProtocol(port corev1.ServicePort) corev1.Protocol {
+	if port.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+	return port.Protocol
+}
+
+// getServiceToUpdate applies the managed fields of desired on a copy of current,
+// so that the resourceVersion, the clusterIP and the allocated nodePorts are preserved
+func getServiceToUpdate(currentService *corev1.Service, desiredService *corev1.Service) *corev1.Service {
+	updated := currentService.DeepCopy()
+	updated.Labels = mergeMaps(updated.Labels, desiredService.Labels)
+	updated.Annotations = mergeMaps(updated.Annotations, desiredService.Annotations)
+	for _, key := range getDroppedAnnotations(currentService, desiredService) {
+		delete(updated.Annotations, key)
+	}
+	updated.Spec.Type = desiredService.Spec.Type
+	updated.Spec.PublishNotReadyAddresses = desiredService.Spec.PublishNotReadyAddresses
+	updated.Spec.LoadBalancerSourceRanges = desiredService.Spec.LoadBalancerSourceRanges
+	updated.Spec.ExternalTrafficPolicy = getExternalTrafficPolicyToUpdate(currentService, desiredService)
+	if updated.Spec.Type != corev1.ServiceTypeLoadBalancer || updated.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+		// the health check node port is allocated only for a LoadBalancer with the Local policy
+		updated.Spec.HealthCheckNodePort = 0
+	}
+	updated.Spec.Selector = desiredService.Spec.Selector
+	updated.Spec.Ports = getServicePortsToUpdate(currentService, desiredService)
+	return updated
+}
+
+// getExternalTrafficPolicyToUpdate keeps the policy defaulted by the API server, it is rejected by a ClusterIP Service
+func getExternalTrafficPolicyToUpdate(currentService *corev1.Service, desiredService *corev1.Service) corev1.ServiceExternalTrafficPolicyType {
+	if desiredService.Spec.Type == corev1.ServiceTypeClusterIP {
+		return ""
+	}
+	if desiredService.Spec.ExternalTrafficPolicy == "" {
+		return currentService.Spec.ExternalTrafficPolicy
+	}
+	return desiredService.Spec.ExternalTrafficPolicy
+}
+
+func getServicePortsToUpdate(currentService *corev1.Service, desiredService *corev1.Service) []corev1.ServicePort {
+	isNodePortAllowed := desiredService.Spec.Type == corev1.ServiceTypeNodePort || desiredService.Spec.Type == corev1.ServiceTypeLoadBalancer
+
+	ports := make([]corev1.ServicePort, len(desiredService.Spec.Ports))
+	for i, port := range desiredService.Spec.Ports {
+		if !isNodePortAllowed {
+			// a ClusterIP Service is rejected if it keeps a nodePort
+			port.NodePort = 0
+		} else if port.NodePort == 0 {
+			port.NodePort = getAllocatedNodePort(currentService.Spec.Ports, port.Name)
+		}
+		ports[i] = port
+	}
+	return ports
+}
+
+func getAllocatedNodePort(ports []corev1.ServicePort, name string) int32 {
+	for _, port := range ports {
+		if port.Name == name {
+			return port.NodePort
+		}
+	}
+	return 0
+}
@@ -1,9 +1,15 @@
 package polkadot
 
 import (
+	"context"
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	v12 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"testing"
 )
@@ -17,14 +23,14 @@ func TestServiceGeneric(t *testing.T) {
 	testsOK := testStruct{
 		{
 			name:        "Service healthy",
-			newResource: getFakeService(ServiceSentryName, corev1.ServiceTypeClusterIP),
+			newResource: getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeClusterIP),
 		},
 	}
 
 	testsNotFound := testStruct{
 		{
 			name:        "Service not found",
-			newResource: getFakeService(ServiceSentryName, corev1.ServiceTypeClusterIP),
+			newResource: getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeClusterIP),
 		},
 	}
 
@@ -72,3 +78,321 @@ func TestServiceGeneric(t *testing.T) {
 	}
 }
 
+
+func TestAreServicesDifferent(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		mutate     func(current *corev1.Service)
+		isExpected bool
+	}{
+		{
+			name:       "Service equal",
+			mutate:     func(current *corev1.Service) {},
+			isExpected: false,
+		},
+		{
+			name: "Service with allocated nodePorts",
+			mutate: func(current *corev1.Service) {
+				current.Spec.Type = corev1.ServiceTypeNodePort
+				current.Spec.ClusterIP = "10.0.0.1"
+				for i := range current.Spec.Ports {
+					current.Spec.Ports[i].NodePort = 30000 + int32(i)
+				}
+			},
+			isExpected: false,
+		},
+		{
+			name: "Service type changed",
+			mutate: func(current *corev1.Service) {
+				current.Spec.Type = corev1.ServiceTypeClusterIP
+			},
+			isExpected: true,
+		},
+		{
+			name: "Service port changed",
+			mutate: func(current *corev1.Service) {
+				current.Spec.Ports[0].Port = 30334
+			},
+			isExpected: true,
+		},
+		{
+			name: "Service selector changed",
+			mutate: func(current *corev1.Service) {
+				current.Spec.Selector = getValidatorLabels(CRName)
+			},
+			isExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			polkadot := getFakePolkadot(withFakeSentry())
+			current := newServiceSentry(polkadot)
+			test.mutate(current)
+
+			isDifferent := areServicesDifferent(current, newServiceSentry(polkadot), log)
+			if isDifferent != test.isExpected {
+				t.Fatalf("areServicesDifferent: (%v)", isDifferent)
+			}
+		})
+	}
+}
+
+func TestHandleServiceGenericUpdate(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	t.Run("Service type updated in place", func(t *testing.T) {
+		// A Polkadot object with metadata and spec.
+		polkadot := getFakePolkadot(withFakeSentry())
+		polkadot.Spec.Kind = string(SentryAndValidator)
+
+		current := newServiceValidator(polkadot)
+		current.Spec.ClusterIP = "10.0.0.1"
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, current)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		polkadot.Spec.Kind = string(Validator)
+		isRequeueForced, err := reconciler.handleServiceGeneric(polkadot, newServiceValidator(polkadot))
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleServiceGeneric: (%v)", err)
+		}
+
+		found := &corev1.Service{}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: GetValidatorServiceName(CRName)}, found)
+		if err != nil {
+			t.Fatalf("handleServiceGeneric: (%v)", err)
+		}
+		if found.Spec.Type != corev1.ServiceTypeNodePort || found.Spec.ClusterIP != "10.0.0.1" {
+			t.Fatalf("the Service was not updated in place: (%v) (%v)", found.Spec.Type, found.Spec.ClusterIP)
+		}
+	})
+
+	t.Run("Service nodePorts preserved", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeSentry())
+
+		current := newServiceSentry(polkadot)
+		current.Spec.Ports[0].NodePort = 31945
+		current.Spec.Ports = current.Spec.Ports[:1]
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, current)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleServiceGeneric(polkadot, newServiceSentry(polkadot))
+		if isRequeueForced || err != nil {
+			t.Fatalf("handleServiceGeneric: (%v)", err)
+		}
+
+		found := &corev1.Service{}
+		err = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryServiceName(CRName)}, found)
+		if err != nil {
+			t.Fatalf("handleServiceGeneric: (%v)", err)
+		}
+		if len(found.Spec.Ports) != len(newServiceSentry(polkadot).Spec.Ports) || found.Spec.Ports[0].NodePort != 31945 {
+			t.Fatalf("the allocated nodePort was not preserved: (%v)", found.Spec.Ports)
+		}
+	})
+
+	t.Run("Service recreated", func(t *testing.T) {
+		polkadot := getFakePolkadot(withFakeSentry())
+
+		current := newServiceSentry(polkadot)
+		current.Spec.ClusterIP = corev1.ClusterIPNone
+
+		// Create a fake client to mock API calls.
+		client := fake.NewFakeClientWithScheme(scheme, polkadot, current)
+		reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+		isRequeueForced, err := reconciler.handleServiceGeneric(polkadot, newServiceSentry(polkadot))
+		if !isRequeueForced || err != nil {
+			t.Fatalf("handleServiceGeneric: (%v)", err)
+		}
+
+		err = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryServiceName(CRName)}, &corev1.Service{})
+		if !errors.IsNotFound(err) {
+			t.Fatalf("the Service was not deleted: (%v)", err)
+		}
+	})
+}
+
+func TestHandleServiceHeadless(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	// A Polkadot object with metadata and spec.
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	// a Service is created at each call
+	for i := 0; i < 6; i++ {
+		if _, err := reconciler.handleService(polkadot); err != nil {
+			t.Fatalf("handleService: (%v)", err)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		statefulSet     *v12.StatefulSet
+		expectedService string
+	}{
+		{
+			name:            "Sentry governing Service",
+			statefulSet:     newStatefulSetSentry(polkadot, nil),
+			expectedService: GetSentryHeadlessServiceName(CRName),
+		},
+		{
+			name:            "Validator governing Service",
+			statefulSet:     newStatefulSetValidator(polkadot, nil),
+			expectedService: GetValidatorHeadlessServiceName(CRName),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.statefulSet.Spec.ServiceName != test.expectedService {
+				t.Fatalf("unexpected serviceName: (%v)", test.statefulSet.Spec.ServiceName)
+			}
+			found := &corev1.Service{}
+			err := client.Get(context.TODO(), types.NamespacedName{Name: test.expectedService}, found)
+			if err != nil {
+				t.Fatalf("handleService: (%v)", err)
+			}
+			if found.Spec.ClusterIP != corev1.ClusterIPNone || !found.Spec.PublishNotReadyAddresses {
+				t.Fatalf("the Service is not headless: (%v)", found.Spec)
+			}
+			if !reflect.DeepEqual(found.Spec.Selector, test.statefulSet.Spec.Selector.MatchLabels) {
+				t.Fatalf("the Service doesn't select the StatefulSet pods: (%v)", found.Spec.Selector)
+			}
+		})
+	}
+}
+
+func TestNewServiceP2P(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.Service = polkadotv1alpha1.ServiceSpec{
+		Type:                     corev1.ServiceTypeLoadBalancer,
+		Annotations:              map[string]string{"service.beta.kubernetes.io/azure-load-balancer-internal": "false"},
+		ExternalTrafficPolicy:    corev1.ServiceExternalTrafficPolicyTypeLocal,
+		NodePort:                 30333,
+		LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+	}
+
+	p2p := newServiceSentry(polkadot)
+	if p2p.Spec.Type != corev1.ServiceTypeLoadBalancer || p2p.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal || len(p2p.Spec.LoadBalancerSourceRanges) != 1 {
+		t.Fatalf("newServiceSentry: unexpected spec (%v)", p2p.Spec)
+	}
+	if len(p2p.Spec.Ports) != 1 || p2p.Spec.Ports[0].Name != P2PPortName || p2p.Spec.Ports[0].NodePort != 30333 {
+		t.Fatalf("newServiceSentry: only the p2p port is expected (%v)", p2p.Spec.Ports)
+	}
+	if p2p.Annotations["service.beta.kubernetes.io/azure-load-balancer-internal"] != "false" {
+		t.Fatalf("newServiceSentry: unexpected annotations (%v)", p2p.Annotations)
+	}
+
+	rpc := newServiceSentryRPC(polkadot)
+	if rpc.Spec.Type != corev1.ServiceTypeClusterIP || len(rpc.Annotations) != 0 {
+		t.Fatalf("newServiceSentryRPC: unexpected Service (%v)", rpc)
+	}
+	for _, port := range rpc.Spec.Ports {
+		if port.Name == P2PPortName {
+			t.Fatalf("newServiceSentryRPC: unexpected p2p port (%v)", rpc.Spec.Ports)
+		}
+	}
+
+	// behind the sentries the validator is reachable only inside the cluster
+	polkadot.Spec.Kind = string(SentryAndValidator)
+	if validator := newServiceValidator(polkadot); validator.Spec.Type != corev1.ServiceTypeClusterIP {
+		t.Fatalf("newServiceValidator: unexpected type (%v)", validator.Spec.Type)
+	}
+}
+
+func TestNewServiceRPC(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+
+	// the RPC of the sentries is served externally by default, the one of the validator is bound to the loopback interface
+	if ports := getServicePortNames(newServiceSentryRPC(polkadot)); !reflect.DeepEqual(ports, []string{RPCPortName, WSPortName, metricsPortName}) {
+		t.Fatalf("newServiceSentryRPC: unexpected ports (%v)", ports)
+	}
+	if ports := getServicePortNames(newServiceValidatorRPC(polkadot)); !reflect.DeepEqual(ports, []string{metricsPortName}) {
+		t.Fatalf("newServiceValidatorRPC: unexpected ports (%v)", ports)
+	}
+	if ports := getServicePortNames(newServiceValidatorHeadless(polkadot)); !reflect.DeepEqual(ports, []string{P2PPortName, metricsPortName}) {
+		t.Fatalf("newServiceValidatorHeadless: unexpected ports (%v)", ports)
+	}
+
+	polkadot.Spec.Validator.RPC.Mode = polkadotv1alpha1.RPCModeUnsafeExternal
+	if ports := getServicePortNames(newServiceValidatorRPC(polkadot)); !reflect.DeepEqual(ports, []string{RPCPortName, WSPortName, metricsPortName}) {
+		t.Fatalf("newServiceValidatorRPC: unexpected ports (%v)", ports)
+	}
+}
+
+func getServicePortNames(service *corev1.Service) []string {
+	var names []string
+	for _, port := range service.Spec.Ports {
+		names = append(names, port.Name)
+	}
+	return names
+}
+
+func TestGetServiceToUpdateExternalTrafficPolicy(t *testing.T) {
+	current := getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeLoadBalancer)
+	current.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	current.Spec.HealthCheckNodePort = 32000
+
+	// the policy is kept if not set, the health check node port with it
+	desired := getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeLoadBalancer)
+	updated := getServiceToUpdate(current, desired)
+	if updated.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal || updated.Spec.HealthCheckNodePort != 32000 {
+		t.Fatalf("getServiceToUpdate: unexpected spec (%v)", updated.Spec)
+	}
+
+	// a ClusterIP Service rejects both
+	desired = getFakeService(GetSentryServiceName(CRName), corev1.ServiceTypeClusterIP)
+	updated = getServiceToUpdate(current, desired)
+	if updated.Spec.ExternalTrafficPolicy != "" || updated.Spec.HealthCheckNodePort != 0 {
+		t.Fatalf("getServiceToUpdate: unexpected spec (%v)", updated.Spec)
+	}
+}
+
+func TestGetServiceToUpdateAnnotations(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.Service.Annotations = map[string]string{"a": "1", "b": "2"}
+	current := newServiceSentry(polkadot)
+	// an annotation set by another controller
+	current.Annotations["external"] = "3"
+
+	// the annotation removed from the CR is removed from the Service, the other ones are kept
+	polkadot.Spec.Sentry.Service.Annotations = map[string]string{"a": "1"}
+	desired := newServiceSentry(polkadot)
+	if !areServicesDifferent(current, desired, log) {
+		t.Fatalf("areServicesDifferent: the annotation removed is not detected")
+	}
+	updated := getServiceToUpdate(current, desired)
+	expected := map[string]string{"a": "1", "external": "3", managedAnnotationsAnnotation: "a"}
+	if !reflect.DeepEqual(updated.Annotations, expected) {
+		t.Fatalf("getServiceToUpdate: unexpected annotations (%v)", updated.Annotations)
+	}
+	if areServicesDifferent(updated, desired, log) {
+		t.Fatalf("areServicesDifferent: unexpected mismatch")
+	}
+}
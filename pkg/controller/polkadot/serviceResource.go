@@ -5,23 +5,117 @@ package polkadot
 import (
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"sort"
+	"strings"
 )
 
+// newServiceSentry returns the p2p Service of the sentries, configured by spec.sentry.service
 func newServiceSentry(CRInstance *polkadotv1alpha1.Polkadot) *corev1.Service {
-	labels := getSentrylabels()
-	return getService(ServiceSentryName,CRInstance.Namespace,labels,corev1.ServiceTypeNodePort)
+	labels := getSentrylabels(CRInstance.Name)
+	return getP2PService(GetSentryServiceName(CRInstance.Name), CRInstance.Namespace, labels, CRInstance.GetSentryServiceType(), CRInstance.Spec.Sentry.Service)
 }
 
+// newServiceValidator returns the p2p Service of the validator, configured by spec.validator.service
 func newServiceValidator(CRInstance *polkadotv1alpha1.Polkadot) *corev1.Service {
-	labels := getValidatorLabels()
-	serviceType := corev1.ServiceTypeClusterIP
-	if CRKind(CRInstance.Spec.Kind) == Validator {
-		serviceType = corev1.ServiceTypeNodePort
+	labels := getValidatorLabels(CRInstance.Name)
+	service := getP2PService(GetValidatorServiceName(CRInstance.Name), CRInstance.Namespace, labels, CRInstance.GetValidatorServiceType(), CRInstance.Spec.Validator.Service)
+	// with the standby mode the active validator is selected among the two validator StatefulSets
+	service.Spec.Selector = getValidatorKeyHolderLabels(CRInstance)
+	return service
+}
+
+// newServiceSentryPublic returns the p2p Service exposing a single sentry pod, configured by spec.sentry.service
+func newServiceSentryPublic(CRInstance *polkadotv1alpha1.Polkadot, podName string) *corev1.Service {
+	labels := getSentrylabels(CRInstance.Name)
+	labels[appsv1.StatefulSetPodNameLabel] = podName
+	return getP2PService(getSentryPublicServiceName(podName), CRInstance.Namespace, labels, CRInstance.GetSentryPublicServiceType(), CRInstance.Spec.Sentry.Service)
+}
+
+// newServiceSentryRPC returns the internal Service publishing the RPC, websocket and metrics ports of the sentries,
+// the RPC and websocket ones following spec.sentry.rpc
+func newServiceSentryRPC(CRInstance *polkadotv1alpha1.Polkadot) *corev1.Service {
+	labels := getSentrylabels(CRInstance.Name)
+	return getRPCService(GetSentryRPCServiceName(CRInstance.Name), CRInstance.Namespace, labels, CRInstance.GetSentryRPC().IsExternal())
+}
+
+// newServiceValidatorRPC returns the internal Service publishing the RPC, websocket and metrics ports of the validator,
+// the RPC and websocket ones following spec.validator.rpc
+func newServiceValidatorRPC(CRInstance *polkadotv1alpha1.Polkadot) *corev1.Service {
+	labels := getValidatorLabels(CRInstance.Name)
+	service := getRPCService(GetValidatorRPCServiceName(CRInstance.Name), CRInstance.Namespace, labels, CRInstance.GetValidatorRPC().IsExternal())
+	service.Spec.Selector = getValidatorKeyHolderLabels(CRInstance)
+	return service
+}
+
+// newServiceSentryHeadless returns the headless Service governing the sentry StatefulSet, it provides the per-pod DNS names
+func newServiceSentryHeadless(CRInstance *polkadotv1alpha1.Polkadot) *corev1.Service {
+	labels := getSentrylabels(CRInstance.Name)
+	return getHeadlessService(GetSentryHeadlessServiceName(CRInstance.Name), CRInstance.Namespace, labels, CRInstance.GetSentryRPC().IsExternal())
+}
+
+// newServiceValidatorHeadless returns the headless Service governing the validator StatefulSet, it provides the per-pod DNS names
+func newServiceValidatorHeadless(CRInstance *polkadotv1alpha1.Polkadot) *corev1.Service {
+	labels := getValidatorLabels(CRInstance.Name)
+	return getHeadlessService(GetValidatorHeadlessServiceName(CRInstance.Name), CRInstance.Namespace, labels, CRInstance.GetValidatorRPC().IsExternal())
+}
+
+// getP2PService returns the Service publishing only the p2p port, it is the one exposed outside the cluster
+func getP2PService(name string, namespace string, labels map[string]string, serviceType corev1.ServiceType, serviceSpec polkadotv1alpha1.ServiceSpec) *corev1.Service {
+	service := getService(name, namespace, labels, serviceType)
+	service.Annotations = getManagedAnnotations(serviceSpec.Annotations)
+	service.Spec.Ports = []corev1.ServicePort{getP2PServicePort()}
+	if serviceType != corev1.ServiceTypeClusterIP {
+		service.Spec.Ports[0].NodePort = serviceSpec.NodePort
+		service.Spec.ExternalTrafficPolicy = serviceSpec.ExternalTrafficPolicy
 	}
-	return getService(ServiceValidatorName,CRInstance.Namespace,labels,serviceType)
+	if serviceType == corev1.ServiceTypeLoadBalancer {
+		service.Spec.LoadBalancerSourceRanges = serviceSpec.LoadBalancerSourceRanges
+	}
+	return service
+}
+
+// getManagedAnnotations returns the annotations with the list of their keys, so that the keys removed later are removed from the Service
+func getManagedAnnotations(annotations map[string]string) map[string]string {
+	result := getCopy(annotations)
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result[managedAnnotationsAnnotation] = strings.Join(keys, ",")
+	return result
+}
+
+// getDroppedAnnotations returns the keys of the annotations set from the CR on the current Service and not desired anymore
+func getDroppedAnnotations(currentService *corev1.Service, desiredService *corev1.Service) []string {
+	var dropped []string
+	for _, key := range strings.Split(currentService.Annotations[managedAnnotationsAnnotation], ",") {
+		if _, isDesired := desiredService.Annotations[key]; key != "" && !isDesired {
+			dropped = append(dropped, key)
+		}
+	}
+	return dropped
+}
+
+// getRPCService returns the ClusterIP Service publishing the RPC, websocket and metrics ports, it is never exposed outside the cluster.
+// The RPC and websocket ports are published only if the endpoints are bound to all the interfaces of the pods
+func getRPCService(name string, namespace string, labels map[string]string, isRPCExternal bool) *corev1.Service {
+	service := getService(name, namespace, labels, corev1.ServiceTypeClusterIP)
+	service.Spec.Ports = getServicePorts(isRPCExternal)[1:]
+	return service
+}
+
+// getHeadlessService publishes the addresses of the pods not ready yet as well, so that the peers can reach a syncing node
+func getHeadlessService(name string, namespace string, labels map[string]string, isRPCExternal bool) *corev1.Service {
+	service := getService(name, namespace, labels, corev1.ServiceTypeClusterIP)
+	service.Spec.Ports = getServicePorts(isRPCExternal)
+	service.Spec.ClusterIP = corev1.ClusterIPNone
+	service.Spec.PublishNotReadyAddresses = true
+	return service
 }
 
 func getService(name string, namespace string, labels  map[string]string, serviceType corev1.ServiceType) *corev1.Service{
@@ -33,20 +127,36 @@ func getService(name string, namespace string, labels  map[string]string, servic
 		},
 		Spec: corev1.ServiceSpec{
 			Type:     serviceType,
-			Ports:    getServicePorts(),
 			Selector: labels,
 		},
 	}
 }
 
-func getServicePorts() []corev1.ServicePort{
+func getP2PServicePort() corev1.ServicePort {
+	return corev1.ServicePort{
+		Name:       P2PPortName,
+		Port:       int32(config.P2PPortEnvVar.Value),
+		TargetPort: intstr.FromInt(config.P2PPortEnvVar.Value),
+		Protocol:   "TCP",
+	}
+}
+
+// getServicePorts returns the ports of a node, the p2p port first. The RPC and websocket ones are returned only if they are reachable
+func getServicePorts(isRPCExternal bool) []corev1.ServicePort{
+	ports := []corev1.ServicePort{getP2PServicePort()}
+	if isRPCExternal {
+		ports = append(ports, getRPCServicePorts()...)
+	}
+	return append(ports, corev1.ServicePort{
+		Name:       metricsPortName,
+		Port:       int32(config.MetricsPortEnvVar.Value),
+		TargetPort: intstr.FromInt(config.MetricsPortEnvVar.Value),
+		Protocol:   "TCP",
+	})
+}
+
+func getRPCServicePorts() []corev1.ServicePort{
 	return []corev1.ServicePort{
-		{
-			Name:       P2PPortName,
-			Port:       int32(config.P2PPortEnvVar.Value),
-			TargetPort: intstr.FromInt(config.P2PPortEnvVar.Value),
-			Protocol:   "TCP",
-		},
 		{
 			Name:       RPCPortName,
 			Port:       int32(config.RPCPortEnvVar.Value),
@@ -59,11 +169,5 @@ func getServicePorts() []corev1.ServicePort{
 			TargetPort: intstr.FromInt(config.WSPortEnvVar.Value),
 			Protocol:   "TCP",
 		},
-		{
-			Name:       metricsPortName,
-			Port:       int32(config.MetricsPortEnvVar.Value),
-			TargetPort: intstr.FromInt(config.MetricsPortEnvVar.Value),
-			Protocol:   "TCP",
-		},
 	}
 }
@@ -6,10 +6,20 @@ import (
 	"github.com/go-logr/logr"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func (r *ReconcilerPolkadot) handleStatefulSet(CRInstance *polkadotv1alpha1.Polkadot) (bool, error){
+	if err := getPodTemplateOverridesError(CRInstance); err != nil {
+		log.Error(err, "Error on patch the pod template...", "Polkadot.Name", CRInstance.Name)
+		r.recorder.Event(CRInstance, corev1.EventTypeWarning, podTemplateInvalidReason, err.Error())
+		return NotForcedRequeue, err
+	}
 	handler := getHandlerStatefulSet(CRInstance)
 	return handler.handleStatefulSetSpecific(r,CRInstance)
 }
@@ -36,23 +46,93 @@ type IHandlerStatefulSet interface {
 type handlerStatefulSetValidator struct {
 }
 func (h *handlerStatefulSetValidator) handleStatefulSetSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error){
-	return r.handleStatefulSetGeneric(CRInstance, newStatefulSetValidator(CRInstance))
+	return r.handleStatefulSetValidators(CRInstance, nil)
 }
 
 type handlerStatefulSetSentry struct {
 }
 func (h *handlerStatefulSetSentry) handleStatefulSetSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error){
-	return r.handleStatefulSetGeneric(CRInstance, newStatefulSetSentry(CRInstance))
+	statefulSet, err := r.newStatefulSetSentryBackedUp(CRInstance, nil)
+	if err != nil {
+		return NotForcedRequeue, err
+	}
+	return r.handleStatefulSetGeneric(CRInstance, statefulSet)
 }
 
 type handlerStatefulSetSentryAndValidator struct {
 }
 func (h *handlerStatefulSetSentryAndValidator) handleStatefulSetSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error){
-	isForcedRequeue, err := r.handleStatefulSetGeneric(CRInstance, newStatefulSetSentry(CRInstance))
+	validatorReservedNodes, err := r.getValidatorReservedNodes(CRInstance)
+	if err != nil {
+		return NotForcedRequeue, err
+	}
+	sentryReservedNodes, err := r.getSentryReservedNodes(CRInstance)
+	if err != nil {
+		return NotForcedRequeue, err
+	}
+
+	sentryStatefulSet, err := r.newStatefulSetSentryBackedUp(CRInstance, validatorReservedNodes)
+	if err != nil {
+		return NotForcedRequeue, err
+	}
+
+	isForcedRequeue, err := r.handleStatefulSetGeneric(CRInstance, sentryStatefulSet)
 	if isForcedRequeue == ForcedRequeue || err != nil {
 		return isForcedRequeue, err
 	}
-	return r.handleStatefulSetGeneric(CRInstance, newStatefulSetValidator(CRInstance))
+	return r.handleStatefulSetValidators(CRInstance, sentryReservedNodes)
+}
+
+// handleStatefulSetValidators handles the validator StatefulSet, and the secondary one of the standby mode
+func (r *ReconcilerPolkadot) handleStatefulSetValidators(CRInstance *polkadotv1alpha1.Polkadot, reservedNodes []string) (bool, error) {
+	isForcedRequeue, err := r.handleStatefulSetGeneric(CRInstance, newStatefulSetValidator(CRInstance, reservedNodes))
+	if isForcedRequeue == ForcedRequeue || err != nil || !isValidatorStandbyEnabled(CRInstance) {
+		return isForcedRequeue, err
+	}
+	return r.handleStatefulSetGeneric(CRInstance, newStatefulSetValidatorSecondary(CRInstance, reservedNodes))
+}
+
+// getValidatorReservedNodes returns the multiaddrs of the validator the sentries connect to
+func (r *ReconcilerPolkadot) getValidatorReservedNodes(CRInstance *polkadotv1alpha1.Polkadot) ([]string, error) {
+	peerID := CRInstance.Spec.Sentry.ReservedValidatorID
+	if peerID == "" {
+		derived, err := r.fetchPeerID(CRInstance, CRInstance.Spec.Validator.NodeKey, getValidatorNodeKeySecret(CRInstance))
+		if err != nil {
+			log.Error(err, "Error on deriving the validator peer ID...", "Polkadot.Name", CRInstance.Name)
+			return nil, err
+		}
+		peerID = derived
+	}
+	return []string{getReservedNodeMultiaddr(GetValidatorServiceName(CRInstance.Name), peerID)}, nil
+}
+
+// getSentryReservedNodes returns the multiaddrs of the sentries the validator connects to.
+// With a node key per sentry pod, every pod is reserved through its own DNS name
+func (r *ReconcilerPolkadot) getSentryReservedNodes(CRInstance *polkadotv1alpha1.Polkadot) ([]string, error) {
+	peerID := CRInstance.Spec.Validator.ReservedSentryID
+	nodeKeySecret := getSentryNodeKeySecret(CRInstance)
+	if peerID == "" && isNodeKeyPerPod(nodeKeySecret) {
+		podNames := getSentryPodNames(CRInstance)
+		peerIDs, err := r.fetchPeerIDs(CRInstance, nodeKeySecret.Name, podNames)
+		if err != nil {
+			log.Error(err, "Error on deriving the sentry peer IDs...", "Polkadot.Name", CRInstance.Name)
+			return nil, err
+		}
+		var reservedNodes []string
+		for i, podName := range podNames {
+			reservedNodes = append(reservedNodes, getReservedNodeMultiaddr(getPodHostName(podName, GetSentryHeadlessServiceName(CRInstance.Name)), peerIDs[i]))
+		}
+		return reservedNodes, nil
+	}
+	if peerID == "" {
+		derived, err := r.fetchPeerID(CRInstance, CRInstance.Spec.Sentry.NodeKey, nodeKeySecret)
+		if err != nil {
+			log.Error(err, "Error on deriving the sentry peer ID...", "Polkadot.Name", CRInstance.Name)
+			return nil, err
+		}
+		peerID = derived
+	}
+	return []string{getReservedNodeMultiaddr(GetSentryServiceName(CRInstance.Name), peerID)}, nil
 }
 
 type handlerStatefulSetDefault struct {
@@ -84,9 +164,25 @@ func (r *ReconcilerPolkadot) handleStatefulSetGeneric(CRInstance *polkadotv1alph
 	}
 	foundResource := toBeFoundResource
 
+	if foundResource.GetDeletionTimestamp() != nil {
+		logger.Info("Waiting for the deletion of the StatefulSet...")
+		return ForcedRequeue, nil
+	}
+	if isStatefulSetRecreationRequired(foundResource, desiredResource, logger) {
+		logger.Info("Deleting the StatefulSet to recreate it...")
+		// foreground deletion: the StatefulSet is visible until all its pods are terminated, the PersistentVolumeClaims are kept
+		err := r.deleteResource(foundResource, client.PropagationPolicy(metav1.DeletePropagationForeground))
+		if err != nil {
+			logger.Error(err, "Error on deleting the StatefulSet...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the StatefulSet")
+		return ForcedRequeue, nil
+	}
+
 	if areStatefulSetDifferent(foundResource, desiredResource, logger) {
 		logger.Info("Updating the StatefulSet...")
-		err := r.updateResource(desiredResource)
+		err := r.updateResource(getStatefulSetToUpdate(foundResource, desiredResource))
 		if err != nil {
 			logger.Error(err, "Update StatefulSet Error...")
 			return NotForcedRequeue, err
@@ -97,6 +193,30 @@ func (r *ReconcilerPolkadot) handleStatefulSetGeneric(CRInstance *polkadotv1alph
 	return NotForcedRequeue, nil
 }
 
+// isStatefulSetRecreationRequired detects the changes of the immutable fields, e.g. the governing Service of a StatefulSet
+// created before the headless Services were introduced
+func isStatefulSetRecreationRequired(current *appsv1.StatefulSet, desired *appsv1.StatefulSet, logger logr.Logger) bool {
+	if current.Spec.ServiceName != desired.Spec.ServiceName {
+		logger.Info("Found a serviceName mismatch...", "Current", current.Spec.ServiceName, "Desired", desired.Spec.ServiceName)
+		return true
+	}
+	return false
+}
+
+// getStatefulSetToUpdate applies the managed fields of desired on a copy of current,
+// so that the resourceVersion, the immutable fields and the other server-set fields are preserved
+func getStatefulSetToUpdate(current *appsv1.StatefulSet, desired *appsv1.StatefulSet) *appsv1.StatefulSet {
+	updated := current.DeepCopy()
+	updated.Labels = mergeMaps(updated.Labels, desired.Labels)
+	updated.Annotations = mergeMaps(updated.Annotations, desired.Annotations)
+	updated.Spec.Replicas = desired.Spec.Replicas
+	updated.Spec.UpdateStrategy = *desired.Spec.UpdateStrategy.DeepCopy()
+	updated.Spec.Template = *desired.Spec.Template.DeepCopy()
+	// e.g. kubectl rollout restart annotates the pod template
+	updated.Spec.Template.Annotations = mergeMaps(current.Spec.Template.Annotations, desired.Spec.Template.Annotations)
+	return updated
+}
+
 func areStatefulSetDifferent(current *appsv1.StatefulSet, desired *appsv1.StatefulSet, logger logr.Logger) bool {
 	result := false
 
@@ -106,6 +226,12 @@ func areStatefulSetDifferent(current *appsv1.StatefulSet, desired *appsv1.Statef
 	if isStatefulSetVersionDifferent(current, desired, logger) {
 		result = true
 	}
+	if isStatefulSetPartitionDifferent(current, desired, logger) {
+		result = true
+	}
+	if isStatefulSetTemplateDifferent(current, desired, logger) {
+		result = true
+	}
 
 	return result
 }
@@ -127,3 +253,133 @@ func isStatefulSetVersionDifferent(current *appsv1.StatefulSet, desired *appsv1.
 	}
 	return false
 }
+
+// isStatefulSetPartitionDifferent detects a step of a staged upgrade, a missing strategy is defaulted to RollingUpdate with partition 0 by the API server
+func isStatefulSetPartitionDifferent(current *appsv1.StatefulSet, desired *appsv1.StatefulSet, logger logr.Logger) bool {
+	if getUpdateStrategyType(current) != getUpdateStrategyType(desired) || getPartition(current) != getPartition(desired) {
+		logger.Info("Found a partition mismatch...", "Current", getPartition(current), "Desired", getPartition(desired))
+		return true
+	}
+	return false
+}
+
+func getUpdateStrategyType(statefulSet *appsv1.StatefulSet) appsv1.StatefulSetUpdateStrategyType {
+	if statefulSet.Spec.UpdateStrategy.Type == "" {
+		return appsv1.RollingUpdateStatefulSetStrategyType
+	}
+	return statefulSet.Spec.UpdateStrategy.Type
+}
+
+func getPartition(statefulSet *appsv1.StatefulSet) int32 {
+	if statefulSet.Spec.UpdateStrategy.RollingUpdate == nil || statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition == nil {
+		return 0
+	}
+	return *statefulSet.Spec.UpdateStrategy.RollingUpdate.Partition
+}
+
+// isStatefulSetTemplateDifferent detects both a change of the CustomResource, through the hash of the desired pod template,
+// and a drift of the live pod template, through a field by field comparison of the fields managed by the operator
+func isStatefulSetTemplateDifferent(current *appsv1.StatefulSet, desired *appsv1.StatefulSet, logger logr.Logger) bool {
+	result := false
+
+	if current.Annotations[specHashAnnotation] != desired.Annotations[specHashAnnotation] {
+		logger.Info("Found a pod template hash mismatch...", "Current", current.Annotations[specHashAnnotation], "Desired", desired.Annotations[specHashAnnotation])
+		result = true
+	}
+	for _, drift := range getPodTemplateDrifts(&current.Spec.Template, &desired.Spec.Template) {
+		logger.Info("Found a pod template mismatch...", "Field", drift)
+		result = true
+	}
+
+	return result
+}
+
+// getPodTemplateDrifts returns the paths of the managed fields of the pod template that differ.
+// Fields not set by the operator are ignored, since they are defaulted by the API server.
+func getPodTemplateDrifts(current *corev1.PodTemplateSpec, desired *corev1.PodTemplateSpec) []string {
+	var drifts []string
+
+	for key, value := range desired.Labels {
+		if current.Labels[key] != value {
+			drifts = append(drifts, "metadata.labels."+key)
+		}
+	}
+	if !equality.Semantic.DeepEqual(current.Spec.SecurityContext, desired.Spec.SecurityContext) {
+		drifts = append(drifts, "spec.securityContext")
+	}
+	drifts = append(drifts, getContainersDrifts("spec.initContainers", current.Spec.InitContainers, desired.Spec.InitContainers)...)
+	drifts = append(drifts, getContainersDrifts("spec.containers", current.Spec.Containers, desired.Spec.Containers)...)
+
+	return drifts
+}
+
+func getContainersDrifts(path string, current []corev1.Container, desired []corev1.Container) []string {
+	var drifts []string
+
+	if !reflect.DeepEqual(getContainerNames(current), getContainerNames(desired)) {
+		return append(drifts, path)
+	}
+	for i := range desired {
+		c, d := &current[i], &desired[i]
+		containerPath := path + "[" + d.Name + "]"
+		if c.Image != d.Image {
+			drifts = append(drifts, containerPath+".image")
+		}
+		if !equality.Semantic.DeepEqual(c.Command, d.Command) {
+			drifts = append(drifts, containerPath+".command")
+		}
+		if !equality.Semantic.DeepEqual(c.Args, d.Args) {
+			drifts = append(drifts, containerPath+".args")
+		}
+		if !equality.Semantic.DeepEqual(c.Env, d.Env) {
+			drifts = append(drifts, containerPath+".env")
+		}
+		if !equality.Semantic.DeepEqual(c.EnvFrom, d.EnvFrom) {
+			drifts = append(drifts, containerPath+".envFrom")
+		}
+		if !areResourcesEqual(c.Resources, d.Resources) {
+			drifts = append(drifts, containerPath+".resources")
+		}
+		if !equality.Semantic.DeepEqual(c.VolumeMounts, d.VolumeMounts) {
+			drifts = append(drifts, containerPath+".volumeMounts")
+		}
+		if !equality.Semantic.DeepEqual(c.SecurityContext, d.SecurityContext) {
+			drifts = append(drifts, containerPath+".securityContext")
+		}
+	}
+	return drifts
+}
+
+func getContainerNames(containers []corev1.Container) []string {
+	names := []string{}
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+// areResourcesEqual takes into account that the API server defaults the requests to the limits
+func areResourcesEqual(current corev1.ResourceRequirements, desired corev1.ResourceRequirements) bool {
+	if !equality.Semantic.DeepEqual(getNotEmpty(current.Limits), getNotEmpty(desired.Limits)) {
+		return false
+	}
+	return equality.Semantic.DeepEqual(getDefaultedRequests(current), getDefaultedRequests(desired))
+}
+
+func getDefaultedRequests(resources corev1.ResourceRequirements) corev1.ResourceList {
+	requests := getNotEmpty(resources.Requests)
+	for name, limit := range resources.Limits {
+		if _, isFound := requests[name]; !isFound {
+			requests[name] = limit
+		}
+	}
+	return requests
+}
+
+func getNotEmpty(resources corev1.ResourceList) corev1.ResourceList {
+	result := corev1.ResourceList{}
+	for name, quantity := range resources {
+		result[name] = quantity
+	}
+	return result
+}
@@ -1,10 +1,21 @@
 package polkadot
 
 import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/p2p"
 	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
 	"testing"
 )
 
@@ -17,14 +28,14 @@ func TestHandleStatefulSetGeneric(t *testing.T) {
 	testsOK := testStruct{
 		{
 			name:        "StatefulSet healthy",
-			newResource: getFakeStatefulSet(SentrySSName,1),
+			newResource: getFakeStatefulSet(GetSentryStatefulSetName(CRName),1),
 		},
 	}
 
 	testsNotFound := testStruct{
 		{
 			name:        "StatefulSet not found",
-			newResource: getFakeStatefulSet(SentrySSName,1),
+			newResource: getFakeStatefulSet(GetSentryStatefulSetName(CRName),1),
 		},
 	}
 
@@ -72,3 +83,510 @@ func TestHandleStatefulSetGeneric(t *testing.T) {
 	}
 }
 
+func TestAreStatefulSetDifferent(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		mutate     func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet)
+		isExpected bool
+	}{
+		{
+			name:       "StatefulSet equal",
+			mutate:     func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {},
+			isExpected: false,
+		},
+		{
+			name: "StatefulSet with server defaults",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				container := &current.Spec.Template.Spec.Containers[0]
+				container.TerminationMessagePath = corev1.TerminationMessagePathDefault
+				container.ImagePullPolicy = corev1.PullAlways
+				container.Resources.Requests = container.Resources.Limits
+				current.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+				current.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+			},
+			isExpected: false,
+		},
+		{
+			name: "StatefulSet replicas changed",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				polkadot.Spec.Sentry.Replicas = 3
+			},
+			isExpected: true,
+		},
+		{
+			name: "StatefulSet resources changed",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				polkadot.Spec.Sentry.Resources.Limits[corev1.ResourceMemory] = resource.MustParse("1Gi")
+			},
+			isExpected: true,
+		},
+		{
+			name: "StatefulSet node key changed",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				polkadot.Spec.Sentry.NodeKey = "0000000000000000000000000000000000000000000000000000000000000014"
+			},
+			isExpected: true,
+		},
+		{
+			name: "StatefulSet metrics sidecar enabled",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				polkadot.Spec.MetricsSupport.Enabled = true
+			},
+			isExpected: true,
+		},
+		{
+			name: "StatefulSet live command drifted",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				container := &current.Spec.Template.Spec.Containers[0]
+				container.Command = append(container.Command, "--unknown-flag")
+			},
+			isExpected: true,
+		},
+		{
+			name: "StatefulSet live security context drifted",
+			mutate: func(polkadot *polkadotv1alpha1.Polkadot, current *v1.StatefulSet) {
+				current.Spec.Template.Spec.SecurityContext = nil
+			},
+			isExpected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			polkadot := getFakePolkadot(withFakeSentry())
+			current := newStatefulSetSentry(polkadot, nil)
+			test.mutate(polkadot, current)
+			desired := newStatefulSetSentry(polkadot, nil)
+
+			isDifferent := areStatefulSetDifferent(current, desired, log)
+			if isDifferent != test.isExpected {
+				t.Fatalf("areStatefulSetDifferent: (%v)", isDifferent)
+			}
+		})
+	}
+}
+
+func TestHandleStatefulSetGenericUpdate(t *testing.T) {
+
+	// A Polkadot object with metadata and spec.
+	polkadot := getFakePolkadot(withFakeSentry())
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	current := newStatefulSetSentry(polkadot, nil)
+	current.Spec.PodManagementPolicy = v1.ParallelPodManagement
+
+	// Objects to track in the fake client.
+	objs := []runtime.Object{polkadot, current}
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, objs...)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	polkadot.Spec.Sentry.Replicas = 2
+	isRequeueForced, err := reconciler.handleStatefulSetGeneric(polkadot, newStatefulSetSentry(polkadot, nil))
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleStatefulSetGeneric: (%v)", err)
+	}
+
+	found := &v1.StatefulSet{}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, found)
+	if err != nil {
+		t.Fatalf("handleStatefulSetGeneric: (%v)", err)
+	}
+	if *found.Spec.Replicas != 2 {
+		t.Fatalf("the StatefulSet was not updated: (%v)", *found.Spec.Replicas)
+	}
+	if found.Spec.PodManagementPolicy != v1.ParallelPodManagement {
+		t.Fatalf("the server-set fields were not preserved: (%v)", found.Spec.PodManagementPolicy)
+	}
+}
+
+func TestHandleStatefulSetGenericRecreation(t *testing.T) {
+
+	// A Polkadot object with metadata and spec.
+	polkadot := getFakePolkadot(withFakeSentry())
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	// a StatefulSet created before the headless Services were introduced
+	current := newStatefulSetSentry(polkadot, nil)
+	current.Spec.ServiceName = "polkadot"
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, current)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	isRequeueForced, err := reconciler.handleStatefulSetGeneric(polkadot, newStatefulSetSentry(polkadot, nil))
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleStatefulSetGeneric: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, &v1.StatefulSet{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the StatefulSet was not deleted: (%v)", err)
+	}
+
+	isRequeueForced, err = reconciler.handleStatefulSetGeneric(polkadot, newStatefulSetSentry(polkadot, nil))
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleStatefulSetGeneric: (%v)", err)
+	}
+	found := &v1.StatefulSet{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, found)
+	if found.Spec.ServiceName != GetSentryHeadlessServiceName(CRName) {
+		t.Fatalf("the StatefulSet was not recreated: (%v)", found.Spec.ServiceName)
+	}
+}
+
+func TestGetRPCArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		rpc         polkadotv1alpha1.RPCSpec
+		isValidator bool
+		expected    string
+	}{
+		{name: "disabled", rpc: polkadotv1alpha1.RPCSpec{Mode: polkadotv1alpha1.RPCModeDisabled}, expected: "--rpc-methods=Safe"},
+		{name: "local only", rpc: polkadotv1alpha1.RPCSpec{Mode: polkadotv1alpha1.RPCModeLocalOnly}, expected: ""},
+		{name: "local only safe methods", rpc: polkadotv1alpha1.RPCSpec{Mode: polkadotv1alpha1.RPCModeLocalOnly, Methods: polkadotv1alpha1.RPCMethodsSafe}, expected: "--rpc-methods=Safe"},
+		{name: "safe external", rpc: polkadotv1alpha1.RPCSpec{Mode: polkadotv1alpha1.RPCModeSafeExternal, CORS: []string{"https://polkadot.js.org", "http://localhost"}}, expected: "--rpc-external --ws-external --rpc-methods=Safe --rpc-cors=https://polkadot.js.org,http://localhost"},
+		{name: "safe external validator", rpc: polkadotv1alpha1.RPCSpec{Mode: polkadotv1alpha1.RPCModeSafeExternal}, isValidator: true, expected: "--unsafe-rpc-external --unsafe-ws-external --rpc-methods=Safe"},
+		{name: "unsafe external", rpc: polkadotv1alpha1.RPCSpec{Mode: polkadotv1alpha1.RPCModeUnsafeExternal, CORS: []string{"all"}}, expected: "--unsafe-rpc-external --unsafe-ws-external --rpc-methods=Unsafe --rpc-cors=all"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if args := strings.Join(getRPCArgs(test.rpc, test.isValidator), " "); args != test.expected {
+				t.Fatalf("getRPCArgs: unexpected args (%v)", args)
+			}
+		})
+	}
+}
+
+func TestGetDatabaseArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		database    polkadotv1alpha1.DatabaseSpec
+		isValidator bool
+		expected    string
+	}{
+		{name: "client defaults", database: polkadotv1alpha1.DatabaseSpec{}, expected: ""},
+		{name: "archive validator", database: polkadotv1alpha1.DatabaseSpec{Pruning: polkadotv1alpha1.PruningArchive}, isValidator: true, expected: "--pruning archive"},
+		{name: "pruned sentry", database: polkadotv1alpha1.DatabaseSpec{Pruning: "1000", StateCacheSize: 1073741824, DBCache: 1024, Backend: polkadotv1alpha1.DatabaseBackendParityDB}, expected: "--pruning 1000 --state-cache-size 1073741824 --db-cache 1024 --database paritydb"},
+		{name: "pruned validator", database: polkadotv1alpha1.DatabaseSpec{Pruning: "1000"}, isValidator: true, expected: "--pruning 1000 --unsafe-pruning"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if args := strings.Join(getDatabaseArgs(test.database, test.isValidator), " "); args != test.expected {
+				t.Fatalf("getDatabaseArgs: unexpected args (%v)", args)
+			}
+		})
+	}
+}
+
+func TestNewStatefulSetResync(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry(), withFakeSentryDataPersistence())
+
+	if initContainers := newStatefulSetSentry(polkadot, nil).Spec.Template.Spec.InitContainers; len(initContainers) != 1 {
+		t.Fatalf("newStatefulSetSentry: unexpected init containers (%v)", initContainers)
+	}
+
+	// the chain database is wiped after the permissions of the volume are set
+	polkadot.Spec.Sentry.Database.Resync = "2020-06-01"
+	initContainers := newStatefulSetSentry(polkadot, nil).Spec.Template.Spec.InitContainers
+	if len(initContainers) != 2 || initContainers[1].Env[0].Value != "2020-06-01" || initContainers[1].VolumeMounts[0].Name != "polkadot-volume" {
+		t.Fatalf("newStatefulSetSentry: unexpected init containers (%v)", initContainers)
+	}
+}
+
+func TestNewStatefulSetImage(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.ClientVersion = "v0.8.0"
+	defer func(repository string) { config.ImageClientEnvVar.Value = repository }(config.ImageClientEnvVar.Value)
+	config.ImageClientEnvVar.Value = "parity/polkadot"
+
+	// the repository of the operator and clientVersion are the defaults
+	statefulSet := newStatefulSetSentry(polkadot, nil)
+	if image := statefulSet.Spec.Template.Spec.Containers[0].Image; image != "parity/polkadot:v0.8.0" || statefulSet.Labels["version"] != "v0.8.0" {
+		t.Fatalf("newStatefulSetSentry: unexpected image (%v) (%v)", image, statefulSet.Labels)
+	}
+
+	digest := "sha256:" + strings.Repeat("a", 64)
+	polkadot.Spec.Image = polkadotv1alpha1.ImageSpec{
+		Repository:       "registry.example.com:5000/polkadot",
+		Tag:              "v0.8.1",
+		Digest:           digest,
+		PullPolicy:       corev1.PullIfNotPresent,
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "registry-credentials"}},
+	}
+	statefulSet = newStatefulSetSentry(polkadot, nil)
+	podSpec := statefulSet.Spec.Template.Spec
+	if image := podSpec.Containers[0].Image; image != "registry.example.com:5000/polkadot:v0.8.1@"+digest || podSpec.Containers[0].ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Fatalf("newStatefulSetSentry: unexpected image (%v)", podSpec.Containers[0])
+	}
+	if len(podSpec.ImagePullSecrets) != 1 || podSpec.ImagePullSecrets[0].Name != "registry-credentials" || statefulSet.Labels["version"] != "v0.8.1" {
+		t.Fatalf("newStatefulSetSentry: unexpected pull secrets or labels (%v) (%v)", podSpec.ImagePullSecrets, statefulSet.Labels)
+	}
+}
+
+func TestNewStatefulSetRPC(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+
+	// the validator RPC is bound to the loopback interface by default, the kubelet probes the p2p port
+	container := newStatefulSetValidator(polkadot, nil).Spec.Template.Spec.Containers[0]
+	if strings.Contains(strings.Join(container.Command, " "), "external") {
+		t.Fatalf("newStatefulSetValidator: unexpected command (%v)", container.Command)
+	}
+	if len(container.Ports) != 1 || container.ReadinessProbe.TCPSocket == nil || container.ReadinessProbe.TCPSocket.Port.StrVal != P2PPortName {
+		t.Fatalf("newStatefulSetValidator: unexpected ports or probe (%v) (%v)", container.Ports, container.ReadinessProbe)
+	}
+
+	container = newStatefulSetSentry(polkadot, nil).Spec.Template.Spec.Containers[0]
+	if !strings.Contains(strings.Join(container.Command, " "), "--rpc-external --ws-external --rpc-methods=Safe") {
+		t.Fatalf("newStatefulSetSentry: unexpected command (%v)", container.Command)
+	}
+	if len(container.Ports) != 3 || container.ReadinessProbe.HTTPGet == nil {
+		t.Fatalf("newStatefulSetSentry: unexpected ports or probe (%v) (%v)", container.Ports, container.ReadinessProbe)
+	}
+}
+
+func TestNewStatefulSetChain(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+
+	// without a chain the client runs its default one
+	podSpec := newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	if strings.Contains(strings.Join(podSpec.Containers[0].Command, " "), "--chain") {
+		t.Fatalf("newStatefulSetSentry: unexpected command (%v)", podSpec.Containers[0].Command)
+	}
+
+	polkadot.Spec.Chain = polkadotv1alpha1.ChainSpec{Name: polkadotv1alpha1.ChainKusama}
+	podSpec = newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	if !strings.Contains(strings.Join(podSpec.Containers[0].Command, " "), "--chain kusama") || len(podSpec.Volumes) != 0 {
+		t.Fatalf("newStatefulSetSentry: unexpected pod spec (%v)", podSpec)
+	}
+
+	// the chain spec of a ConfigMap is mounted
+	polkadot.Spec.Chain = polkadotv1alpha1.ChainSpec{ConfigMapRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "testnet"}, Key: "raw.json"}}
+	podSpec = newStatefulSetValidator(polkadot, nil).Spec.Template.Spec
+	if !strings.Contains(strings.Join(podSpec.Containers[0].Command, " "), "--chain /chainspec/spec.json") {
+		t.Fatalf("newStatefulSetValidator: unexpected command (%v)", podSpec.Containers[0].Command)
+	}
+	volume := podSpec.Volumes[len(podSpec.Volumes)-1]
+	if volume.ConfigMap == nil || volume.ConfigMap.Name != "testnet" || volume.ConfigMap.Items[0].Key != "raw.json" || len(podSpec.InitContainers) != 0 {
+		t.Fatalf("newStatefulSetValidator: unexpected chain spec volume (%v)", volume)
+	}
+
+	// the chain spec of a URL is downloaded by an init container
+	polkadot.Spec.Chain = polkadotv1alpha1.ChainSpec{URL: "https://example.com/spec.json"}
+	podSpec = newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	volume = podSpec.Volumes[len(podSpec.Volumes)-1]
+	if volume.EmptyDir == nil || len(podSpec.InitContainers) != 1 || podSpec.InitContainers[0].Command[len(podSpec.InitContainers[0].Command)-1] != "https://example.com/spec.json" {
+		t.Fatalf("newStatefulSetSentry: unexpected pod spec (%v)", podSpec)
+	}
+}
+
+func TestNewStatefulSetOverrides(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.ExtraArgs = []string{"--in-peers=50", "--wasm-execution", "Compiled"}
+	polkadot.Spec.Sentry.Env = []corev1.EnvVar{{Name: "RUST_LOG", Value: "sync=debug"}, {Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}}}
+	polkadot.Spec.Sentry.EnvFrom = []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "sentry-env"}}}}
+	polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"metadata":{"annotations":{"prometheus.io/scrape":"true"}},"spec":{"nodeSelector":{"pool":"p2p"},"tolerations":[{"key":"dedicated","operator":"Exists"}],"priorityClassName":"high"}}`)}
+
+	statefulSet := newStatefulSetSentry(polkadot, nil)
+	template := statefulSet.Spec.Template
+	container := template.Spec.Containers[0]
+	if !strings.HasSuffix(strings.Join(container.Command, " "), "--sentry --in-peers=50 --wasm-execution Compiled") {
+		t.Fatalf("newStatefulSetSentry: unexpected command (%v)", container.Command)
+	}
+	if len(container.Env) != 2 || container.Env[1].ValueFrom.FieldRef.APIVersion != "v1" || len(container.EnvFrom) != 1 {
+		t.Fatalf("newStatefulSetSentry: unexpected env (%v) (%v)", container.Env, container.EnvFrom)
+	}
+	if template.Annotations["prometheus.io/scrape"] != "true" || template.Spec.NodeSelector["pool"] != "p2p" || len(template.Spec.Tolerations) != 1 || template.Spec.PriorityClassName != "high" {
+		t.Fatalf("newStatefulSetSentry: pod template not patched (%v)", template)
+	}
+	// the managed settings are kept
+	if template.Labels["role"] != "sentry" || template.Spec.SecurityContext == nil || container.Name != serviceName {
+		t.Fatalf("newStatefulSetSentry: managed settings overridden (%v)", template)
+	}
+
+	// a change of the pod template is rolled out
+	polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"nodeSelector":{"pool":"sentries"}}}`)}
+	if newStatefulSetSentry(polkadot, nil).Annotations[specHashAnnotation] == statefulSet.Annotations[specHashAnnotation] {
+		t.Fatalf("newStatefulSetSentry: spec hash not updated")
+	}
+}
+
+func TestHandleStatefulSetInvalidPodTemplate(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"nodeSelector":"pool"}}`)}
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("v1.AddToScheme: %v", err)
+	}
+	client := fake.NewFakeClientWithScheme(scheme, polkadot)
+	recorder := record.NewFakeRecorder(10)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}
+
+	// the StatefulSet is not created without the patch
+	isRequeueForced, err := reconciler.handleStatefulSet(polkadot)
+	if isRequeueForced || err == nil || !strings.Contains(err.Error(), "spec.sentry.podTemplate") {
+		t.Fatalf("handleStatefulSet: expected an error (%v)", err)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, podTemplateInvalidReason) {
+		t.Fatalf("unexpected event (%v)", event)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, &v1.StatefulSet{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("the StatefulSet was created: (%v)", err)
+	}
+
+	polkadot.Spec.Sentry.PodTemplate = &runtime.RawExtension{Raw: []byte(`{"spec":{"nodeSelector":{"pool":"p2p"}}}`)}
+	if _, err := reconciler.handleStatefulSet(polkadot); err != nil {
+		t.Fatalf("handleStatefulSet: (%v)", err)
+	}
+}
+
+func TestHandleStatefulSetReservedNodes(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	// the sentry node key is in plain text, the validator one is generated by the operator
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+	validatorKey := "0000000000000000000000000000000000000000000000000000000000000021"
+	generated := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: GetValidatorNodeKeySecretName(CRName)},
+		Data:       map[string][]byte{nodeKeySecretKey: []byte(validatorKey)},
+	}
+
+	sentryPeerID, _ := p2p.PeerIDFromNodeKey(polkadot.Spec.Sentry.NodeKey)
+	validatorPeerID, _ := p2p.PeerIDFromNodeKey(validatorKey)
+
+	tests := []struct {
+		name                    string
+		reservedValidatorID     string
+		expectedValidatorPeerID string
+	}{
+		{
+			name:                    "Reserved nodes derived",
+			expectedValidatorPeerID: validatorPeerID,
+		},
+		{
+			name:                    "Reserved validator ID overridden",
+			reservedValidatorID:     "QmQtR1cdEaJM11qBWQBd34FoSgFichCjhtsBfrUFsVAjZM",
+			expectedValidatorPeerID: "QmQtR1cdEaJM11qBWQBd34FoSgFichCjhtsBfrUFsVAjZM",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			polkadot := polkadot.DeepCopy()
+			polkadot.Spec.Sentry.ReservedValidatorID = test.reservedValidatorID
+
+			// Create a fake client to mock API calls.
+			client := fake.NewFakeClientWithScheme(scheme, polkadot, generated)
+			reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+			// the first call creates the sentry StatefulSet, the second one the validator StatefulSet
+			for i := 0; i < 2; i++ {
+				if _, err := reconciler.handleStatefulSet(polkadot); err != nil {
+					t.Fatalf("handleStatefulSet: (%v)", err)
+				}
+			}
+
+			sentry := &v1.StatefulSet{}
+			_ = client.Get(context.TODO(), types.NamespacedName{Name: GetSentryStatefulSetName(CRName)}, sentry)
+			validator := &v1.StatefulSet{}
+			_ = client.Get(context.TODO(), types.NamespacedName{Name: GetValidatorStatefulSetName(CRName)}, validator)
+
+			sentryCommand := strings.Join(sentry.Spec.Template.Spec.Containers[0].Command, " ")
+			if !strings.Contains(sentryCommand, "--reserved-nodes /dns4/"+GetValidatorServiceName(CRName)+"/tcp/") || !strings.HasSuffix(sentryCommand, "/p2p/"+test.expectedValidatorPeerID) {
+				t.Fatalf("handleStatefulSet: unexpected sentry command (%v)", sentryCommand)
+			}
+			validatorCommand := strings.Join(validator.Spec.Template.Spec.Containers[0].Command, " ")
+			if !strings.Contains(validatorCommand, "--reserved-only --reserved-nodes /dns4/"+GetSentryServiceName(CRName)+"/tcp/") || !strings.HasSuffix(validatorCommand, "/p2p/"+sentryPeerID) {
+				t.Fatalf("handleStatefulSet: unexpected validator command (%v)", validatorCommand)
+			}
+		})
+	}
+}
+
+func TestHandleStatefulSetReservedNodesPerPod(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	// the sentry node keys are generated by the operator, one per pod
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+	polkadot.Spec.Sentry.NodeKey = ""
+	polkadot.Spec.Sentry.Replicas = 2
+	polkadot.Spec.Validator.NodeKey = "0000000000000000000000000000000000000000000000000000000000000021"
+
+	// Create a fake client to mock API calls.
+	client := fake.NewFakeClientWithScheme(scheme, polkadot)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	getValidatorCommand := func() string {
+		for i := 0; i < 3; i++ {
+			if _, err := reconciler.handleNodeKey(polkadot); err != nil {
+				t.Fatalf("handleNodeKey: (%v)", err)
+			}
+			if _, err := reconciler.handleStatefulSet(polkadot); err != nil {
+				t.Fatalf("handleStatefulSet: (%v)", err)
+			}
+		}
+		validator := &v1.StatefulSet{}
+		_ = client.Get(context.TODO(), types.NamespacedName{Name: GetValidatorStatefulSetName(CRName)}, validator)
+		return strings.Join(validator.Spec.Template.Spec.Containers[0].Command, " ")
+	}
+
+	validatorCommand := getValidatorCommand()
+	for _, podName := range getSentryPodNames(polkadot) {
+		if !strings.Contains(validatorCommand, "/dns4/"+podName+"."+GetSentryHeadlessServiceName(CRName)+"/tcp/") {
+			t.Fatalf("handleStatefulSet: the sentry %v is not reserved (%v)", podName, validatorCommand)
+		}
+	}
+	if strings.Count(validatorCommand, "/p2p/") != 2 {
+		t.Fatalf("handleStatefulSet: unexpected reserved nodes (%v)", validatorCommand)
+	}
+
+	// scaling the sentries updates the reserved nodes of the validator
+	polkadot.Spec.Sentry.Replicas = 3
+	validatorCommand = getValidatorCommand()
+	if strings.Count(validatorCommand, "/p2p/") != 3 || !strings.Contains(validatorCommand, "/dns4/"+GetSentryStatefulSetName(CRName)+"-2.") {
+		t.Fatalf("handleStatefulSet: unexpected reserved nodes after scaling (%v)", validatorCommand)
+	}
+}
@@ -3,19 +3,27 @@
 package polkadot
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/swisscom-blockchain/polkadot-k8s-operator/config"
 	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"hash/fnv"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"strconv"
+	"strings"
 )
 
-func getCommands(nodeKey,clientName string, isDataPersistenceEnabled bool) []string{
+func getCommands(nodeKey string, nodeKeySecret *corev1.SecretKeySelector, clientName string, isDataPersistenceEnabled bool, rpcArgs []string, databaseArgs []string, chain polkadotv1alpha1.ChainSpec) []string{
 	c := []string{
 		"polkadot",
-		"--node-key", nodeKey,
+	}
+	c = append(c, getChainArgs(chain)...)
+	c = append(c, getNodeKeyArgs(nodeKey, nodeKeySecret)...)
+	c = append(c,
 		"--name", clientName,
 		"--port",
 		strconv.Itoa(config.P2PPortEnvVar.Value),
@@ -23,118 +31,361 @@ func getCommands(nodeKey,clientName string, isDataPersistenceEnabled bool) []str
 		strconv.Itoa(config.RPCPortEnvVar.Value),
 		"--ws-port",
 		strconv.Itoa(config.WSPortEnvVar.Value),
-		"--unsafe-rpc-external",
-		"--unsafe-ws-external",
-		"--rpc-cors=all",
 		//"--no-telemetry",
-	}
+	)
+	c = append(c, rpcArgs...)
+	c = append(c, databaseArgs...)
 	if isDataPersistenceEnabled == true {
 		c = append(c,"-d=" + volumeMountPath)
 	}
 	return c
 }
 
+// getNodeKeyArgs passes the node key stored in a Secret as a mounted file, so that it is not visible in the StatefulSet
+func getNodeKeyArgs(nodeKey string, nodeKeySecret *corev1.SecretKeySelector) []string {
+	if isNodeKeyPerPod(nodeKeySecret) {
+		// the file is named after the pod, the variable is expanded by the kubelet
+		return []string{"--node-key-file", nodeKeyMountPath + "/$(" + podNameEnvVar + ")"}
+	}
+	if nodeKeySecret != nil {
+		return []string{"--node-key-file", nodeKeyMountPath + "/" + nodeKeyFileName}
+	}
+	if nodeKey == "" {
+		// the validator standby runs with a node key generated by the client
+		return nil
+	}
+	return []string{"--node-key", nodeKey}
+}
+
+// getChainArgs selects the chain by its name, or by the path of the chain spec mounted in the pod
+func getChainArgs(chain polkadotv1alpha1.ChainSpec) []string {
+	if chain.Name != "" {
+		return []string{"--chain", chain.Name}
+	}
+	if isChainSpecFile(chain) {
+		return []string{"--chain", chainSpecMountPath + "/" + chainSpecFileName}
+	}
+	return nil
+}
+
+func isChainSpecFile(chain polkadotv1alpha1.ChainSpec) bool {
+	return chain.ConfigMapRef != nil || chain.URL != ""
+}
+
+// getRPCArgs returns the flags binding the RPC and websocket endpoints according to the mode of the role.
+// The client refuses to serve them externally on a validator with the "--rpc-external" flags, the "unsafe" ones are used instead:
+// the methods are restricted by "--rpc-methods"
+func getRPCArgs(rpc polkadotv1alpha1.RPCSpec, isValidator bool) []string {
+	var args []string
+	if rpc.IsExternal() {
+		if rpc.Mode == polkadotv1alpha1.RPCModeUnsafeExternal || isValidator {
+			args = append(args, "--unsafe-rpc-external", "--unsafe-ws-external")
+		} else {
+			args = append(args, "--rpc-external", "--ws-external")
+		}
+	}
+	if rpc.IsExternal() || rpc.Methods != "" || rpc.Mode == polkadotv1alpha1.RPCModeDisabled {
+		// on the loopback interface the client serves all the methods by default
+		args = append(args, "--rpc-methods="+string(rpc.GetMethods()))
+	}
+	if len(rpc.CORS) > 0 {
+		args = append(args, "--rpc-cors="+strings.Join(rpc.CORS, ","))
+	}
+	return args
+}
+
+// getDatabaseArgs returns the flags of the chain database, the defaults of the client apply to the settings not set.
+// The client requires an archive node to validate, a pruned validator is run with "--unsafe-pruning"
+func getDatabaseArgs(database polkadotv1alpha1.DatabaseSpec, isValidator bool) []string {
+	var args []string
+	if database.Pruning != "" {
+		args = append(args, "--pruning", database.Pruning)
+		if isValidator && database.Pruning != polkadotv1alpha1.PruningArchive {
+			args = append(args, "--unsafe-pruning")
+		}
+	}
+	if database.StateCacheSize > 0 {
+		args = append(args, "--state-cache-size", strconv.FormatInt(database.StateCacheSize, 10))
+	}
+	if database.DBCache > 0 {
+		args = append(args, "--db-cache", strconv.Itoa(int(database.DBCache)))
+	}
+	if database.Backend != "" {
+		args = append(args, "--database", string(database.Backend))
+	}
+	return args
+}
+
+// getKeystoreArgs points the client to the keystore of the Secret, by default the keystore is stored in the base path
+func getKeystoreArgs(keystore *polkadotv1alpha1.KeystoreSpec) []string {
+	if keystore == nil {
+		return nil
+	}
+	return []string{"--keystore-path", keystoreMountPath}
+}
+
+// getValidatorRPC returns the RPC settings of the validator, while a key rotation is pending the unsafe methods are served
+// on all the interfaces: the key rotation NetworkPolicy, created beforehand, restricts them to the operator
+func getValidatorRPC(CRInstance *polkadotv1alpha1.Polkadot) polkadotv1alpha1.RPCSpec {
+	rpc := CRInstance.GetValidatorRPC()
+	if isKeyRotationLockedDown(CRInstance) {
+		rpc.Mode = polkadotv1alpha1.RPCModeUnsafeExternal
+		rpc.Methods = polkadotv1alpha1.RPCMethodsUnsafe
+	}
+	return rpc
+}
+
+func getReservedNodesArgs(reservedNodes []string) []string {
+	if len(reservedNodes) == 0 {
+		return nil
+	}
+	return append([]string{"--reserved-nodes"}, reservedNodes...)
+}
+
+// getReservedNodeMultiaddr returns the multiaddr of the node with the given peer ID, reachable at the host name on the p2p port
+func getReservedNodeMultiaddr(host string, peerID string) string {
+	return "/dns4/" + host + "/tcp/" + strconv.Itoa(config.P2PPortEnvVar.Value) + "/p2p/" + peerID
+}
+
 type Parameters struct{
 	name                     string
 	namespace                string
+	governingServiceName     string
 	labels                   map[string]string
+	podLabels                map[string]string
 	replicas                 int32
 	version                  string
+	image                    polkadotv1alpha1.ImageSpec
+	imageReference           string
+	partition                int32
+	isOnDelete               bool
+	isLeaseStartGateEnabled  bool
 	commands                 []string
 	clientContainerResources corev1.ResourceRequirements
 	dataPersistence          polkadotv1alpha1.DataPersistenceSupport
 	isMetricsSupportEnabled  bool
+	nodeKeySecret            *corev1.SecretKeySelector
+	isPublicAddressEnabled   bool
+	isRPCExternal            bool
+	chain                    polkadotv1alpha1.ChainSpec
+	overrides                polkadotv1alpha1.Overrides
+	resync                   string
+	volumeOwner              string
+	keystore                 *polkadotv1alpha1.KeystoreSpec
 }
 
-func newStatefulSetSentry(CRInstance *polkadotv1alpha1.Polkadot) *appsv1.StatefulSet {
+// newStatefulSetSentry returns the sentry StatefulSet, reservedNodes are the multiaddrs of the validator (SentryAndValidator kind only)
+func newStatefulSetSentry(CRInstance *polkadotv1alpha1.Polkadot, reservedNodes []string) *appsv1.StatefulSet {
 	replicas := CRInstance.Spec.Sentry.Replicas
-	version := CRInstance.Spec.ClientVersion
+	imageReference, version := getDesiredClientImage(CRInstance)
 	clientName := CRInstance.Spec.Sentry.ClientName
 	nodeKey := CRInstance.Spec.Sentry.NodeKey
+	nodeKeySecret := getSentryNodeKeySecret(CRInstance)
 	clientContainerResources := CRInstance.Spec.Sentry.Resources
 	dataPersistence := CRInstance.Spec.Sentry.DataPersistenceSupport
 	isMetricsSupportEnabled := CRInstance.Spec.MetricsSupport.Enabled
+	rpc := CRInstance.GetSentryRPC()
 
-	labels := getSentrylabels()
+	labels := getSentrylabels(CRInstance.Name)
 
-	commands := getCommands(nodeKey,clientName,dataPersistence.Enabled)
+	commands := getCommands(nodeKey,nodeKeySecret,clientName,dataPersistence.Enabled,getRPCArgs(rpc, false),getDatabaseArgs(CRInstance.Spec.Sentry.Database, false),CRInstance.Spec.Chain)
 	commands = append(commands,"--sentry")
 	if CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
-		reservedValidatorID := CRInstance.Spec.Sentry.ReservedValidatorID
-		commands = append(commands, "--reserved-nodes", "/dns4/"+ServiceValidatorName+"/tcp/30333/p2p/"+reservedValidatorID)
+		commands = append(commands, getReservedNodesArgs(reservedNodes)...)
+	}
+	isPublicAddressEnabled := CRInstance.Spec.Sentry.PublicAddressSupport.Enabled
+	if isPublicAddressEnabled {
+		// the address of the pod is set by the operator in a pod annotation, the variable is expanded by the kubelet
+		commands = append(commands, "--public-addr", "$("+publicAddrEnvVar+")")
 	}
+	commands = append(commands, CRInstance.Spec.Sentry.ExtraArgs...)
 
 	p := Parameters{
-		name:                     SentrySSName,
+		name:                     GetSentryStatefulSetName(CRInstance.Name),
+		partition:                getUpgradePartition(CRInstance, GetSentryStatefulSetName(CRInstance.Name), replicas),
 		namespace:                CRInstance.Namespace,
+		governingServiceName:     GetSentryHeadlessServiceName(CRInstance.Name),
 		labels:                   labels,
 		replicas:                 replicas,
 		version:                  version,
+		image:                    getClientImage(CRInstance),
+		imageReference:           imageReference,
 		commands:                 commands,
 		clientContainerResources: clientContainerResources,
 		dataPersistence:          dataPersistence,
 		isMetricsSupportEnabled:  isMetricsSupportEnabled,
+		nodeKeySecret:            nodeKeySecret,
+		isPublicAddressEnabled:   isPublicAddressEnabled,
+		isRPCExternal:            rpc.IsExternal(),
+		chain:                    CRInstance.Spec.Chain,
+		overrides:                CRInstance.Spec.Sentry.Overrides,
+		resync:                   CRInstance.Spec.Sentry.Database.Resync,
+		volumeOwner:              getVolumeOwner(CRInstance),
 	}
 
 	return getStatefulSet(p)
 }
 
-func newStatefulSetValidator(CRInstance *polkadotv1alpha1.Polkadot) *appsv1.StatefulSet {
-	replicas := int32(1)
-	version := CRInstance.Spec.ClientVersion
+// newStatefulSetValidator returns the validator StatefulSet, reservedNodes are the multiaddrs of the sentries (SentryAndValidator kind only)
+func newStatefulSetValidator(CRInstance *polkadotv1alpha1.Polkadot, reservedNodes []string) *appsv1.StatefulSet {
+	return newStatefulSetValidatorNode(CRInstance, reservedNodes, polkadotv1alpha1.ValidatorNodePrimary)
+}
+
+// newStatefulSetValidatorSecondary returns the second validator StatefulSet of the standby mode
+func newStatefulSetValidatorSecondary(CRInstance *polkadotv1alpha1.Polkadot, reservedNodes []string) *appsv1.StatefulSet {
+	return newStatefulSetValidatorNode(CRInstance, reservedNodes, polkadotv1alpha1.ValidatorNodeSecondary)
+}
+
+// newStatefulSetValidatorNode returns the StatefulSet of a validator node. Only the active node runs with the session keys and the node key,
+// the standby one is a node of the same chain kept synced, see handleValidatorStandby
+func newStatefulSetValidatorNode(CRInstance *polkadotv1alpha1.Polkadot, reservedNodes []string, node polkadotv1alpha1.ValidatorNode) *appsv1.StatefulSet {
+	replicas := getValidatorNodeReplicas(CRInstance, node)
+	imageReference, version := getDesiredClientImage(CRInstance)
 	clientName := CRInstance.Spec.Validator.ClientName
 	nodeKey := CRInstance.Spec.Validator.NodeKey
+	nodeKeySecret := getValidatorNodeKeySecret(CRInstance)
 	clientContainerResources := CRInstance.Spec.Validator.Resources
 	dataPersistence := CRInstance.Spec.Validator.DataPersistenceSupport
 	isMetricsSupportEnabled := CRInstance.Spec.MetricsSupport.Enabled
+	rpc := getValidatorRPC(CRInstance)
+	keystore := CRInstance.Spec.Validator.Keystore
+	isLeaseStartGateEnabled := CRInstance.Spec.Validator.LeaseStartGate.Enabled
+	if node == polkadotv1alpha1.ValidatorNodeSecondary && isRestoreSourceClone(dataPersistence.RestoreFrom) {
+		// only the claim of the primary validator is provisioned from the restore source, the standby syncs from the network
+		dataPersistence.RestoreFrom = nil
+	}
 
-	labels := getValidatorLabels()
+	labels := getValidatorNodeLabels(CRInstance.Name, node)
+	var podLabels map[string]string
+	isKeyHolder := isValidatorNodeKeyHolder(CRInstance, node)
+	if !isKeyHolder {
+		nodeKey, nodeKeySecret, keystore, isLeaseStartGateEnabled = "", nil, nil, false
+	} else if isValidatorStandbyEnabled(CRInstance) {
+		podLabels = map[string]string{activeValidatorLabel: "true"}
+	}
 
-	commands := getCommands(nodeKey,clientName,dataPersistence.Enabled)
-	commands = append(commands,"--validator")
+	commands := getCommands(nodeKey,nodeKeySecret,clientName,dataPersistence.Enabled,getRPCArgs(rpc, true),getDatabaseArgs(CRInstance.Spec.Validator.Database, true),CRInstance.Spec.Chain)
+	if isKeyHolder {
+		commands = append(commands,"--validator")
+	}
+	commands = append(commands, getKeystoreArgs(keystore)...)
 	if CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
-		reservedSentryID := CRInstance.Spec.Validator.ReservedSentryID
-		commands = append(commands,
-			"--reserved-only",
-			"--reserved-nodes", "/dns4/"+ServiceSentryName+"/tcp/30333/p2p/"+reservedSentryID)
+		commands = append(commands, "--reserved-only")
+		commands = append(commands, getReservedNodesArgs(reservedNodes)...)
 	}
+	commands = append(commands, CRInstance.Spec.Validator.ExtraArgs...)
 
 	p := Parameters{
-		name:                     ValidatorSSName,
+		name:                     getValidatorNodeStatefulSetName(CRInstance.Name, node),
 		namespace:                CRInstance.Namespace,
+		governingServiceName:     GetValidatorHeadlessServiceName(CRInstance.Name),
 		labels:                   labels,
+		podLabels:                podLabels,
 		replicas:                 replicas,
 		version:                  version,
+		image:                    getClientImage(CRInstance),
+		imageReference:           imageReference,
 		commands:                 commands,
 		clientContainerResources: clientContainerResources,
 		dataPersistence:          dataPersistence,
 		isMetricsSupportEnabled:  isMetricsSupportEnabled,
+		nodeKeySecret:            nodeKeySecret,
+		isRPCExternal:            rpc.IsExternal(),
+		chain:                    CRInstance.Spec.Chain,
+		overrides:                CRInstance.Spec.Validator.Overrides,
+		resync:                   CRInstance.Spec.Validator.Database.Resync,
+		volumeOwner:              getVolumeOwner(CRInstance),
+		keystore:                 keystore,
+		// the pod is replaced by the operator only once no other validator pod exists, see handleValidatorUpdate
+		isOnDelete:               true,
+		isLeaseStartGateEnabled:       isLeaseStartGateEnabled,
 	}
 
 	return getStatefulSet(p)
 }
 
 func getStatefulSet(p Parameters) *appsv1.StatefulSet{
+	spec := getStatefulSetSpec(p)
+	if p.overrides.PodTemplate != nil {
+		// a patch that can't be applied is reported by handleStatefulSet, see getPodTemplateOverridesError
+		if template, err := getPatchedPodTemplate(spec.Template, p.overrides.PodTemplate.Raw); err == nil {
+			spec.Template = template
+		}
+	}
 	return &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      p.name,
-			Namespace: p.namespace,
-			Labels:    getCopyLabelsWithVersion(p.labels, p.version),
+			Name:        p.name,
+			Namespace:   p.namespace,
+			Labels:      getCopyLabelsWithVersion(p.labels, p.version),
+			Annotations: map[string]string{specHashAnnotation: getPodTemplateHash(&spec.Template)},
 		},
-		Spec: getStatefulSetSpec(p),
+		Spec: spec,
 	}
 }
 
+// getPatchedPodTemplate applies the strategic merge patch of the pod template set by the user.
+// The admission webhook rejects the patches of the fields managed by the operator
+func getPatchedPodTemplate(template corev1.PodTemplateSpec, patch []byte) (corev1.PodTemplateSpec, error) {
+	original, err := json.Marshal(template)
+	if err != nil {
+		return template, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, patch, corev1.PodTemplateSpec{})
+	if err != nil {
+		return template, err
+	}
+	result := corev1.PodTemplateSpec{}
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return template, err
+	}
+	return result, nil
+}
+
+// getPodTemplateOverridesError returns the error of the podTemplate patch of a role that can't be applied to the pod template of the role,
+// the webhook rejects them but it can be disabled
+func getPodTemplateOverridesError(CRInstance *polkadotv1alpha1.Polkadot) error {
+	unpatched := CRInstance.DeepCopy()
+	unpatched.Spec.Sentry.PodTemplate = nil
+	unpatched.Spec.Validator.PodTemplate = nil
+	if patch := CRInstance.Spec.Sentry.PodTemplate; patch != nil {
+		if _, err := getPatchedPodTemplate(newStatefulSetSentry(unpatched, nil).Spec.Template, patch.Raw); err != nil {
+			return fmt.Errorf("spec.sentry.podTemplate can't be applied: %v", err)
+		}
+	}
+	if patch := CRInstance.Spec.Validator.PodTemplate; patch != nil {
+		if _, err := getPatchedPodTemplate(newStatefulSetValidator(unpatched, nil).Spec.Template, patch.Raw); err != nil {
+			return fmt.Errorf("spec.validator.podTemplate can't be applied: %v", err)
+		}
+	}
+	return nil
+}
+
+// getPodTemplateHash identifies the desired pod template, the live template can't be compared as a whole because of the server-set defaults
+func getPodTemplateHash(template *corev1.PodTemplateSpec) string {
+	return getSpecHash(template)
+}
+
+func getSpecHash(spec interface{}) string {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return ""
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write(data)
+	return strconv.FormatUint(uint64(hash.Sum32()), 16)
+}
+
 func getStatefulSetSpec(p Parameters) appsv1.StatefulSetSpec{
 	sSpec := appsv1.StatefulSetSpec{
 		Replicas: &p.replicas,
 		Selector: &metav1.LabelSelector{
 			MatchLabels: p.labels,
 		},
-		ServiceName: serviceName,
+		ServiceName: p.governingServiceName,
+		UpdateStrategy: getUpdateStrategy(p),
 		Template: corev1.PodTemplateSpec{
 			ObjectMeta: metav1.ObjectMeta{
-				Labels: p.labels,
+				Labels: mergeMaps(p.labels, p.podLabels),
 			},
 			Spec: getPodSpec(p),
 		},
@@ -145,38 +396,123 @@ func getStatefulSetSpec(p Parameters) appsv1.StatefulSetSpec{
 	return sSpec
 }
 
+func getUpdateStrategy(p Parameters) appsv1.StatefulSetUpdateStrategy {
+	if p.isOnDelete {
+		return appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+	}
+	return appsv1.StatefulSetUpdateStrategy{
+		Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &p.partition},
+	}
+}
+
 func getPodSpec(p Parameters) corev1.PodSpec{
 	spec := corev1.PodSpec{
 		SecurityContext: getPodSecurityContext(),
 		Containers: []corev1.Container{
 			getContainerClient(p),
 		},
+		ImagePullSecrets: p.image.ImagePullSecrets,
 	}
 	if p.dataPersistence.Enabled == true{
-		spec.InitContainers = []corev1.Container{ *getVolumePermissionInitContainer(p.dataPersistence.PersistentVolumeClaim.ObjectMeta.Name) }
+		volumeMountName := p.dataPersistence.PersistentVolumeClaim.ObjectMeta.Name
+		isRestoreClone := isRestoreSourceClone(p.dataPersistence.RestoreFrom)
+		// the owner of a claim copied from another node is recorded by the restore init container, once the keys of the node are removed
+		volumeOwner := p.volumeOwner
+		if isRestoreClone {
+			volumeOwner = ""
+		}
+		spec.InitContainers = []corev1.Container{ *getVolumePermissionInitContainer(volumeMountName, volumeOwner) }
+		if isRestoreClone {
+			spec.InitContainers = append(spec.InitContainers, getRestoreCloneInitContainer(volumeMountName, p.volumeOwner, p.resync))
+		}
+		if p.resync != "" {
+			spec.InitContainers = append(spec.InitContainers, getResyncInitContainer(volumeMountName, p.resync))
+		}
+		if p.dataPersistence.RestoreFrom != nil && !isRestoreClone {
+			spec.InitContainers = append(spec.InitContainers, getRestoreInitContainer(volumeMountName, *p.dataPersistence.RestoreFrom))
+		}
 	}
 	if p.isMetricsSupportEnabled == true{
 		spec.Containers = append(spec.Containers, getContainerMetrics())
 	}
+	if p.nodeKeySecret != nil {
+		spec.Volumes = append(spec.Volumes, getNodeKeyVolume(p.nodeKeySecret))
+	}
+	if p.keystore != nil {
+		spec.Volumes = append(spec.Volumes, getKeystoreVolume(p.keystore))
+	}
+	if p.isPublicAddressEnabled {
+		spec.InitContainers = append(spec.InitContainers, getPublicAddressInitContainer())
+		spec.Volumes = append(spec.Volumes, getPublicAddressVolume())
+	}
+	if isChainSpecFile(p.chain) {
+		if p.chain.URL != "" {
+			spec.InitContainers = append(spec.InitContainers, getChainSpecDownloadInitContainer(p.chain.URL))
+		}
+		spec.Volumes = append(spec.Volumes, getChainSpecVolume(p.chain))
+	}
+	if p.isLeaseStartGateEnabled {
+		// the start gate is passed before the other init containers touch the chain data
+		spec.InitContainers = append([]corev1.Container{getValidatorLeaseInitContainer()}, spec.InitContainers...)
+		spec.Volumes = append(spec.Volumes, getValidatorLeaseVolume())
+	}
 	return spec
 }
 
 func getContainerClient(p Parameters) corev1.Container{
 	container:=corev1.Container{
 			Name:           serviceName,
-			Image:          config.ImageClientEnvVar.Value + ":" + p.version,
+			Image:          p.imageReference,
+			ImagePullPolicy: p.image.PullPolicy,
 			Command:        p.commands,
-			Ports:          getContainerPortsClient(),
-			LivenessProbe:  getHealthProbeClient(),
-			ReadinessProbe: getHealthProbeClient(),
+			Ports:          getContainerPortsClient(p.isRPCExternal),
+			LivenessProbe:  getHealthProbeClient(p.isRPCExternal),
+			ReadinessProbe: getHealthProbeClient(p.isRPCExternal),
 			Resources:     p.clientContainerResources,
 		}
 		if p.dataPersistence.Enabled == true{
 			container.VolumeMounts=getVolumeMounts(p.dataPersistence.PersistentVolumeClaim.ObjectMeta.Name)
 		}
+		if p.nodeKeySecret != nil {
+			container.VolumeMounts = append(container.VolumeMounts, getNodeKeyVolumeMount())
+		}
+		if p.keystore != nil {
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: keystoreVolumeName, MountPath: keystoreMountPath, ReadOnly: true})
+		}
+		if isNodeKeyPerPod(p.nodeKeySecret) {
+			container.Env = append(container.Env, getPodNameEnvVar())
+		}
+		if p.isPublicAddressEnabled {
+			container.Env = append(container.Env, getPublicAddressEnvVar())
+		}
+		if isChainSpecFile(p.chain) {
+			container.VolumeMounts = append(container.VolumeMounts, getChainSpecVolumeMount(true))
+		}
+		container.Env = append(container.Env, getDefaultedEnv(p.overrides.Env)...)
+		container.EnvFrom = p.overrides.EnvFrom
 		return container
 }
 
+// getClientImage returns the client image of the CR, with the repository of the operator and the tag defaulted
+func getClientImage(CRInstance *polkadotv1alpha1.Polkadot) polkadotv1alpha1.ImageSpec {
+	image := *CRInstance.Spec.Image.DeepCopy()
+	if image.Repository == "" {
+		image.Repository = config.ImageClientEnvVar.Value
+	}
+	image.Tag = CRInstance.GetClientImageTag()
+	return image
+}
+
+// getImageReference returns the reference pulled by the kubelet, the digest takes precedence over the tag
+func getImageReference(image polkadotv1alpha1.ImageSpec) string {
+	reference := image.Repository + ":" + image.Tag
+	if image.Digest != "" {
+		reference += "@" + image.Digest
+	}
+	return reference
+}
+
 func getContainerMetrics() corev1.Container{
 	return corev1.Container {
 		Name:          "metrics-exporter",
@@ -186,23 +522,331 @@ func getContainerMetrics() corev1.Container{
 	}
 }
 
-func getVolumePermissionInitContainer(volumeMountName string) *corev1.Container {
+// getVolumePermissionInitContainer gives the data volume to the user of the client. With a volumeOwner, the volume is marked as the
+// volume of the pod if it is not marked yet, so that a later restore from another node doesn't take it for a copy
+func getVolumePermissionInitContainer(volumeMountName string, volumeOwner string) *corev1.Container {
 	rootUser := int64(0)
 	runAsNonRootFalse := false
 
+	command := "chown -R 1000:1000 " + volumeMountPath
+	var env []corev1.EnvVar
+	if volumeOwner != "" {
+		ownerFile := volumeMountPath + "/" + volumeOwnerFileName
+		command = `[ -f ` + ownerFile + ` ] || printf %s "$` + volumeOwnerEnvVar + `" > ` + ownerFile + `; ` + command
+		env = getVolumeOwnerEnv(volumeOwner)
+	}
 	return &corev1.Container {
 		Name:  "volume-mount-permissions-data",
-		Image: "busybox",
+		Image: busyboxImage,
 		VolumeMounts: getVolumeMounts(volumeMountName),
+		Env: env,
 		SecurityContext: &corev1.SecurityContext{
 			RunAsUser:          &rootUser,
 			RunAsNonRoot: &runAsNonRootFalse,
 		},
-		Command: []string{"sh", "-c", "chown -R 1000:1000 " + volumeMountPath},
+		Command: []string{"sh", "-c", command},
 
 	}
 }
 
+// getVolumeOwnerEnv identifies the volume of the pod: the name of the pod and the UID of the CR, a CR recreated with the same name
+// has another UID
+func getVolumeOwnerEnv(volumeOwner string) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		getPodNameEnvVar(),
+		{Name: volumeOwnerEnvVar, Value: volumeOwner + "/$(" + podNameEnvVar + ")"},
+	}
+}
+
+// getVolumeOwner returns the identity of the CR recorded in the data volumes
+func getVolumeOwner(CRInstance *polkadotv1alpha1.Polkadot) string {
+	return string(CRInstance.UID)
+}
+
+// getResyncInitContainer wipes the chain database of the data volume once per resync token, so that the client syncs it again
+// with the current database settings. The keystore and the network key stored next to the database are kept
+func getResyncInitContainer(volumeMountName string, resync string) corev1.Container {
+	resyncFile := volumeMountPath + "/" + resyncFileName
+	script := `if [ "$(cat ` + resyncFile + ` 2>/dev/null)" != "$` + resyncEnvVar + `" ]; then ` +
+		`rm -rf ` + volumeMountPath + `/chains/*/db ` + volumeMountPath + `/chains/*/paritydb && ` +
+		`printf %s "$` + resyncEnvVar + `" > ` + resyncFile + `; fi`
+	return corev1.Container{
+		Name:         "resync-data",
+		Image:        busyboxImage,
+		VolumeMounts: getVolumeMounts(volumeMountName),
+		Env:          []corev1.EnvVar{{Name: resyncEnvVar, Value: resync}},
+		Command:      []string{"sh", "-c", script},
+	}
+}
+
+// getRestoreInitContainer fills the chain database of the data volume from the archive, only if the database is empty.
+// The archive is downloaded and unpacked on the fly
+func getRestoreInitContainer(volumeMountName string, source polkadotv1alpha1.RestoreSource) corev1.Container {
+	container := corev1.Container{
+		Name:         restoreContainerName,
+		Image:        restoreDownloadImage,
+		VolumeMounts: getVolumeMounts(volumeMountName),
+		// the errors of curl and tar are reported in the restore status
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	archiveName := source.URL
+	container.Env = []corev1.EnvVar{{Name: restoreURLEnvVar, Value: source.URL}}
+	download := `curl -fsSL --retry 3 "$` + restoreURLEnvVar + `"`
+	if source.S3 != nil {
+		archiveName = source.S3.Key
+		container.Env = []corev1.EnvVar{{Name: restoreURLEnvVar, Value: getS3ObjectURL(*source.S3)}}
+		if source.S3.CredentialsSecretRef != nil {
+			container.Env = append(container.Env, getS3CredentialsEnv(source.S3.CredentialsSecretRef.Name)...)
+			download += " " + getS3SignArgs(source.S3.S3Bucket)
+		}
+	}
+	extract := "tar -xf - -C " + volumeMountPath
+	if !strings.HasSuffix(archiveName, ".tar") {
+		extract = "tar -xzf - -C " + volumeMountPath
+	}
+	container.Command = []string{"sh", "-c", getRestoreScript(download + " | " + extract)}
+	return container
+}
+
+// getRestoreCloneInitContainer prepares a data volume provisioned from the claim or the VolumeSnapshot of another node: only the chain
+// databases are kept, the keystore and the network key of the source node are removed. The volume is then marked as the volume of the pod,
+// a restarted pod skips the restore. With a resync token, the copied database counts as the database of the current token
+func getRestoreCloneInitContainer(volumeMountName string, volumeOwner string, resync string) corev1.Container {
+	ownerFile := volumeMountPath + "/" + volumeOwnerFileName
+	script := `set -e; if [ "$(cat ` + ownerFile + ` 2>/dev/null)" = "$` + volumeOwnerEnvVar + `" ]; then ` +
+		`echo "skipped, the volume is not a copy of another node" > /dev/termination-log; exit 0; fi; ` +
+		`[ -n "$(ls -d ` + volumeMountPath + `/chains/*/db ` + volumeMountPath + `/chains/*/paritydb 2>/dev/null)" ] || ` +
+		`{ echo "no chain database found in the restored volume" >&2; exit 1; }; ` +
+		`for d in ` + volumeMountPath + `/chains/*/*; do case "${d##*/}" in db|paritydb) ;; *) rm -rf "$d" ;; esac; done; ` +
+		`[ -z "$` + resyncEnvVar + `" ] || printf %s "$` + resyncEnvVar + `" > ` + volumeMountPath + `/` + resyncFileName + `; ` +
+		`printf %s "$` + volumeOwnerEnvVar + `" > ` + ownerFile + `; echo "restored" > /dev/termination-log`
+	return corev1.Container{
+		Name:         restoreContainerName,
+		Image:        busyboxImage,
+		VolumeMounts: getVolumeMounts(volumeMountName),
+		Env:          append(getVolumeOwnerEnv(volumeOwner), corev1.EnvVar{Name: resyncEnvVar, Value: resync}),
+		Command:      []string{"sh", "-c", script},
+		// the error is reported in the restore status
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+}
+
+// getRestoreScript runs the fetch command if the chain database is empty. A restore interrupted before the end
+// is detected by the marker file and started over, a partial database is never left for the client
+func getRestoreScript(fetch string) string {
+	restoringFile := volumeMountPath + "/" + restoringFileName
+	return `set -e -o pipefail; ` +
+		`if [ ! -f ` + restoringFile + ` ] && [ -n "$(find ` + volumeMountPath + `/chains -mindepth 3 -maxdepth 3 \( -path '*/db/*' -o -path '*/paritydb/*' \) 2>/dev/null | head -n 1)" ]; then ` +
+		`echo "skipped, the chain database is not empty" > /dev/termination-log; exit 0; fi; ` +
+		`touch ` + restoringFile + `; rm -rf ` + volumeMountPath + `/chains/*/db ` + volumeMountPath + `/chains/*/paritydb; ` +
+		fetch + `; ` +
+		`rm ` + restoringFile + `; echo "restored" > /dev/termination-log`
+}
+
+// getS3ObjectURL returns the path-style URL of the object
+func getS3ObjectURL(object polkadotv1alpha1.S3Object) string {
+	return getS3BucketURL(object.S3Bucket) + "/" + strings.TrimPrefix(object.Key, "/")
+}
+
+func getS3BucketURL(bucket polkadotv1alpha1.S3Bucket) string {
+	return strings.TrimSuffix(bucket.Endpoint, "/") + "/" + bucket.Bucket
+}
+
+// getS3SignArgs returns the curl arguments signing the requests with the credentials of the env
+func getS3SignArgs(bucket polkadotv1alpha1.S3Bucket) string {
+	return `--aws-sigv4 "aws:amz:` + getS3Region(bucket) + `:s3" --user "$AWS_ACCESS_KEY_ID:$AWS_SECRET_ACCESS_KEY"`
+}
+
+func getS3Region(bucket polkadotv1alpha1.S3Bucket) string {
+	if bucket.Region == "" {
+		return defaultS3Region
+	}
+	return bucket.Region
+}
+
+// getS3CredentialsEnv returns the access keys of the S3 credentials Secret, they are referenced, not copied in the pod template
+func getS3CredentialsEnv(secretName string) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		env = append(env, corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: secretName}, Key: key},
+			},
+		})
+	}
+	return env
+}
+
+// getNodeKeyVolume projects the node key of the Secret in a file readable by the group of the pod (fsGroup).
+// With a node key per pod all the keys are projected, each file is named after its key, i.e. the pod name
+func getNodeKeyVolume(nodeKeySecret *corev1.SecretKeySelector) corev1.Volume {
+	mode := int32(0440)
+	volume := corev1.Volume{
+		Name: nodeKeyVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  nodeKeySecret.Name,
+				DefaultMode: &mode,
+			},
+		},
+	}
+	if !isNodeKeyPerPod(nodeKeySecret) {
+		volume.Secret.Items = []corev1.KeyToPath{{Key: nodeKeySecret.Key, Path: nodeKeyFileName}}
+	}
+	return volume
+}
+
+// getKeystoreVolume projects the keys of the Secret as the files of the keystore, readable by the client only
+func getKeystoreVolume(keystore *polkadotv1alpha1.KeystoreSpec) corev1.Volume {
+	mode := int32(0440)
+	return corev1.Volume{
+		Name: keystoreVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  keystore.SecretRef.Name,
+				DefaultMode: &mode,
+			},
+		},
+	}
+}
+
+// getChainSpecVolume projects the chain spec of the ConfigMap, a chain spec downloaded from a URL is stored in an empty dir
+func getChainSpecVolume(chain polkadotv1alpha1.ChainSpec) corev1.Volume {
+	volume := corev1.Volume{Name: chainSpecVolumeName}
+	if chain.ConfigMapRef != nil {
+		volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: chain.ConfigMapRef.LocalObjectReference,
+			Items:                []corev1.KeyToPath{{Key: chain.ConfigMapRef.Key, Path: chainSpecFileName}},
+		}
+		return volume
+	}
+	volume.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	return volume
+}
+
+func getChainSpecVolumeMount(readOnly bool) corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      chainSpecVolumeName,
+		MountPath: chainSpecMountPath,
+		ReadOnly:  readOnly,
+	}
+}
+
+// getChainSpecDownloadInitContainer downloads the chain spec each time the pod starts, the pod fails to start if it can't be downloaded
+func getChainSpecDownloadInitContainer(url string) corev1.Container {
+	return corev1.Container{
+		Name:         "download-chain-spec",
+		Image:        chainSpecDownloadImage,
+		VolumeMounts: []corev1.VolumeMount{getChainSpecVolumeMount(false)},
+		Command:      []string{"curl", "-fsSL", "--retry", "3", "-o", chainSpecMountPath + "/" + chainSpecFileName, url},
+	}
+}
+
+// getPublicAddressInitContainer waits for the operator to annotate the pod with its public address,
+// the environment of the client container is resolved only once the init containers are completed
+func getPublicAddressInitContainer() corev1.Container {
+	return corev1.Container{
+		Name:         "wait-public-address",
+		Image:        busyboxImage,
+		VolumeMounts: []corev1.VolumeMount{{Name: publicAddrVolumeName, MountPath: publicAddrMountPath, ReadOnly: true}},
+		Command:      []string{"sh", "-c", "until [ -s " + publicAddrMountPath + "/" + publicAddrFileName + " ]; do sleep 2; done"},
+	}
+}
+
+// getPublicAddressVolume projects the public address annotation, the file is updated by the kubelet when the annotation is set
+func getPublicAddressVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: publicAddrVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{
+					Path:     publicAddrFileName,
+					FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: getAnnotationFieldPath(publicAddrAnnotation)},
+				}},
+			},
+		},
+	}
+}
+
+func getPublicAddressEnvVar() corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: publicAddrEnvVar,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: getAnnotationFieldPath(publicAddrAnnotation)},
+		},
+	}
+}
+
+// getValidatorLeaseInitContainer waits for the operator to grant the validator Lease to the pod, the annotation holds the UID of the holder.
+// The Lease is checked once before the client starts
+func getValidatorLeaseInitContainer() corev1.Container {
+	holderFile := validatorLeaseMountPath + "/" + validatorLeaseHolderFileName
+	uidFile := validatorLeaseMountPath + "/" + validatorLeaseUIDFileName
+	return corev1.Container{
+		Name:         "wait-validator-lease",
+		Image:        busyboxImage,
+		VolumeMounts: []corev1.VolumeMount{{Name: validatorLeaseVolumeName, MountPath: validatorLeaseMountPath, ReadOnly: true}},
+		Command:      []string{"sh", "-c", "until [ \"$(cat " + holderFile + ")\" = \"$(cat " + uidFile + ")\" ]; do sleep 2; done"},
+	}
+}
+
+// getValidatorLeaseVolume projects the UID of the pod and the holder annotation, the file is updated by the kubelet when the Lease is granted
+func getValidatorLeaseVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: validatorLeaseVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{
+					{
+						Path:     validatorLeaseHolderFileName,
+						FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: getAnnotationFieldPath(validatorLeaseAnnotation)},
+					},
+					{
+						Path:     validatorLeaseUIDFileName,
+						FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.uid"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getAnnotationFieldPath(annotation string) string {
+	return "metadata.annotations['" + annotation + "']"
+}
+
+// getDefaultedEnv sets the apiVersion of the field references as defaulted by the API server, otherwise the env is detected as drifted
+func getDefaultedEnv(env []corev1.EnvVar) []corev1.EnvVar {
+	var defaulted []corev1.EnvVar
+	for _, envVar := range env {
+		envVar = *envVar.DeepCopy()
+		if envVar.ValueFrom != nil && envVar.ValueFrom.FieldRef != nil && envVar.ValueFrom.FieldRef.APIVersion == "" {
+			envVar.ValueFrom.FieldRef.APIVersion = "v1"
+		}
+		defaulted = append(defaulted, envVar)
+	}
+	return defaulted
+}
+
+func getPodNameEnvVar() corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: podNameEnvVar,
+		ValueFrom: &corev1.EnvVarSource{
+			// the apiVersion is set as defaulted by the API server, otherwise the env is detected as drifted
+			FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"},
+		},
+	}
+}
+
+func getNodeKeyVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      nodeKeyVolumeName,
+		MountPath: nodeKeyMountPath,
+		ReadOnly:  true,
+	}
+}
+
 func getPodSecurityContext() *corev1.PodSecurityContext {
 	user := int64(1000)
 	group := int64(1000)
@@ -223,7 +867,20 @@ func getVolumeMounts(volumeName string) []corev1.VolumeMount{
 	}}
 }
 
-func getHealthProbeClient() *corev1.Probe{
+// getHealthProbeClient checks the health endpoint of the RPC, if it is bound to the loopback interface the kubelet can't reach it:
+// the p2p port is checked instead
+func getHealthProbeClient(isRPCExternal bool) *corev1.Probe{
+	if !isRPCExternal {
+		return &corev1.Probe{
+			Handler: corev1.Handler{
+				TCPSocket: &corev1.TCPSocketAction{
+					Port: intstr.IntOrString{Type: intstr.String, StrVal: P2PPortName},
+				},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+		}
+	}
 	return &corev1.Probe{
 		Handler: corev1.Handler{
 			HTTPGet: &corev1.HTTPGetAction{
@@ -249,12 +906,18 @@ func getHealthProbeMetrics() *corev1.Probe{
 	}
 }
 
-func getContainerPortsClient() []corev1.ContainerPort{
-	return []corev1.ContainerPort{
+// getContainerPortsClient returns the ports of the client, the RPC and websocket ones only if they are reachable from outside the pod
+func getContainerPortsClient(isRPCExternal bool) []corev1.ContainerPort{
+	ports := []corev1.ContainerPort{
 		{
 			ContainerPort: int32(config.P2PPortEnvVar.Value),
 			Name:          P2PPortName,
 		},
+	}
+	if !isRPCExternal {
+		return ports
+	}
+	return append(ports, []corev1.ContainerPort{
 		{
 			ContainerPort: int32(config.RPCPortEnvVar.Value),
 			Name:          RPCPortName,
@@ -263,7 +926,7 @@ func getContainerPortsClient() []corev1.ContainerPort{
 			ContainerPort: int32(config.WSPortEnvVar.Value),
 			Name:          WSPortName,
 		},
-	}
+	}...)
 }
 
 func getContainerPortsMetrics() []corev1.ContainerPort{
@@ -0,0 +1,356 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// handleStatus observes the child resources of the CustomResource and writes the result into the status subresource
+func (r *ReconcilerPolkadot) handleStatus(CRInstance *polkadotv1alpha1.Polkadot) error {
+	logger := log.WithValues("Polkadot.Namespace", CRInstance.Namespace, "Polkadot.Name", CRInstance.Name)
+
+	status := CRInstance.Status.DeepCopy()
+	status.ObservedGeneration = CRInstance.Generation
+
+	sentryStatefulSet, err := r.fetchStatefulSetStatus(CRInstance, GetSentryStatefulSetName(CRInstance.Name), isSentryDeployed(CRInstance))
+	if err != nil {
+		logger.Error(err, "Error on fetch the sentry StatefulSet...")
+		return err
+	}
+	// with the standby mode the validator status reports the active validator node
+	activeValidatorStatefulSetName := getValidatorNodeStatefulSetName(CRInstance.Name, CRInstance.GetActiveValidatorNode())
+	validatorStatefulSet, err := r.fetchStatefulSetStatus(CRInstance, activeValidatorStatefulSetName, isValidatorDeployed(CRInstance))
+	if err != nil {
+		logger.Error(err, "Error on fetch the validator StatefulSet...")
+		return err
+	}
+	isBackupRunning, err := r.isBackupRunning(CRInstance)
+	if err != nil {
+		logger.Error(err, "Error on fetch the backup Jobs...")
+		return err
+	}
+	sentryDesiredReplicas := getSentryDesiredReplicas(CRInstance)
+	if isBackupRunning && sentryDesiredReplicas > 0 {
+		// the sentry backed up is stopped on purpose
+		sentryDesiredReplicas--
+	}
+	status.Sentry = getNodeSetStatus(sentryStatefulSet, sentryDesiredReplicas)
+	status.Validator = getNodeSetStatus(validatorStatefulSet, getValidatorDesiredReplicas(CRInstance))
+	if isSentryDeployed(CRInstance) {
+		if CRInstance.Spec.Sentry.PublicAddressSupport.Enabled {
+			status.Sentry.PublicAddresses = r.fetchPublicAddressesStatus(CRInstance, getSentryPodNames(CRInstance))
+		}
+		status.Sentry.Restores = r.fetchRestoresStatus(CRInstance, CRInstance.Spec.Sentry.DataPersistenceSupport, getSentryPodNames(CRInstance))
+		if nodeKeySecret := getSentryNodeKeySecret(CRInstance); isNodeKeyPerPod(nodeKeySecret) {
+			status.Sentry.PodPeerIDs = r.fetchPodPeerIDsStatus(CRInstance, nodeKeySecret.Name, getSentryPodNames(CRInstance))
+		} else {
+			status.Sentry.PeerID = r.fetchPeerIDStatus(CRInstance, CRInstance.Spec.Sentry.NodeKey, nodeKeySecret)
+		}
+	}
+	if isValidatorDeployed(CRInstance) {
+		status.Validator.PeerID = r.fetchPeerIDStatus(CRInstance, CRInstance.Spec.Validator.NodeKey, getValidatorNodeKeySecret(CRInstance))
+		status.Validator.Restores = r.fetchRestoresStatus(CRInstance, CRInstance.Spec.Validator.DataPersistenceSupport, []string{activeValidatorStatefulSetName + "-0"})
+	}
+
+	statefulSets := []*appsv1.StatefulSet{sentryStatefulSet, validatorStatefulSet}
+	areStatefulSetsReady := areNodeSetsReady(status.Sentry, status.Validator) && areStatefulSetsFound(statefulSets)
+	isRolloutComplete := areStatefulSetsFound(statefulSets) && areStatefulSetsRolledOut(statefulSets)
+	if isRolloutComplete {
+		status.ClientVersion = getObservedClientVersion(statefulSets, status.ClientVersion)
+		imageDigest, err := r.fetchImageDigestStatus(CRInstance, status.ImageDigest)
+		if err != nil {
+			logger.Error(err, "Error on fetch the pods...")
+			return err
+		}
+		status.ImageDigest = imageDigest
+	}
+
+	backups, err := r.fetchBackupsStatus(CRInstance, status.Backups)
+	if err != nil {
+		logger.Error(err, "Error on fetch the backups status...")
+		return err
+	}
+	status.Backups = backups
+
+	// the nodes are polled by the node status cache, out of the reconciliation
+	nodes := r.nodeStatusCache.getNodesStatus(CRInstance)
+	status.Nodes = nodes
+	status.Phase = getPhase(status.Sentry, status.Validator, areStatefulSetsReady, isRolloutComplete, isAnyNodeSyncing(nodes))
+
+	if areStatefulSetsReady {
+		setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionStatefulSetsReady, metav1.ConditionTrue, "AllReplicasReady", "All the StatefulSet replicas are ready")
+	} else {
+		setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionStatefulSetsReady, metav1.ConditionFalse, "ReplicasNotReady", "Not all the StatefulSet replicas are ready")
+	}
+
+	areServicesFound, err := r.areServicesFound(CRInstance)
+	if err != nil {
+		logger.Error(err, "Error on fetch the Services...")
+		return err
+	}
+	if areServicesFound {
+		setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionServicesReady, metav1.ConditionTrue, "ServicesCreated", "All the Services are created")
+	} else {
+		setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionServicesReady, metav1.ConditionFalse, "ServicesNotFound", "Not all the Services are created")
+	}
+
+	if isNetworkPolicyRequired(CRInstance) {
+		isNotFound, err := r.fetchResource(&v1.NetworkPolicy{}, types.NamespacedName{Name: GetValidatorNetworkPolicyName(CRInstance.Name), Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the Network Policy...")
+			return err
+		}
+		if isNotFound == true {
+			setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionNetworkPolicyApplied, metav1.ConditionFalse, "NetworkPolicyNotFound", "The validator Network Policy is not created")
+		} else {
+			setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionNetworkPolicyApplied, metav1.ConditionTrue, "NetworkPolicyCreated", "The validator Network Policy is created")
+		}
+	} else {
+		setCondition(status, CRInstance.Generation, polkadotv1alpha1.ConditionNetworkPolicyApplied, metav1.ConditionFalse, "NotRequired", "Secure communication is disabled or not supported by the kind")
+	}
+
+	if reflect.DeepEqual(&CRInstance.Status, status) {
+		return nil
+	}
+	logger.Info("Updating the Polkadot status...", "Phase", status.Phase)
+	CRInstance.Status = *status
+	err = r.updateResourceStatus(CRInstance)
+	if err != nil {
+		logger.Error(err, "Update Polkadot status Error...")
+		return err
+	}
+	return nil
+}
+
+func (r *ReconcilerPolkadot) fetchStatefulSetStatus(CRInstance *polkadotv1alpha1.Polkadot, name string, isDeployed bool) (*appsv1.StatefulSet, error) {
+	if !isDeployed {
+		return nil, nil
+	}
+	toBeFoundResource := &appsv1.StatefulSet{}
+	isNotFound, err := r.fetchResource(toBeFoundResource, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+	if err != nil || isNotFound == true {
+		return nil, err
+	}
+	return toBeFoundResource, nil
+}
+
+// fetchPeerIDStatus returns an empty peer ID if the node key is not available yet, e.g. the referenced Secret is not created
+func (r *ReconcilerPolkadot) fetchPeerIDStatus(CRInstance *polkadotv1alpha1.Polkadot, nodeKey string, nodeKeySecret *corev1.SecretKeySelector) string {
+	peerID, err := r.fetchPeerID(CRInstance, nodeKey, nodeKeySecret)
+	if err != nil {
+		log.Info("Unable to derive the peer ID from the node key", "Polkadot.Name", CRInstance.Name, "Reason", err.Error())
+		return ""
+	}
+	return peerID
+}
+
+func (r *ReconcilerPolkadot) areServicesFound(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	var names []string
+	if isSentryDeployed(CRInstance) {
+		names = append(names, GetSentryServiceName(CRInstance.Name), GetSentryRPCServiceName(CRInstance.Name), GetSentryHeadlessServiceName(CRInstance.Name))
+	}
+	if isValidatorDeployed(CRInstance) {
+		names = append(names, GetValidatorServiceName(CRInstance.Name), GetValidatorRPCServiceName(CRInstance.Name), GetValidatorHeadlessServiceName(CRInstance.Name))
+	}
+	if len(names) == 0 {
+		return false, nil
+	}
+	for _, name := range names {
+		isNotFound, err := r.fetchResource(&corev1.Service{}, types.NamespacedName{Name: name, Namespace: CRInstance.Namespace})
+		if err != nil || isNotFound == true {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func isSentryDeployed(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	kind := CRKind(CRInstance.Spec.Kind)
+	return kind == Sentry || kind == SentryAndValidator
+}
+
+func isValidatorDeployed(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	kind := CRKind(CRInstance.Spec.Kind)
+	return kind == Validator || kind == SentryAndValidator
+}
+
+func isNetworkPolicyRequired(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	return CRInstance.Spec.SecureCommunicationSupport.Enabled == true && CRKind(CRInstance.Spec.Kind) == SentryAndValidator
+}
+
+func getSentryDesiredReplicas(CRInstance *polkadotv1alpha1.Polkadot) int32 {
+	if !isSentryDeployed(CRInstance) {
+		return 0
+	}
+	return CRInstance.Spec.Sentry.Replicas
+}
+
+func getValidatorDesiredReplicas(CRInstance *polkadotv1alpha1.Polkadot) int32 {
+	if !isValidatorDeployed(CRInstance) {
+		return 0
+	}
+	return 1
+}
+
+func getNodeSetStatus(statefulSet *appsv1.StatefulSet, desiredReplicas int32) polkadotv1alpha1.NodeSetStatus {
+	nodeSetStatus := polkadotv1alpha1.NodeSetStatus{Replicas: desiredReplicas}
+	if statefulSet != nil {
+		nodeSetStatus.ReadyReplicas = statefulSet.Status.ReadyReplicas
+	}
+	return nodeSetStatus
+}
+
+func areNodeSetsReady(nodeSets ...polkadotv1alpha1.NodeSetStatus) bool {
+	desired := int32(0)
+	for _, nodeSet := range nodeSets {
+		if nodeSet.ReadyReplicas < nodeSet.Replicas {
+			return false
+		}
+		desired += nodeSet.Replicas
+	}
+	return desired > 0
+}
+
+func areStatefulSetsFound(statefulSets []*appsv1.StatefulSet) bool {
+	found := 0
+	for _, statefulSet := range statefulSets {
+		if statefulSet != nil {
+			found++
+		}
+	}
+	return found > 0
+}
+
+func areStatefulSetsRolledOut(statefulSets []*appsv1.StatefulSet) bool {
+	for _, statefulSet := range statefulSets {
+		if statefulSet == nil {
+			continue
+		}
+		if statefulSet.Status.ObservedGeneration < statefulSet.Generation {
+			return false
+		}
+		if statefulSet.Spec.Replicas != nil && statefulSet.Status.UpdatedReplicas < *statefulSet.Spec.Replicas {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchImageDigestStatus returns the digest of the client image shared by all the started pods, or the previously observed one.
+// The container runtime resolves the tag to a digest when it pulls the image
+func (r *ReconcilerPolkadot) fetchImageDigestStatus(CRInstance *polkadotv1alpha1.Polkadot, previousDigest string) (string, error) {
+	podList := &corev1.PodList{}
+	err := r.client.List(context.TODO(), podList, client.InNamespace(CRInstance.Namespace), client.MatchingLabels(getAppLabels(CRInstance.Name)))
+	if err != nil {
+		return previousDigest, err
+	}
+	digest := ""
+	for _, pod := range podList.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Name != serviceName {
+				continue
+			}
+			podDigest := getImageDigest(containerStatus.ImageID)
+			if podDigest == "" {
+				continue
+			}
+			if digest != "" && podDigest != digest {
+				return previousDigest, nil
+			}
+			digest = podDigest
+		}
+	}
+	if digest == "" {
+		return previousDigest, nil
+	}
+	return digest, nil
+}
+
+// getImageDigest returns the digest of an image ID reported by the kubelet, e.g. docker-pullable://parity/polkadot@sha256:...
+// An image ID without repository digest, e.g. a locally built image, has none
+func getImageDigest(imageID string) string {
+	i := strings.LastIndex(imageID, "@")
+	if i < 0 {
+		return ""
+	}
+	return imageID[i+1:]
+}
+
+// getObservedClientVersion returns the version shared by all the rolled out StatefulSets, or the previously observed one
+func getObservedClientVersion(statefulSets []*appsv1.StatefulSet, previousVersion string) string {
+	version := ""
+	for _, statefulSet := range statefulSets {
+		if statefulSet == nil {
+			continue
+		}
+		if version != "" && statefulSet.Labels["version"] != version {
+			return previousVersion
+		}
+		version = statefulSet.Labels["version"]
+	}
+	if version == "" {
+		return previousVersion
+	}
+	return version
+}
+
+func getPhase(sentry, validator polkadotv1alpha1.NodeSetStatus, areStatefulSetsReady, isRolloutComplete, isSyncing bool) polkadotv1alpha1.PolkadotPhase {
+	ready := sentry.ReadyReplicas + validator.ReadyReplicas
+	if ready == 0 {
+		return polkadotv1alpha1.PhasePending
+	}
+	if !areStatefulSetsReady {
+		return polkadotv1alpha1.PhaseDegraded
+	}
+	if !isRolloutComplete || isSyncing {
+		return polkadotv1alpha1.PhaseSyncing
+	}
+	return polkadotv1alpha1.PhaseRunning
+}
+
+// setCondition adds or updates a condition, the transition time changes only when the condition status changes
+func setCondition(status *polkadotv1alpha1.PolkadotStatus, generation int64, conditionType polkadotv1alpha1.PolkadotConditionType, conditionStatus metav1.ConditionStatus, reason, message string) {
+	newCondition := polkadotv1alpha1.PolkadotCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		ObservedGeneration: generation,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	for i, condition := range status.Conditions {
+		if condition.Type != conditionType {
+			continue
+		}
+		if condition.Status == conditionStatus {
+			newCondition.LastTransitionTime = condition.LastTransitionTime
+		}
+		status.Conditions[i] = newCondition
+		return
+	}
+	status.Conditions = append(status.Conditions, newCondition)
+}
+
+// fetchPodPeerIDsStatus returns the peer IDs of the pods, nil if the node keys are not available yet
+func (r *ReconcilerPolkadot) fetchPodPeerIDsStatus(CRInstance *polkadotv1alpha1.Polkadot, secretName string, podNames []string) map[string]string {
+	peerIDs, err := r.fetchPeerIDs(CRInstance, secretName, podNames)
+	if err != nil {
+		log.Info("Unable to derive the peer IDs from the node keys", "Polkadot.Name", CRInstance.Name, "Reason", err.Error())
+		return nil
+	}
+	podPeerIDs := make(map[string]string)
+	for i, podName := range podNames {
+		podPeerIDs[podName] = peerIDs[i]
+	}
+	return podPeerIDs
+}
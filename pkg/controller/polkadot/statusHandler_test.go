@@ -0,0 +1,183 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	v12 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func TestHandleStatus(t *testing.T) {
+
+	tests := []struct {
+		name                      string
+		objs                      []runtime.Object
+		expectedPhase             polkadotv1alpha1.PolkadotPhase
+		expectedReadyReplicas     int32
+		expectedStatefulSetsReady metav1.ConditionStatus
+	}{
+		{
+			name:                      "Polkadot pending",
+			objs:                      []runtime.Object{},
+			expectedPhase:             polkadotv1alpha1.PhasePending,
+			expectedReadyReplicas:     0,
+			expectedStatefulSetsReady: metav1.ConditionFalse,
+		},
+		{
+			name:                      "Polkadot degraded",
+			objs:                      []runtime.Object{getFakeStatefulSetWithStatus(GetSentryStatefulSetName(CRName), 2, 1, 2)},
+			expectedPhase:             polkadotv1alpha1.PhaseDegraded,
+			expectedReadyReplicas:     1,
+			expectedStatefulSetsReady: metav1.ConditionFalse,
+		},
+		{
+			name:                      "Polkadot syncing",
+			objs:                      []runtime.Object{getFakeStatefulSetWithStatus(GetSentryStatefulSetName(CRName), 2, 2, 1)},
+			expectedPhase:             polkadotv1alpha1.PhaseSyncing,
+			expectedReadyReplicas:     2,
+			expectedStatefulSetsReady: metav1.ConditionTrue,
+		},
+		{
+			name:                      "Polkadot running",
+			objs:                      []runtime.Object{getFakeStatefulSetWithStatus(GetSentryStatefulSetName(CRName), 2, 2, 2)},
+			expectedPhase:             polkadotv1alpha1.PhaseRunning,
+			expectedReadyReplicas:     2,
+			expectedStatefulSetsReady: metav1.ConditionTrue,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := v12.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A Polkadot object with metadata and spec.
+			polkadot := getFakePolkadot()
+			polkadot.Spec.Kind = string(Sentry)
+			polkadot.Spec.Sentry.Replicas = 2
+
+			// Objects to track in the fake client.
+			objs := append([]runtime.Object{polkadot}, test.objs...)
+
+			// Create a fake client to mock API calls.
+			client := fake.NewFakeClientWithScheme(scheme, objs...)
+			reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+			err := reconciler.handleStatus(polkadot)
+			if err != nil {
+				t.Fatalf("handleStatus: (%v)", err)
+			}
+
+			found := &polkadotv1alpha1.Polkadot{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: CRName}, found)
+			if err != nil {
+				t.Fatalf("handleStatus: (%v)", err)
+			}
+			if found.Status.Phase != test.expectedPhase {
+				t.Fatalf("the phase doesn't match the expected result: (%v) (%v)", found.Status.Phase, test.expectedPhase)
+			}
+			if found.Status.Sentry.ReadyReplicas != test.expectedReadyReplicas || found.Status.Sentry.Replicas != 2 {
+				t.Fatalf("the sentry status doesn't match the expected result: (%v)", found.Status.Sentry)
+			}
+			if getCondition(found.Status, polkadotv1alpha1.ConditionStatefulSetsReady).Status != test.expectedStatefulSetsReady {
+				t.Fatalf("the StatefulSetsReady condition doesn't match the expected result: (%v)", found.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestFetchImageDigestStatus(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot()
+	polkadot.Spec.Kind = string(Sentry)
+	digest := "sha256:" + strings.Repeat("a", 64)
+	started := getFakePod(GetSentryStatefulSetName(CRName)+"-0", getSentrylabels(CRName), corev1.PodRunning)
+	started.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: serviceName, ImageID: "docker-pullable://parity/polkadot@" + digest}}
+	pending := getFakePod(GetSentryStatefulSetName(CRName)+"-1", getSentrylabels(CRName), corev1.PodPending)
+
+	client := fake.NewFakeClientWithScheme(scheme, polkadot, started, pending)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme}
+
+	// the pods not started yet are ignored
+	imageDigest, err := reconciler.fetchImageDigestStatus(polkadot, "")
+	if err != nil || imageDigest != digest {
+		t.Fatalf("fetchImageDigestStatus: unexpected digest (%v) (%v)", imageDigest, err)
+	}
+
+	// the previous digest is kept while the pods run different images
+	pending.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: serviceName, ImageID: "docker-pullable://parity/polkadot@sha256:" + strings.Repeat("b", 64)}}
+	if err := client.Update(context.TODO(), pending); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	imageDigest, err = reconciler.fetchImageDigestStatus(polkadot, "previous")
+	if err != nil || imageDigest != "previous" {
+		t.Fatalf("fetchImageDigestStatus: unexpected digest (%v) (%v)", imageDigest, err)
+	}
+
+	if getImageDigest("sha256:"+strings.Repeat("c", 64)) != "" {
+		t.Fatalf("getImageDigest: an image ID without repository digest has no digest")
+	}
+}
+
+func TestSetCondition(t *testing.T) {
+	status := &polkadotv1alpha1.PolkadotStatus{}
+
+	setCondition(status, 1, polkadotv1alpha1.ConditionServicesReady, metav1.ConditionFalse, "ServicesNotFound", "")
+	firstTransition := getCondition(*status, polkadotv1alpha1.ConditionServicesReady).LastTransitionTime
+
+	setCondition(status, 2, polkadotv1alpha1.ConditionServicesReady, metav1.ConditionFalse, "ServicesNotFound", "")
+	condition := getCondition(*status, polkadotv1alpha1.ConditionServicesReady)
+	if len(status.Conditions) != 1 || condition.ObservedGeneration != 2 || condition.LastTransitionTime != firstTransition {
+		t.Fatalf("the unchanged condition was not kept: (%v)", status.Conditions)
+	}
+
+	setCondition(status, 3, polkadotv1alpha1.ConditionServicesReady, metav1.ConditionTrue, "ServicesCreated", "")
+	if len(status.Conditions) != 1 || getCondition(*status, polkadotv1alpha1.ConditionServicesReady).Status != metav1.ConditionTrue {
+		t.Fatalf("the condition was not updated: (%v)", status.Conditions)
+	}
+}
+
+func getCondition(status polkadotv1alpha1.PolkadotStatus, conditionType polkadotv1alpha1.PolkadotConditionType) polkadotv1alpha1.PolkadotCondition {
+	for _, condition := range status.Conditions {
+		if condition.Type == conditionType {
+			return condition
+		}
+	}
+	return polkadotv1alpha1.PolkadotCondition{}
+}
+
+func getFakeStatefulSetWithStatus(name string, replicas, readyReplicas, updatedReplicas int32) *v12.StatefulSet {
+	statefulSet := getFakeStatefulSet(name, replicas)
+	statefulSet.Status = v12.StatefulSetStatus{
+		Replicas:        replicas,
+		ReadyReplicas:   readyReplicas,
+		UpdatedReplicas: updatedReplicas,
+	}
+	return statefulSet
+}
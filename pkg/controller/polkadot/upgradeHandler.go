@@ -0,0 +1,408 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"fmt"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"strings"
+	"time"
+)
+
+func (r *ReconcilerPolkadot) handleUpgrade(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerUpgrade(CRInstance)
+	return handler.handleUpgradeSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerUpgrade(CRInstance *polkadotv1alpha1.Polkadot) IHandlerUpgrade {
+	if CRInstance.Spec.Upgrade == nil {
+		return &handlerUpgradeDefault{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == Validator {
+		return &handlerUpgradeValidator{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == Sentry {
+		return &handlerUpgradeSentry{}
+	}
+	if CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerUpgradeSentryAndValidator{}
+	}
+	return &handlerUpgradeDefault{}
+}
+
+//pattern Strategy
+type IHandlerUpgrade interface {
+	handleUpgradeSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerUpgradeValidator struct {
+}
+
+func (h *handlerUpgradeValidator) handleUpgradeSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return r.handleUpgradeGeneric(CRInstance, GetValidatorStatefulSetName(CRInstance.Name))
+}
+
+type handlerUpgradeSentry struct {
+}
+
+func (h *handlerUpgradeSentry) handleUpgradeSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return r.handleUpgradeGeneric(CRInstance, GetSentryStatefulSetName(CRInstance.Name))
+}
+
+type handlerUpgradeSentryAndValidator struct {
+}
+
+func (h *handlerUpgradeSentryAndValidator) handleUpgradeSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	// the sentries are upgraded first, their StatefulSet runs the client image before the upgrade
+	return r.handleUpgradeGeneric(CRInstance, GetSentryStatefulSetName(CRInstance.Name))
+}
+
+type handlerUpgradeDefault struct {
+}
+
+func (h *handlerUpgradeDefault) handleUpgradeSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return handleSkip()
+}
+
+// handleUpgradeGeneric starts a staged upgrade when the client image of the CR differs from the one of the live StatefulSet
+// upgraded first, then it moves the upgrade forward one pod at a time or rolls it back
+func (r *ReconcilerPolkadot) handleUpgradeGeneric(CRInstance *polkadotv1alpha1.Polkadot, statefulSetName string) (bool, error) {
+
+	logger := log.WithValues("StatefulSet.Namespace", CRInstance.Namespace, "StatefulSet.Name", statefulSetName)
+	toImage := getImageReference(getClientImage(CRInstance))
+	upgrade := CRInstance.Status.Upgrade
+
+	if upgrade != nil && upgrade.ToImage == toImage && upgrade.Phase != polkadotv1alpha1.UpgradePhaseBlocked {
+		if upgrade.Phase == polkadotv1alpha1.UpgradePhaseInProgress {
+			return r.handleUpgradeProgress(CRInstance)
+		}
+		if upgrade.Phase == polkadotv1alpha1.UpgradePhaseRolledBack {
+			return r.handleUpgradeRollback(CRInstance)
+		}
+		return NotForcedRequeue, nil
+	}
+
+	if upgrade != nil && (upgrade.Phase == polkadotv1alpha1.UpgradePhaseInProgress || upgrade.Phase == polkadotv1alpha1.UpgradePhaseBlocked) && upgrade.FromImage == toImage {
+		logger.Info("The staged upgrade is canceled, the client image is set back...", "Image", toImage)
+		canceled := upgrade.DeepCopy()
+		canceled.Phase = polkadotv1alpha1.UpgradePhaseRolledBack
+		canceled.CompletionTime = getUpgradeTime()
+		canceled.Message = "the client image was set back to the one before the upgrade"
+		_, err := r.updateUpgradeStatus(CRInstance, canceled)
+		return ForcedRequeue, err
+	}
+
+	statefulSet := &appsv1.StatefulSet{}
+	isNotFound, err := r.fetchResource(statefulSet, types.NamespacedName{Name: statefulSetName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the StatefulSet...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true {
+		// a new StatefulSet is created with the client image of the CR
+		return NotForcedRequeue, nil
+	}
+	fromImage, fromVersion := getClientContainerImage(statefulSet.Spec.Template.Spec.Containers), statefulSet.Labels["version"]
+	if fromImage == "" || fromImage == toImage {
+		return NotForcedRequeue, nil
+	}
+	if upgrade != nil && upgrade.Phase == polkadotv1alpha1.UpgradePhaseInProgress {
+		// the new upgrade replaces one in progress, part of the pods run the image before both
+		fromImage, fromVersion = upgrade.FromImage, upgrade.FromVersion
+	}
+
+	podNames := getUpgradePodNames(CRInstance)
+	if len(podNames) == 0 {
+		return NotForcedRequeue, nil
+	}
+	if failure := getUpgradeBlockedFailure(CRInstance); failure != "" {
+		if upgrade != nil && upgrade.Phase == polkadotv1alpha1.UpgradePhaseBlocked && upgrade.ToImage == toImage && upgrade.Message == failure {
+			return NotForcedRequeue, nil
+		}
+		logger.Info("The staged upgrade is blocked...", "Reason", failure)
+		_, err = r.updateUpgradeStatus(CRInstance, &polkadotv1alpha1.UpgradeStatus{
+			Phase:       polkadotv1alpha1.UpgradePhaseBlocked,
+			FromImage:   fromImage,
+			FromVersion: fromVersion,
+			ToImage:     toImage,
+			ToVersion:   CRInstance.GetClientImageTag(),
+			TotalPods:   int32(len(podNames)),
+			Message:     failure,
+		})
+		if err != nil {
+			return NotForcedRequeue, err
+		}
+		r.recorder.Event(CRInstance, corev1.EventTypeWarning, upgradeBlockedReason, "Kept the client image "+fromImage+", "+failure)
+		// the StatefulSets keep the client image before the upgrade
+		return ForcedRequeue, nil
+	}
+	logger.Info("Starting a staged upgrade...", "From", fromImage, "To", toImage)
+	now := getUpgradeTime()
+	_, err = r.updateUpgradeStatus(CRInstance, &polkadotv1alpha1.UpgradeStatus{
+		Phase:        polkadotv1alpha1.UpgradePhaseInProgress,
+		FromImage:    fromImage,
+		FromVersion:  fromVersion,
+		ToImage:      toImage,
+		ToVersion:    CRInstance.GetClientImageTag(),
+		PodName:      podNames[0],
+		TotalPods:    int32(len(podNames)),
+		StartTime:    now,
+		PodStartTime: now,
+	})
+	// the StatefulSets are partitioned before the new client image is rolled out
+	return ForcedRequeue, err
+}
+
+// handleUpgradeProgress checks the health gates of the pod being upgraded: once they pass the next pod is upgraded,
+// if they don't pass within the timeout the upgrade is rolled back
+func (r *ReconcilerPolkadot) handleUpgradeProgress(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+
+	upgrade := CRInstance.Status.Upgrade.DeepCopy()
+	podNames := getUpgradePodNames(CRInstance)
+	upgrade.TotalPods = int32(len(podNames))
+	if upgrade.UpgradedPods >= upgrade.TotalPods {
+		// the remaining pods were scaled down
+		return r.completeUpgrade(CRInstance, upgrade)
+	}
+	podName := podNames[upgrade.UpgradedPods]
+	logger := log.WithValues("Pod.Namespace", CRInstance.Namespace, "Pod.Name", podName)
+	if upgrade.PodName != podName {
+		upgrade.PodName = podName
+		upgrade.PodStartTime = getUpgradeTime()
+	}
+
+	isBackupRunning, err := r.isBackupRunning(CRInstance)
+	if err != nil {
+		logger.Error(err, "Error on fetch the backup Jobs...")
+		return NotForcedRequeue, err
+	}
+	if isBackupRunning {
+		// the sentry backed up is stopped on purpose, the timeout starts again once it is restarted
+		upgrade.PodStartTime = getUpgradeTime()
+		upgrade.Message = "waiting for the end of the backup"
+		return r.updateUpgradeStatus(CRInstance, upgrade)
+	}
+
+	failure, err := r.getUpgradeGateFailure(CRInstance, podName, upgrade.ToImage)
+	if err != nil {
+		logger.Error(err, "Error on fetch the upgraded pod...")
+		return NotForcedRequeue, err
+	}
+	if failure == "" {
+		logger.Info("The upgraded pod passed the health gates")
+		upgrade.UpgradedPods++
+		upgrade.Message = ""
+		if upgrade.UpgradedPods >= upgrade.TotalPods {
+			return r.completeUpgrade(CRInstance, upgrade)
+		}
+		upgrade.PodName = podNames[upgrade.UpgradedPods]
+		upgrade.PodStartTime = getUpgradeTime()
+		_, err := r.updateUpgradeStatus(CRInstance, upgrade)
+		// the partition of the StatefulSets is moved to the next pod
+		return ForcedRequeue, err
+	}
+
+	if upgrade.PodStartTime != nil && time.Since(upgrade.PodStartTime.Time) > CRInstance.GetUpgradeTimeout() {
+		logger.Info("The upgraded pod failed the health gates, rolling back the upgrade...", "Reason", failure)
+		upgrade.Phase = polkadotv1alpha1.UpgradePhaseRolledBack
+		upgrade.CompletionTime = getUpgradeTime()
+		upgrade.Message = "the pod " + podName + " failed the health gates: " + failure
+		_, err := r.updateUpgradeStatus(CRInstance, upgrade)
+		if err != nil {
+			return NotForcedRequeue, err
+		}
+		r.recorder.Event(CRInstance, corev1.EventTypeWarning, upgradeRolledBackReason,
+			"Rolled back the client image to "+upgrade.FromImage+", "+upgrade.Message)
+		// the StatefulSets are rolled back to the previous client image
+		return ForcedRequeue, nil
+	}
+
+	upgrade.Message = failure
+	return r.updateUpgradeStatus(CRInstance, upgrade)
+}
+
+func (r *ReconcilerPolkadot) completeUpgrade(CRInstance *polkadotv1alpha1.Polkadot, upgrade *polkadotv1alpha1.UpgradeStatus) (bool, error) {
+	log.Info("Completed the staged upgrade", "Polkadot.Name", CRInstance.Name, "Image", upgrade.ToImage)
+	upgrade.Phase = polkadotv1alpha1.UpgradePhaseCompleted
+	upgrade.PodName = ""
+	upgrade.CompletionTime = getUpgradeTime()
+	_, err := r.updateUpgradeStatus(CRInstance, upgrade)
+	if err != nil {
+		return NotForcedRequeue, err
+	}
+	r.recorder.Event(CRInstance, corev1.EventTypeNormal, upgradeCompletedReason, "Upgraded the client image of all the pods to "+upgrade.ToImage)
+	return ForcedRequeue, nil
+}
+
+// handleUpgradeRollback deletes the upgraded pods that are not ready once their StatefulSet is rolled back: the StatefulSet
+// controller doesn't replace a pod that never became ready
+func (r *ReconcilerPolkadot) handleUpgradeRollback(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	upgrade := CRInstance.Status.Upgrade
+	for _, podName := range getUpgradePodNames(CRInstance) {
+		logger := log.WithValues("Pod.Namespace", CRInstance.Namespace, "Pod.Name", podName)
+		pod := &corev1.Pod{}
+		isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: podName, Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the pod...")
+			return NotForcedRequeue, err
+		}
+		if isNotFound == true || pod.DeletionTimestamp != nil || isPodReady(pod) || getClientContainerImage(pod.Spec.Containers) != upgrade.ToImage {
+			continue
+		}
+		statefulSet := &appsv1.StatefulSet{}
+		isNotFound, err = r.fetchResource(statefulSet, types.NamespacedName{Name: podName[:strings.LastIndex(podName, "-")], Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the StatefulSet...")
+			return NotForcedRequeue, err
+		}
+		if isNotFound == true || getClientContainerImage(statefulSet.Spec.Template.Spec.Containers) != upgrade.FromImage {
+			continue
+		}
+		logger.Info("Deleting the pod stuck on the client image rolled back...")
+		err = r.deleteResource(pod)
+		if err != nil {
+			logger.Error(err, "Error on deleting the pod...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the pod")
+		return ForcedRequeue, nil
+	}
+	return NotForcedRequeue, nil
+}
+
+// getUpgradeGateFailure returns why the upgraded pod is not healthy yet, an empty string once it runs the new client image,
+// it is ready and, if its RPC is external, it has peers and it is at the chain head
+func (r *ReconcilerPolkadot) getUpgradeGateFailure(CRInstance *polkadotv1alpha1.Polkadot, podName string, image string) (string, error) {
+	pod := &corev1.Pod{}
+	isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: podName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		return "", err
+	}
+	if isNotFound == true || pod.DeletionTimestamp != nil || getClientContainerImage(pod.Spec.Containers) != image {
+		return "the pod is not upgraded yet", nil
+	}
+	return r.getNodeHealthFailure(CRInstance, pod), nil
+}
+
+// getNodeHealthFailure returns why the node of the pod is not healthy: not ready, not peered or behind the chain head.
+// The chain synchronization is checked with the last polled status, a node that can't be queried is never healthy
+func (r *ReconcilerPolkadot) getNodeHealthFailure(CRInstance *polkadotv1alpha1.Polkadot, pod *corev1.Pod) string {
+	if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" || !isPodReady(pod) {
+		return "the pod is not ready"
+	}
+	node := r.nodeStatusCache.getPodNodeStatus(CRInstance, *pod)
+	if node.Error != "" {
+		return node.Error
+	}
+	if node.Peers == 0 {
+		return "the node has no peers"
+	}
+	if node.IsSyncing || node.HighestBlock-node.CurrentBlock > CRInstance.GetUpgradeMaxBlocksBehind() {
+		return fmt.Sprintf("the node is syncing, block %d of %d", node.CurrentBlock, node.HighestBlock)
+	}
+	return ""
+}
+
+// getUpgradeBlockedFailure returns why the health gates can't check the chain synchronization of the pods to upgrade,
+// an empty string if they can
+func getUpgradeBlockedFailure(CRInstance *polkadotv1alpha1.Polkadot) string {
+	if isSentryDeployed(CRInstance) && !isNodeQueryable(CRInstance, CRInstance.GetSentryRPC()) {
+		return "the chain synchronization of the sentries can't be checked, " + nodeNotQueryableHint
+	}
+	if isValidatorDeployed(CRInstance) && !isNodeQueryable(CRInstance, CRInstance.GetValidatorRPC()) {
+		return "the chain synchronization of the validator can't be checked, " + nodeNotQueryableHint
+	}
+	return ""
+}
+
+func (r *ReconcilerPolkadot) updateUpgradeStatus(CRInstance *polkadotv1alpha1.Polkadot, upgrade *polkadotv1alpha1.UpgradeStatus) (bool, error) {
+	if reflect.DeepEqual(CRInstance.Status.Upgrade, upgrade) {
+		return NotForcedRequeue, nil
+	}
+	CRInstance.Status.Upgrade = upgrade
+	err := r.updateResourceStatus(CRInstance)
+	if err != nil {
+		log.Error(err, "Update Polkadot status Error...", "Polkadot.Name", CRInstance.Name)
+		return NotForcedRequeue, err
+	}
+	return NotForcedRequeue, nil
+}
+
+// getUpgradePodNames returns the pods in the order of a staged upgrade: the sentries from the highest ordinal,
+// as the StatefulSet controller does, then the validator standby and the active validator last
+func getUpgradePodNames(CRInstance *polkadotv1alpha1.Polkadot) []string {
+	var names []string
+	if isSentryDeployed(CRInstance) {
+		sentryPodNames := getSentryPodNames(CRInstance)
+		for i := len(sentryPodNames) - 1; i >= 0; i-- {
+			names = append(names, sentryPodNames[i])
+		}
+	}
+	if isValidatorStandbyEnabled(CRInstance) {
+		standby := getOtherValidatorNode(CRInstance.GetActiveValidatorNode())
+		names = append(names, getValidatorNodeStatefulSetName(CRInstance.Name, standby)+"-0")
+	}
+	if isValidatorDeployed(CRInstance) {
+		names = append(names, getValidatorNodeStatefulSetName(CRInstance.Name, CRInstance.GetActiveValidatorNode())+"-0")
+	}
+	return names
+}
+
+// getUpgradePartition returns the partition of the StatefulSet during a staged upgrade, so that only the pods upgraded
+// and the pod being upgraded run the new client image. It is 0 otherwise
+func getUpgradePartition(CRInstance *polkadotv1alpha1.Polkadot, statefulSetName string, replicas int32) int32 {
+	upgrade := CRInstance.Status.Upgrade
+	if CRInstance.Spec.Upgrade == nil || upgrade == nil || upgrade.Phase != polkadotv1alpha1.UpgradePhaseInProgress {
+		return 0
+	}
+	released := int32(0)
+	for i, podName := range getUpgradePodNames(CRInstance) {
+		if int32(i) > upgrade.UpgradedPods {
+			break
+		}
+		// the pods are named after the StatefulSet and their ordinal, the validator StatefulSet name prefixes the secondary one
+		if podName[:strings.LastIndex(podName, "-")] == statefulSetName {
+			released++
+		}
+	}
+	if released >= replicas {
+		return 0
+	}
+	return replicas - released
+}
+
+// getDesiredClientImage returns the reference and the version of the client image of the CR, or the ones before
+// the upgrade to it if it was rolled back or blocked
+func getDesiredClientImage(CRInstance *polkadotv1alpha1.Polkadot) (string, string) {
+	reference := getImageReference(getClientImage(CRInstance))
+	upgrade := CRInstance.Status.Upgrade
+	if CRInstance.Spec.Upgrade != nil && upgrade != nil && (upgrade.Phase == polkadotv1alpha1.UpgradePhaseRolledBack || upgrade.Phase == polkadotv1alpha1.UpgradePhaseBlocked) && upgrade.ToImage == reference {
+		return upgrade.FromImage, upgrade.FromVersion
+	}
+	return reference, CRInstance.GetClientImageTag()
+}
+
+func getClientContainerImage(containers []corev1.Container) string {
+	for _, container := range containers {
+		if container.Name == serviceName {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// isUpgradeInProgress tells if the health gates of an upgraded pod have to be polled
+func isUpgradeInProgress(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	return CRInstance.Spec.Upgrade != nil && CRInstance.Status.Upgrade != nil && CRInstance.Status.Upgrade.Phase == polkadotv1alpha1.UpgradePhaseInProgress
+}
+
+func getUpgradeTime() *metav1.Time {
+	now := metav1.Now()
+	return &now
+}
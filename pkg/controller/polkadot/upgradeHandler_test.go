@@ -0,0 +1,278 @@
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getFakeUpgradedPod(name string, labels map[string]string, podSpec corev1.PodSpec, isReady bool) *corev1.Pod {
+	pod := getFakePod(name, labels, corev1.PodRunning)
+	pod.Spec = podSpec
+	if isReady {
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
+
+func TestHandleUpgrade(t *testing.T) {
+
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+	polkadot.Spec.Sentry.Replicas = 2
+	polkadot.Spec.ClientVersion = "v0.8.0"
+	polkadot.Spec.Upgrade = &polkadotv1alpha1.UpgradeSpec{TimeoutSeconds: 600, MaxBlocksBehind: 5}
+	// the validator RPC is local only, its chain synchronization is read from the metrics exporter
+	polkadot.Spec.MetricsSupport.Enabled = true
+	fromImage := getImageReference(getClientImage(polkadot))
+	liveStatefulSet := newStatefulSetSentry(polkadot, nil)
+	reconciler, client, recorder := getFakeReconciler(t, polkadot, liveStatefulSet)
+	sentryName := GetSentryStatefulSetName(CRName)
+	validatorName := GetValidatorStatefulSetName(CRName)
+
+	// a new client version starts an upgrade from the image of the live StatefulSet
+	polkadot.Spec.ClientVersion = "v0.8.1"
+	isRequeueForced, err := reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	upgrade := polkadot.Status.Upgrade
+	if upgrade == nil || upgrade.Phase != polkadotv1alpha1.UpgradePhaseInProgress || upgrade.FromImage != fromImage || upgrade.FromVersion != "v0.8.0" ||
+		upgrade.ToVersion != "v0.8.1" || upgrade.PodName != sentryName+"-1" || upgrade.TotalPods != 3 {
+		t.Fatalf("handleUpgrade: unexpected status (%v)", upgrade)
+	}
+	if getUpgradePartition(polkadot, sentryName, 2) != 1 || getUpgradePartition(polkadot, validatorName, 1) != 1 {
+		t.Fatalf("getUpgradePartition: only the sentry with the highest ordinal must be upgraded")
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: sentryName}, statefulSet)
+	statefulSet.Spec = newStatefulSetSentry(polkadot, nil).Spec
+	if err := client.Update(context.TODO(), statefulSet); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+
+	// the upgrade waits for the upgraded pod
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	if polkadot.Status.Upgrade.UpgradedPods != 0 || polkadot.Status.Upgrade.Message == "" {
+		t.Fatalf("handleUpgrade: the missing pod must fail the health gates (%v)", polkadot.Status.Upgrade)
+	}
+
+	// a pod syncing the chain fails the health gates
+	server := fake.NewServer(map[string]interface{}{
+		"system_health":    map[string]interface{}{"peers": 3, "isSyncing": true, "shouldHavePeers": true},
+		"system_syncState": map[string]interface{}{"startingBlock": 0, "currentBlock": 100, "highestBlock": 200},
+	})
+	defer setFakeRPCPort(t, server.URL)()
+	upgradedPodSpec := newStatefulSetSentry(polkadot, nil).Spec.Template.Spec
+	if err := client.Create(context.TODO(), getFakeUpgradedPod(sentryName+"-1", getSentrylabels(CRName), upgradedPodSpec, true)); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	server.Close()
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	if polkadot.Status.Upgrade.UpgradedPods != 0 || !strings.Contains(polkadot.Status.Upgrade.Message, "syncing") {
+		t.Fatalf("handleUpgrade: the syncing pod must fail the health gates (%v)", polkadot.Status.Upgrade)
+	}
+
+	// a pod peered at the chain head passes the health gates, the next sentry is upgraded
+	server = fake.NewServer(map[string]interface{}{
+		"system_health":    map[string]interface{}{"peers": 3, "isSyncing": false, "shouldHavePeers": true},
+		"system_syncState": map[string]interface{}{"startingBlock": 0, "currentBlock": 198, "highestBlock": 200},
+	})
+	defer server.Close()
+	defer setFakeRPCPort(t, server.URL)()
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	if polkadot.Status.Upgrade.UpgradedPods != 1 || polkadot.Status.Upgrade.PodName != sentryName+"-0" {
+		t.Fatalf("handleUpgrade: the upgrade did not move to the next pod (%v)", polkadot.Status.Upgrade)
+	}
+	if getUpgradePartition(polkadot, sentryName, 2) != 0 || getUpgradePartition(polkadot, validatorName, 1) != 1 {
+		t.Fatalf("getUpgradePartition: the validator must be upgraded after the sentries")
+	}
+
+	// the upgrade is rolled back once the pod fails the health gates for longer than the timeout
+	podStartTime := metav1.NewTime(time.Now().Add(-time.Hour))
+	polkadot.Status.Upgrade.PodStartTime = &podStartTime
+	if err := client.Create(context.TODO(), getFakeUpgradedPod(sentryName+"-0", getSentrylabels(CRName), upgradedPodSpec, false)); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	if polkadot.Status.Upgrade.Phase != polkadotv1alpha1.UpgradePhaseRolledBack || polkadot.Status.Upgrade.CompletionTime == nil {
+		t.Fatalf("handleUpgrade: the upgrade was not rolled back (%v)", polkadot.Status.Upgrade)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, upgradeRolledBackReason) || !strings.Contains(event, fromImage) {
+		t.Fatalf("handleUpgrade: unexpected event (%v)", event)
+	}
+	if image, _ := getDesiredClientImage(polkadot); image != fromImage || getUpgradePartition(polkadot, sentryName, 2) != 0 {
+		t.Fatalf("getDesiredClientImage: the StatefulSets must be rolled back to %v, found %v", fromImage, image)
+	}
+
+	// the pod stuck on the new image is deleted once its StatefulSet is rolled back
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: sentryName}, statefulSet)
+	statefulSet.Spec = newStatefulSetSentry(polkadot, nil).Spec
+	if err := client.Update(context.TODO(), statefulSet); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: sentryName + "-0"}, &corev1.Pod{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("handleUpgrade: the pod stuck on the new image was not deleted (%v)", err)
+	}
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: sentryName + "-1"}, &corev1.Pod{}); err != nil {
+		t.Fatalf("handleUpgrade: the ready pod must be rolled back by the StatefulSet (%v)", err)
+	}
+}
+
+func TestHandleUpgradeCompleted(t *testing.T) {
+
+	server := fake.NewServer(map[string]interface{}{
+		"system_health":    map[string]interface{}{"peers": 3, "isSyncing": false, "shouldHavePeers": true},
+		"system_syncState": map[string]interface{}{"startingBlock": 0, "currentBlock": 200, "highestBlock": 200},
+	})
+	defer server.Close()
+	defer setFakeRPCPort(t, server.URL)()
+
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.ClientVersion = "v0.8.0"
+	polkadot.Spec.Upgrade = &polkadotv1alpha1.UpgradeSpec{}
+	reconciler, client, recorder := getFakeReconciler(t, polkadot, newStatefulSetSentry(polkadot, nil))
+
+	polkadot.Spec.ClientVersion = "v0.8.1"
+	isRequeueForced, err := reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	if !isUpgradeInProgress(polkadot) || getUpgradePartition(polkadot, GetSentryStatefulSetName(CRName), 1) != 0 {
+		t.Fatalf("handleUpgrade: the upgrade of the only sentry was not started (%v)", polkadot.Status.Upgrade)
+	}
+
+	pod := getFakeUpgradedPod(GetSentryStatefulSetName(CRName)+"-0", getSentrylabels(CRName), newStatefulSetSentry(polkadot, nil).Spec.Template.Spec, true)
+	if err := client.Create(context.TODO(), pod); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	upgrade := polkadot.Status.Upgrade
+	if upgrade.Phase != polkadotv1alpha1.UpgradePhaseCompleted || upgrade.UpgradedPods != 1 || upgrade.CompletionTime == nil || isUpgradeInProgress(polkadot) {
+		t.Fatalf("handleUpgrade: the upgrade was not completed (%v)", upgrade)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, upgradeCompletedReason) {
+		t.Fatalf("handleUpgrade: unexpected event (%v)", event)
+	}
+
+	// the completed upgrade is not started again
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if isRequeueForced || err != nil || polkadot.Status.Upgrade.Phase != polkadotv1alpha1.UpgradePhaseCompleted {
+		t.Fatalf("handleUpgrade: (%v) (%v)", err, polkadot.Status.Upgrade)
+	}
+}
+
+func TestHandleUpgradeBlocked(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator())
+	polkadot.Spec.ClientVersion = "v0.8.0"
+	polkadot.Spec.Upgrade = &polkadotv1alpha1.UpgradeSpec{}
+	fromImage := getImageReference(getClientImage(polkadot))
+	reconciler, client, recorder := getFakeReconciler(t, polkadot, newStatefulSetValidator(polkadot, nil))
+
+	// the RPC of the validator is local only and the metrics support is disabled: the upgrade doesn't start
+	polkadot.Spec.ClientVersion = "v0.8.1"
+	isRequeueForced, err := reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleUpgrade: (%v)", err)
+	}
+	upgrade := polkadot.Status.Upgrade
+	if upgrade == nil || upgrade.Phase != polkadotv1alpha1.UpgradePhaseBlocked || !strings.Contains(upgrade.Message, "validator") || isUpgradeInProgress(polkadot) {
+		t.Fatalf("handleUpgrade: the upgrade was not blocked (%v)", upgrade)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, upgradeBlockedReason) {
+		t.Fatalf("handleUpgrade: unexpected event (%v)", event)
+	}
+	if image, _ := getDesiredClientImage(polkadot); image != fromImage {
+		t.Fatalf("getDesiredClientImage: the StatefulSets must keep %v, found %v", fromImage, image)
+	}
+
+	// the blocked upgrade is reported once
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if isRequeueForced || err != nil || len(recorder.Events) != 0 {
+		t.Fatalf("handleUpgrade: the blocked upgrade was reported again (%v)", err)
+	}
+
+	// the upgrade starts once the metrics exporter reports the validator
+	polkadot.Spec.MetricsSupport.Enabled = true
+	if err := client.Update(context.TODO(), polkadot); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleUpgrade(polkadot)
+	if !isRequeueForced || err != nil || !isUpgradeInProgress(polkadot) || polkadot.Status.Upgrade.FromImage != fromImage {
+		t.Fatalf("handleUpgrade: the upgrade was not started (%v) (%v)", err, polkadot.Status.Upgrade)
+	}
+}
+
+func TestGetNodeHealthFailureMetrics(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator())
+	pod := getFakeUpgradedPod(GetValidatorStatefulSetName(CRName)+"-0", getValidatorLabels(CRName), corev1.PodSpec{}, true)
+	reconciler, _, _ := getFakeReconciler(t, polkadot, pod)
+
+	// the RPC of the validator is local only
+	if failure := reconciler.getNodeHealthFailure(polkadot, pod); failure != nodeNotQueryableError {
+		t.Fatalf("getNodeHealthFailure: a node that can't be queried must not be healthy (%v)", failure)
+	}
+
+	polkadot.Spec.MetricsSupport.Enabled = true
+	server := getFakeExporter(3, 1, 100)
+	defer server.Close()
+	defer setFakeMetricsPort(t, server.URL)()
+	if failure := reconciler.getNodeHealthFailure(polkadot, pod); !strings.Contains(failure, "syncing") {
+		t.Fatalf("getNodeHealthFailure: the syncing node must not be healthy (%v)", failure)
+	}
+
+	synced := getFakeExporter(3, 0, 200)
+	defer synced.Close()
+	defer setFakeMetricsPort(t, synced.URL)()
+	if failure := reconciler.getNodeHealthFailure(polkadot, pod); failure != "" {
+		t.Fatalf("getNodeHealthFailure: the synced node must be healthy (%v)", failure)
+	}
+}
+
+func TestGetUpgradePartitionWithoutUpgrade(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeSentry())
+	polkadot.Spec.Sentry.Replicas = 3
+	polkadot.Status.Upgrade = &polkadotv1alpha1.UpgradeStatus{Phase: polkadotv1alpha1.UpgradePhaseInProgress}
+
+	// without the upgrade settings the pods are updated at once
+	if partition := getUpgradePartition(polkadot, GetSentryStatefulSetName(CRName), 3); partition != 0 {
+		t.Fatalf("getUpgradePartition: unexpected partition (%v)", partition)
+	}
+	if partition := newStatefulSetSentry(polkadot, nil).Spec.UpdateStrategy.RollingUpdate.Partition; partition == nil || *partition != 0 {
+		t.Fatalf("newStatefulSetSentry: unexpected partition (%v)", partition)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"time"
+)
+
+func (r *ReconcilerPolkadot) handleValidatorLease(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerValidatorLease(CRInstance)
+	return handler.handleValidatorLeaseSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerValidatorLease(CRInstance *polkadotv1alpha1.Polkadot) IHandlerValidatorLease {
+	if isValidatorLeaseStartGated(CRInstance) {
+		return &handlerValidatorLeaseValidator{}
+	}
+	return &handlerValidatorLeaseDefault{}
+}
+
+//pattern Strategy
+type IHandlerValidatorLease interface {
+	handleValidatorLeaseSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerValidatorLeaseValidator struct {
+}
+
+func (h *handlerValidatorLeaseValidator) handleValidatorLeaseSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return r.handleValidatorLeaseGeneric(CRInstance)
+}
+
+type handlerValidatorLeaseDefault struct {
+}
+
+func (h *handlerValidatorLeaseDefault) handleValidatorLeaseSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	// the Lease of a disabled lease start gate is removed
+	return r.handleValidatorLeaseRemoval(CRInstance)
+}
+
+// handleValidatorLeaseGeneric grants the validator Lease to the validator pod and renews it while the pod exists, even terminating.
+// The Lease is renewed by the operator, not by the validator, and the pod checks it only before starting the client: it doesn't
+// fence a client already running. The removal of a pod doesn't confirm the termination of its containers when it is forced,
+// e.g. on a node lost, so the Lease of a pod removed is granted to a new pod only once expired and once its containers are
+// known terminated (see isValidatorLeaseReleased)
+func (r *ReconcilerPolkadot) handleValidatorLeaseGeneric(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+
+	logger := log.WithValues("Lease.Namespace", CRInstance.Namespace, "Lease.Name", GetValidatorLeaseName(CRInstance.Name))
+
+	lease := &coordinationv1.Lease{}
+	isNotFound, err := r.fetchResource(lease, types.NamespacedName{Name: GetValidatorLeaseName(CRInstance.Name), Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the Lease...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true {
+		logger.Info("Creating a new Lease...")
+		err := r.createResource(newLeaseValidator(CRInstance), CRInstance)
+		if err != nil {
+			logger.Error(err, "Error on creating a new Lease...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Created the new Lease")
+		return ForcedRequeue, nil
+	}
+
+	pods, err := r.fetchPods(CRInstance, getValidatorKeyHolderLabels(CRInstance))
+	if err != nil {
+		logger.Error(err, "Error on fetch the validator pods...")
+		return NotForcedRequeue, err
+	}
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	for i := range pods {
+		if holder != "" && string(pods[i].UID) == holder {
+			return r.handleValidatorLeaseRenewal(lease, &pods[i])
+		}
+	}
+
+	if holder != "" && !isLeaseExpired(lease) {
+		logger.Info("Waiting for the expiry of the Lease of the removed validator pod...", "Holder", holder)
+		return NotForcedRequeue, nil
+	}
+	if holder != "" {
+		isReleased, err := r.isValidatorLeaseReleased(CRInstance, lease)
+		if err != nil {
+			logger.Error(err, "Error on fetch the node of the removed validator pod...")
+			return NotForcedRequeue, err
+		}
+		if !isReleased {
+			nodeName := lease.Annotations[validatorLeaseNodeAnnotation]
+			logger.Info("Waiting for the confirmation of the termination of the removed validator pod...", "Holder", holder, "Node", nodeName)
+			r.recorder.Event(CRInstance, corev1.EventTypeWarning, validatorLeaseBlockedReason,
+				"The node "+nodeName+" of the removed validator pod "+holder+" is not ready, its client may still be running: once it is known terminated, "+
+					"annotate the CR with "+polkadotv1alpha1.ReleaseValidatorLeaseAnnotation+"="+holder)
+			return NotForcedRequeue, nil
+		}
+	}
+	if len(pods) != 1 || pods[0].GetDeletionTimestamp() != nil || pods[0].UID == "" || pods[0].Spec.NodeName == "" {
+		// the Lease is never granted while another validator pod may be running, nor to a pod not scheduled yet
+		return NotForcedRequeue, nil
+	}
+
+	pod := &pods[0]
+	logger.Info("Granting the Lease to the validator pod...", "Pod.Name", pod.Name)
+	uid := string(pod.UID)
+	now := metav1.NewMicroTime(time.Now())
+	transitions := int32(0)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions
+	}
+	if holder != "" {
+		transitions++
+	}
+	lease.Annotations = mergeMaps(lease.Annotations, map[string]string{validatorLeaseNodeAnnotation: pod.Spec.NodeName})
+	lease.Spec.HolderIdentity = &uid
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	lease.Spec.LeaseTransitions = &transitions
+	err = r.updateResource(lease)
+	if err != nil {
+		logger.Error(err, "Update Lease Error...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Granted the Lease")
+	return r.handleValidatorLeaseRenewal(lease, pod)
+}
+
+// handleValidatorLeaseRenewal renews the Lease of the holder pod and annotates the pod, so that its client is started
+func (r *ReconcilerPolkadot) handleValidatorLeaseRenewal(lease *coordinationv1.Lease, pod *corev1.Pod) (bool, error) {
+
+	logger := log.WithValues("Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+
+	if lease.Spec.RenewTime == nil || time.Since(lease.Spec.RenewTime.Time) > validatorLeaseRenewInterval {
+		now := metav1.NewMicroTime(time.Now())
+		lease.Spec.RenewTime = &now
+		err := r.updateResource(lease)
+		if err != nil {
+			logger.Error(err, "Update Lease Error...")
+			return NotForcedRequeue, err
+		}
+	}
+
+	if pod.GetDeletionTimestamp() != nil || pod.Annotations[validatorLeaseAnnotation] == string(pod.UID) {
+		return NotForcedRequeue, nil
+	}
+	logger.Info("Annotating the Pod with the holder of the validator Lease...")
+	pod.Annotations = mergeMaps(pod.Annotations, map[string]string{validatorLeaseAnnotation: string(pod.UID)})
+	err := r.updateResource(pod)
+	if err != nil {
+		logger.Error(err, "Update Pod Error...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Annotated the Pod")
+	return NotForcedRequeue, nil
+}
+
+func (r *ReconcilerPolkadot) handleValidatorLeaseRemoval(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+
+	logger := log.WithValues("Lease.Namespace", CRInstance.Namespace, "Lease.Name", GetValidatorLeaseName(CRInstance.Name))
+
+	lease := &coordinationv1.Lease{}
+	isNotFound, err := r.fetchResource(lease, types.NamespacedName{Name: GetValidatorLeaseName(CRInstance.Name), Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the Lease...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true {
+		return NotForcedRequeue, nil
+	}
+	logger.Info("Deleting the Lease...")
+	err = r.deleteResource(lease)
+	if err != nil {
+		logger.Error(err, "Error on deleting the Lease...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Deleted the Lease")
+	return NotForcedRequeue, nil
+}
+
+// isValidatorLeaseReleased tells if the containers of the removed holder pod are known terminated: the kubelet of a ready node
+// kills the containers of the pods removed from the API server, while a node not ready or removed may still run them, e.g. after
+// a forced removal. The termination is then confirmed by an administrator with the release-validator-lease annotation of the CR
+func (r *ReconcilerPolkadot) isValidatorLeaseReleased(CRInstance *polkadotv1alpha1.Polkadot, lease *coordinationv1.Lease) (bool, error) {
+	if lease.Spec.HolderIdentity != nil && CRInstance.Annotations[polkadotv1alpha1.ReleaseValidatorLeaseAnnotation] == *lease.Spec.HolderIdentity {
+		return true, nil
+	}
+	nodeName := lease.Annotations[validatorLeaseNodeAnnotation]
+	if nodeName == "" {
+		return false, nil
+	}
+	node := &corev1.Node{}
+	isNotFound, err := r.fetchResource(node, types.NamespacedName{Name: nodeName})
+	if err != nil || isNotFound == true {
+		return false, err
+	}
+	return isNodeReady(node), nil
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func isLeaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return time.Since(lease.Spec.RenewTime.Time) > time.Duration(*lease.Spec.LeaseDurationSeconds)*time.Second
+}
+
+// isValidatorLeaseStartGated tells if the validator client waits for the validator Lease, that has to be renewed periodically
+func isValidatorLeaseStartGated(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	return isValidatorDeployed(CRInstance) && CRInstance.Spec.Validator.LeaseStartGate.Enabled
+}
@@ -0,0 +1,214 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getFakeNode(name string, status corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}},
+	}
+}
+
+func TestHandleValidatorLease(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Errorf("appsv1.AddToScheme: %v", err)
+	}
+	if err := coordinationv1.AddToScheme(scheme); err != nil {
+		t.Errorf("coordinationv1.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeValidator())
+	polkadot.Spec.Validator.LeaseStartGate.Enabled = true
+	client := clientfake.NewFakeClientWithScheme(scheme, polkadot, getFakeNode("node-a", corev1.ConditionTrue))
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: record.NewFakeRecorder(10)}
+	leaseName := types.NamespacedName{Name: GetValidatorLeaseName(CRName)}
+	podName := types.NamespacedName{Name: GetValidatorStatefulSetName(CRName) + "-0"}
+
+	// the Lease is created without holder
+	isRequeueForced, err := reconciler.handleValidatorLease(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	lease := &coordinationv1.Lease{}
+	if err := client.Get(context.TODO(), leaseName, lease); err != nil {
+		t.Fatalf("handleValidatorLease: the Lease was not created (%v)", err)
+	}
+	if lease.Spec.HolderIdentity != nil || *lease.Spec.LeaseDurationSeconds != int32(validatorLeaseDuration.Seconds()) {
+		t.Fatalf("handleValidatorLease: unexpected Lease (%v)", lease.Spec)
+	}
+
+	// the Lease is granted to the single validator pod, annotated with its UID
+	pod := getFakeValidatorPod(podName.Name, "revision-1")
+	pod.UID = "uid-1"
+	pod.Spec.NodeName = "node-a"
+	if err := client.Create(context.TODO(), pod); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), leaseName, lease)
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "uid-1" || lease.Spec.RenewTime == nil || lease.Annotations[validatorLeaseNodeAnnotation] != "node-a" {
+		t.Fatalf("handleValidatorLease: the Lease was not granted (%v)", lease.Spec)
+	}
+	_ = client.Get(context.TODO(), podName, pod)
+	if pod.Annotations[validatorLeaseAnnotation] != "uid-1" {
+		t.Fatalf("handleValidatorLease: the pod was not annotated (%v)", pod.Annotations)
+	}
+
+	// the Lease of a removed pod is not granted to the new pod before its expiry
+	if err := client.Delete(context.TODO(), pod); err != nil {
+		t.Fatalf("Delete: (%v)", err)
+	}
+	newPod := getFakeValidatorPod(podName.Name, "revision-2")
+	newPod.UID = "uid-2"
+	newPod.Spec.NodeName = "node-a"
+	if err := client.Create(context.TODO(), newPod); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), leaseName, lease)
+	if *lease.Spec.HolderIdentity != "uid-1" {
+		t.Fatalf("handleValidatorLease: the Lease was granted before its expiry (%v)", lease.Spec)
+	}
+
+	// the expired Lease is granted to the new pod, the kubelet of the ready node kills the containers of the removed pod
+	renewTime := metav1.NewMicroTime(time.Now().Add(-2 * validatorLeaseDuration))
+	lease.Spec.RenewTime = &renewTime
+	if err := client.Update(context.TODO(), lease); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), leaseName, lease)
+	if *lease.Spec.HolderIdentity != "uid-2" || *lease.Spec.LeaseTransitions != 1 || isLeaseExpired(lease) {
+		t.Fatalf("handleValidatorLease: the Lease was not granted to the new pod (%v)", lease.Spec)
+	}
+
+	// the Lease is removed with the lease start gate
+	polkadot.Spec.Validator.LeaseStartGate.Enabled = false
+	isRequeueForced, err = reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	err = client.Get(context.TODO(), leaseName, lease)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("handleValidatorLease: the Lease was not deleted (%v)", err)
+	}
+}
+
+func TestHandleValidatorLeaseSeveralPods(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator())
+	polkadot.Spec.Validator.LeaseStartGate.Enabled = true
+	lease := newLeaseValidator(polkadot)
+	pod := getFakeValidatorPod(GetValidatorStatefulSetName(CRName)+"-0", "revision-1")
+	pod.UID = "uid-1"
+	extraPod := getFakeValidatorPod(GetValidatorStatefulSetName(CRName)+"-1", "revision-1")
+	extraPod.UID = "uid-2"
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := coordinationv1.AddToScheme(scheme); err != nil {
+		t.Errorf("coordinationv1.AddToScheme: %v", err)
+	}
+	client := clientfake.NewFakeClientWithScheme(scheme, polkadot, lease, pod, extraPod)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: record.NewFakeRecorder(10)}
+
+	// the Lease is not granted while several validator pods exist
+	isRequeueForced, err := reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: lease.Name}, lease)
+	if lease.Spec.HolderIdentity != nil {
+		t.Fatalf("handleValidatorLease: the Lease was granted (%v)", *lease.Spec.HolderIdentity)
+	}
+}
+
+func TestHandleValidatorLeaseNodeNotReady(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator())
+	polkadot.Spec.Validator.LeaseStartGate.Enabled = true
+	lease := newLeaseValidator(polkadot)
+	holder := "uid-1"
+	renewTime := metav1.NewMicroTime(time.Now().Add(-2 * validatorLeaseDuration))
+	lease.Annotations = map[string]string{validatorLeaseNodeAnnotation: "node-a"}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.RenewTime = &renewTime
+	pod := getFakeValidatorPod(GetValidatorStatefulSetName(CRName)+"-0", "revision-1")
+	pod.UID = "uid-2"
+	pod.Spec.NodeName = "node-b"
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := coordinationv1.AddToScheme(scheme); err != nil {
+		t.Errorf("coordinationv1.AddToScheme: %v", err)
+	}
+	client := clientfake.NewFakeClientWithScheme(scheme, polkadot, lease, pod, getFakeNode("node-a", corev1.ConditionUnknown))
+	recorder := record.NewFakeRecorder(10)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}
+
+	// the expired Lease of a pod removed from a node not ready is not granted again
+	isRequeueForced, err := reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: lease.Name}, lease)
+	if *lease.Spec.HolderIdentity != holder {
+		t.Fatalf("handleValidatorLease: the Lease was granted without confirmation (%v)", *lease.Spec.HolderIdentity)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, validatorLeaseBlockedReason) || !strings.Contains(event, holder) {
+		t.Fatalf("handleValidatorLease: unexpected event (%v)", event)
+	}
+
+	// the Lease is granted once the termination of the holder is confirmed
+	polkadot.Annotations = map[string]string{polkadotv1alpha1.ReleaseValidatorLeaseAnnotation: holder}
+	isRequeueForced, err = reconciler.handleValidatorLease(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorLease: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: lease.Name}, lease)
+	if *lease.Spec.HolderIdentity != "uid-2" || lease.Annotations[validatorLeaseNodeAnnotation] != "node-b" {
+		t.Fatalf("handleValidatorLease: the Lease was not granted to the new pod (%v)", lease.Spec)
+	}
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newLeaseValidator returns the validator Lease without holder, it is granted by the operator to a single validator pod
+func newLeaseValidator(CRInstance *polkadotv1alpha1.Polkadot) *coordinationv1.Lease {
+	duration := int32(validatorLeaseDuration.Seconds())
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetValidatorLeaseName(CRInstance.Name),
+			Namespace: CRInstance.Namespace,
+			Labels:    getValidatorLabels(CRInstance.Name),
+		},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: &duration,
+		},
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"strings"
+)
+
+// validatorScaleWebhookPath is the path of the webhook in deploy/webhook.yaml
+const validatorScaleWebhookPath = "/validate-apps-v1-statefulset-validator-scale"
+
+// SetupValidatorScaleWebhookWithManager registers the validating webhook of the StatefulSets and of their scale subresource,
+// it rejects the scaling of a validator StatefulSet above one replica
+func SetupValidatorScaleWebhookWithManager(mgr manager.Manager) error {
+	mgr.GetWebhookServer().Register(validatorScaleWebhookPath, &webhook.Admission{Handler: &validatorScaleValidator{client: mgr.GetClient()}})
+	return nil
+}
+
+type validatorScaleValidator struct {
+	client client.Client
+}
+
+// Handle rejects more than one replica for the validator StatefulSets of a Polkadot CR, the other StatefulSets are allowed.
+// handleValidatorUpdateGeneric scales a validator StatefulSet back to one replica if the webhook is not called
+func (v *validatorScaleValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	statefulSet := &appsv1.StatefulSet{}
+	replicas := int32(1)
+	switch req.SubResource {
+	case "scale":
+		scale := &autoscalingv1.Scale{}
+		if err := json.Unmarshal(req.Object.Raw, scale); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		replicas = scale.Spec.Replicas
+		if replicas <= 1 {
+			return admission.Allowed("")
+		}
+		err := v.client.Get(ctx, types.NamespacedName{Name: req.Name, Namespace: req.Namespace}, statefulSet)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+	case "":
+		if err := json.Unmarshal(req.Object.Raw, statefulSet); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if statefulSet.Spec.Replicas != nil {
+			replicas = *statefulSet.Spec.Replicas
+		}
+	default:
+		return admission.Allowed("")
+	}
+
+	if replicas <= 1 || !isValidatorStatefulSet(statefulSet) {
+		return admission.Allowed("")
+	}
+	return admission.Denied(fmt.Sprintf("the validator StatefulSet %s can't be scaled to %d replicas, a validator runs a single instance", statefulSet.Name, replicas))
+}
+
+// isValidatorStatefulSet tells if the StatefulSet is a validator StatefulSet of a Polkadot CR, primary or secondary
+func isValidatorStatefulSet(statefulSet *appsv1.StatefulSet) bool {
+	owner := metav1.GetControllerOf(statefulSet)
+	if owner == nil || owner.Kind != "Polkadot" {
+		return false
+	}
+	return strings.HasPrefix(statefulSet.Labels["role"], "validator")
+}
@@ -0,0 +1,81 @@
+package polkadot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"testing"
+)
+
+func getFakeAdmissionRequest(t *testing.T, name string, subResource string, object interface{}) admission.Request {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		t.Fatalf("json.Marshal: (%v)", err)
+	}
+	return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+		Name:        name,
+		Namespace:   corev1.NamespaceAll,
+		SubResource: subResource,
+		Operation:   admissionv1beta1.Update,
+		Object:      runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func TestValidatorScaleWebhook(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Fatalf("apis.AddToScheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("appsv1.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot()
+	validator := newStatefulSetValidator(polkadot, nil)
+	sentry := newStatefulSetSentry(polkadot, nil)
+	for _, statefulSet := range []*appsv1.StatefulSet{validator, sentry} {
+		if err := controllerutil.SetControllerReference(polkadot, statefulSet, scheme); err != nil {
+			t.Fatalf("SetControllerReference: (%v)", err)
+		}
+	}
+	validatorScaleValidator := &validatorScaleValidator{client: clientfake.NewFakeClientWithScheme(scheme, validator)}
+
+	scaled := func(statefulSet *appsv1.StatefulSet, replicas int32) *appsv1.StatefulSet {
+		result := statefulSet.DeepCopy()
+		result.Spec.Replicas = &replicas
+		return result
+	}
+	scale := func(replicas int32) *autoscalingv1.Scale {
+		return &autoscalingv1.Scale{ObjectMeta: metav1.ObjectMeta{Name: validator.Name, Namespace: corev1.NamespaceAll}, Spec: autoscalingv1.ScaleSpec{Replicas: replicas}}
+	}
+
+	tests := []struct {
+		name      string
+		request   admission.Request
+		isAllowed bool
+	}{
+		{"validator with one replica", getFakeAdmissionRequest(t, validator.Name, "", scaled(validator, 1)), true},
+		{"validator scaled to zero", getFakeAdmissionRequest(t, validator.Name, "", scaled(validator, 0)), true},
+		{"validator with two replicas", getFakeAdmissionRequest(t, validator.Name, "", scaled(validator, 2)), false},
+		{"sentry with two replicas", getFakeAdmissionRequest(t, sentry.Name, "", scaled(sentry, 2)), true},
+		{"validator scale subresource to one replica", getFakeAdmissionRequest(t, validator.Name, "scale", scale(1)), true},
+		{"validator scale subresource to two replicas", getFakeAdmissionRequest(t, validator.Name, "scale", scale(2)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := validatorScaleValidator.Handle(context.TODO(), tt.request)
+			if response.Allowed != tt.isAllowed {
+				t.Fatalf("Handle: allowed (%v), expected (%v): (%v)", response.Allowed, tt.isAllowed, response.Result)
+			}
+		})
+	}
+}
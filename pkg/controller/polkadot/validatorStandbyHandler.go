@@ -0,0 +1,289 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"fmt"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"time"
+)
+
+func (r *ReconcilerPolkadot) handleValidatorStandby(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerValidatorStandby(CRInstance)
+	return handler.handleValidatorStandbySpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerValidatorStandby(CRInstance *polkadotv1alpha1.Polkadot) IHandlerValidatorStandby {
+	if isValidatorStandbyEnabled(CRInstance) {
+		return &handlerValidatorStandbyValidator{}
+	}
+	return &handlerValidatorStandbyDefault{}
+}
+
+//pattern Strategy
+type IHandlerValidatorStandby interface {
+	handleValidatorStandbySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerValidatorStandbyValidator struct {
+}
+
+func (h *handlerValidatorStandbyValidator) handleValidatorStandbySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return r.handleValidatorStandbyGeneric(CRInstance)
+}
+
+type handlerValidatorStandbyDefault struct {
+}
+
+func (h *handlerValidatorStandbyDefault) handleValidatorStandbySpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	// the secondary StatefulSet of a disabled standby mode is removed, the admission webhook keeps the keys on the primary one
+	return r.handleValidatorStandbyRemoval(CRInstance)
+}
+
+// handleValidatorStandbyGeneric fails the validator over to the standby once the active validator is unhealthy for failoverAfterSeconds.
+// The standby becomes the active node at once, but the session keys are moved to it only once no pod of the previous validator
+// exists: its StatefulSet is scaled to 0 and a pod stuck terminating, e.g. on a node lost, must be removed by the administrator
+func (r *ReconcilerPolkadot) handleValidatorStandbyGeneric(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+
+	logger := log.WithValues("Polkadot.Namespace", CRInstance.Namespace, "Polkadot.Name", CRInstance.Name)
+
+	standby := &polkadotv1alpha1.StandbyStatus{Active: polkadotv1alpha1.ValidatorNodePrimary, Phase: polkadotv1alpha1.StandbyPhaseHealthy}
+	if CRInstance.Status.Standby != nil {
+		standby = CRInstance.Status.Standby.DeepCopy()
+	}
+	active := standby.Active
+	activeStatefulSetName := getValidatorNodeStatefulSetName(CRInstance.Name, active)
+	otherStatefulSetName := getValidatorNodeStatefulSetName(CRInstance.Name, getOtherValidatorNode(active))
+
+	if standby.IsFailingOver() {
+		statefulSet := &appsv1.StatefulSet{}
+		isNotFound, err := r.fetchResource(statefulSet, types.NamespacedName{Name: otherStatefulSetName, Namespace: CRInstance.Namespace})
+		if err != nil {
+			logger.Error(err, "Error on fetch the StatefulSet...")
+			return NotForcedRequeue, err
+		}
+		if isNotFound == false && !isStatefulSetScaledDown(statefulSet) {
+			// the StatefulSet is scaled to 0 by handleStatefulSet, it must not recreate the pod of the previous validator
+			standby.Message = fmt.Sprintf("waiting for the scale down of %s", otherStatefulSetName)
+			return r.handleValidatorFailoverWait(CRInstance, standby, fmt.Sprintf("the StatefulSet %s is not scaled down", otherStatefulSetName))
+		}
+		pods, err := r.fetchPods(CRInstance, getValidatorNodeLabels(CRInstance.Name, getOtherValidatorNode(active)))
+		if err != nil {
+			logger.Error(err, "Error on fetch the validator pods...")
+			return NotForcedRequeue, err
+		}
+		if len(pods) > 0 {
+			standby.Message = fmt.Sprintf("waiting for the termination of the pod %s of the previous validator", pods[0].Name)
+			logger.Info("Waiting for the termination of the previous validator before moving the session keys...", "Pod.Name", pods[0].Name)
+			return r.handleValidatorFailoverWait(CRInstance, standby, fmt.Sprintf("the pod %s of the previous validator is not terminated", pods[0].Name))
+		}
+		standby.Phase = polkadotv1alpha1.StandbyPhaseHealthy
+		standby.Message = ""
+		logger.Info("Moving the session keys to the standby...", "StatefulSet.Name", activeStatefulSetName)
+		r.recorder.Event(CRInstance, corev1.EventTypeNormal, validatorFailoverCompletedReason,
+			fmt.Sprintf("The pods of %s are terminated, the session keys are moved to %s", otherStatefulSetName, activeStatefulSetName))
+		_, err = r.updateStandbyStatus(CRInstance, standby)
+		return ForcedRequeue, err
+	}
+
+	if isUpgradeInProgress(CRInstance) {
+		// the validators are restarted on purpose by the upgrade, its health gates roll it back
+		standby.Phase, standby.UnhealthySince, standby.Message = polkadotv1alpha1.StandbyPhaseHealthy, nil, ""
+		return r.updateStandbyStatus(CRInstance, standby)
+	}
+	failure, err := r.getValidatorNodeHealthFailure(CRInstance, active)
+	if err != nil {
+		logger.Error(err, "Error on fetch the validator pod...")
+		return NotForcedRequeue, err
+	}
+	if failure == "" {
+		standby.Phase, standby.UnhealthySince, standby.Message = polkadotv1alpha1.StandbyPhaseHealthy, nil, ""
+		return r.updateStandbyStatus(CRInstance, standby)
+	}
+
+	standby.Phase = polkadotv1alpha1.StandbyPhaseUnhealthy
+	standby.Message = failure
+	now := metav1.Now()
+	if standby.UnhealthySince == nil {
+		standby.UnhealthySince = &now
+	}
+	if time.Since(standby.UnhealthySince.Time) < CRInstance.GetStandbyFailoverAfter() {
+		return r.updateStandbyStatus(CRInstance, standby)
+	}
+	standbyFailure, err := r.getValidatorNodeHealthFailure(CRInstance, getOtherValidatorNode(active))
+	if err != nil {
+		logger.Error(err, "Error on fetch the validator pod...")
+		return NotForcedRequeue, err
+	}
+	if standbyFailure != "" {
+		standby.Message = fmt.Sprintf("%s, the standby can't take over: %s", failure, standbyFailure)
+		return r.updateStandbyStatus(CRInstance, standby)
+	}
+
+	logger.Info("Failing the validator over to the standby...", "Reason", failure, "StatefulSet.Name", otherStatefulSetName)
+	r.recorder.Event(CRInstance, corev1.EventTypeWarning, validatorFailoverReason,
+		fmt.Sprintf("Failing the validator over from %s to %s: %s", activeStatefulSetName, otherStatefulSetName, failure))
+	standby.Active = getOtherValidatorNode(active)
+	standby.Phase = polkadotv1alpha1.StandbyPhaseFailingOver
+	standby.UnhealthySince = nil
+	standby.LastFailoverTime = &now
+	standby.Failovers++
+	standby.Message = fmt.Sprintf("waiting for the termination of the pods of %s", activeStatefulSetName)
+	_, err = r.updateStandbyStatus(CRInstance, standby)
+	return ForcedRequeue, err
+}
+
+// handleValidatorFailoverWait reports a failover waiting for the previous validator. Past validatorFailoverTimeout the failover is
+// stuck, e.g. a pod terminating on a node lost is never removed by Kubernetes: the manual step is reported once with an Event
+func (r *ReconcilerPolkadot) handleValidatorFailoverWait(CRInstance *polkadotv1alpha1.Polkadot, standby *polkadotv1alpha1.StandbyStatus, pending string) (bool, error) {
+	if standby.LastFailoverTime == nil || time.Since(standby.LastFailoverTime.Time) < validatorFailoverTimeout {
+		return r.updateStandbyStatus(CRInstance, standby)
+	}
+	standby.Message = fmt.Sprintf("%s after %s: once its node is known to be down, force delete the pods of the previous validator "+
+		"(kubectl delete pod --grace-period=0 --force) to move the session keys to the standby", pending, validatorFailoverTimeout)
+	if standby.Phase != polkadotv1alpha1.StandbyPhaseFailoverStuck {
+		log.Info("The failover is stuck on the previous validator...", "Polkadot.Name", CRInstance.Name, "Reason", pending)
+		r.recorder.Event(CRInstance, corev1.EventTypeWarning, validatorFailoverStuckReason, "The failover is stuck, "+standby.Message)
+	}
+	standby.Phase = polkadotv1alpha1.StandbyPhaseFailoverStuck
+	return r.updateStandbyStatus(CRInstance, standby)
+}
+
+// getValidatorNodeHealthFailure returns why the pod of the validator node is not healthy, it applies the health gates of the staged upgrades
+func (r *ReconcilerPolkadot) getValidatorNodeHealthFailure(CRInstance *polkadotv1alpha1.Polkadot, node polkadotv1alpha1.ValidatorNode) (string, error) {
+	pod := &corev1.Pod{}
+	isNotFound, err := r.fetchResource(pod, types.NamespacedName{Name: getValidatorNodeStatefulSetName(CRInstance.Name, node) + "-0", Namespace: CRInstance.Namespace})
+	if err != nil {
+		return "", err
+	}
+	if isNotFound == true || pod.DeletionTimestamp != nil {
+		return "the pod is not running", nil
+	}
+	return r.getNodeHealthFailure(CRInstance, pod), nil
+}
+
+func (r *ReconcilerPolkadot) updateStandbyStatus(CRInstance *polkadotv1alpha1.Polkadot, standby *polkadotv1alpha1.StandbyStatus) (bool, error) {
+	if reflect.DeepEqual(CRInstance.Status.Standby, standby) {
+		return NotForcedRequeue, nil
+	}
+	CRInstance.Status.Standby = standby
+	err := r.updateResourceStatus(CRInstance)
+	if err != nil {
+		log.Error(err, "Update Polkadot status Error...", "Polkadot.Name", CRInstance.Name)
+		return NotForcedRequeue, err
+	}
+	return NotForcedRequeue, nil
+}
+
+func (r *ReconcilerPolkadot) handleValidatorStandbyRemoval(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+
+	logger := log.WithValues("StatefulSet.Namespace", CRInstance.Namespace, "StatefulSet.Name", GetValidatorSecondaryStatefulSetName(CRInstance.Name))
+
+	statefulSet := &appsv1.StatefulSet{}
+	isNotFound, err := r.fetchResource(statefulSet, types.NamespacedName{Name: GetValidatorSecondaryStatefulSetName(CRInstance.Name), Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the StatefulSet...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == false && statefulSet.GetDeletionTimestamp() == nil {
+		logger.Info("Deleting the StatefulSet of the validator standby...")
+		err := r.deleteResource(statefulSet)
+		if err != nil {
+			logger.Error(err, "Error on deleting the StatefulSet...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the StatefulSet")
+	}
+	return r.updateStandbyStatus(CRInstance, nil)
+}
+
+// isStatefulSetScaledDown tells if the StatefulSet controller observed the scaling to 0 and counts no pod left
+func isStatefulSetScaledDown(statefulSet *appsv1.StatefulSet) bool {
+	return statefulSet.Spec.Replicas != nil && *statefulSet.Spec.Replicas == 0 &&
+		statefulSet.Status.ObservedGeneration >= statefulSet.Generation && statefulSet.Status.Replicas == 0
+}
+
+// isValidatorStandbyEnabled tells if the validator runs with a warm standby
+func isValidatorStandbyEnabled(CRInstance *polkadotv1alpha1.Polkadot) bool {
+	return isValidatorDeployed(CRInstance) && CRInstance.Spec.Validator.Standby != nil
+}
+
+// isValidatorNodeKeyHolder tells if the validator node runs with the session keys: always the active one,
+// except during a failover, until the pods of the previous validator are gone
+func isValidatorNodeKeyHolder(CRInstance *polkadotv1alpha1.Polkadot, node polkadotv1alpha1.ValidatorNode) bool {
+	if node != CRInstance.GetActiveValidatorNode() {
+		return false
+	}
+	return !isValidatorStandbyEnabled(CRInstance) || CRInstance.Status.Standby == nil || !CRInstance.Status.Standby.IsFailingOver()
+}
+
+// getValidatorNodeReplicas returns the replicas of a validator node, the previous validator is scaled to 0 during a failover.
+// The validators are stopped while the migration of a legacy validator is not confirmed, see handleLegacyResources
+func getValidatorNodeReplicas(CRInstance *polkadotv1alpha1.Polkadot, node polkadotv1alpha1.ValidatorNode) int32 {
+	if isLegacyMigrationPending(CRInstance) {
+		return 0
+	}
+	standby := CRInstance.Status.Standby
+	if isValidatorStandbyEnabled(CRInstance) && standby != nil && standby.IsFailingOver() && node != CRInstance.GetActiveValidatorNode() {
+		return 0
+	}
+	return 1
+}
+
+// getValidatorNodes returns the validator nodes of the CR, the secondary one with the standby mode only
+func getValidatorNodes(CRInstance *polkadotv1alpha1.Polkadot) []polkadotv1alpha1.ValidatorNode {
+	if isValidatorStandbyEnabled(CRInstance) {
+		return []polkadotv1alpha1.ValidatorNode{polkadotv1alpha1.ValidatorNodePrimary, polkadotv1alpha1.ValidatorNodeSecondary}
+	}
+	return []polkadotv1alpha1.ValidatorNode{polkadotv1alpha1.ValidatorNodePrimary}
+}
+
+func getOtherValidatorNode(node polkadotv1alpha1.ValidatorNode) polkadotv1alpha1.ValidatorNode {
+	if node == polkadotv1alpha1.ValidatorNodeSecondary {
+		return polkadotv1alpha1.ValidatorNodePrimary
+	}
+	return polkadotv1alpha1.ValidatorNodeSecondary
+}
+
+func getValidatorNodeStatefulSetName(CRName string, node polkadotv1alpha1.ValidatorNode) string {
+	if node == polkadotv1alpha1.ValidatorNodeSecondary {
+		return GetValidatorSecondaryStatefulSetName(CRName)
+	}
+	return GetValidatorStatefulSetName(CRName)
+}
+
+func getValidatorNodeLabels(CRName string, node polkadotv1alpha1.ValidatorNode) map[string]string {
+	if node == polkadotv1alpha1.ValidatorNodeSecondary {
+		return getValidatorSecondaryLabels(CRName)
+	}
+	return getValidatorLabels(CRName)
+}
+
+// getValidatorKeyHolderLabels select the validator pods running with the session keys, the ones the validator Services route to
+func getValidatorKeyHolderLabels(CRInstance *polkadotv1alpha1.Polkadot) map[string]string {
+	if isValidatorStandbyEnabled(CRInstance) {
+		return getActiveValidatorLabels(CRInstance.Name)
+	}
+	return getValidatorLabels(CRInstance.Name)
+}
+
+// getValidatorPodSelector selects the pods of all the validator nodes, the standby is isolated as the active validator
+func getValidatorPodSelector(CRInstance *polkadotv1alpha1.Polkadot) metav1.LabelSelector {
+	if !isValidatorStandbyEnabled(CRInstance) {
+		return metav1.LabelSelector{MatchLabels: getValidatorLabels(CRInstance.Name)}
+	}
+	return metav1.LabelSelector{
+		MatchLabels: getAppLabels(CRInstance.Name),
+		MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      "role",
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   []string{getValidatorLabels(CRInstance.Name)["role"], getValidatorSecondaryLabels(CRInstance.Name)["role"]},
+		}},
+	}
+}
@@ -0,0 +1,202 @@
+package polkadot
+
+import (
+	"context"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func isValidatorKeyHolder(statefulSet *appsv1.StatefulSet) bool {
+	commands := strings.Join(statefulSet.Spec.Template.Spec.Containers[0].Command, " ")
+	return strings.Contains(commands, "--validator") && strings.Contains(commands, "--node-key") && strings.Contains(commands, "--keystore-path") &&
+		statefulSet.Spec.Template.Labels[activeValidatorLabel] == "true"
+}
+
+func TestNewStatefulSetValidatorStandby(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeStandby())
+
+	// the primary validator holds the keys, the standby runs with a node key of its own
+	primary := newStatefulSetValidator(polkadot, nil)
+	secondary := newStatefulSetValidatorSecondary(polkadot, nil)
+	if !isValidatorKeyHolder(primary) || *primary.Spec.Replicas != 1 {
+		t.Fatalf("newStatefulSetValidator: unexpected primary validator (%v)", primary.Spec.Template.Spec.Containers[0].Command)
+	}
+	commands := strings.Join(secondary.Spec.Template.Spec.Containers[0].Command, " ")
+	if strings.Contains(commands, "--validator") || strings.Contains(commands, "--node-key") || strings.Contains(commands, "--keystore-path") || *secondary.Spec.Replicas != 1 {
+		t.Fatalf("newStatefulSetValidatorSecondary: the standby runs with the keys (%v)", commands)
+	}
+	for _, volume := range secondary.Spec.Template.Spec.Volumes {
+		if volume.Name == keystoreVolumeName {
+			t.Fatalf("newStatefulSetValidatorSecondary: the keystore is mounted on the standby")
+		}
+	}
+	if secondary.Name != GetValidatorSecondaryStatefulSetName(CRName) || secondary.Spec.Selector.MatchLabels["role"] != "validator-secondary" {
+		t.Fatalf("newStatefulSetValidatorSecondary: unexpected StatefulSet (%v) (%v)", secondary.Name, secondary.Spec.Selector.MatchLabels)
+	}
+
+	// the validator Services route to the key holder only
+	if newServiceValidator(polkadot).Spec.Selector[activeValidatorLabel] != "true" || newServiceValidatorRPC(polkadot).Spec.Selector[activeValidatorLabel] != "true" {
+		t.Fatalf("newServiceValidator: unexpected selector (%v)", newServiceValidator(polkadot).Spec.Selector)
+	}
+
+	// during a failover nobody holds the keys and the previous validator is scaled down
+	polkadot.Status.Standby = &polkadotv1alpha1.StandbyStatus{Active: polkadotv1alpha1.ValidatorNodeSecondary, Phase: polkadotv1alpha1.StandbyPhaseFailingOver}
+	primary = newStatefulSetValidator(polkadot, nil)
+	secondary = newStatefulSetValidatorSecondary(polkadot, nil)
+	if isValidatorKeyHolder(primary) || isValidatorKeyHolder(secondary) || *primary.Spec.Replicas != 0 || *secondary.Spec.Replicas != 1 {
+		t.Fatalf("newStatefulSetValidatorNode: unexpected StatefulSets during the failover")
+	}
+
+	polkadot.Status.Standby.Phase = polkadotv1alpha1.StandbyPhaseHealthy
+	primary = newStatefulSetValidator(polkadot, nil)
+	secondary = newStatefulSetValidatorSecondary(polkadot, nil)
+	if isValidatorKeyHolder(primary) || !isValidatorKeyHolder(secondary) || *primary.Spec.Replicas != 1 {
+		t.Fatalf("newStatefulSetValidatorNode: the keys were not moved to the standby")
+	}
+
+	// without standby the validator pod template is unchanged
+	polkadot.Spec.Validator.Standby = nil
+	primary = newStatefulSetValidator(polkadot, nil)
+	if _, isFound := primary.Spec.Template.Labels[activeValidatorLabel]; isFound || newServiceValidator(polkadot).Spec.Selector["role"] != "validator" {
+		t.Fatalf("newStatefulSetValidator: unexpected labels without standby (%v)", primary.Spec.Template.Labels)
+	}
+}
+
+func TestHandleValidatorStandby(t *testing.T) {
+	server := getFakeExporter(3, 0, 200)
+	defer server.Close()
+	defer setFakeMetricsPort(t, server.URL)()
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeStandby())
+	primary := newStatefulSetValidator(polkadot, nil)
+	primaryPod := getFakeUpgradedPod(GetValidatorStatefulSetName(CRName)+"-0", getValidatorLabels(CRName), corev1.PodSpec{}, false)
+	secondaryPod := getFakeUpgradedPod(GetValidatorSecondaryStatefulSetName(CRName)+"-0", getValidatorSecondaryLabels(CRName), corev1.PodSpec{}, true)
+	reconciler, client, recorder := getFakeReconciler(t, polkadot, primary, primaryPod, secondaryPod)
+
+	// the unhealthy validator is not failed over before failoverAfterSeconds
+	isRequeueForced, err := reconciler.handleValidatorStandby(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorStandby: (%v)", err)
+	}
+	standby := polkadot.Status.Standby
+	if standby == nil || standby.Active != polkadotv1alpha1.ValidatorNodePrimary || standby.Phase != polkadotv1alpha1.StandbyPhaseUnhealthy || standby.UnhealthySince == nil {
+		t.Fatalf("handleValidatorStandby: unexpected status (%v)", standby)
+	}
+
+	// the standby becomes active, the keys are moved once the previous validator is gone
+	unhealthySince := metav1.NewTime(time.Now().Add(-2 * polkadot.GetStandbyFailoverAfter()))
+	polkadot.Status.Standby.UnhealthySince = &unhealthySince
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorStandby: (%v)", err)
+	}
+	standby = polkadot.Status.Standby
+	if standby.Active != polkadotv1alpha1.ValidatorNodeSecondary || standby.Phase != polkadotv1alpha1.StandbyPhaseFailingOver || standby.Failovers != 1 || standby.LastFailoverTime == nil {
+		t.Fatalf("handleValidatorStandby: the failover was not started (%v)", standby)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, validatorFailoverReason) {
+		t.Fatalf("handleValidatorStandby: unexpected event (%v)", event)
+	}
+
+	// the previous validator StatefulSet must be scaled down
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	if isRequeueForced || err != nil || polkadot.Status.Standby.Phase != polkadotv1alpha1.StandbyPhaseFailingOver {
+		t.Fatalf("handleValidatorStandby: the failover completed before the scale down (%v)", err)
+	}
+	scaledDown := newStatefulSetValidator(polkadot, nil)
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: primary.Name}, primary)
+	primary.Spec.Replicas = scaledDown.Spec.Replicas
+	primary.Status.ObservedGeneration = primary.Generation
+	if err := client.Update(context.TODO(), primary); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+
+	// the pod of the previous validator must be gone, even terminating
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	if isRequeueForced || err != nil || !strings.Contains(polkadot.Status.Standby.Message, primaryPod.Name) {
+		t.Fatalf("handleValidatorStandby: the failover completed before the termination of the pod (%v)", polkadot.Status.Standby)
+	}
+
+	// a pod not terminated after the failover timeout, e.g. on a node lost, is reported once as the manual step to take
+	lastFailoverTime := metav1.NewTime(time.Now().Add(-2 * validatorFailoverTimeout))
+	polkadot.Status.Standby.LastFailoverTime = &lastFailoverTime
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	standby = polkadot.Status.Standby
+	if isRequeueForced || err != nil || standby.Phase != polkadotv1alpha1.StandbyPhaseFailoverStuck || !strings.Contains(standby.Message, "force delete") {
+		t.Fatalf("handleValidatorStandby: the stuck failover was not reported (%v)", standby)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, validatorFailoverStuckReason) {
+		t.Fatalf("handleValidatorStandby: unexpected event (%v)", event)
+	}
+	if isValidatorKeyHolder(newStatefulSetValidatorSecondary(polkadot, nil)) || *newStatefulSetValidator(polkadot, nil).Spec.Replicas != 0 {
+		t.Fatalf("newStatefulSetValidatorNode: the keys were moved during the stuck failover")
+	}
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	if isRequeueForced || err != nil || len(recorder.Events) != 0 {
+		t.Fatalf("handleValidatorStandby: the stuck failover was reported again (%v)", err)
+	}
+	if err := client.Delete(context.TODO(), primaryPod); err != nil {
+		t.Fatalf("Delete: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorStandby: (%v)", err)
+	}
+	if polkadot.Status.Standby.Phase != polkadotv1alpha1.StandbyPhaseHealthy || !isValidatorKeyHolder(newStatefulSetValidatorSecondary(polkadot, nil)) {
+		t.Fatalf("handleValidatorStandby: the keys were not moved (%v)", polkadot.Status.Standby)
+	}
+	event = <-recorder.Events
+	if !strings.Contains(event, validatorFailoverCompletedReason) {
+		t.Fatalf("handleValidatorStandby: unexpected event (%v)", event)
+	}
+}
+
+func TestHandleValidatorStandbyUnhealthy(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeStandby())
+	unhealthySince := metav1.NewTime(time.Now().Add(-2 * polkadot.GetStandbyFailoverAfter()))
+	polkadot.Status.Standby = &polkadotv1alpha1.StandbyStatus{Active: polkadotv1alpha1.ValidatorNodePrimary, Phase: polkadotv1alpha1.StandbyPhaseUnhealthy, UnhealthySince: &unhealthySince}
+	reconciler, client, _ := getFakeReconciler(t, polkadot)
+
+	// the validator is not failed over to a standby not ready
+	isRequeueForced, err := reconciler.handleValidatorStandby(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorStandby: (%v)", err)
+	}
+	if polkadot.Status.Standby.Active != polkadotv1alpha1.ValidatorNodePrimary || !strings.Contains(polkadot.Status.Standby.Message, "the standby can't take over") {
+		t.Fatalf("handleValidatorStandby: unexpected status (%v)", polkadot.Status.Standby)
+	}
+
+	// nor to a standby ready but still syncing the chain
+	server := getFakeExporter(3, 1, 100)
+	defer server.Close()
+	defer setFakeMetricsPort(t, server.URL)()
+	standbyPod := getFakeUpgradedPod(GetValidatorSecondaryStatefulSetName(CRName)+"-0", getValidatorSecondaryLabels(CRName), corev1.PodSpec{}, true)
+	if err := client.Create(context.TODO(), standbyPod); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorStandby(polkadot)
+	if isRequeueForced || err != nil || polkadot.Status.Standby.Active != polkadotv1alpha1.ValidatorNodePrimary || !strings.Contains(polkadot.Status.Standby.Message, "syncing") {
+		t.Fatalf("handleValidatorStandby: the validator was failed over to a syncing standby (%v)", polkadot.Status.Standby)
+	}
+}
+
+func TestGetUpgradePartitionStandby(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator(), withFakeValidatorDataPersistence(), withFakeStandby())
+	polkadot.Spec.Upgrade = &polkadotv1alpha1.UpgradeSpec{}
+	polkadot.Status.Upgrade = &polkadotv1alpha1.UpgradeStatus{Phase: polkadotv1alpha1.UpgradePhaseInProgress, TotalPods: 2}
+
+	// the standby is upgraded before the active validator
+	names := getUpgradePodNames(polkadot)
+	if len(names) != 2 || names[0] != GetValidatorSecondaryStatefulSetName(CRName)+"-0" || names[1] != GetValidatorStatefulSetName(CRName)+"-0" {
+		t.Fatalf("getUpgradePodNames: unexpected order (%v)", names)
+	}
+	if getUpgradePartition(polkadot, GetValidatorSecondaryStatefulSetName(CRName), 1) != 0 || getUpgradePartition(polkadot, GetValidatorStatefulSetName(CRName), 1) != 1 {
+		t.Fatalf("getUpgradePartition: the active validator is released with the standby")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	"context"
+	"fmt"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func (r *ReconcilerPolkadot) handleValidatorUpdate(CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	handler := getHandlerValidatorUpdate(CRInstance)
+	return handler.handleValidatorUpdateSpecific(r, CRInstance)
+}
+
+//pattern factory
+func getHandlerValidatorUpdate(CRInstance *polkadotv1alpha1.Polkadot) IHandlerValidatorUpdate {
+	if CRKind(CRInstance.Spec.Kind) == Validator || CRKind(CRInstance.Spec.Kind) == SentryAndValidator {
+		return &handlerValidatorUpdateValidator{}
+	}
+	return &handlerValidatorUpdateDefault{}
+}
+
+//pattern Strategy
+type IHandlerValidatorUpdate interface {
+	handleValidatorUpdateSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error)
+}
+
+type handlerValidatorUpdateValidator struct {
+}
+
+func (h *handlerValidatorUpdateValidator) handleValidatorUpdateSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	for _, node := range getValidatorNodes(CRInstance) {
+		isForcedRequeue, err := r.handleValidatorUpdateGeneric(CRInstance, node)
+		if isForcedRequeue == ForcedRequeue || err != nil {
+			return isForcedRequeue, err
+		}
+	}
+	return NotForcedRequeue, nil
+}
+
+type handlerValidatorUpdateDefault struct {
+}
+
+func (h *handlerValidatorUpdateDefault) handleValidatorUpdateSpecific(r *ReconcilerPolkadot, CRInstance *polkadotv1alpha1.Polkadot) (bool, error) {
+	return handleSkip()
+}
+
+// handleValidatorUpdateGeneric replaces the pod of the OnDelete StatefulSet of a validator node once its template is updated.
+// The StatefulSet controller creates the new pod only once the old one is removed, so two validators never run with the same keys.
+// A scaling of the StatefulSet above one replica is rejected by the validating webhook of the StatefulSets (validatorScaleWebhook.go).
+// As a safety net, when the webhook is disabled or not reachable, the StatefulSet is scaled back to one replica and the extra pods are deleted
+func (r *ReconcilerPolkadot) handleValidatorUpdateGeneric(CRInstance *polkadotv1alpha1.Polkadot, node polkadotv1alpha1.ValidatorNode) (bool, error) {
+
+	statefulSetName := getValidatorNodeStatefulSetName(CRInstance.Name, node)
+	logger := log.WithValues("StatefulSet.Namespace", CRInstance.Namespace, "StatefulSet.Name", statefulSetName)
+
+	statefulSet := &appsv1.StatefulSet{}
+	isNotFound, err := r.fetchResource(statefulSet, types.NamespacedName{Name: statefulSetName, Namespace: CRInstance.Namespace})
+	if err != nil {
+		logger.Error(err, "Error on fetch the StatefulSet...")
+		return NotForcedRequeue, err
+	}
+	if isNotFound == true || statefulSet.GetDeletionTimestamp() != nil {
+		return NotForcedRequeue, nil
+	}
+
+	if statefulSet.Spec.Replicas != nil && *statefulSet.Spec.Replicas > 1 {
+		logger.Info("Refusing the scaling of the validator StatefulSet...", "Replicas", *statefulSet.Spec.Replicas)
+		r.recorder.Event(CRInstance, corev1.EventTypeWarning, validatorScaleRefusedReason,
+			fmt.Sprintf("Refused the scaling of the validator StatefulSet to %d replicas, a validator runs a single instance", *statefulSet.Spec.Replicas))
+		replicas := int32(1)
+		updated := statefulSet.DeepCopy()
+		updated.Spec.Replicas = &replicas
+		err := r.updateResource(updated)
+		if err != nil {
+			logger.Error(err, "Update StatefulSet Error...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Scaled the StatefulSet back to one replica")
+		return ForcedRequeue, nil
+	}
+
+	pods, err := r.fetchPods(CRInstance, getValidatorNodeLabels(CRInstance.Name, node))
+	if err != nil {
+		logger.Error(err, "Error on fetch the validator pods...")
+		return NotForcedRequeue, err
+	}
+	podName := statefulSetName + "-0"
+	for i := range pods {
+		if pods[i].Name == podName || pods[i].GetDeletionTimestamp() != nil {
+			continue
+		}
+		logger.Info("Deleting the extra validator pod...", "Pod.Name", pods[i].Name)
+		err := r.deleteResource(&pods[i])
+		if err != nil {
+			logger.Error(err, "Error on deleting the pod...")
+			return NotForcedRequeue, err
+		}
+		logger.Info("Deleted the pod")
+		return ForcedRequeue, nil
+	}
+	if len(pods) == 0 {
+		return NotForcedRequeue, nil
+	}
+	if len(pods) > 1 || pods[0].GetDeletionTimestamp() != nil {
+		// the StatefulSet watch triggers a new reconciliation once the terminating pods are removed
+		logger.Info("Waiting for the termination of the validator pods...", "Pods", len(pods))
+		return NotForcedRequeue, nil
+	}
+
+	pod := &pods[0]
+	if !isValidatorPodOutdated(statefulSet, pod) {
+		return NotForcedRequeue, nil
+	}
+	if getUpgradePartition(CRInstance, statefulSet.Name, 1) > 0 {
+		// a staged upgrade updates the validator after the sentries
+		return NotForcedRequeue, nil
+	}
+	logger.Info("Deleting the validator pod to update it, the new pod is created once it is terminated...", "Pod.Name", pod.Name)
+	err = r.deleteResource(pod)
+	if err != nil {
+		logger.Error(err, "Error on deleting the pod...")
+		return NotForcedRequeue, err
+	}
+	logger.Info("Deleted the pod")
+	return ForcedRequeue, nil
+}
+
+// isValidatorPodOutdated tells if the pod doesn't run the revision of the current template of the StatefulSet,
+// as observed by the StatefulSet controller
+func isValidatorPodOutdated(statefulSet *appsv1.StatefulSet, pod *corev1.Pod) bool {
+	if statefulSet.Status.ObservedGeneration < statefulSet.Generation || statefulSet.Status.UpdateRevision == "" {
+		return false
+	}
+	return pod.Labels[appsv1.ControllerRevisionHashLabelKey] != statefulSet.Status.UpdateRevision
+}
+
+// fetchPods returns the pods of the CR matching the labels, including the terminating ones
+func (r *ReconcilerPolkadot) fetchPods(CRInstance *polkadotv1alpha1.Polkadot, labels map[string]string) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	err := r.client.List(context.TODO(), podList, client.InNamespace(CRInstance.Namespace), client.MatchingLabels(labels))
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
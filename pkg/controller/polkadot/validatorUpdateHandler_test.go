@@ -0,0 +1,173 @@
+package polkadot
+
+import (
+	"context"
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis"
+	polkadotv1alpha1 "github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/apis/polkadot/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"strings"
+	"testing"
+)
+
+func getFakeValidatorPod(name string, revision string) *corev1.Pod {
+	labels := getValidatorLabels(CRName)
+	labels[appsv1.ControllerRevisionHashLabelKey] = revision
+	return getFakePod(name, labels, corev1.PodRunning)
+}
+
+func TestHandleValidatorUpdate(t *testing.T) {
+
+	scheme := runtime.NewScheme()
+	if err := apis.AddToScheme(scheme); err != nil {
+		t.Errorf("apis.AddToScheme: %v", err)
+	}
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Errorf("corev1.AddToScheme: %v", err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Errorf("appsv1.AddToScheme: %v", err)
+	}
+
+	polkadot := getFakePolkadot(withFakeValidator())
+	statefulSet := newStatefulSetValidator(polkadot, nil)
+	statefulSet.Status.UpdateRevision = "revision-2"
+	podName := GetValidatorStatefulSetName(CRName) + "-0"
+	client := clientfake.NewFakeClientWithScheme(scheme, polkadot, statefulSet, getFakeValidatorPod(podName, "revision-2"))
+	recorder := record.NewFakeRecorder(10)
+	reconciler := ReconcilerPolkadot{client: client, scheme: scheme, recorder: recorder}
+
+	// the pod running the revision of the template is kept
+	isRequeueForced, err := reconciler.handleValidatorUpdate(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: podName}, &corev1.Pod{}); err != nil {
+		t.Fatalf("handleValidatorUpdate: the up to date pod was deleted (%v)", err)
+	}
+
+	// a scaling above one replica is refused and the extra pod is deleted
+	replicas := int32(2)
+	statefulSet.Spec.Replicas = &replicas
+	if err := client.Update(context.TODO(), statefulSet); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	if err := client.Create(context.TODO(), getFakeValidatorPod(GetValidatorStatefulSetName(CRName)+"-1", "revision-2")); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorUpdate(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+	_ = client.Get(context.TODO(), types.NamespacedName{Name: statefulSet.Name}, statefulSet)
+	if *statefulSet.Spec.Replicas != 1 {
+		t.Fatalf("handleValidatorUpdate: the StatefulSet was not scaled back (%v)", *statefulSet.Spec.Replicas)
+	}
+	event := <-recorder.Events
+	if !strings.Contains(event, validatorScaleRefusedReason) {
+		t.Fatalf("handleValidatorUpdate: unexpected event (%v)", event)
+	}
+	isRequeueForced, err = reconciler.handleValidatorUpdate(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: GetValidatorStatefulSetName(CRName) + "-1"}, &corev1.Pod{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("handleValidatorUpdate: the extra pod was not deleted (%v)", err)
+	}
+
+	// the pod is replaced once the template is updated
+	statefulSet.Status.UpdateRevision = "revision-3"
+	if err := client.Update(context.TODO(), statefulSet); err != nil {
+		t.Fatalf("Update: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorUpdate(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+	err = client.Get(context.TODO(), types.NamespacedName{Name: podName}, &corev1.Pod{})
+	if !errors.IsNotFound(err) {
+		t.Fatalf("handleValidatorUpdate: the outdated pod was not deleted (%v)", err)
+	}
+
+	// no pod is deleted while a validator pod is terminating
+	terminatingPod := getFakeValidatorPod(podName, "revision-2")
+	deletionTimestamp := metav1.Now()
+	terminatingPod.DeletionTimestamp = &deletionTimestamp
+	if err := client.Create(context.TODO(), terminatingPod); err != nil {
+		t.Fatalf("Create: (%v)", err)
+	}
+	isRequeueForced, err = reconciler.handleValidatorUpdate(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+}
+
+func TestHandleValidatorUpdateStagedUpgrade(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator())
+	polkadot.Spec.Kind = string(SentryAndValidator)
+	polkadot.Spec.Sentry.Replicas = 1
+	polkadot.Spec.Upgrade = &polkadotv1alpha1.UpgradeSpec{}
+	polkadot.Status.Upgrade = &polkadotv1alpha1.UpgradeStatus{Phase: polkadotv1alpha1.UpgradePhaseInProgress, TotalPods: 2}
+	statefulSet := newStatefulSetValidator(polkadot, nil)
+	statefulSet.Status.UpdateRevision = "revision-2"
+	reconciler, client, _ := getFakeReconciler(t, polkadot, statefulSet, getFakeValidatorPod(GetValidatorStatefulSetName(CRName)+"-0", "revision-1"))
+
+	// the validator is updated only once the sentries are upgraded
+	isRequeueForced, err := reconciler.handleValidatorUpdate(polkadot)
+	if isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+	if err := client.Get(context.TODO(), types.NamespacedName{Name: GetValidatorStatefulSetName(CRName) + "-0"}, &corev1.Pod{}); err != nil {
+		t.Fatalf("handleValidatorUpdate: the validator was deleted before the sentries were upgraded (%v)", err)
+	}
+
+	polkadot.Status.Upgrade.UpgradedPods = 1
+	isRequeueForced, err = reconciler.handleValidatorUpdate(polkadot)
+	if !isRequeueForced || err != nil {
+		t.Fatalf("handleValidatorUpdate: (%v)", err)
+	}
+}
+
+func TestNewStatefulSetValidatorUpdateStrategy(t *testing.T) {
+	polkadot := getFakePolkadot(withFakeValidator())
+
+	statefulSet := newStatefulSetValidator(polkadot, nil)
+	if statefulSet.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType || *statefulSet.Spec.Replicas != 1 {
+		t.Fatalf("newStatefulSetValidator: unexpected update strategy (%v)", statefulSet.Spec.UpdateStrategy)
+	}
+	for _, container := range statefulSet.Spec.Template.Spec.InitContainers {
+		if container.Name == "wait-validator-lease" {
+			t.Fatalf("newStatefulSetValidator: the Lease is waited for without the lease start gate")
+		}
+	}
+
+	// the Lease is acquired before the other init containers run
+	polkadot.Spec.Validator.LeaseStartGate.Enabled = true
+	polkadot.Spec.Validator.DataPersistenceSupport.Enabled = true
+	podSpec := newStatefulSetValidator(polkadot, nil).Spec.Template.Spec
+	if len(podSpec.InitContainers) < 2 || podSpec.InitContainers[0].Name != "wait-validator-lease" {
+		t.Fatalf("newStatefulSetValidator: unexpected init containers (%v)", podSpec.InitContainers)
+	}
+	isMounted := false
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == validatorLeaseVolumeName && volume.DownwardAPI != nil && len(volume.DownwardAPI.Items) == 2 {
+			isMounted = true
+		}
+	}
+	if !isMounted {
+		t.Fatalf("newStatefulSetValidator: the Lease holder is not mounted (%v)", podSpec.Volumes)
+	}
+
+	// the sentries keep the rolling updates
+	polkadot.Spec.Sentry.Replicas = 1
+	if newStatefulSetSentry(polkadot, nil).Spec.UpdateStrategy.Type != appsv1.RollingUpdateStatefulSetStrategyType {
+		t.Fatalf("newStatefulSetSentry: unexpected update strategy")
+	}
+}
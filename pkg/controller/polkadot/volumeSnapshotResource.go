@@ -0,0 +1,42 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package polkadot
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// the VolumeSnapshot API is not part of the Kubernetes version the operator is pinned to, it is handled as unstructured
+var volumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1beta1", Kind: "VolumeSnapshot"}
+
+func newVolumeSnapshot(name string, pvc *corev1.PersistentVolumeClaim, volumeSnapshotClassName string) *unstructured.Unstructured {
+	source := map[string]interface{}{
+		"persistentVolumeClaimName": pvc.Name,
+	}
+	spec := map[string]interface{}{
+		"source": source,
+	}
+	if volumeSnapshotClassName != "" {
+		spec["volumeSnapshotClassName"] = volumeSnapshotClassName
+	}
+
+	snapshot := newVolumeSnapshotEmpty()
+	snapshot.SetName(name)
+	snapshot.SetNamespace(pvc.Namespace)
+	snapshot.SetLabels(getCopy(pvc.Labels))
+	snapshot.Object["spec"] = spec
+	return snapshot
+}
+
+func newVolumeSnapshotEmpty() *unstructured.Unstructured {
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	return snapshot
+}
+
+func isVolumeSnapshotReady(snapshot *unstructured.Unstructured) bool {
+	isReady, isFound, err := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	return err == nil && isFound && isReady
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package p2p
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// base58Alphabet is the Bitcoin alphabet, used by libp2p to encode the peer IDs
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var bigRadix = big.NewInt(58)
+
+func encodeBase58(data []byte) string {
+	number := new(big.Int).SetBytes(data)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for number.Sign() > 0 {
+		number.DivMod(number, bigRadix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	// every leading zero byte is encoded as the first symbol of the alphabet
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func decodeBase58(encoded string) ([]byte, error) {
+	number := new(big.Int)
+	for _, symbol := range encoded {
+		digit := strings.IndexRune(base58Alphabet, symbol)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", symbol)
+		}
+		number.Mul(number, bigRadix)
+		number.Add(number, big.NewInt(int64(digit)))
+	}
+
+	leadingZeros := 0
+	for leadingZeros < len(encoded) && encoded[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+	return append(make([]byte, leadingZeros), number.Bytes()...), nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+
+// Package p2p contains the libp2p identity helpers used by the Substrate based nodes (node keys and peer IDs)
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// NodeKeySize is the size of the Ed25519 secret passed to the nodes via --node-key
+	NodeKeySize = 32
+
+	multihashIdentity = 0x00
+	multihashSha256   = 0x12
+	sha256Size        = 32
+	// maxIdentityDigestSize is the size of the protobuf encoded public keys inlined in the peer IDs (42 bytes max)
+	maxIdentityDigestSize = 42
+
+	// the libp2p PublicKey protobuf message: field 1 is the key type (1 = Ed25519), field 2 is the key data
+	protobufKeyTypeTag = 0x08
+	protobufKeyDataTag = 0x12
+	keyTypeEd25519     = 0x01
+)
+
+// GenerateNodeKey returns a new random node key, hex encoded
+func GenerateNodeKey() (string, error) {
+	key := make([]byte, NodeKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// PeerIDFromNodeKey derives the peer ID the node announces when it is started with the given node key ("12D3KooW...")
+func PeerIDFromNodeKey(nodeKey string) (string, error) {
+	key, err := ParseNodeKey(nodeKey)
+	if err != nil {
+		return "", err
+	}
+	publicKey := ed25519.NewKeyFromSeed(key).Public().(ed25519.PublicKey)
+	// the protobuf encoded Ed25519 public keys are short enough to be inlined with the identity multihash
+	return encodeBase58(getMultihash(multihashIdentity, marshalPublicKey(publicKey))), nil
+}
+
+func marshalPublicKey(publicKey ed25519.PublicKey) []byte {
+	encoded := []byte{protobufKeyTypeTag, keyTypeEd25519, protobufKeyDataTag, byte(len(publicKey))}
+	return append(encoded, publicKey...)
+}
+
+func getMultihash(code uint64, digest []byte) []byte {
+	multihash := make([]byte, 0, 2*binary.MaxVarintLen64+len(digest))
+	multihash = appendUvarint(multihash, code)
+	multihash = appendUvarint(multihash, uint64(len(digest)))
+	return append(multihash, digest...)
+}
+
+func appendUvarint(data []byte, value uint64) []byte {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buffer, value)
+	return append(data, buffer[:n]...)
+}
+
+// ParseNodeKey decodes a node key, the hex encoding of a 32 bytes secret with an optional 0x prefix
+func ParseNodeKey(nodeKey string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(nodeKey), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("the node key is not hex encoded: %v", err)
+	}
+	if len(key) != NodeKeySize {
+		return nil, fmt.Errorf("the node key must be %d bytes long, found %d bytes", NodeKeySize, len(key))
+	}
+	return key, nil
+}
+
+// ValidatePeerID checks that id is a base58 encoded libp2p peer ID,
+// i.e. the multihash of a public key: sha2-256 ("Qm...") or identity ("12D3KooW..." for the Ed25519 keys)
+func ValidatePeerID(id string) error {
+	if id == "" {
+		return fmt.Errorf("the peer ID is empty")
+	}
+	multihash, err := decodeBase58(id)
+	if err != nil {
+		return fmt.Errorf("the peer ID is not base58 encoded: %v", err)
+	}
+
+	code, n := binary.Uvarint(multihash)
+	if n <= 0 {
+		return fmt.Errorf("the peer ID is not a valid multihash")
+	}
+	size, m := binary.Uvarint(multihash[n:])
+	if m <= 0 {
+		return fmt.Errorf("the peer ID is not a valid multihash")
+	}
+	digest := multihash[n+m:]
+	if uint64(len(digest)) != size {
+		return fmt.Errorf("the peer ID multihash declares %d bytes, found %d bytes", size, len(digest))
+	}
+
+	switch code {
+	case multihashSha256:
+		if size != sha256Size {
+			return fmt.Errorf("the peer ID sha2-256 digest must be %d bytes long", sha256Size)
+		}
+	case multihashIdentity:
+		if size == 0 || size > maxIdentityDigestSize {
+			return fmt.Errorf("the peer ID identity digest must be at most %d bytes long", maxIdentityDigestSize)
+		}
+	default:
+		return fmt.Errorf("the peer ID multihash code 0x%x is not supported", code)
+	}
+	return nil
+}
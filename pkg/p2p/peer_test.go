@@ -0,0 +1,107 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestParseNodeKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodeKey string
+		isValid bool
+	}{
+		{name: "Node key valid", nodeKey: "0000000000000000000000000000000000000000000000000000000000000013", isValid: true},
+		{name: "Node key with prefix", nodeKey: "0x0000000000000000000000000000000000000000000000000000000000000013", isValid: true},
+		{name: "Node key too short", nodeKey: "0013", isValid: false},
+		{name: "Node key not hex", nodeKey: "zz00000000000000000000000000000000000000000000000000000000000013", isValid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := ParseNodeKey(test.nodeKey)
+			if (err == nil) != test.isValid {
+				t.Fatalf("ParseNodeKey: (%v)", err)
+			}
+			if test.isValid && len(key) != NodeKeySize {
+				t.Fatalf("ParseNodeKey: unexpected size (%v)", len(key))
+			}
+		})
+	}
+}
+
+func TestValidatePeerID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		isValid bool
+	}{
+		{name: "Peer ID sha2-256", id: "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPPhm", isValid: true},
+		{name: "Peer ID identity", id: "12D3KooWEyoppNCUx8Yx66oV9fJnriXwCcXwDDUA2kj6vnc6iDEp", isValid: true},
+		{name: "Peer ID empty", id: "", isValid: false},
+		{name: "Peer ID not base58", id: "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPP0l", isValid: false},
+		{name: "Peer ID truncated", id: "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPP", isValid: false},
+		{name: "Peer ID node key", id: "0000000000000000000000000000000000000000000000000000000000000013", isValid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidatePeerID(test.id)
+			if (err == nil) != test.isValid {
+				t.Fatalf("ValidatePeerID: (%v)", err)
+			}
+		})
+	}
+}
+
+func TestBase58(t *testing.T) {
+	data := []byte{0, 0, 0x12, 0x20, 0xff, 0x01}
+	decoded, err := decodeBase58(encodeBase58(data))
+	if err != nil || !bytes.Equal(decoded, data) {
+		t.Fatalf("decodeBase58: (%v) (%v)", decoded, err)
+	}
+}
+
+func TestPeerIDFromNodeKey(t *testing.T) {
+	// the peer IDs printed by the nodes of the sample CR, encoded with the legacy sha2-256 multihash
+	tests := []struct {
+		name         string
+		nodeKey      string
+		legacyPeerID string
+	}{
+		{name: "Peer ID sentry", nodeKey: "0000000000000000000000000000000000000000000000000000000000000013", legacyPeerID: "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPPhm"},
+		{name: "Peer ID validator", nodeKey: "0000000000000000000000000000000000000000000000000000000000000021", legacyPeerID: "QmQtR1cdEaJM11qBWQBd34FoSgFichCjhtsBfrUFsVAjZM"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			peerID, err := PeerIDFromNodeKey(test.nodeKey)
+			if err != nil {
+				t.Fatalf("PeerIDFromNodeKey: (%v)", err)
+			}
+			if err := ValidatePeerID(peerID); err != nil || !strings.HasPrefix(peerID, "12D3KooW") {
+				t.Fatalf("PeerIDFromNodeKey: invalid peer ID (%v) (%v)", peerID, err)
+			}
+
+			multihash, _ := decodeBase58(peerID)
+			publicKey := multihash[2:]
+			digest := sha256.Sum256(publicKey)
+			legacyPeerID := encodeBase58(getMultihash(multihashSha256, digest[:]))
+			if legacyPeerID != test.legacyPeerID {
+				t.Fatalf("PeerIDFromNodeKey: the public key doesn't match the legacy peer ID (%v)", legacyPeerID)
+			}
+		})
+	}
+}
+
+func TestGenerateNodeKey(t *testing.T) {
+	nodeKey, err := GenerateNodeKey()
+	if err != nil {
+		t.Fatalf("GenerateNodeKey: (%v)", err)
+	}
+	if _, err := PeerIDFromNodeKey(nodeKey); err != nil {
+		t.Fatalf("GenerateNodeKey: (%v)", err)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+
+// Package substrate contains a minimal JSON-RPC client for the Substrate based nodes (e.g. Polkadot, Kusama)
+package substrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Client calls the JSON-RPC methods exposed by a node on its http-rpc port
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// Health is the result of system_health
+type Health struct {
+	Peers           int64 `json:"peers"`
+	IsSyncing       bool  `json:"isSyncing"`
+	ShouldHavePeers bool  `json:"shouldHavePeers"`
+}
+
+// SyncState is the result of system_syncState, HighestBlock is nil when no peer has announced a block yet
+type SyncState struct {
+	StartingBlock int64  `json:"startingBlock"`
+	CurrentBlock  int64  `json:"currentBlock"`
+	HighestBlock  *int64 `json:"highestBlock"`
+}
+
+// PeerInfo is an item of the result of system_peers
+type PeerInfo struct {
+	PeerID     string `json:"peerId"`
+	Roles      string `json:"roles"`
+	BestHash   string `json:"bestHash"`
+	BestNumber int64  `json:"bestNumber"`
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int             `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+}
+
+// Error is a JSON-RPC error returned by the node
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// NewClient returns a client for the node reachable at url (e.g. http://10.0.0.1:9933)
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *Client) Health() (*Health, error) {
+	result := &Health{}
+	if err := c.Call("system_health", result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) SyncState() (*SyncState, error) {
+	result := &SyncState{}
+	if err := c.Call("system_syncState", result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Client) Peers() ([]PeerInfo, error) {
+	var result []PeerInfo
+	if err := c.Call("system_peers", &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RotateKeys generates new session keys in the keystore of the node and returns their public keys, hex encoded.
+// author_rotateKeys is an unsafe method
+func (c *Client) RotateKeys() (string, error) {
+	var result string
+	if err := c.Call("author_rotateKeys", &result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// Call invokes method with params and decodes the result into result
+func (c *Client) Call(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(request{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	httpResponse, err := c.httpClient.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected http status %s", method, httpResponse.Status)
+	}
+
+	rpcResponse := &response{}
+	if err := json.NewDecoder(httpResponse.Body).Decode(rpcResponse); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	if rpcResponse.Error != nil {
+		return rpcResponse.Error
+	}
+	return json.Unmarshal(rpcResponse.Result, result)
+}
@@ -0,0 +1,73 @@
+package substrate
+
+import (
+	"github.com/swisscom-blockchain/polkadot-k8s-operator/pkg/substrate/fake"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	server := fake.NewServer(map[string]interface{}{
+		"system_health":    map[string]interface{}{"peers": 3, "isSyncing": true, "shouldHavePeers": true},
+		"system_syncState": map[string]interface{}{"startingBlock": 0, "currentBlock": 100, "highestBlock": 250},
+		"system_peers": []map[string]interface{}{
+			{"peerId": "QmQMTLWkNwGf7P5MQv7kUHCynMg7jje6h3vbvwd2ALPPhm", "roles": "FULL", "bestHash": "0x01", "bestNumber": 250},
+		},
+		"author_rotateKeys": "0x0102",
+	})
+	defer server.Close()
+	client := NewClient(server.URL, time.Second)
+
+	health, err := client.Health()
+	if err != nil {
+		t.Fatalf("Health: (%v)", err)
+	}
+	if health.Peers != 3 || !health.IsSyncing {
+		t.Fatalf("the health doesn't match the expected result: (%v)", health)
+	}
+
+	syncState, err := client.SyncState()
+	if err != nil {
+		t.Fatalf("SyncState: (%v)", err)
+	}
+	if syncState.CurrentBlock != 100 || syncState.HighestBlock == nil || *syncState.HighestBlock != 250 {
+		t.Fatalf("the sync state doesn't match the expected result: (%v)", syncState)
+	}
+
+	peers, err := client.Peers()
+	if err != nil {
+		t.Fatalf("Peers: (%v)", err)
+	}
+	if len(peers) != 1 || peers[0].BestNumber != 250 {
+		t.Fatalf("the peers don't match the expected result: (%v)", peers)
+	}
+
+	keys, err := client.RotateKeys()
+	if err != nil || keys != "0x0102" {
+		t.Fatalf("the session keys don't match the expected result: (%v) (%v)", keys, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := fake.NewServer(map[string]interface{}{
+		"system_syncState": map[string]interface{}{"startingBlock": 0, "currentBlock": 0, "highestBlock": nil},
+	})
+	defer server.Close()
+	client := NewClient(server.URL, time.Second)
+
+	_, err := client.Health()
+	if _, isRPCError := err.(*Error); !isRPCError {
+		t.Fatalf("expected a rpc error: (%v)", err)
+	}
+
+	syncState, err := client.SyncState()
+	if err != nil || syncState.HighestBlock != nil {
+		t.Fatalf("the sync state doesn't match the expected result: (%v) (%v)", syncState, err)
+	}
+
+	server.Close()
+	_, err = client.Peers()
+	if err == nil {
+		t.Fatalf("expected an error from a closed server")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+package substrate
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExporterClient reads the metrics of the dotexporter sidecar (metrics/dotexporter.py), which queries the node through
+// localhost: it reaches the nodes whose RPC endpoints are bound to the loopback interface
+type ExporterClient struct {
+	url        string
+	httpClient *http.Client
+}
+
+// ExporterMetrics is the node state published by the exporter, the exporter doesn't publish the highest block
+// announced by the peers
+type ExporterMetrics struct {
+	Health    Health
+	HeadBlock int64
+}
+
+// NewExporterClient returns a client for the exporter reachable at url (e.g. http://10.0.0.1:9615)
+func NewExporterClient(url string, timeout time.Duration) *ExporterClient {
+	return &ExporterClient{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Metrics reads the node state from the /metrics endpoint of the exporter
+func (c *ExporterClient) Metrics() (*ExporterMetrics, error) {
+	httpResponse, err := c.httpClient.Get(c.url + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics: unexpected http status %s", httpResponse.Status)
+	}
+
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(httpResponse.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.LastIndex(line, " ")
+		if separator < 0 {
+			continue
+		}
+		value, err := strconv.ParseInt(line[separator+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[getExporterMetricKey(line[:separator])] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("metrics: %v", err)
+	}
+
+	if values["dot_rpc_healthy"] != 1 {
+		return nil, fmt.Errorf("metrics: the exporter can't query the node")
+	}
+	for _, key := range []string{"dot_peer_count", "dot_isSyncing", "dot_chain_block_number/head"} {
+		if _, isFound := values[key]; !isFound {
+			return nil, fmt.Errorf("metrics: %s is missing", key)
+		}
+	}
+	return &ExporterMetrics{
+		Health: Health{
+			Peers:           values["dot_peer_count"],
+			IsSyncing:       values["dot_isSyncing"] == 1,
+			ShouldHavePeers: values["dot_shouldHavePeers"] == 1,
+		},
+		HeadBlock: values["dot_chain_block_number/head"],
+	}, nil
+}
+
+// getExporterMetricKey returns the name of the metric, followed by the block label for dot_chain_block_number.
+// The other labels are the chain spec, common to all the metrics of the exporter
+func getExporterMetricKey(metric string) string {
+	labelsStart := strings.Index(metric, "{")
+	if labelsStart < 0 {
+		return metric
+	}
+	name := metric[:labelsStart]
+	for _, label := range strings.Split(strings.Trim(metric[labelsStart:], "{}"), ",") {
+		if strings.HasPrefix(label, `block="`) {
+			return name + "/" + strings.Trim(strings.TrimPrefix(label, "block="), `"`)
+		}
+	}
+	return name
+}
@@ -0,0 +1,59 @@
+package substrate
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getFakeExporter(metrics string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, metrics)
+	}))
+}
+
+func TestExporterClient(t *testing.T) {
+	server := getFakeExporter(`dot_chain_block_number{chain="Kusama",block="finalized"} 98
+dot_chain_block_number{chain="Kusama",block="head"} 100
+dot_peer_count{chain="Kusama"} 3
+dot_shouldHavePeers{chain="Kusama"} 1
+dot_isSyncing{chain="Kusama"} 1
+dot_specVersion{chain="Kusama"} 2026
+dot_rpc_healthy{chain="Kusama"} 1
+`)
+	defer server.Close()
+
+	metrics, err := NewExporterClient(server.URL, time.Second).Metrics()
+	if err != nil {
+		t.Fatalf("Metrics: (%v)", err)
+	}
+	if metrics.HeadBlock != 100 || metrics.Health.Peers != 3 || !metrics.Health.IsSyncing || !metrics.Health.ShouldHavePeers {
+		t.Fatalf("the metrics don't match the expected result: (%v)", metrics)
+	}
+}
+
+func TestExporterClientErrors(t *testing.T) {
+	server := getFakeExporter("dot_rpc_healthy 0\n")
+	defer server.Close()
+	client := NewExporterClient(server.URL, time.Second)
+	if _, err := client.Metrics(); err == nil {
+		t.Fatalf("expected an error when the exporter can't query the node")
+	}
+
+	partialServer := getFakeExporter("dot_peer_count 3\ndot_rpc_healthy 1\n")
+	defer partialServer.Close()
+	if _, err := NewExporterClient(partialServer.URL, time.Second).Metrics(); err == nil {
+		t.Fatalf("expected an error when a metric is missing")
+	}
+
+	server.Close()
+	if _, err := client.Metrics(); err == nil {
+		t.Fatalf("expected an error from a closed server")
+	}
+}
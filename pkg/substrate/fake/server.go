@@ -0,0 +1,71 @@
+// Copyright (c) 2020 Swisscom Blockchain AG
+// Licensed under MIT License
+
+// Package fake contains an in-process fake of the JSON-RPC server of a Substrate node
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Server answers the JSON-RPC requests with the configured results
+type Server struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	results map[string]interface{}
+	calls   map[string]int
+}
+
+type request struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// NewServer starts a server answering each method with the given result, unknown methods get a "Method not found" error.
+// The caller must Close the server.
+func NewServer(results map[string]interface{}) *Server {
+	s := &Server{results: results, calls: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetResult changes the result of method
+func (s *Server) SetResult(method string, result interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results[method] = result
+}
+
+// Calls returns how many times method has been invoked
+func (s *Server) Calls(method string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls[method]
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	req := &request{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	s.calls[req.Method]++
+	result, isFound := s.results[req.Method]
+	s.mutex.Unlock()
+
+	response := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if isFound {
+		response["result"] = result
+	} else {
+		response["error"] = map[string]interface{}{"code": -32601, "message": "Method not found"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
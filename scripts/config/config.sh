@@ -2,9 +2,18 @@ IMAGE_OPERATOR=ironoa/customresource-operator:v0.0.8 #define your favourite
 IMAGE_METRICS=ironoa/polkadot-metrics:v0.0.1 # define your favourite
 # The above parameters have to match with the ones in the deployed resource defined in the deploy/operator.yaml file
 
+# namespace of the operator, the one of the current kubectl context by default
+NAMESPACE=${NAMESPACE:-$(kubectl config view --minify --output 'jsonpath={..namespace}')}
+NAMESPACE=${NAMESPACE:-default}
+# serve the admission webhooks of deploy/webhook.yaml, cert-manager must be installed in the cluster
+ENABLE_WEBHOOKS=${ENABLE_WEBHOOKS:-false}
+
 K8S_OPERATOR=operator.yaml
+K8S_WEBHOOK=webhook.yaml
 K8S_CR=polkadot.swisscomblockchain.com_v1alpha1_polkadot_cr.yaml
 K8S_CRD=polkadot.swisscomblockchain.com_polkadots_crd.yaml
 K8S_SERVICE_ACCOUNT=service_account.yaml
 K8S_ROLE=role.yaml
-K8S_ROLE_BINDING=role_binding.yaml
\ No newline at end of file
+K8S_ROLE_BINDING=role_binding.yaml
+K8S_CLUSTER_ROLE=cluster_role.yaml
+K8S_CLUSTER_ROLE_BINDING=cluster_role_binding.yaml
\ No newline at end of file
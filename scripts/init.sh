@@ -11,6 +11,8 @@ pushd .. >/dev/null 2>&1
 kubectl create -f deploy/"$K8S_SERVICE_ACCOUNT"
 kubectl create -f deploy/"$K8S_ROLE"
 kubectl create -f deploy/"$K8S_ROLE_BINDING"
+kubectl create -f deploy/"$K8S_CLUSTER_ROLE"
+sed "s/REPLACE_NAMESPACE/$NAMESPACE/g" deploy/"$K8S_CLUSTER_ROLE_BINDING" | kubectl create -f -
 kubectl create -f deploy/crds/"$K8S_CRD"
 popd >/dev/null 2>&1 || exit
 
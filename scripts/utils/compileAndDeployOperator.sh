@@ -9,4 +9,10 @@ pushd .. >/dev/null 2>&1
 operator-sdk build "$IMAGE_OPERATOR"
 docker push "$IMAGE_OPERATOR"
 kubectl create -f deploy/"$K8S_OPERATOR"
+if test "$ENABLE_WEBHOOKS" = "true"
+then
+      sed "s/REPLACE_NAMESPACE/$NAMESPACE/g" deploy/"$K8S_WEBHOOK" | kubectl create -f -
+      kubectl patch deployment polkadot-operator --type json \
+        --patch '[{"op": "add", "path": "/spec/template/spec/containers/0/args", "value": ["--enable-webhooks"]}]'
+fi
 popd >/dev/null 2>&1 || exit
@@ -6,5 +6,9 @@ then
 fi
 
 pushd .. >/dev/null 2>&1
+if test "$ENABLE_WEBHOOKS" = "true"
+then
+      sed "s/REPLACE_NAMESPACE/$NAMESPACE/g" deploy/"$K8S_WEBHOOK" | kubectl delete -f -
+fi
 kubectl delete -f deploy/"$K8S_OPERATOR"
 popd >/dev/null 2>&1 || exit
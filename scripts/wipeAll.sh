@@ -11,6 +11,8 @@ source ./utils/wipeOperator.sh
 
 pushd .. >/dev/null 2>&1
 kubectl delete -f deploy/crds/"$K8S_CRD"
+sed "s/REPLACE_NAMESPACE/$NAMESPACE/g" deploy/"$K8S_CLUSTER_ROLE_BINDING" | kubectl delete -f -
+kubectl delete -f deploy/"$K8S_CLUSTER_ROLE"
 kubectl delete -f deploy/"$K8S_ROLE_BINDING"
 kubectl delete -f deploy/"$K8S_ROLE"
 kubectl delete -f deploy/"$K8S_SERVICE_ACCOUNT"
@@ -14,12 +14,12 @@ import (
 	framework "github.com/operator-framework/operator-sdk/pkg/test"
 )
 
-const (
-	sentrySSName = polkadot.SentrySSName
-	validatorSSName = polkadot.ValidatorSSName
-	serviceSentryName = polkadot.ServiceSentryName
-	serviceValidatorName = polkadot.ServiceValidatorName
-	validatorNetworkPolicy = polkadot.ValidatorNetworkPolicy
+var (
+	sentrySSName = polkadot.GetSentryStatefulSetName(utils.CRName)
+	validatorSSName = polkadot.GetValidatorStatefulSetName(utils.CRName)
+	serviceSentryName = polkadot.GetSentryServiceName(utils.CRName)
+	serviceValidatorName = polkadot.GetValidatorServiceName(utils.CRName)
+	validatorNetworkPolicy = polkadot.GetValidatorNetworkPolicyName(utils.CRName)
 )
 
 var (
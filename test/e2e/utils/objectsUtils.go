@@ -7,10 +7,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const CRName = "example-polkadot"
+
 func NewPolkadotSentry(namespace string) *polkadotv1alpha1.Polkadot{
 	return &polkadotv1alpha1.Polkadot{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "example-polkadot",
+			Name:      CRName,
 			Namespace: namespace,
 		},
 		Spec: polkadotv1alpha1.PolkadotSpec{
@@ -24,7 +26,7 @@ func NewPolkadotSentry(namespace string) *polkadotv1alpha1.Polkadot{
 func NewPolkadotValidator(namespace string) *polkadotv1alpha1.Polkadot{
 	return &polkadotv1alpha1.Polkadot{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "example-polkadot",
+			Name:      CRName,
 			Namespace: namespace,
 		},
 		Spec: polkadotv1alpha1.PolkadotSpec{
@@ -38,7 +40,7 @@ func NewPolkadotValidator(namespace string) *polkadotv1alpha1.Polkadot{
 func NewPolkadotSentryAndValidator(namespace string, isSecureCommunicationEnabled bool) *polkadotv1alpha1.Polkadot{
 	return &polkadotv1alpha1.Polkadot{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "example-polkadot",
+			Name:      CRName,
 			Namespace: namespace,
 		},
 		Spec: polkadotv1alpha1.PolkadotSpec{